@@ -1,9 +1,17 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	// Multiplier schedule time zones must resolve on hosts without zoneinfo
+	_ "time/tzdata"
 
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/alert"
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/database"
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/handler"
@@ -54,19 +62,47 @@ func main() {
 	usageRepo := repository.NewUsageRepository(db)
 	uuidRepo := repository.NewUUIDRepository(db)
 	onlineRepo := repository.NewOnlineUserRepository(db)
+	subRepo := repository.NewSubscriptionRepository(db)
+	packRepo := repository.NewTrafficPackRepository(db)
+	nodeStatusRepo := repository.NewNodeStatusRepository(db)
+	nodeEventRepo := repository.NewNodeEventRepository(db)
+	pushReceiptRepo := repository.NewPushReceiptRepository(db)
+	scheduleRepo := repository.NewMultiplierScheduleRepository(db)
+	userChangeRepo := repository.NewUserListChangeRepository(db)
+	linkTokenRepo := repository.NewTelegramLinkTokenRepository(db)
+	thresholdRepo := repository.NewTelegramThresholdRepository(db)
 
 	// Initialize services
 	authService := service.NewAuthService(&cfg.Auth, userRepo, db)
-	accountingService := service.NewAccountingService(userRepo, nodeRepo, planRepo, usageRepo, uuidRepo, logger)
+	multiplierResolver := service.NewMultiplierResolver(nodeRepo, planRepo, scheduleRepo)
+	nodePushService := service.NewNodePushService()
+	onlineUserService := service.NewOnlineUserService(&cfg.Node, onlineRepo, logger)
+	nodeUserService := service.NewNodeUserService(&cfg.Node, userRepo, userChangeRepo, packRepo, subRepo, nodePushService, logger)
+	accountingService := service.NewAccountingService(userRepo, nodeRepo, planRepo, usageRepo, uuidRepo, multiplierResolver, nodeUserService, logger)
+	nodeKeyService := service.NewNodeKeyService(&cfg.Node, nodeRepo)
+	nodeStatusService := service.NewNodeStatusService(&cfg.Node, nodeStatusRepo, logger)
+	if err := nodeStatusService.Load(); err != nil {
+		logger.Warn("Failed to load node status history", zap.Error(err))
+	}
+	nodeHealthService := service.NewNodeHealthService(&cfg.Node, nodeRepo, nodeEventRepo, logger)
+	ingestService := service.NewTrafficIngestService(&cfg.Ingest, accountingService, logger)
+	if err := ingestService.Start(); err != nil {
+		logger.Fatal("Failed to start traffic ingestion", zap.Error(err))
+	}
+	pushDedupService := service.NewPushDedupService(&cfg.Node, nodeRepo, pushReceiptRepo, logger)
+	telegramLinkService := service.NewTelegramLinkService(userRepo, linkTokenRepo)
+	thresholdService := service.NewNotificationThresholdService(thresholdRepo, userRepo, usageRepo, packRepo)
+	subscriptionService := service.NewSubscriptionService(&cfg.Subscription, subRepo, userRepo, planRepo, accountingService, nodeUserService, logger)
 
 	// Initialize handlers
 	authHandler := handler.NewAuthHandler(authService)
-	userHandler := handler.NewUserHandler(userRepo, nodeRepo, planRepo, accountingService, authService)
-	adminHandler := handler.NewAdminHandler(userRepo, nodeRepo, planRepo, labelRepo, uuidRepo, authService)
-	nodeHandler := handler.NewNodeHandler(nodeRepo, userRepo, planRepo, uuidRepo, onlineRepo, accountingService, logger)
+	userHandler := handler.NewUserHandler(userRepo, nodeRepo, planRepo, accountingService, authService, subscriptionService, packRepo, multiplierResolver, telegramLinkService, thresholdRepo)
+	adminHandler := handler.NewAdminHandler(userRepo, nodeRepo, planRepo, labelRepo, uuidRepo, authService, accountingService, subscriptionService, subRepo, packRepo, nodeKeyService, nodeStatusService, nodeEventRepo, multiplierResolver, scheduleRepo, nodeUserService, nodePushService, onlineRepo, logger)
+	subscribeHandler := handler.NewSubscribeHandler(&cfg.Server, userRepo, nodeRepo, planRepo, uuidRepo, subRepo, packRepo, accountingService, authService, logger)
+	nodeHandler := handler.NewNodeHandler(nodeRepo, onlineUserService, nodeUserService, nodePushService, nodeStatusService, ingestService, pushDedupService, logger)
 
 	// Initialize Telegram bot
-	telegramBot, err := telegram.NewBot(&cfg.Telegram, userRepo, logger)
+	telegramBot, err := telegram.NewBot(&cfg.Telegram, &cfg.Server, userRepo, nodeRepo, planRepo, packRepo, accountingService, subscriptionService, authService, multiplierResolver, telegramLinkService, nodeUserService, logger)
 	if err != nil {
 		logger.Error("Failed to initialize Telegram bot", zap.Error(err))
 	} else if telegramBot != nil {
@@ -75,7 +111,8 @@ func main() {
 	}
 
 	// Initialize background jobs
-	jobScheduler := jobs.NewJobScheduler(db, accountingService, userRepo, usageRepo, telegramBot, logger)
+	alertWebhook := alert.NewWebhook(&cfg.Alert)
+	jobScheduler := jobs.NewJobScheduler(db, accountingService, subscriptionService, nodeStatusService, nodeHealthService, pushDedupService, nodeUserService, onlineUserService, thresholdService, userRepo, telegramBot, alertWebhook, logger)
 	jobScheduler.Start()
 
 	// Initialize Gin
@@ -106,6 +143,9 @@ func main() {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
+	// Subscription link (authenticated by the user's token)
+	r.GET("/sub/:token", subscribeHandler.Subscribe)
+
 	// Auth endpoints (public)
 	authGroup := r.Group("/api/v1/auth")
 	{
@@ -123,6 +163,12 @@ func main() {
 		userGroup.GET("/usage", userHandler.GetMyUsage)
 		userGroup.GET("/usage/history", userHandler.GetMyUsageHistory)
 		userGroup.POST("/telegram/link", userHandler.GenerateTelegramLink)
+		userGroup.GET("/notifications/thresholds", userHandler.ListNotificationThresholds)
+		userGroup.POST("/notifications/thresholds", userHandler.CreateNotificationThreshold)
+		userGroup.PUT("/notifications/thresholds/:id", userHandler.UpdateNotificationThreshold)
+		userGroup.DELETE("/notifications/thresholds/:id", userHandler.DeleteNotificationThreshold)
+		userGroup.GET("/subscribe", subscribeHandler.GetSubscribeURL)
+		userGroup.POST("/subscribe/reset", subscribeHandler.ResetSubscribeURL)
 	}
 
 	// Admin endpoints (authenticated + admin role)
@@ -136,6 +182,14 @@ func main() {
 		adminGroup.GET("/users/:id", adminHandler.GetUser)
 		adminGroup.PUT("/users/:id", adminHandler.UpdateUser)
 		adminGroup.DELETE("/users/:id", adminHandler.DeleteUser)
+		adminGroup.PUT("/users/:id/reset-anchor", adminHandler.UpdateResetAnchor)
+		adminGroup.GET("/users/:id/subscriptions", adminHandler.ListUserSubscriptions)
+		adminGroup.POST("/users/:id/subscriptions", adminHandler.CreateUserSubscription)
+		adminGroup.DELETE("/users/:id/subscriptions/:sub_id", adminHandler.CancelUserSubscription)
+		adminGroup.GET("/users/:id/packs", adminHandler.ListUserTrafficPacks)
+		adminGroup.POST("/users/:id/packs", adminHandler.GrantTrafficPack)
+		adminGroup.DELETE("/users/:id/packs/:pack_id", adminHandler.RevokeTrafficPack)
+		adminGroup.GET("/users/:id/online-ips", adminHandler.ListUserOnlineIPs)
 
 		// Nodes
 		adminGroup.POST("/nodes", adminHandler.CreateNode)
@@ -143,6 +197,11 @@ func main() {
 		adminGroup.GET("/nodes/:id", adminHandler.GetNode)
 		adminGroup.PUT("/nodes/:id", adminHandler.UpdateNode)
 		adminGroup.DELETE("/nodes/:id", adminHandler.DeleteNode)
+		adminGroup.POST("/nodes/:id/api-key", adminHandler.RotateNodeKey)
+		adminGroup.DELETE("/nodes/:id/api-key/previous", adminHandler.RevokePreviousNodeKey)
+		adminGroup.GET("/nodes/:id/status", adminHandler.GetNodeStatus)
+		adminGroup.GET("/nodes/:id/status/history", adminHandler.GetNodeStatusHistory)
+		adminGroup.GET("/nodes/:id/events", adminHandler.ListNodeEvents)
 
 		// Plans
 		adminGroup.POST("/plans", adminHandler.CreatePlan)
@@ -150,6 +209,10 @@ func main() {
 		adminGroup.GET("/plans/:id", adminHandler.GetPlan)
 		adminGroup.PUT("/plans/:id", adminHandler.UpdatePlan)
 		adminGroup.DELETE("/plans/:id", adminHandler.DeletePlan)
+		adminGroup.GET("/plans/:id/multipliers", adminHandler.ListPlanMultipliers)
+		adminGroup.PUT("/plans/:id/multipliers/:label_id", adminHandler.SetPlanMultiplier)
+		adminGroup.DELETE("/plans/:id/multipliers/:label_id", adminHandler.DeletePlanMultiplier)
+		adminGroup.GET("/multipliers/explain", adminHandler.ExplainMultiplier)
 
 		// Labels
 		adminGroup.POST("/labels", adminHandler.CreateLabel)
@@ -157,15 +220,23 @@ func main() {
 		adminGroup.GET("/labels/:id", adminHandler.GetLabel)
 		adminGroup.PUT("/labels/:id", adminHandler.UpdateLabel)
 		adminGroup.DELETE("/labels/:id", adminHandler.DeleteLabel)
+
+		// Multiplier schedules
+		adminGroup.POST("/multiplier-schedules", adminHandler.CreateMultiplierSchedule)
+		adminGroup.GET("/multiplier-schedules", adminHandler.ListMultiplierSchedules)
+		adminGroup.GET("/multiplier-schedules/:id", adminHandler.GetMultiplierSchedule)
+		adminGroup.PUT("/multiplier-schedules/:id", adminHandler.UpdateMultiplierSchedule)
+		adminGroup.DELETE("/multiplier-schedules/:id", adminHandler.DeleteMultiplierSchedule)
 	}
 
 	// Node protocol endpoints (Xboard-compatible)
 	// V1 API (UniProxy)
 	nodeV1 := r.Group("/api/v1/server/UniProxy")
-	nodeV1.Use(middleware.NodeAuthMiddleware(&cfg.Node, nodeRepo))
+	nodeV1.Use(middleware.NodeAuthMiddleware(nodeKeyService, nodeRepo))
 	{
 		nodeV1.GET("/config", nodeHandler.GetConfig)
 		nodeV1.GET("/user", nodeHandler.GetUsers)
+		nodeV1.GET("/ws", nodeHandler.StreamEvents)
 		nodeV1.POST("/push", nodeHandler.PushTraffic)
 		nodeV1.POST("/alive", nodeHandler.PushAlive)
 		nodeV1.GET("/alivelist", nodeHandler.GetAliveList)
@@ -174,10 +245,11 @@ func main() {
 
 	// V2 API (same implementation)
 	nodeV2 := r.Group("/api/v2/server")
-	nodeV2.Use(middleware.NodeAuthMiddleware(&cfg.Node, nodeRepo))
+	nodeV2.Use(middleware.NodeAuthMiddleware(nodeKeyService, nodeRepo))
 	{
 		nodeV2.GET("/config", nodeHandler.GetConfig)
 		nodeV2.GET("/user", nodeHandler.GetUsers)
+		nodeV2.GET("/ws", nodeHandler.StreamEvents)
 		nodeV2.POST("/push", nodeHandler.PushTraffic)
 		nodeV2.POST("/alive", nodeHandler.PushAlive)
 		nodeV2.GET("/alivelist", nodeHandler.GetAliveList)
@@ -188,7 +260,25 @@ func main() {
 	addr := cfg.Server.GetAddress()
 	logger.Info("Starting server", zap.String("address", addr))
 
-	if err := r.Run(addr); err != nil {
-		logger.Fatal("Failed to start server", zap.Error(err))
+	srv := &http.Server{Addr: addr, Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatal("Failed to start server", zap.Error(err))
+		}
+	}()
+
+	// Wait for a shutdown signal, then flush buffered traffic
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	logger.Info("Shutting down server")
+	// Shutdown does not wait for hijacked connections, so end push streams
+	nodePushService.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("Failed to shut down server", zap.Error(err))
 	}
+	ingestService.Stop()
 }
@@ -0,0 +1,62 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
+)
+
+// Webhook posts alerts as JSON to a configured URL
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// Payload is the body sent for every alert
+type Payload struct {
+	Event     string      `json:"event"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// NewWebhook returns nil when no webhook URL is configured
+func NewWebhook(cfg *config.AlertConfig) *Webhook {
+	if cfg.WebhookURL == "" {
+		return nil
+	}
+
+	return &Webhook{
+		url:    cfg.WebhookURL,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (w *Webhook) Send(event string, data interface{}) error {
+	if w == nil {
+		return nil
+	}
+
+	body, err := json.Marshal(Payload{
+		Event:     event,
+		Timestamp: time.Now(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
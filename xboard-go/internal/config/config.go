@@ -3,16 +3,20 @@ package config
 import (
 	"encoding/json"
 	"os"
+	"strconv"
 	"time"
 )
 
 type Config struct {
-	Server     ServerConfig     `json:"server"`
-	Database   DatabaseConfig   `json:"database"`
-	Auth       AuthConfig       `json:"auth"`
-	Node       NodeConfig       `json:"node"`
-	Prometheus PrometheusConfig `json:"prometheus"`
-	Telegram   TelegramConfig   `json:"telegram"`
+	Server       ServerConfig       `json:"server"`
+	Database     DatabaseConfig     `json:"database"`
+	Auth         AuthConfig         `json:"auth"`
+	Node         NodeConfig         `json:"node"`
+	Prometheus   PrometheusConfig   `json:"prometheus"`
+	Telegram     TelegramConfig     `json:"telegram"`
+	Subscription SubscriptionConfig `json:"subscription"`
+	Alert        AlertConfig        `json:"alert"`
+	Ingest       IngestConfig       `json:"ingest"`
 }
 
 type ServerConfig struct {
@@ -21,6 +25,9 @@ type ServerConfig struct {
 	Mode           string   `json:"mode"`
 	TrustedProxies []string `json:"trusted_proxies"`
 	CORSOrigins    []string `json:"cors_origins"`
+	// PublicURL is the externally reachable base URL used in subscription
+	// links. Empty derives it from the request.
+	PublicURL string `json:"public_url"`
 }
 
 func (s *ServerConfig) GetAddress() string {
@@ -73,6 +80,77 @@ type NodeConfig struct {
 	ServerToken  string `json:"server_token"`
 	PullInterval int    `json:"pull_interval"`
 	PushInterval int    `json:"push_interval"`
+	// DisableGlobalToken stops accepting ServerToken from nodes that have
+	// no API key of their own yet
+	DisableGlobalToken bool `json:"disable_global_token"`
+	// StatusHistorySize is how many status reports are kept in memory per
+	// node for charts
+	StatusHistorySize int `json:"status_history_size"`
+	// StatusRetentionDays is how long status snapshots are kept in the
+	// database
+	StatusRetentionDays int `json:"status_retention_days"`
+	// A node is degraded once its last heartbeat is older than
+	// DegradedAfterSeconds, and offline after OfflineAfterSeconds
+	DegradedAfterSeconds int `json:"degraded_after_seconds"`
+	OfflineAfterSeconds  int `json:"offline_after_seconds"`
+	// PushReceiptRetentionHours is how long X-Request-ID values of traffic
+	// pushes are remembered to detect retries
+	PushReceiptRetentionHours int `json:"push_receipt_retention_hours"`
+	// UserChangeRetentionHours is how long user list changes are kept for
+	// nodes asking for deltas; older versions get the full list
+	UserChangeRetentionHours int `json:"user_change_retention_hours"`
+	// OnlineStaleAfterSeconds is how long an online device is kept after the
+	// last alive report listing it
+	OnlineStaleAfterSeconds int `json:"online_stale_after_seconds"`
+}
+
+func (n *NodeConfig) GetStatusHistorySize() int {
+	if n.StatusHistorySize <= 0 {
+		return 1440
+	}
+	return n.StatusHistorySize
+}
+
+func (n *NodeConfig) GetDegradedAfter() time.Duration {
+	if n.DegradedAfterSeconds <= 0 {
+		return 3 * time.Minute
+	}
+	return time.Duration(n.DegradedAfterSeconds) * time.Second
+}
+
+func (n *NodeConfig) GetOfflineAfter() time.Duration {
+	if n.OfflineAfterSeconds <= 0 {
+		return 10 * time.Minute
+	}
+	return time.Duration(n.OfflineAfterSeconds) * time.Second
+}
+
+func (n *NodeConfig) GetPushReceiptRetention() time.Duration {
+	if n.PushReceiptRetentionHours <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(n.PushReceiptRetentionHours) * time.Hour
+}
+
+func (n *NodeConfig) GetUserChangeRetention() time.Duration {
+	if n.UserChangeRetentionHours <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(n.UserChangeRetentionHours) * time.Hour
+}
+
+func (n *NodeConfig) GetOnlineStaleAfter() time.Duration {
+	if n.OnlineStaleAfterSeconds <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(n.OnlineStaleAfterSeconds) * time.Second
+}
+
+func (n *NodeConfig) GetStatusRetention() time.Duration {
+	if n.StatusRetentionDays <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return time.Duration(n.StatusRetentionDays) * 24 * time.Hour
 }
 
 type PrometheusConfig struct {
@@ -84,6 +162,50 @@ type TelegramConfig struct {
 	PollingTimeout int    `json:"polling_timeout"`
 }
 
+type SubscriptionConfig struct {
+	// DefaultPlanID is the plan users fall back to when a subscription
+	// expires. Zero leaves them without a plan.
+	DefaultPlanID uint64 `json:"default_plan_id"`
+}
+
+type AlertConfig struct {
+	// WebhookURL receives a JSON POST for node down and recovery alerts
+	WebhookURL string `json:"webhook_url"`
+}
+
+type IngestConfig struct {
+	// FlushIntervalSeconds is how often buffered traffic is written to the
+	// database
+	FlushIntervalSeconds int `json:"flush_interval_seconds"`
+	// MaxPendingEntries triggers an early flush once this many (user, node)
+	// pairs are buffered
+	MaxPendingEntries int `json:"max_pending_entries"`
+	// WALPath is the write-ahead file that keeps acknowledged traffic
+	// across crashes
+	WALPath string `json:"wal_path"`
+}
+
+func (i *IngestConfig) GetFlushInterval() time.Duration {
+	if i.FlushIntervalSeconds <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(i.FlushIntervalSeconds) * time.Second
+}
+
+func (i *IngestConfig) GetMaxPendingEntries() int {
+	if i.MaxPendingEntries <= 0 {
+		return 10000
+	}
+	return i.MaxPendingEntries
+}
+
+func (i *IngestConfig) GetWALPath() string {
+	if i.WALPath == "" {
+		return "data/traffic.wal"
+	}
+	return i.WALPath
+}
+
 func Load(configPath string) (*Config, error) {
 	file, err := os.ReadFile(configPath)
 	if err != nil {
@@ -105,6 +227,9 @@ func Load(configPath string) (*Config, error) {
 	if mode := os.Getenv("SERVER_MODE"); mode != "" {
 		cfg.Server.Mode = mode
 	}
+	if publicURL := os.Getenv("SERVER_PUBLIC_URL"); publicURL != "" {
+		cfg.Server.PublicURL = publicURL
+	}
 	if host := os.Getenv("DB_HOST"); host != "" {
 		cfg.Database.Host = host
 	}
@@ -126,12 +251,26 @@ func Load(configPath string) (*Config, error) {
 	if token := os.Getenv("NODE_SERVER_TOKEN"); token != "" {
 		cfg.Node.ServerToken = token
 	}
+	if disable := os.Getenv("NODE_DISABLE_GLOBAL_TOKEN"); disable != "" {
+		cfg.Node.DisableGlobalToken = disable == "true" || disable == "1"
+	}
 	if promURL := os.Getenv("PROMETHEUS_URL"); promURL != "" {
 		cfg.Prometheus.URL = promURL
 	}
 	if tgToken := os.Getenv("TELEGRAM_TOKEN"); tgToken != "" {
 		cfg.Telegram.Token = tgToken
 	}
+	if webhookURL := os.Getenv("ALERT_WEBHOOK_URL"); webhookURL != "" {
+		cfg.Alert.WebhookURL = webhookURL
+	}
+	if walPath := os.Getenv("INGEST_WAL_PATH"); walPath != "" {
+		cfg.Ingest.WALPath = walPath
+	}
+	if planID := os.Getenv("SUBSCRIPTION_DEFAULT_PLAN_ID"); planID != "" {
+		if id, err := strconv.ParseUint(planID, 10, 64); err == nil {
+			cfg.Subscription.DefaultPlanID = id
+		}
+	}
 
 	return &cfg, nil
 }
@@ -45,6 +45,14 @@ func AutoMigrate(db *gorm.DB) error {
 		&models.User{},
 		&models.Label{},
 		&models.Plan{},
+		&models.Subscription{},
+		&models.TrafficPack{},
+		&models.NodeStatus{},
+		&models.NodeEvent{},
+		&models.NodePushReceipt{},
+		&models.TrafficBatch{},
+		&models.MultiplierSchedule{},
+		&models.UserListChange{},
 		&models.PlanLabel{},
 		&models.PlanLabelMultiplier{},
 		&models.Node{},
@@ -55,5 +63,6 @@ func AutoMigrate(db *gorm.DB) error {
 		&models.UserUUID{},
 		&models.OnlineUser{},
 		&models.RefreshToken{},
+		&models.TelegramLinkToken{},
 	)
 }
@@ -3,22 +3,38 @@ package handler
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/protocol"
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/service"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
 type AdminHandler struct {
-	userRepo    repository.UserRepository
-	nodeRepo    repository.NodeRepository
-	planRepo    repository.PlanRepository
-	labelRepo   repository.LabelRepository
-	uuidRepo    repository.UUIDRepository
-	authService service.AuthService
+	userRepo        repository.UserRepository
+	nodeRepo        repository.NodeRepository
+	planRepo        repository.PlanRepository
+	labelRepo       repository.LabelRepository
+	uuidRepo        repository.UUIDRepository
+	authService     service.AuthService
+	accountingSvc   service.AccountingService
+	subscriptionSvc service.SubscriptionService
+	subRepo         repository.SubscriptionRepository
+	packRepo        repository.TrafficPackRepository
+	nodeKeySvc      service.NodeKeyService
+	nodeStatusSvc   service.NodeStatusService
+	nodeEventRepo   repository.NodeEventRepository
+	multipliers     service.MultiplierResolver
+	scheduleRepo    repository.MultiplierScheduleRepository
+	nodeUsers       service.NodeUserService
+	nodePush        service.NodePushService
+	onlineRepo      repository.OnlineUserRepository
+	logger          *zap.Logger
 }
 
 func NewAdminHandler(
@@ -28,14 +44,40 @@ func NewAdminHandler(
 	labelRepo repository.LabelRepository,
 	uuidRepo repository.UUIDRepository,
 	authService service.AuthService,
+	accountingSvc service.AccountingService,
+	subscriptionSvc service.SubscriptionService,
+	subRepo repository.SubscriptionRepository,
+	packRepo repository.TrafficPackRepository,
+	nodeKeySvc service.NodeKeyService,
+	nodeStatusSvc service.NodeStatusService,
+	nodeEventRepo repository.NodeEventRepository,
+	multipliers service.MultiplierResolver,
+	scheduleRepo repository.MultiplierScheduleRepository,
+	nodeUsers service.NodeUserService,
+	nodePush service.NodePushService,
+	onlineRepo repository.OnlineUserRepository,
+	logger *zap.Logger,
 ) *AdminHandler {
 	return &AdminHandler{
-		userRepo:    userRepo,
-		nodeRepo:    nodeRepo,
-		planRepo:    planRepo,
-		labelRepo:   labelRepo,
-		uuidRepo:    uuidRepo,
-		authService: authService,
+		userRepo:        userRepo,
+		nodeRepo:        nodeRepo,
+		planRepo:        planRepo,
+		labelRepo:       labelRepo,
+		uuidRepo:        uuidRepo,
+		authService:     authService,
+		accountingSvc:   accountingSvc,
+		subscriptionSvc: subscriptionSvc,
+		subRepo:         subRepo,
+		packRepo:        packRepo,
+		nodeKeySvc:      nodeKeySvc,
+		nodeStatusSvc:   nodeStatusSvc,
+		nodeEventRepo:   nodeEventRepo,
+		multipliers:     multipliers,
+		scheduleRepo:    scheduleRepo,
+		nodeUsers:       nodeUsers,
+		nodePush:        nodePush,
+		onlineRepo:      onlineRepo,
+		logger:          logger,
 	}
 }
 
@@ -83,6 +125,7 @@ func (h *AdminHandler) CreateUser(c *gin.Context) {
 		UUID:   uuid.New().String(),
 	}
 	h.uuidRepo.Create(userUUID)
+	h.nodeUsers.UsersChanged("user_created", user.ID)
 
 	c.JSON(http.StatusCreated, gin.H{
 		"user": user,
@@ -108,10 +151,10 @@ func (h *AdminHandler) ListUsers(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"users": users,
 		"pagination": gin.H{
-			"total":  total,
-			"page":   page,
-			"limit":  limit,
-			"pages":  (total + int64(limit) - 1) / int64(limit),
+			"total": total,
+			"page":  page,
+			"limit": limit,
+			"pages": (total + int64(limit) - 1) / int64(limit),
 		},
 	})
 }
@@ -148,6 +191,10 @@ type UpdateUserRequest struct {
 	Email  *string `json:"email" binding:"omitempty,email"`
 	PlanID *uint64 `json:"plan_id"`
 	Banned *bool   `json:"banned"`
+	// Per-user limit overrides; a negative value removes the override so
+	// the plan limit applies again
+	SpeedLimit  *int64 `json:"speed_limit"`
+	DeviceLimit *int64 `json:"device_limit"`
 }
 
 func (h *AdminHandler) UpdateUser(c *gin.Context) {
@@ -187,12 +234,25 @@ func (h *AdminHandler) UpdateUser(c *gin.Context) {
 	if req.Email != nil {
 		user.Email = *req.Email
 	}
-	if req.PlanID != nil {
-		user.PlanID = req.PlanID
-	}
 	if req.Banned != nil {
 		user.Banned = *req.Banned
 	}
+	if req.SpeedLimit != nil {
+		if *req.SpeedLimit < 0 {
+			user.SpeedLimit = nil
+		} else {
+			limit := uint64(*req.SpeedLimit)
+			user.SpeedLimit = &limit
+		}
+	}
+	if req.DeviceLimit != nil {
+		if *req.DeviceLimit < 0 {
+			user.DeviceLimit = nil
+		} else {
+			limit := uint(*req.DeviceLimit)
+			user.DeviceLimit = &limit
+		}
+	}
 
 	if err := h.userRepo.Update(user); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -203,6 +263,30 @@ func (h *AdminHandler) UpdateUser(c *gin.Context) {
 		})
 		return
 	}
+	h.nodeUsers.UsersChanged("user_updated", user.ID)
+
+	// A plan change restarts the usage period and replaces the user's
+	// subscription, the same as subscribing to another plan
+	if req.PlanID != nil {
+		if err := h.subscriptionSvc.AssignPlan(user.ID, *req.PlanID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": gin.H{
+					"code":    "PLAN_ASSIGNMENT_FAILED",
+					"message": err.Error(),
+				},
+			})
+			return
+		}
+		if user, err = h.userRepo.FindByID(id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": gin.H{
+					"code":    "INTERNAL_ERROR",
+					"message": "Failed to fetch user",
+				},
+			})
+			return
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"user": user,
@@ -230,26 +314,49 @@ func (h *AdminHandler) DeleteUser(c *gin.Context) {
 		})
 		return
 	}
+	h.nodeUsers.UsersChanged("user_deleted", id)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "User deleted successfully",
 	})
 }
 
-// Node management
-
-type CreateNodeRequest struct {
-	Name           string   `json:"name" binding:"required"`
-	NodeType       string   `json:"node_type" binding:"required"`
-	Host           string   `json:"host" binding:"required"`
-	Port           uint     `json:"port" binding:"required"`
-	ProtocolConfig string   `json:"protocol_config"`
-	NodeMultiplier float64  `json:"node_multiplier"`
-	LabelIDs       []uint64 `json:"label_ids"`
+// UpdateResetAnchorRequest changes only the fields present. A negative number
+// or an empty time zone removes that part of the anchor.
+type UpdateResetAnchorRequest struct {
+	DayOfMonth *int    `json:"day_of_month" binding:"omitempty,max=31,ne=0"`
+	Weekday    *int    `json:"weekday" binding:"omitempty,max=6"`
+	Month      *int    `json:"month" binding:"omitempty,max=12,ne=0"`
+	Timezone   *string `json:"timezone"`
 }
 
-func (h *AdminHandler) CreateNode(c *gin.Context) {
-	var req CreateNodeRequest
+// UpdateResetAnchor changes the day a user's usage periods reset on and splits
+// the current period so the next reset falls on the new anchor. If the split
+// fails, the previous anchor is restored.
+func (h *AdminHandler) UpdateResetAnchor(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid user ID",
+			},
+		})
+		return
+	}
+
+	user, err := h.userRepo.FindByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"code":    "USER_NOT_FOUND",
+				"message": "User not found",
+			},
+		})
+		return
+	}
+
+	var req UpdateResetAnchorRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": gin.H{
@@ -260,230 +367,1395 @@ func (h *AdminHandler) CreateNode(c *gin.Context) {
 		return
 	}
 
-	if req.NodeMultiplier == 0 {
-		req.NodeMultiplier = 1.0
+	if req.Timezone != nil && *req.Timezone != "" {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": gin.H{
+					"code":    "INVALID_TIMEZONE",
+					"message": "Unknown time zone: " + *req.Timezone,
+				},
+			})
+			return
+		}
 	}
 
-	node := &models.Node{
-		Name:           req.Name,
-		NodeType:       req.NodeType,
-		Host:           req.Host,
-		Port:           req.Port,
-		ProtocolConfig: req.ProtocolConfig,
-		NodeMultiplier: req.NodeMultiplier,
-		Status:         "active",
+	previous := *user
+	if req.DayOfMonth != nil {
+		user.ResetDay = anchorField(*req.DayOfMonth)
+	}
+	if req.Weekday != nil {
+		user.ResetWeekday = anchorField(*req.Weekday)
+	}
+	if req.Month != nil {
+		user.ResetMonth = anchorField(*req.Month)
+	}
+	if req.Timezone != nil {
+		if *req.Timezone == "" {
+			user.ResetTimezone = nil
+		} else {
+			user.ResetTimezone = req.Timezone
+		}
 	}
 
-	if err := h.nodeRepo.Create(node); err != nil {
+	if err := h.userRepo.Update(user); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": gin.H{
-				"code":    "NODE_CREATION_FAILED",
+				"code":    "UPDATE_FAILED",
 				"message": err.Error(),
 			},
 		})
 		return
 	}
 
-	// Add labels
-	for _, labelID := range req.LabelIDs {
-		h.nodeRepo.AddLabel(node.ID, labelID)
+	period, err := h.accountingSvc.SplitCurrentPeriod(user.ID)
+	if err != nil {
+		// Periods still follow the old anchor, so keep it
+		user.ResetDay = previous.ResetDay
+		user.ResetWeekday = previous.ResetWeekday
+		user.ResetMonth = previous.ResetMonth
+		user.ResetTimezone = previous.ResetTimezone
+		if restoreErr := h.userRepo.Update(user); restoreErr != nil {
+			h.logger.Error("Failed to restore reset anchor",
+				zap.Uint64("user_id", user.ID),
+				zap.Error(restoreErr),
+			)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "PERIOD_SPLIT_FAILED",
+				"message": err.Error(),
+			},
+		})
+		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"node": node,
+	c.JSON(http.StatusOK, gin.H{
+		"reset_anchor":   user.ResetAnchor(),
+		"current_period": period,
 	})
 }
 
-func (h *AdminHandler) ListNodes(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
-	offset := (page - 1) * limit
+// anchorField returns the anchor value to store, or nil for a negative value
+func anchorField(value int) *int {
+	if value < 0 {
+		return nil
+	}
+	return &value
+}
 
-	nodes, total, err := h.nodeRepo.List(offset, limit)
+// Subscription management
+
+type CreateSubscriptionRequest struct {
+	PlanID       uint64 `json:"plan_id" binding:"required"`
+	DurationDays int    `json:"duration_days" binding:"required,min=1"`
+	AutoRenew    bool   `json:"auto_renew"`
+}
+
+func (h *AdminHandler) ListUserSubscriptions(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid user ID",
+			},
+		})
+		return
+	}
+
+	subs, err := h.subRepo.ListByUser(id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": gin.H{
 				"code":    "INTERNAL_ERROR",
-				"message": "Failed to fetch nodes",
+				"message": "Failed to fetch subscriptions",
 			},
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"nodes": nodes,
-		"pagination": gin.H{
-			"total": total,
-			"page":  page,
-			"limit": limit,
-			"pages": (total + int64(limit) - 1) / int64(limit),
-		},
+		"subscriptions": subs,
 	})
 }
 
-func (h *AdminHandler) GetNode(c *gin.Context) {
+func (h *AdminHandler) CreateUserSubscription(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": gin.H{
 				"code":    "INVALID_ID",
-				"message": "Invalid node ID",
+				"message": "Invalid user ID",
 			},
 		})
 		return
 	}
 
-	node, err := h.nodeRepo.FindByID(id)
+	var req CreateSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_REQUEST",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	duration := time.Duration(req.DurationDays) * 24 * time.Hour
+	sub, err := h.subscriptionSvc.Subscribe(id, req.PlanID, duration, req.AutoRenew)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "SUBSCRIPTION_FAILED",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"subscription": sub,
+	})
+}
+
+func (h *AdminHandler) CancelUserSubscription(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid user ID",
+			},
+		})
+		return
+	}
+
+	subID, err := strconv.ParseUint(c.Param("sub_id"), 10, 64)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid subscription ID",
+			},
+		})
+		return
+	}
+
+	sub, err := h.subRepo.FindByID(subID)
+	if err != nil || sub.UserID != userID {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": gin.H{
-				"code":    "NODE_NOT_FOUND",
-				"message": "Node not found",
+				"code":    "SUBSCRIPTION_NOT_FOUND",
+				"message": "Subscription not found",
+			},
+		})
+		return
+	}
+
+	if err := h.subscriptionSvc.Cancel(subID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "CANCEL_FAILED",
+				"message": err.Error(),
 			},
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"node": node,
+		"message": "Subscription cancelled successfully",
 	})
 }
 
-type UpdateNodeRequest struct {
-	Name           *string  `json:"name"`
-	NodeType       *string  `json:"node_type"`
-	Host           *string  `json:"host"`
-	Port           *uint    `json:"port"`
-	ProtocolConfig *string  `json:"protocol_config"`
-	NodeMultiplier *float64 `json:"node_multiplier"`
-	Status         *string  `json:"status"`
-	LabelIDs       []uint64 `json:"label_ids"`
+type GrantTrafficPackRequest struct {
+	Bytes     uint64     `json:"bytes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	Priority  int        `json:"priority"`
+	Source    string     `json:"source" binding:"omitempty,oneof=grant purchase"`
+	Note      string     `json:"note"`
 }
 
-func (h *AdminHandler) UpdateNode(c *gin.Context) {
+func (h *AdminHandler) ListUserTrafficPacks(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": gin.H{
 				"code":    "INVALID_ID",
-				"message": "Invalid node ID",
+				"message": "Invalid user ID",
+			},
+		})
+		return
+	}
+
+	packs, err := h.packRepo.ListByUser(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to fetch traffic packs",
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"packs": packs,
+	})
+}
+
+func (h *AdminHandler) GrantTrafficPack(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid user ID",
+			},
+		})
+		return
+	}
+
+	var req GrantTrafficPackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_REQUEST",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_REQUEST",
+				"message": "expires_at must be in the future",
+			},
+		})
+		return
+	}
+
+	if _, err := h.userRepo.FindByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"code":    "USER_NOT_FOUND",
+				"message": "User not found",
+			},
+		})
+		return
+	}
+
+	source := req.Source
+	if source == "" {
+		source = "grant"
+	}
+
+	pack := &models.TrafficPack{
+		UserID:    id,
+		Bytes:     req.Bytes,
+		Priority:  req.Priority,
+		Source:    source,
+		Note:      req.Note,
+		ExpiresAt: req.ExpiresAt,
+	}
+
+	if err := h.packRepo.Create(pack); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to grant traffic pack",
 			},
 		})
 		return
 	}
+	h.nodeUsers.UsersChanged("pack_granted", id)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"pack": pack,
+	})
+}
+
+func (h *AdminHandler) RevokeTrafficPack(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid user ID",
+			},
+		})
+		return
+	}
+
+	packID, err := strconv.ParseUint(c.Param("pack_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid pack ID",
+			},
+		})
+		return
+	}
+
+	pack, err := h.packRepo.FindByID(packID)
+	if err != nil || pack.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"code":    "PACK_NOT_FOUND",
+				"message": "Traffic pack not found",
+			},
+		})
+		return
+	}
+
+	if err := h.packRepo.Revoke(packID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to revoke traffic pack",
+			},
+		})
+		return
+	}
+	h.nodeUsers.UsersChanged("pack_revoked", userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Traffic pack revoked successfully",
+	})
+}
+
+// ListUserOnlineIPs returns the IPs a user is online from, most recently
+// seen first, with the nodes reporting each. Each IP counts as one device
+// towards the device limit, however many nodes report it.
+func (h *AdminHandler) ListUserOnlineIPs(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid user ID",
+			},
+		})
+		return
+	}
+
+	onlineUsers, err := h.onlineRepo.ListByUser(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to fetch online IPs",
+			},
+		})
+		return
+	}
+
+	// Rows come most recent first, so the first row of an IP is its latest
+	ips := []models.OnlineIPDTO{}
+	index := make(map[string]int)
+	for _, online := range onlineUsers {
+		i, ok := index[online.IPAddress]
+		if !ok {
+			i = len(ips)
+			index[online.IPAddress] = i
+			ips = append(ips, models.OnlineIPDTO{
+				IP:         online.IPAddress,
+				LastSeenAt: online.LastSeenAt,
+			})
+		}
+		ips[i].Nodes = append(ips[i].Nodes, models.OnlineIPNodeDTO{
+			NodeID:         online.NodeID,
+			NodeIdentifier: online.NodeIdentifier,
+			LastSeenAt:     online.LastSeenAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_count": len(ips),
+		"ips":          ips,
+	})
+}
+
+// Node management
+
+type CreateNodeRequest struct {
+	Name           string   `json:"name" binding:"required"`
+	NodeType       string   `json:"node_type" binding:"required"`
+	Host           string   `json:"host" binding:"required"`
+	Port           uint     `json:"port" binding:"required"`
+	ProtocolConfig string   `json:"protocol_config"`
+	NodeMultiplier float64  `json:"node_multiplier"`
+	LabelIDs       []uint64 `json:"label_ids"`
+}
+
+func (h *AdminHandler) CreateNode(c *gin.Context) {
+	var req CreateNodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_REQUEST",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	if req.NodeMultiplier == 0 {
+		req.NodeMultiplier = 1.0
+	}
+
+	req.NodeType = protocol.NormalizeNodeType(req.NodeType)
+	if _, err := protocol.Parse(req.NodeType, req.ProtocolConfig); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_PROTOCOL_CONFIG",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	node := &models.Node{
+		Name:           req.Name,
+		NodeType:       req.NodeType,
+		Host:           req.Host,
+		Port:           req.Port,
+		ProtocolConfig: req.ProtocolConfig,
+		NodeMultiplier: req.NodeMultiplier,
+		Status:         "active",
+	}
+
+	if err := h.nodeRepo.Create(node); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "NODE_CREATION_FAILED",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	// Add labels
+	for _, labelID := range req.LabelIDs {
+		h.nodeRepo.AddLabel(node.ID, labelID)
+	}
+
+	apiKey, err := h.nodeKeySvc.RotateKey(node, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "NODE_CREATION_FAILED",
+				"message": "Failed to generate node API key",
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"node":    node,
+		"api_key": apiKey,
+	})
+}
+
+func (h *AdminHandler) ListNodes(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset := (page - 1) * limit
+
+	nodes, total, err := h.nodeRepo.List(offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to fetch nodes",
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"nodes": nodes,
+		"pagination": gin.H{
+			"total": total,
+			"page":  page,
+			"limit": limit,
+			"pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+func (h *AdminHandler) GetNode(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid node ID",
+			},
+		})
+		return
+	}
+
+	node, err := h.nodeRepo.FindByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"code":    "NODE_NOT_FOUND",
+				"message": "Node not found",
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"node": node,
+	})
+}
+
+type UpdateNodeRequest struct {
+	Name           *string  `json:"name"`
+	NodeType       *string  `json:"node_type"`
+	Host           *string  `json:"host"`
+	Port           *uint    `json:"port"`
+	ProtocolConfig *string  `json:"protocol_config"`
+	NodeMultiplier *float64 `json:"node_multiplier"`
+	Status         *string  `json:"status"`
+	LabelIDs       []uint64 `json:"label_ids"`
+}
+
+func (h *AdminHandler) UpdateNode(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid node ID",
+			},
+		})
+		return
+	}
+
+	node, err := h.nodeRepo.FindByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"code":    "NODE_NOT_FOUND",
+				"message": "Node not found",
+			},
+		})
+		return
+	}
+
+	var req UpdateNodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_REQUEST",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	if req.Name != nil {
+		node.Name = *req.Name
+	}
+	if req.NodeType != nil {
+		node.NodeType = protocol.NormalizeNodeType(*req.NodeType)
+	}
+	if req.Host != nil {
+		node.Host = *req.Host
+	}
+	if req.Port != nil {
+		node.Port = *req.Port
+	}
+	if req.ProtocolConfig != nil {
+		node.ProtocolConfig = *req.ProtocolConfig
+	}
+	if req.NodeMultiplier != nil {
+		node.NodeMultiplier = *req.NodeMultiplier
+	}
+	if req.Status != nil {
+		node.Status = *req.Status
+	}
+
+	if _, err := protocol.Parse(node.NodeType, node.ProtocolConfig); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_PROTOCOL_CONFIG",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	if err := h.nodeRepo.Update(node); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "UPDATE_FAILED",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	// Update labels if provided
+	if req.LabelIDs != nil {
+		// Remove all existing labels
+		if node.Labels != nil {
+			for _, label := range node.Labels {
+				h.nodeRepo.RemoveLabel(node.ID, label.ID)
+			}
+		}
+		// Add new labels
+		for _, labelID := range req.LabelIDs {
+			h.nodeRepo.AddLabel(node.ID, labelID)
+		}
+	}
+	h.multipliers.InvalidateNode(node.ID)
+	h.nodeUsers.NodeChanged("node_updated", node.ID)
+	h.nodePush.ConfigChanged(node.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"node": node,
+	})
+}
+
+func (h *AdminHandler) GetNodeStatus(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid node ID",
+			},
+		})
+		return
+	}
+
+	node, err := h.nodeRepo.FindByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"code":    "NODE_NOT_FOUND",
+				"message": "Node not found",
+			},
+		})
+		return
+	}
+
+	status, ok := h.nodeStatusSvc.Latest(id)
+	if !ok {
+		status = nil
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":       status,
+		"last_seen_at": node.LastSeenAt,
+	})
+}
+
+func (h *AdminHandler) GetNodeStatusHistory(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid node ID",
+			},
+		})
+		return
+	}
+
+	window, err := time.ParseDuration(c.DefaultQuery("range", "1h"))
+	if err != nil || window <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_REQUEST",
+				"message": "range must be a positive duration like 30m or 6h",
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"history": h.nodeStatusSvc.History(id, time.Now().Add(-window)),
+	})
+}
+
+func (h *AdminHandler) ListNodeEvents(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid node ID",
+			},
+		})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	events, err := h.nodeEventRepo.ListByNode(id, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to fetch node events",
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+	})
+}
+
+type RotateNodeKeyRequest struct {
+	// GracePeriodSeconds keeps the old key valid for this long
+	GracePeriodSeconds int `json:"grace_period_seconds" binding:"min=0,max=604800"`
+}
+
+func (h *AdminHandler) RotateNodeKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid node ID",
+			},
+		})
+		return
+	}
+
+	var req RotateNodeKeyRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": gin.H{
+					"code":    "INVALID_REQUEST",
+					"message": err.Error(),
+				},
+			})
+			return
+		}
+	}
+
+	node, err := h.nodeRepo.FindByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"code":    "NODE_NOT_FOUND",
+				"message": "Node not found",
+			},
+		})
+		return
+	}
+
+	apiKey, err := h.nodeKeySvc.RotateKey(node, time.Duration(req.GracePeriodSeconds)*time.Second)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "ROTATION_FAILED",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_key":                 apiKey,
+		"previous_key_expires_at": node.PreviousAPIKeyExpiresAt,
+	})
+}
+
+func (h *AdminHandler) RevokePreviousNodeKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid node ID",
+			},
+		})
+		return
+	}
+
+	node, err := h.nodeRepo.FindByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"code":    "NODE_NOT_FOUND",
+				"message": "Node not found",
+			},
+		})
+		return
+	}
+
+	if err := h.nodeKeySvc.RevokePreviousKey(node); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to revoke previous key",
+			},
+		})
+		return
+	}
+	// Streams opened with the revoked key must reconnect with the current one
+	h.nodePush.Disconnect(node.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Previous key revoked successfully",
+	})
+}
+
+func (h *AdminHandler) DeleteNode(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid node ID",
+			},
+		})
+		return
+	}
+
+	if err := h.nodeRepo.Delete(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "DELETE_FAILED",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+	h.multipliers.InvalidateNode(id)
+	h.nodePush.Disconnect(id)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Node deleted successfully",
+	})
+}
+
+// Plan management
+
+type CreatePlanRequest struct {
+	Name               string   `json:"name" binding:"required"`
+	QuotaBytes         uint64   `json:"quota_bytes" binding:"required"`
+	ResetPeriod        string   `json:"reset_period" binding:"required,oneof=none daily weekly monthly yearly"`
+	BaseMultiplier     float64  `json:"base_multiplier"`
+	SpeedLimit         uint64   `json:"speed_limit"`
+	DeviceLimit        uint     `json:"device_limit"`
+	LabelIDs           []uint64 `json:"label_ids"`
+	MultiplierStrategy string   `json:"multiplier_strategy" binding:"omitempty,oneof=product max min priority sum"`
+}
+
+func (h *AdminHandler) CreatePlan(c *gin.Context) {
+	var req CreatePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_REQUEST",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	if req.BaseMultiplier == 0 {
+		req.BaseMultiplier = 1.0
+	}
+	if req.MultiplierStrategy == "" {
+		req.MultiplierStrategy = models.MultiplierStrategyProduct
+	}
+
+	plan := &models.Plan{
+		Name:               req.Name,
+		QuotaBytes:         req.QuotaBytes,
+		ResetPeriod:        req.ResetPeriod,
+		BaseMultiplier:     req.BaseMultiplier,
+		SpeedLimit:         req.SpeedLimit,
+		DeviceLimit:        req.DeviceLimit,
+		MultiplierStrategy: req.MultiplierStrategy,
+	}
+
+	if err := h.planRepo.Create(plan); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "PLAN_CREATION_FAILED",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	// Add labels
+	for _, labelID := range req.LabelIDs {
+		h.planRepo.AddLabel(plan.ID, labelID)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"plan": plan,
+	})
+}
+
+func (h *AdminHandler) ListPlans(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset := (page - 1) * limit
+
+	plans, total, err := h.planRepo.List(offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to fetch plans",
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"plans": plans,
+		"pagination": gin.H{
+			"total": total,
+			"page":  page,
+			"limit": limit,
+			"pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+func (h *AdminHandler) GetPlan(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid plan ID",
+			},
+		})
+		return
+	}
+
+	plan, err := h.planRepo.FindByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"code":    "PLAN_NOT_FOUND",
+				"message": "Plan not found",
+			},
+		})
+		return
+	}
+
+	labelMultipliers, err := h.planRepo.GetAllLabelMultipliers(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to fetch label multipliers",
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"plan":              plan,
+		"label_multipliers": labelMultipliers,
+	})
+}
+
+type UpdatePlanRequest struct {
+	Name               *string  `json:"name"`
+	QuotaBytes         *uint64  `json:"quota_bytes"`
+	ResetPeriod        *string  `json:"reset_period"`
+	BaseMultiplier     *float64 `json:"base_multiplier"`
+	SpeedLimit         *uint64  `json:"speed_limit"`
+	DeviceLimit        *uint    `json:"device_limit"`
+	LabelIDs           []uint64 `json:"label_ids"`
+	MultiplierStrategy *string  `json:"multiplier_strategy" binding:"omitempty,oneof=product max min priority sum"`
+}
+
+func (h *AdminHandler) UpdatePlan(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid plan ID",
+			},
+		})
+		return
+	}
+
+	plan, err := h.planRepo.FindByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"code":    "PLAN_NOT_FOUND",
+				"message": "Plan not found",
+			},
+		})
+		return
+	}
+
+	var req UpdatePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_REQUEST",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	if req.Name != nil {
+		plan.Name = *req.Name
+	}
+	if req.QuotaBytes != nil {
+		plan.QuotaBytes = *req.QuotaBytes
+	}
+	if req.ResetPeriod != nil {
+		plan.ResetPeriod = *req.ResetPeriod
+	}
+	if req.BaseMultiplier != nil {
+		plan.BaseMultiplier = *req.BaseMultiplier
+	}
+	if req.SpeedLimit != nil {
+		plan.SpeedLimit = *req.SpeedLimit
+	}
+	if req.DeviceLimit != nil {
+		plan.DeviceLimit = *req.DeviceLimit
+	}
+	if req.MultiplierStrategy != nil {
+		plan.MultiplierStrategy = *req.MultiplierStrategy
+	}
+
+	if err := h.planRepo.Update(plan); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "UPDATE_FAILED",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	// Update labels if provided. Multipliers of removed labels go with them.
+	if req.LabelIDs != nil {
+		if err := h.planRepo.SetLabels(plan.ID, req.LabelIDs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": gin.H{
+					"code":    "UPDATE_FAILED",
+					"message": err.Error(),
+				},
+			})
+			return
+		}
+	}
+	h.multipliers.InvalidatePlan(plan.ID)
+	h.nodeUsers.PlanChanged("plan_updated", plan.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"plan": plan,
+	})
+}
+
+func (h *AdminHandler) DeletePlan(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid plan ID",
+			},
+		})
+		return
+	}
+
+	if err := h.planRepo.Delete(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "DELETE_FAILED",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+	h.multipliers.InvalidatePlan(id)
+	// Its users are no longer on the plan, so they cannot be looked up by it
+	h.nodeUsers.AllChanged("plan_deleted")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Plan deleted successfully",
+	})
+}
+
+// Plan label multipliers
+
+func (h *AdminHandler) ListPlanMultipliers(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid plan ID",
+			},
+		})
+		return
+	}
+
+	if _, err := h.planRepo.FindByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"code":    "PLAN_NOT_FOUND",
+				"message": "Plan not found",
+			},
+		})
+		return
+	}
+
+	labelMultipliers, err := h.planRepo.GetAllLabelMultipliers(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to fetch label multipliers",
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"plan_id":           id,
+		"label_multipliers": labelMultipliers,
+	})
+}
+
+type SetPlanMultiplierRequest struct {
+	Multiplier float64 `json:"multiplier" binding:"required,gt=0"`
+}
+
+// SetPlanMultiplier creates or replaces the plan's multiplier for one of its
+// labels
+func (h *AdminHandler) SetPlanMultiplier(c *gin.Context) {
+	planID, labelID, ok := h.planLabelParams(c)
+	if !ok {
+		return
+	}
+
+	var req SetPlanMultiplierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_REQUEST",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	plan, err := h.planRepo.FindByIDWithLabels(planID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"code":    "PLAN_NOT_FOUND",
+				"message": "Plan not found",
+			},
+		})
+		return
+	}
+	attached := false
+	for _, label := range plan.Labels {
+		if label.ID == labelID {
+			attached = true
+			break
+		}
+	}
+	if !attached {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "LABEL_NOT_IN_PLAN",
+				"message": "Label is not attached to the plan",
+			},
+		})
+		return
+	}
+
+	if err := h.planRepo.SetLabelMultiplier(planID, labelID, req.Multiplier); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "UPDATE_FAILED",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+	h.multipliers.InvalidatePlan(planID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"plan_id":    planID,
+		"label_id":   labelID,
+		"multiplier": req.Multiplier,
+	})
+}
+
+func (h *AdminHandler) DeletePlanMultiplier(c *gin.Context) {
+	planID, labelID, ok := h.planLabelParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.planRepo.DeleteLabelMultiplier(planID, labelID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "DELETE_FAILED",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+	h.multipliers.InvalidatePlan(planID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Label multiplier deleted successfully",
+	})
+}
+
+// planLabelParams parses the plan and label IDs of a plan multiplier route,
+// responding with an error if either is invalid
+func (h *AdminHandler) planLabelParams(c *gin.Context) (uint64, uint64, bool) {
+	planID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid plan ID",
+			},
+		})
+		return 0, 0, false
+	}
+	labelID, err := strconv.ParseUint(c.Param("label_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid label ID",
+			},
+		})
+		return 0, 0, false
+	}
+	return planID, labelID, true
+}
+
+// ExplainMultiplier is a dry run of the multiplier a user would be billed at
+// on a node, with the labels, schedules and factors that produced it. The
+// optional at parameter (RFC 3339) evaluates schedules at another time.
+func (h *AdminHandler) ExplainMultiplier(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Query("user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid user ID",
+			},
+		})
+		return
+	}
+	nodeID, err := strconv.ParseUint(c.Query("node_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid node ID",
+			},
+		})
+		return
+	}
+	at := time.Now()
+	if value := c.Query("at"); value != "" {
+		at, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": gin.H{
+					"code":    "INVALID_REQUEST",
+					"message": "Invalid at, want RFC 3339",
+				},
+			})
+			return
+		}
+	}
 
-	node, err := h.nodeRepo.FindByID(id)
+	user, err := h.userRepo.FindByID(userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": gin.H{
-				"code":    "NODE_NOT_FOUND",
-				"message": "Node not found",
+				"code":    "USER_NOT_FOUND",
+				"message": "User not found",
 			},
 		})
 		return
 	}
-
-	var req UpdateNodeRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if user.PlanID == nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": gin.H{
-				"code":    "INVALID_REQUEST",
-				"message": err.Error(),
+				"code":    "NO_PLAN",
+				"message": "User has no plan",
 			},
 		})
 		return
 	}
 
-	if req.Name != nil {
-		node.Name = *req.Name
-	}
-	if req.NodeType != nil {
-		node.NodeType = *req.NodeType
-	}
-	if req.Host != nil {
-		node.Host = *req.Host
-	}
-	if req.Port != nil {
-		node.Port = *req.Port
-	}
-	if req.ProtocolConfig != nil {
-		node.ProtocolConfig = *req.ProtocolConfig
-	}
-	if req.NodeMultiplier != nil {
-		node.NodeMultiplier = *req.NodeMultiplier
-	}
-	if req.Status != nil {
-		node.Status = *req.Status
-	}
-
-	if err := h.nodeRepo.Update(node); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+	if _, err := h.nodeRepo.FindByID(nodeID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
 			"error": gin.H{
-				"code":    "UPDATE_FAILED",
-				"message": err.Error(),
+				"code":    "NODE_NOT_FOUND",
+				"message": "Node not found",
 			},
 		})
 		return
 	}
 
-	// Update labels if provided
-	if req.LabelIDs != nil {
-		// Remove all existing labels
-		if node.Labels != nil {
-			for _, label := range node.Labels {
-				h.nodeRepo.RemoveLabel(node.ID, label.ID)
-			}
-		}
-		// Add new labels
-		for _, labelID := range req.LabelIDs {
-			h.nodeRepo.AddLabel(node.ID, labelID)
-		}
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"node": node,
-	})
-}
-
-func (h *AdminHandler) DeleteNode(c *gin.Context) {
-	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	explanation, err := h.multipliers.Explain(*user.PlanID, nodeID, at)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": gin.H{
-				"code":    "INVALID_ID",
-				"message": "Invalid node ID",
-			},
-		})
-		return
-	}
-
-	if err := h.nodeRepo.Delete(id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": gin.H{
-				"code":    "DELETE_FAILED",
-				"message": err.Error(),
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to calculate multiplier",
 			},
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Node deleted successfully",
+		"user_id":     user.ID,
+		"plan_id":     *user.PlanID,
+		"node_id":     nodeID,
+		"explanation": explanation,
 	})
 }
 
-// Plan management
+// Label management
 
-type CreatePlanRequest struct {
-	Name           string   `json:"name" binding:"required"`
-	QuotaBytes     uint64   `json:"quota_bytes" binding:"required"`
-	ResetPeriod    string   `json:"reset_period" binding:"required,oneof=none daily weekly monthly yearly"`
-	BaseMultiplier float64  `json:"base_multiplier"`
-	LabelIDs       []uint64 `json:"label_ids"`
+type CreateLabelRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+	Priority    int    `json:"priority"`
 }
 
-func (h *AdminHandler) CreatePlan(c *gin.Context) {
-	var req CreatePlanRequest
+func (h *AdminHandler) CreateLabel(c *gin.Context) {
+	var req CreateLabelRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": gin.H{
@@ -494,55 +1766,45 @@ func (h *AdminHandler) CreatePlan(c *gin.Context) {
 		return
 	}
 
-	if req.BaseMultiplier == 0 {
-		req.BaseMultiplier = 1.0
-	}
-
-	plan := &models.Plan{
-		Name:           req.Name,
-		QuotaBytes:     req.QuotaBytes,
-		ResetPeriod:    req.ResetPeriod,
-		BaseMultiplier: req.BaseMultiplier,
+	label := &models.Label{
+		Name:        req.Name,
+		Description: req.Description,
+		Priority:    req.Priority,
 	}
 
-	if err := h.planRepo.Create(plan); err != nil {
+	if err := h.labelRepo.Create(label); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": gin.H{
-				"code":    "PLAN_CREATION_FAILED",
+				"code":    "LABEL_CREATION_FAILED",
 				"message": err.Error(),
 			},
 		})
 		return
 	}
 
-	// Add labels
-	for _, labelID := range req.LabelIDs {
-		h.planRepo.AddLabel(plan.ID, labelID)
-	}
-
 	c.JSON(http.StatusCreated, gin.H{
-		"plan": plan,
+		"label": label,
 	})
 }
 
-func (h *AdminHandler) ListPlans(c *gin.Context) {
+func (h *AdminHandler) ListLabels(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 	offset := (page - 1) * limit
 
-	plans, total, err := h.planRepo.List(offset, limit)
+	labels, total, err := h.labelRepo.List(offset, limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": gin.H{
 				"code":    "INTERNAL_ERROR",
-				"message": "Failed to fetch plans",
+				"message": "Failed to fetch labels",
 			},
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"plans": plans,
+		"labels": labels,
 		"pagination": gin.H{
 			"total": total,
 			"page":  page,
@@ -552,66 +1814,64 @@ func (h *AdminHandler) ListPlans(c *gin.Context) {
 	})
 }
 
-func (h *AdminHandler) GetPlan(c *gin.Context) {
+func (h *AdminHandler) GetLabel(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": gin.H{
 				"code":    "INVALID_ID",
-				"message": "Invalid plan ID",
+				"message": "Invalid label ID",
 			},
 		})
 		return
 	}
 
-	plan, err := h.planRepo.FindByID(id)
+	label, err := h.labelRepo.FindByID(id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": gin.H{
-				"code":    "PLAN_NOT_FOUND",
-				"message": "Plan not found",
+				"code":    "LABEL_NOT_FOUND",
+				"message": "Label not found",
 			},
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"plan": plan,
+		"label": label,
 	})
 }
 
-type UpdatePlanRequest struct {
-	Name           *string  `json:"name"`
-	QuotaBytes     *uint64  `json:"quota_bytes"`
-	ResetPeriod    *string  `json:"reset_period"`
-	BaseMultiplier *float64 `json:"base_multiplier"`
-	LabelIDs       []uint64 `json:"label_ids"`
+type UpdateLabelRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Priority    *int    `json:"priority"`
 }
 
-func (h *AdminHandler) UpdatePlan(c *gin.Context) {
+func (h *AdminHandler) UpdateLabel(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": gin.H{
 				"code":    "INVALID_ID",
-				"message": "Invalid plan ID",
+				"message": "Invalid label ID",
 			},
 		})
 		return
 	}
 
-	plan, err := h.planRepo.FindByID(id)
+	label, err := h.labelRepo.FindByID(id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": gin.H{
-				"code":    "PLAN_NOT_FOUND",
-				"message": "Plan not found",
+				"code":    "LABEL_NOT_FOUND",
+				"message": "Label not found",
 			},
 		})
 		return
 	}
 
-	var req UpdatePlanRequest
+	var req UpdateLabelRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": gin.H{
@@ -623,19 +1883,16 @@ func (h *AdminHandler) UpdatePlan(c *gin.Context) {
 	}
 
 	if req.Name != nil {
-		plan.Name = *req.Name
-	}
-	if req.QuotaBytes != nil {
-		plan.QuotaBytes = *req.QuotaBytes
+		label.Name = *req.Name
 	}
-	if req.ResetPeriod != nil {
-		plan.ResetPeriod = *req.ResetPeriod
+	if req.Description != nil {
+		label.Description = *req.Description
 	}
-	if req.BaseMultiplier != nil {
-		plan.BaseMultiplier = *req.BaseMultiplier
+	if req.Priority != nil {
+		label.Priority = *req.Priority
 	}
 
-	if err := h.planRepo.Update(plan); err != nil {
+	if err := h.labelRepo.Update(label); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": gin.H{
 				"code":    "UPDATE_FAILED",
@@ -644,39 +1901,28 @@ func (h *AdminHandler) UpdatePlan(c *gin.Context) {
 		})
 		return
 	}
-
-	// Update labels if provided
-	if req.LabelIDs != nil {
-		// Remove all existing labels
-		if plan.Labels != nil {
-			for _, label := range plan.Labels {
-				h.planRepo.RemoveLabel(plan.ID, label.ID)
-			}
-		}
-		// Add new labels
-		for _, labelID := range req.LabelIDs {
-			h.planRepo.AddLabel(plan.ID, labelID)
-		}
+	if req.Priority != nil {
+		h.multipliers.InvalidateAll()
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"plan": plan,
+		"label": label,
 	})
 }
 
-func (h *AdminHandler) DeletePlan(c *gin.Context) {
+func (h *AdminHandler) DeleteLabel(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": gin.H{
 				"code":    "INVALID_ID",
-				"message": "Invalid plan ID",
+				"message": "Invalid label ID",
 			},
 		})
 		return
 	}
 
-	if err := h.planRepo.Delete(id); err != nil {
+	if err := h.labelRepo.Delete(id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": gin.H{
 				"code":    "DELETE_FAILED",
@@ -685,21 +1931,32 @@ func (h *AdminHandler) DeletePlan(c *gin.Context) {
 		})
 		return
 	}
+	// Label multipliers and node labels referencing it are gone
+	h.multipliers.InvalidateAll()
+	h.nodeUsers.AllChanged("label_deleted")
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Plan deleted successfully",
+		"message": "Label deleted successfully",
 	})
 }
 
-// Label management
-
-type CreateLabelRequest struct {
-	Name        string `json:"name" binding:"required"`
-	Description string `json:"description"`
+// Multiplier schedules
+
+type CreateMultiplierScheduleRequest struct {
+	Name       string  `json:"name"`
+	NodeID     *uint64 `json:"node_id"`
+	LabelID    *uint64 `json:"label_id"`
+	PlanID     *uint64 `json:"plan_id"`
+	Timezone   string  `json:"timezone"`
+	Weekdays   *uint8  `json:"weekdays"`
+	StartTime  string  `json:"start_time" binding:"required"`
+	EndTime    string  `json:"end_time" binding:"required"`
+	Multiplier float64 `json:"multiplier" binding:"required,gt=0"`
+	Enabled    *bool   `json:"enabled"`
 }
 
-func (h *AdminHandler) CreateLabel(c *gin.Context) {
-	var req CreateLabelRequest
+func (h *AdminHandler) CreateMultiplierSchedule(c *gin.Context) {
+	var req CreateMultiplierScheduleRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": gin.H{
@@ -710,110 +1967,159 @@ func (h *AdminHandler) CreateLabel(c *gin.Context) {
 		return
 	}
 
-	label := &models.Label{
-		Name:        req.Name,
-		Description: req.Description,
+	schedule := &models.MultiplierSchedule{
+		Name:       req.Name,
+		NodeID:     req.NodeID,
+		LabelID:    req.LabelID,
+		PlanID:     req.PlanID,
+		Timezone:   "UTC",
+		Weekdays:   models.AllWeekdays,
+		StartTime:  req.StartTime,
+		EndTime:    req.EndTime,
+		Multiplier: req.Multiplier,
+		Enabled:    true,
+	}
+	if req.Timezone != "" {
+		schedule.Timezone = req.Timezone
+	}
+	if req.Weekdays != nil {
+		schedule.Weekdays = *req.Weekdays
+	}
+	if req.Enabled != nil {
+		schedule.Enabled = *req.Enabled
 	}
 
-	if err := h.labelRepo.Create(label); err != nil {
+	if err := schedule.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_SCHEDULE",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+	if !h.scheduleTargetExists(schedule) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"code":    "TARGET_NOT_FOUND",
+				"message": "Schedule node, label or plan not found",
+			},
+		})
+		return
+	}
+
+	if err := h.scheduleRepo.Create(schedule); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": gin.H{
-				"code":    "LABEL_CREATION_FAILED",
+				"code":    "SCHEDULE_CREATION_FAILED",
 				"message": err.Error(),
 			},
 		})
 		return
 	}
+	h.multipliers.InvalidateAll()
 
 	c.JSON(http.StatusCreated, gin.H{
-		"label": label,
+		"schedule": schedule,
 	})
 }
 
-func (h *AdminHandler) ListLabels(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
-	offset := (page - 1) * limit
+// scheduleTargetExists reports whether the node, label or plan a schedule is
+// attached to exists
+func (h *AdminHandler) scheduleTargetExists(schedule *models.MultiplierSchedule) bool {
+	var err error
+	switch schedule.Scope() {
+	case models.ScheduleScopeNode:
+		_, err = h.nodeRepo.FindByID(*schedule.NodeID)
+	case models.ScheduleScopeLabel:
+		_, err = h.labelRepo.FindByID(*schedule.LabelID)
+	default:
+		_, err = h.planRepo.FindByID(*schedule.PlanID)
+	}
+	return err == nil
+}
 
-	labels, total, err := h.labelRepo.List(offset, limit)
+func (h *AdminHandler) ListMultiplierSchedules(c *gin.Context) {
+	schedules, err := h.scheduleRepo.List()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": gin.H{
 				"code":    "INTERNAL_ERROR",
-				"message": "Failed to fetch labels",
+				"message": "Failed to fetch schedules",
 			},
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"labels": labels,
-		"pagination": gin.H{
-			"total": total,
-			"page":  page,
-			"limit": limit,
-			"pages": (total + int64(limit) - 1) / int64(limit),
-		},
+		"schedules": schedules,
 	})
 }
 
-func (h *AdminHandler) GetLabel(c *gin.Context) {
+func (h *AdminHandler) GetMultiplierSchedule(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": gin.H{
 				"code":    "INVALID_ID",
-				"message": "Invalid label ID",
+				"message": "Invalid schedule ID",
 			},
 		})
 		return
 	}
 
-	label, err := h.labelRepo.FindByID(id)
+	schedule, err := h.scheduleRepo.FindByID(id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": gin.H{
-				"code":    "LABEL_NOT_FOUND",
-				"message": "Label not found",
+				"code":    "SCHEDULE_NOT_FOUND",
+				"message": "Schedule not found",
 			},
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"label": label,
+		"schedule": schedule,
 	})
 }
 
-type UpdateLabelRequest struct {
-	Name        *string `json:"name"`
-	Description *string `json:"description"`
+// UpdateMultiplierScheduleRequest changes a schedule's window or multiplier.
+// What it is attached to cannot change; delete and recreate it instead.
+type UpdateMultiplierScheduleRequest struct {
+	Name       *string  `json:"name"`
+	Timezone   *string  `json:"timezone"`
+	Weekdays   *uint8   `json:"weekdays"`
+	StartTime  *string  `json:"start_time"`
+	EndTime    *string  `json:"end_time"`
+	Multiplier *float64 `json:"multiplier"`
+	Enabled    *bool    `json:"enabled"`
 }
 
-func (h *AdminHandler) UpdateLabel(c *gin.Context) {
+func (h *AdminHandler) UpdateMultiplierSchedule(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": gin.H{
 				"code":    "INVALID_ID",
-				"message": "Invalid label ID",
+				"message": "Invalid schedule ID",
 			},
 		})
 		return
 	}
 
-	label, err := h.labelRepo.FindByID(id)
+	schedule, err := h.scheduleRepo.FindByID(id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": gin.H{
-				"code":    "LABEL_NOT_FOUND",
-				"message": "Label not found",
+				"code":    "SCHEDULE_NOT_FOUND",
+				"message": "Schedule not found",
 			},
 		})
 		return
 	}
 
-	var req UpdateLabelRequest
+	var req UpdateMultiplierScheduleRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": gin.H{
@@ -825,13 +2131,38 @@ func (h *AdminHandler) UpdateLabel(c *gin.Context) {
 	}
 
 	if req.Name != nil {
-		label.Name = *req.Name
+		schedule.Name = *req.Name
 	}
-	if req.Description != nil {
-		label.Description = *req.Description
+	if req.Timezone != nil {
+		schedule.Timezone = *req.Timezone
+	}
+	if req.Weekdays != nil {
+		schedule.Weekdays = *req.Weekdays
+	}
+	if req.StartTime != nil {
+		schedule.StartTime = *req.StartTime
+	}
+	if req.EndTime != nil {
+		schedule.EndTime = *req.EndTime
+	}
+	if req.Multiplier != nil {
+		schedule.Multiplier = *req.Multiplier
+	}
+	if req.Enabled != nil {
+		schedule.Enabled = *req.Enabled
 	}
 
-	if err := h.labelRepo.Update(label); err != nil {
+	if err := schedule.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_SCHEDULE",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	if err := h.scheduleRepo.Update(schedule); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": gin.H{
 				"code":    "UPDATE_FAILED",
@@ -840,25 +2171,26 @@ func (h *AdminHandler) UpdateLabel(c *gin.Context) {
 		})
 		return
 	}
+	h.multipliers.InvalidateAll()
 
 	c.JSON(http.StatusOK, gin.H{
-		"label": label,
+		"schedule": schedule,
 	})
 }
 
-func (h *AdminHandler) DeleteLabel(c *gin.Context) {
+func (h *AdminHandler) DeleteMultiplierSchedule(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": gin.H{
 				"code":    "INVALID_ID",
-				"message": "Invalid label ID",
+				"message": "Invalid schedule ID",
 			},
 		})
 		return
 	}
 
-	if err := h.labelRepo.Delete(id); err != nil {
+	if err := h.scheduleRepo.Delete(id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": gin.H{
 				"code":    "DELETE_FAILED",
@@ -867,8 +2199,9 @@ func (h *AdminHandler) DeleteLabel(c *gin.Context) {
 		})
 		return
 	}
+	h.multipliers.InvalidateAll()
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Label deleted successfully",
+		"message": "Schedule deleted successfully",
 	})
 }
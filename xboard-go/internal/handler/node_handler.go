@@ -7,44 +7,56 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/metrics"
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/protocol"
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/service"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
-	"gorm.io/gorm"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// nodeStreamPingInterval is how often an idle push stream is pinged, so
+	// dead connections are noticed and proxies keep them open
+	nodeStreamPingInterval = 30 * time.Second
+	nodeStreamWriteTimeout = 10 * time.Second
 )
 
 type NodeHandler struct {
-	nodeRepo      repository.NodeRepository
-	userRepo      repository.UserRepository
-	planRepo      repository.PlanRepository
-	uuidRepo      repository.UUIDRepository
-	onlineRepo    repository.OnlineUserRepository
-	accountingSvc service.AccountingService
-	logger        *zap.Logger
+	nodeRepo  repository.NodeRepository
+	onlineSvc service.OnlineUserService
+	userSvc   service.NodeUserService
+	pushSvc   service.NodePushService
+	statusSvc service.NodeStatusService
+	ingestSvc service.TrafficIngestService
+	dedupSvc  service.PushDedupService
+	logger    *zap.Logger
 }
 
 func NewNodeHandler(
 	nodeRepo repository.NodeRepository,
-	userRepo repository.UserRepository,
-	planRepo repository.PlanRepository,
-	uuidRepo repository.UUIDRepository,
-	onlineRepo repository.OnlineUserRepository,
-	accountingSvc service.AccountingService,
+	onlineSvc service.OnlineUserService,
+	userSvc service.NodeUserService,
+	pushSvc service.NodePushService,
+	statusSvc service.NodeStatusService,
+	ingestSvc service.TrafficIngestService,
+	dedupSvc service.PushDedupService,
 	logger *zap.Logger,
 ) *NodeHandler {
 	return &NodeHandler{
-		nodeRepo:      nodeRepo,
-		userRepo:      userRepo,
-		planRepo:      planRepo,
-		uuidRepo:      uuidRepo,
-		onlineRepo:    onlineRepo,
-		accountingSvc: accountingSvc,
-		logger:        logger,
+		nodeRepo:  nodeRepo,
+		onlineSvc: onlineSvc,
+		userSvc:   userSvc,
+		pushSvc:   pushSvc,
+		statusSvc: statusSvc,
+		ingestSvc: ingestSvc,
+		dedupSvc:  dedupSvc,
+		logger:    logger,
 	}
 }
 
@@ -62,24 +74,22 @@ func (h *NodeHandler) GetConfig(c *gin.Context) {
 		},
 	}
 
-	// Parse protocol-specific config if available
-	if node.ProtocolConfig != "" {
-		var protocolConfig map[string]interface{}
-		if err := json.Unmarshal([]byte(node.ProtocolConfig), &protocolConfig); err == nil {
-			for k, v := range protocolConfig {
-				switch k {
-				case "network", "tls", "host", "server_name":
-					// Add these to top level
-					switch k {
-					case "network":
-						config.Network = v.(string)
-					case "tls":
-						config.TLS = int(v.(float64))
-					}
-				}
-			}
-		}
+	// Render protocol-specific config
+	protocolConfig, unknownKeys, err := protocol.Load(node.NodeType, node.ProtocolConfig)
+	if err != nil {
+		h.logger.Error("Invalid node protocol config", zap.Uint64("node_id", node.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Invalid node configuration",
+		})
+		return
+	}
+	if len(unknownKeys) > 0 {
+		h.logger.Warn("Ignoring unknown node protocol config keys",
+			zap.Uint64("node_id", node.ID),
+			zap.Strings("keys", unknownKeys),
+		)
 	}
+	protocolConfig.Apply(&config)
 
 	// Calculate ETag
 	data, _ := json.Marshal(config)
@@ -96,19 +106,25 @@ func (h *NodeHandler) GetConfig(c *gin.Context) {
 }
 
 // GetUsers returns list of users allowed on this node (GET /user)
+// With a version query parameter it returns only what changed since that
+// version, falling back to the full list when the changes are not known.
 func (h *NodeHandler) GetUsers(c *gin.Context) {
 	nodeID := c.MustGet("node_id").(uint64)
 
-	node, err := h.nodeRepo.FindByIDWithLabels(nodeID)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"message": "Server does not exist",
-		})
+	if raw, ok := c.GetQuery("version"); ok {
+		since, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid version",
+			})
+			return
+		}
+		h.getUserDelta(c, nodeID, since)
 		return
 	}
 
 	// Get all users with plans that allow this node's labels
-	users, err := h.getAllowedUsers(node)
+	users, version, err := h.userSvc.ListUsers(nodeID)
 	if err != nil {
 		h.logger.Error("Failed to get allowed users", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -127,6 +143,7 @@ func (h *NodeHandler) GetUsers(c *gin.Context) {
 	etag := calculateETag(data)
 
 	// Check If-None-Match header
+	c.Header("X-User-List-Version", strconv.FormatUint(version, 10))
 	if c.GetHeader("If-None-Match") == fmt.Sprintf("\"%s\"", etag) {
 		c.Status(http.StatusNotModified)
 		return
@@ -136,6 +153,146 @@ func (h *NodeHandler) GetUsers(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+func (h *NodeHandler) getUserDelta(c *gin.Context, nodeID, since uint64) {
+	delta, err := h.userSvc.Delta(nodeID, since)
+	if err != nil {
+		h.logger.Error("Failed to get user list delta", zap.Uint64("node_id", nodeID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get users",
+		})
+		return
+	}
+
+	c.Header("X-User-List-Version", strconv.FormatUint(delta.Version, 10))
+	c.JSON(http.StatusOK, userDeltaResponse(delta))
+}
+
+func userDeltaResponse(delta *service.NodeUserDelta) gin.H {
+	if delta.Full {
+		return gin.H{
+			"version": delta.Version,
+			"full":    true,
+			"users":   delta.Users,
+		}
+	}
+	return gin.H{
+		"version": delta.Version,
+		"full":    false,
+		"added":   delta.Added,
+		"removed": delta.Removed,
+	}
+}
+
+// StreamEvents upgrades to a WebSocket the node holds open to hear about
+// user list and config changes as they happen (GET /ws). The stream starts
+// with the user list relative to the optional version query parameter, then
+// sends a delta whenever it changes. Config changes are only announced; the
+// node fetches them from GET /config.
+func (h *NodeHandler) StreamEvents(c *gin.Context) {
+	nodeID := c.MustGet("node_id").(uint64)
+
+	var since uint64
+	if raw, ok := c.GetQuery("version"); ok {
+		var err error
+		if since, err = strconv.ParseUint(raw, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid version",
+			})
+			return
+		}
+	}
+
+	// Nodes are not browsers and authenticate with their token, so the
+	// origin is not checked
+	server := websocket.Server{
+		Handler: func(ws *websocket.Conn) {
+			h.serveStream(ws, nodeID, since)
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+func (h *NodeHandler) serveStream(ws *websocket.Conn, nodeID, version uint64) {
+	defer ws.Close()
+
+	sub, unsubscribe := h.pushSvc.Subscribe(nodeID)
+	defer unsubscribe()
+
+	metrics.NodePushStreams.Inc()
+	defer metrics.NodePushStreams.Dec()
+	h.logger.Debug("Node push stream opened", zap.Uint64("node_id", nodeID))
+
+	// Nodes send nothing; reading only notices the connection closing
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		var message string
+		for {
+			if err := websocket.Message.Receive(ws, &message); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := h.sendUserDelta(ws, nodeID, &version, true); err != nil {
+		return
+	}
+
+	ping := time.NewTicker(nodeStreamPingInterval)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case <-sub.Users:
+			err = h.sendUserDelta(ws, nodeID, &version, false)
+		case <-sub.Config:
+			err = h.sendEvent(ws, gin.H{"event": "config"})
+		case <-ping.C:
+			err = h.sendEvent(ws, gin.H{"event": "ping"})
+		case <-sub.Done:
+			return
+		case <-closed:
+			h.logger.Debug("Node push stream closed", zap.Uint64("node_id", nodeID))
+			return
+		}
+		if err != nil {
+			h.logger.Debug("Node push stream failed", zap.Uint64("node_id", nodeID), zap.Error(err))
+			return
+		}
+	}
+}
+
+// sendUserDelta sends the user list changes since version and advances it.
+// Empty deltas are skipped unless always is set.
+func (h *NodeHandler) sendUserDelta(ws *websocket.Conn, nodeID uint64, version *uint64, always bool) error {
+	delta, err := h.userSvc.Delta(nodeID, *version)
+	if err != nil {
+		// The next change retries; the node still polls as a fallback
+		h.logger.Error("Failed to get user list delta", zap.Uint64("node_id", nodeID), zap.Error(err))
+		return nil
+	}
+	if !always && !delta.Full && len(delta.Added) == 0 && len(delta.Removed) == 0 {
+		*version = delta.Version
+		return nil
+	}
+
+	event := userDeltaResponse(delta)
+	event["event"] = "users"
+	if err := h.sendEvent(ws, event); err != nil {
+		return err
+	}
+	*version = delta.Version
+	return nil
+}
+
+func (h *NodeHandler) sendEvent(ws *websocket.Conn, event gin.H) error {
+	if err := ws.SetWriteDeadline(time.Now().Add(nodeStreamWriteTimeout)); err != nil {
+		return err
+	}
+	return websocket.JSON.Send(ws, event)
+}
+
 // PushTraffic handles traffic reports from nodes (POST /push)
 func (h *NodeHandler) PushTraffic(c *gin.Context) {
 	nodeID := c.MustGet("node_id").(uint64)
@@ -159,10 +316,65 @@ func (h *NodeHandler) PushTraffic(c *gin.Context) {
 		return
 	}
 
-	// Process traffic
-	if err := h.accountingSvc.ProcessTrafficReport(nodeID, reports); err != nil {
-		h.logger.Error("Failed to process traffic", zap.Error(err))
+	// Optional idempotency: a request ID and/or a per-node sequence number
+	requestID := c.GetHeader("X-Request-ID")
+	if len(requestID) > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    422,
+			"message": "X-Request-ID must be at most 64 characters",
+		})
+		return
+	}
+	var seq uint64
+	if rawSeq := c.GetHeader("X-Push-Seq"); rawSeq != "" {
+		parsed, err := strconv.ParseUint(rawSeq, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    422,
+				"message": "Invalid X-Push-Seq",
+			})
+			return
+		}
+		seq = parsed
+	}
+
+	claim, fresh, err := h.dedupSvc.Claim(nodeID, requestID, seq)
+	if err != nil {
+		h.logger.Error("Failed to check traffic push", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "Failed to record traffic",
+		})
+		return
+	}
+	if !fresh {
+		// Already billed; acknowledge so the node stops retrying
+		h.logger.Info("Ignoring duplicate traffic push",
+			zap.Uint64("node_id", nodeID),
+			zap.String("request_id", requestID),
+			zap.Uint64("seq", seq),
+		)
+		metrics.DuplicateTrafficReportsTotal.WithLabelValues(strconv.FormatUint(nodeID, 10)).Inc()
+		c.JSON(http.StatusOK, gin.H{
+			"code":    0,
+			"message": "ok",
+			"data":    true,
+		})
+		return
+	}
+
+	// Buffer traffic; it is billed on the next flush
+	if err := h.ingestSvc.Submit(nodeID, reports); err != nil {
+		h.logger.Error("Failed to record traffic", zap.Error(err))
 		metrics.AccountingErrorsTotal.Inc()
+		if err := h.dedupSvc.Release(claim); err != nil {
+			h.logger.Error("Failed to release traffic push", zap.Error(err))
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "Failed to record traffic",
+		})
+		return
 	}
 
 	// Update metrics
@@ -187,19 +399,16 @@ func (h *NodeHandler) PushAlive(c *gin.Context) {
 		return
 	}
 
-	// Process online users
-	for userID, ips := range aliveData {
-		for _, ipWithNode := range ips {
-			// Parse IP (format: "IP_nodeIdentifier")
-			// For simplicity, we'll just use the whole string as IP
-			if err := h.onlineRepo.UpsertOnlineUser(userID, nodeID, ipWithNode); err != nil {
-				h.logger.Error("Failed to upsert online user",
-					zap.Uint64("user_id", userID),
-					zap.String("ip", ipWithNode),
-					zap.Error(err),
-				)
-			}
-		}
+	// The report is everything online on the node, replacing the last one
+	if err := h.onlineSvc.Report(nodeID, aliveData); err != nil {
+		h.logger.Error("Failed to record online users",
+			zap.Uint64("node_id", nodeID),
+			zap.Error(err),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to record online users",
+		})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -208,8 +417,21 @@ func (h *NodeHandler) PushAlive(c *gin.Context) {
 }
 
 // GetAliveList returns device limit info (GET /alivelist)
+// Like Xboard, only users allowed on this node with a device limit are
+// included, so nodes can reject new devices once a user reaches the limit.
 func (h *NodeHandler) GetAliveList(c *gin.Context) {
-	counts, err := h.onlineRepo.GetAllOnlineDeviceCounts()
+	nodeID := c.MustGet("node_id").(uint64)
+
+	users, _, err := h.userSvc.ListUsers(nodeID)
+	if err != nil {
+		h.logger.Error("Failed to get allowed users", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get device limits",
+		})
+		return
+	}
+
+	counts, err := h.onlineSvc.DeviceCounts()
 	if err != nil {
 		h.logger.Error("Failed to get online device counts", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -218,8 +440,18 @@ func (h *NodeHandler) GetAliveList(c *gin.Context) {
 		return
 	}
 
+	alive := make(map[uint64]uint)
+	for _, user := range users {
+		if user.DeviceLimit == 0 {
+			continue
+		}
+		if count, ok := counts[user.ID]; ok {
+			alive[user.ID] = count
+		}
+	}
+
 	c.JSON(http.StatusOK, models.DeviceLimitDTO{
-		Alive: counts,
+		Alive: alive,
 	})
 }
 
@@ -228,8 +460,8 @@ func (h *NodeHandler) PushStatus(c *gin.Context) {
 	nodeID := c.MustGet("node_id").(uint64)
 
 	var status struct {
-		CPU  float64 `json:"cpu" binding:"required,min=0,max=100"`
-		Mem  struct {
+		CPU float64 `json:"cpu" binding:"required,min=0,max=100"`
+		Mem struct {
 			Total uint64 `json:"total" binding:"required,min=0"`
 			Used  uint64 `json:"used" binding:"required,min=0"`
 		} `json:"mem" binding:"required"`
@@ -256,8 +488,17 @@ func (h *NodeHandler) PushStatus(c *gin.Context) {
 		h.logger.Error("Failed to update node last seen", zap.Error(err))
 	}
 
-	// In a real implementation, you'd cache this status data
-	// For now, we just acknowledge receipt
+	h.statusSvc.Record(models.NodeStatus{
+		NodeID:     nodeID,
+		CPU:        status.CPU,
+		MemTotal:   status.Mem.Total,
+		MemUsed:    status.Mem.Used,
+		SwapTotal:  status.Swap.Total,
+		SwapUsed:   status.Swap.Used,
+		DiskTotal:  status.Disk.Total,
+		DiskUsed:   status.Disk.Used,
+		ReportedAt: time.Now(),
+	})
 
 	c.JSON(http.StatusOK, gin.H{
 		"data":    true,
@@ -268,94 +509,6 @@ func (h *NodeHandler) PushStatus(c *gin.Context) {
 
 // Helper functions
 
-func (h *NodeHandler) getAllowedUsers(node *models.Node) ([]models.NodeUserDTO, error) {
-	// Get node label IDs
-	labelIDs := make([]uint64, len(node.Labels))
-	for i, label := range node.Labels {
-		labelIDs[i] = label.ID
-	}
-
-	// Find plans that allow any of these labels
-	var plans []models.Plan
-	if len(labelIDs) > 0 {
-		// Load all plans and filter by matching labels
-		allPlans, _, _ := h.planRepo.List(0, 10000)
-		for _, plan := range allPlans {
-			planLabels, _ := h.planRepo.GetLabels(plan.ID)
-			for _, planLabel := range planLabels {
-				for _, nodeLabel := range node.Labels {
-					if planLabel.ID == nodeLabel.ID {
-						plans = append(plans, plan)
-						break
-					}
-				}
-			}
-		}
-	}
-
-	// Get plan IDs
-	planIDs := make([]uint64, len(plans))
-	for i, plan := range plans {
-		planIDs[i] = plan.ID
-	}
-
-	// Find users with these plans
-	// This is a simplified implementation - in production, use proper DB queries
-	var users []models.User
-	allUsers, _, _ := h.userRepo.List(0, 10000)
-	for _, user := range allUsers {
-		if user.PlanID != nil {
-			for _, planID := range planIDs {
-				if *user.PlanID == planID {
-					users = append(users, user)
-					break
-				}
-			}
-		}
-	}
-
-	// Get UUIDs for users
-	uuidMap, err := h.uuidRepo.GetAllUserUUIDs()
-	if err != nil {
-		return nil, err
-	}
-
-	// Build user DTOs
-	var userDTOs []models.NodeUserDTO
-	for _, user := range users {
-		if user.Banned {
-			continue
-		}
-
-		// Check if user has exceeded quota
-		usage, err := h.accountingSvc.GetCurrentUsage(user.ID)
-		if err != nil && err != gorm.ErrRecordNotFound {
-			continue
-		}
-
-		if usage != nil && user.Plan != nil {
-			totalUsage := usage.BillableBytesUp + usage.BillableBytesDown
-			if totalUsage >= user.Plan.QuotaBytes {
-				continue
-			}
-		}
-
-		uuid, exists := uuidMap[user.ID]
-		if !exists {
-			continue
-		}
-
-		userDTOs = append(userDTOs, models.NodeUserDTO{
-			ID:          user.ID,
-			UUID:        uuid,
-			SpeedLimit:  0, // TODO: Add speed limit support
-			DeviceLimit: 0, // TODO: Add device limit support
-		})
-	}
-
-	return userDTOs, nil
-}
-
 func parseTrafficData(raw interface{}) []models.TrafficReport {
 	var reports []models.TrafficReport
 
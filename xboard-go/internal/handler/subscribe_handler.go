@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/service"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/subscribe"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type SubscribeHandler struct {
+	cfg           *config.ServerConfig
+	userRepo      repository.UserRepository
+	nodeRepo      repository.NodeRepository
+	planRepo      repository.PlanRepository
+	uuidRepo      repository.UUIDRepository
+	subRepo       repository.SubscriptionRepository
+	packRepo      repository.TrafficPackRepository
+	accountingSvc service.AccountingService
+	authService   service.AuthService
+	logger        *zap.Logger
+}
+
+func NewSubscribeHandler(
+	cfg *config.ServerConfig,
+	userRepo repository.UserRepository,
+	nodeRepo repository.NodeRepository,
+	planRepo repository.PlanRepository,
+	uuidRepo repository.UUIDRepository,
+	subRepo repository.SubscriptionRepository,
+	packRepo repository.TrafficPackRepository,
+	accountingSvc service.AccountingService,
+	authService service.AuthService,
+	logger *zap.Logger,
+) *SubscribeHandler {
+	return &SubscribeHandler{
+		cfg:           cfg,
+		userRepo:      userRepo,
+		nodeRepo:      nodeRepo,
+		planRepo:      planRepo,
+		uuidRepo:      uuidRepo,
+		subRepo:       subRepo,
+		packRepo:      packRepo,
+		accountingSvc: accountingSvc,
+		authService:   authService,
+		logger:        logger,
+	}
+}
+
+// Subscribe renders the user's nodes for proxy clients (GET /sub/:token)
+func (h *SubscribeHandler) Subscribe(c *gin.Context) {
+	user, err := h.userRepo.FindByToken(c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"code":    "SUBSCRIPTION_NOT_FOUND",
+				"message": "Subscription not found",
+			},
+		})
+		return
+	}
+
+	if user.Banned {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": gin.H{
+				"code":    "USER_BANNED",
+				"message": "User is banned",
+			},
+		})
+		return
+	}
+
+	now := time.Now()
+	sub, err := h.subRepo.FindActiveByUser(user.ID)
+	if err == gorm.ErrRecordNotFound {
+		sub = nil
+	} else if err != nil {
+		h.logger.Error("Failed to get subscription", zap.Uint64("user_id", user.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to fetch subscription",
+			},
+		})
+		return
+	}
+
+	profile := &subscribe.Profile{}
+	// A lapsed subscription no longer grants its plan's nodes
+	if sub == nil || sub.IsActive(now) {
+		profile.Nodes, err = h.allowedNodes(user)
+		if err != nil {
+			h.logger.Error("Failed to get subscription nodes", zap.Uint64("user_id", user.ID), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": gin.H{
+					"code":    "INTERNAL_ERROR",
+					"message": "Failed to fetch nodes",
+				},
+			})
+			return
+		}
+	}
+
+	if userUUID, err := h.uuidRepo.FindByUserID(user.ID); err == nil {
+		profile.UUID = userUUID.UUID
+	} else {
+		profile.Nodes = nil
+	}
+
+	format := subscribe.DetectFormat(c.Query("flag"), c.GetHeader("User-Agent"))
+	body, contentType, err := subscribe.Render(format, profile)
+	if err != nil {
+		h.logger.Error("Failed to render subscription", zap.String("format", string(format)), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to render subscription",
+			},
+		})
+		return
+	}
+
+	c.Header("subscription-userinfo", h.userInfo(user, sub, now))
+	c.Header("profile-update-interval", "24")
+	if format == subscribe.FormatClash {
+		c.Header("Content-Disposition", "attachment; filename=\"xboard.yaml\"")
+	}
+	c.Data(http.StatusOK, contentType, body)
+}
+
+// allowedNodes returns the active nodes the user's plan grants access to. A
+// plan that no longer exists grants none.
+func (h *SubscribeHandler) allowedNodes(user *models.User) ([]models.Node, error) {
+	if user.PlanID == nil {
+		return nil, nil
+	}
+
+	plan, err := h.planRepo.FindByIDWithLabels(*user.PlanID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	allNodes, err := h.nodeRepo.FindActiveNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []models.Node
+	for _, node := range allNodes {
+		if plan.AllowsNode(&node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil
+}
+
+// userInfo builds the subscription-userinfo header clients use to show
+// usage and expiry
+func (h *SubscribeHandler) userInfo(user *models.User, sub *models.Subscription, now time.Time) string {
+	var upload, download, total uint64
+	if usage, err := h.accountingSvc.GetCurrentUsage(user.ID); err == nil {
+		upload = usage.BillableBytesUp
+		download = usage.BillableBytesDown
+		total = usage.EffectiveQuota(user.Plan)
+	} else if user.Plan != nil {
+		total = user.Plan.QuotaBytes
+	}
+
+	if packRemaining, err := h.packRepo.GetRemaining(user.ID, now); err == nil {
+		total += packRemaining
+	}
+
+	var expire int64
+	if sub != nil {
+		expire = sub.ExpiresAt.Unix()
+	}
+
+	return fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d", upload, download, total, expire)
+}
+
+// GetSubscribeURL returns the user's subscription URL, issuing a token on
+// first use (GET /api/v1/me/subscribe)
+func (h *SubscribeHandler) GetSubscribeURL(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint64)
+
+	user, err := h.userRepo.FindByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"code":    "USER_NOT_FOUND",
+				"message": "User not found",
+			},
+		})
+		return
+	}
+
+	token := ""
+	if user.Token != nil {
+		token = *user.Token
+	} else if token, err = h.authService.ResetSubscribeToken(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to generate subscription token",
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"subscribe_url": h.subscribeURL(c, token),
+	})
+}
+
+// ResetSubscribeURL replaces the user's subscription token
+// (POST /api/v1/me/subscribe/reset)
+func (h *SubscribeHandler) ResetSubscribeURL(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint64)
+
+	user, err := h.userRepo.FindByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"code":    "USER_NOT_FOUND",
+				"message": "User not found",
+			},
+		})
+		return
+	}
+
+	token, err := h.authService.ResetSubscribeToken(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to generate subscription token",
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"subscribe_url": h.subscribeURL(c, token),
+	})
+}
+
+// subscribeURL prefers the configured public URL, since the panel usually
+// sits behind a reverse proxy
+func (h *SubscribeHandler) subscribeURL(c *gin.Context, token string) string {
+	base := strings.TrimRight(h.cfg.PublicURL, "/")
+	if base == "" {
+		scheme := "http"
+		if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		base = scheme + "://" + c.Request.Host
+	}
+	return base + "/sub/" + token
+}
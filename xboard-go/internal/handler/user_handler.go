@@ -2,8 +2,10 @@ package handler
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/service"
 
@@ -11,11 +13,16 @@ import (
 )
 
 type UserHandler struct {
-	userRepo      repository.UserRepository
-	nodeRepo      repository.NodeRepository
-	planRepo      repository.PlanRepository
-	accountingSvc service.AccountingService
-	authService   service.AuthService
+	userRepo        repository.UserRepository
+	nodeRepo        repository.NodeRepository
+	planRepo        repository.PlanRepository
+	accountingSvc   service.AccountingService
+	authService     service.AuthService
+	subscriptionSvc service.SubscriptionService
+	packRepo        repository.TrafficPackRepository
+	multipliers     service.MultiplierResolver
+	linkSvc         service.TelegramLinkService
+	thresholdRepo   repository.TelegramThresholdRepository
 }
 
 func NewUserHandler(
@@ -24,13 +31,23 @@ func NewUserHandler(
 	planRepo repository.PlanRepository,
 	accountingSvc service.AccountingService,
 	authService service.AuthService,
+	subscriptionSvc service.SubscriptionService,
+	packRepo repository.TrafficPackRepository,
+	multipliers service.MultiplierResolver,
+	linkSvc service.TelegramLinkService,
+	thresholdRepo repository.TelegramThresholdRepository,
 ) *UserHandler {
 	return &UserHandler{
-		userRepo:      userRepo,
-		nodeRepo:      nodeRepo,
-		planRepo:      planRepo,
-		accountingSvc: accountingSvc,
-		authService:   authService,
+		userRepo:        userRepo,
+		nodeRepo:        nodeRepo,
+		planRepo:        planRepo,
+		accountingSvc:   accountingSvc,
+		authService:     authService,
+		subscriptionSvc: subscriptionSvc,
+		packRepo:        packRepo,
+		multipliers:     multipliers,
+		linkSvc:         linkSvc,
+		thresholdRepo:   thresholdRepo,
 	}
 }
 
@@ -75,9 +92,23 @@ func (h *UserHandler) GetMyPlan(c *gin.Context) {
 		return
 	}
 
-	if user.PlanID == nil {
+	subscription, err := h.subscriptionSvc.GetCurrent(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to fetch subscription",
+			},
+		})
+		return
+	}
+
+	// A lapsed subscription no longer grants its plan, even before the
+	// expiry job has moved the user to the fallback plan
+	if user.PlanID == nil || (subscription != nil && !subscription.IsActive(time.Now())) {
 		c.JSON(http.StatusOK, gin.H{
-			"plan": nil,
+			"plan":         nil,
+			"subscription": subscription,
 		})
 		return
 	}
@@ -94,7 +125,8 @@ func (h *UserHandler) GetMyPlan(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"plan": plan,
+		"plan":         plan,
+		"subscription": subscription,
 	})
 }
 
@@ -112,7 +144,20 @@ func (h *UserHandler) GetMyNodes(c *gin.Context) {
 		return
 	}
 
-	if user.PlanID == nil {
+	subscription, err := h.subscriptionSvc.GetCurrent(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to fetch subscription",
+			},
+		})
+		return
+	}
+
+	// A lapsed subscription no longer grants its plan's nodes
+	now := time.Now()
+	if user.PlanID == nil || (subscription != nil && !subscription.IsActive(now)) {
 		c.JSON(http.StatusOK, gin.H{
 			"nodes": []interface{}{},
 		})
@@ -139,23 +184,21 @@ func (h *UserHandler) GetMyNodes(c *gin.Context) {
 		return
 	}
 
-	// Filter nodes that have at least one label matching the plan
-	planLabelIDs := make(map[uint64]bool)
-	for _, label := range plan.Labels {
-		planLabelIDs[label.ID] = true
-	}
-
+	// Filter nodes that have at least one label matching the plan, with the
+	// rate traffic on them is billed at right now
 	var allowedNodes []interface{}
 	for _, node := range allNodes {
-		hasMatchingLabel := false
-		for _, label := range node.Labels {
-			if planLabelIDs[label.ID] {
-				hasMatchingLabel = true
-				break
+		if plan.AllowsNode(&node) {
+			explanation, err := h.multipliers.Explain(plan.ID, node.ID, now)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": gin.H{
+						"code":    "INTERNAL_ERROR",
+						"message": "Failed to calculate multiplier",
+					},
+				})
+				return
 			}
-		}
-
-		if hasMatchingLabel {
 			allowedNodes = append(allowedNodes, gin.H{
 				"id":              node.ID,
 				"name":            node.Name,
@@ -163,6 +206,8 @@ func (h *UserHandler) GetMyNodes(c *gin.Context) {
 				"host":            node.Host,
 				"port":            node.Port,
 				"node_multiplier": node.NodeMultiplier,
+				"multiplier":      explanation.Multiplier,
+				"schedules":       explanation.Schedules,
 				"status":          node.Status,
 				"labels":          node.Labels,
 			})
@@ -177,29 +222,57 @@ func (h *UserHandler) GetMyNodes(c *gin.Context) {
 func (h *UserHandler) GetMyUsage(c *gin.Context) {
 	userID := c.MustGet("user_id").(uint64)
 
+	packRemaining, err := h.packRepo.GetRemaining(userID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to get traffic packs",
+			},
+		})
+		return
+	}
+
 	usage, err := h.accountingSvc.GetCurrentUsage(userID)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"usage": gin.H{
-				"real_bytes_up":       0,
-				"real_bytes_down":     0,
-				"billable_bytes_up":   0,
-				"billable_bytes_down": 0,
-				"period_start":        nil,
-				"period_end":          nil,
+				"real_bytes_up":        0,
+				"real_bytes_down":      0,
+				"billable_bytes_up":    0,
+				"billable_bytes_down":  0,
+				"quota_bytes":          0,
+				"pack_remaining_bytes": packRemaining,
+				"remaining_bytes":      packRemaining,
+				"period_start":         nil,
+				"period_end":           nil,
 			},
 		})
 		return
 	}
 
+	var quota uint64
+	if user, err := h.userRepo.FindByID(userID); err == nil {
+		quota = usage.EffectiveQuota(user.Plan)
+	}
+
+	// Base quota left in the period plus unused pack bytes
+	remaining := packRemaining
+	if billable := usage.BillableBytesUp + usage.BillableBytesDown; billable < quota {
+		remaining += quota - billable
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"usage": gin.H{
-			"real_bytes_up":       usage.RealBytesUp,
-			"real_bytes_down":     usage.RealBytesDown,
-			"billable_bytes_up":   usage.BillableBytesUp,
-			"billable_bytes_down": usage.BillableBytesDown,
-			"period_start":        usage.PeriodStart,
-			"period_end":          usage.PeriodEnd,
+			"real_bytes_up":        usage.RealBytesUp,
+			"real_bytes_down":      usage.RealBytesDown,
+			"billable_bytes_up":    usage.BillableBytesUp,
+			"billable_bytes_down":  usage.BillableBytesDown,
+			"quota_bytes":          quota,
+			"pack_remaining_bytes": packRemaining,
+			"remaining_bytes":      remaining,
+			"period_start":         usage.PeriodStart,
+			"period_end":           usage.PeriodEnd,
 		},
 	})
 }
@@ -230,7 +303,9 @@ type TelegramLinkResponse struct {
 }
 
 func (h *UserHandler) GenerateTelegramLink(c *gin.Context) {
-	token, err := h.authService.GenerateTelegramLinkToken()
+	userID := c.MustGet("user_id").(uint64)
+
+	token, err := h.linkSvc.CreateToken(userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": gin.H{
@@ -241,11 +316,267 @@ func (h *UserHandler) GenerateTelegramLink(c *gin.Context) {
 		return
 	}
 
-	// TODO: Store this token with user_id and expiration in cache/db
+	c.JSON(http.StatusOK, gin.H{
+		"link_token":   token.Token,
+		"expires_in":   int(service.TelegramLinkTokenTTL.Seconds()),
+		"expires_at":   token.ExpiresAt,
+		"instructions": "Send this token to the bot using /link <token> within 5 minutes. It can be used once.",
+	})
+}
+
+// Notification thresholds
+
+// maxThresholdsPerUser bounds how many notification thresholds a user keeps
+const maxThresholdsPerUser = 20
+
+func (h *UserHandler) ListNotificationThresholds(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint64)
+
+	thresholds, err := h.thresholdRepo.FindByUser(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to fetch thresholds",
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"thresholds": thresholds,
+	})
+}
+
+type CreateNotificationThresholdRequest struct {
+	ThresholdType  string  `json:"threshold_type" binding:"required,oneof=percent bytes_remaining"`
+	ThresholdValue float64 `json:"threshold_value" binding:"required"`
+	Enabled        *bool   `json:"enabled"`
+}
+
+func (h *UserHandler) CreateNotificationThreshold(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint64)
+
+	var req CreateNotificationThresholdRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_REQUEST",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	threshold := &models.TelegramThreshold{
+		UserID:         userID,
+		ThresholdType:  req.ThresholdType,
+		ThresholdValue: req.ThresholdValue,
+		Enabled:        true,
+	}
+	if req.Enabled != nil {
+		threshold.Enabled = *req.Enabled
+	}
+
+	if err := threshold.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_THRESHOLD",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	existing, err := h.thresholdRepo.FindByUser(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "INTERNAL_ERROR",
+				"message": "Failed to fetch thresholds",
+			},
+		})
+		return
+	}
+	if len(existing) >= maxThresholdsPerUser {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "TOO_MANY_THRESHOLDS",
+				"message": "At most " + strconv.Itoa(maxThresholdsPerUser) + " thresholds are allowed",
+			},
+		})
+		return
+	}
+	if duplicateThreshold(existing, threshold) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": gin.H{
+				"code":    "THRESHOLD_EXISTS",
+				"message": "A threshold with this type and value already exists",
+			},
+		})
+		return
+	}
+
+	if err := h.thresholdRepo.Create(threshold); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "THRESHOLD_CREATION_FAILED",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"threshold": threshold,
+	})
+}
+
+// UpdateNotificationThresholdRequest changes a threshold. Changing its type
+// or value lets it fire again in the current period.
+type UpdateNotificationThresholdRequest struct {
+	ThresholdType  *string  `json:"threshold_type" binding:"omitempty,oneof=percent bytes_remaining"`
+	ThresholdValue *float64 `json:"threshold_value"`
+	Enabled        *bool    `json:"enabled"`
+}
+
+func (h *UserHandler) UpdateNotificationThreshold(c *gin.Context) {
+	threshold, ok := h.findOwnThreshold(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateNotificationThresholdRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_REQUEST",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	changed := false
+	if req.ThresholdType != nil && *req.ThresholdType != threshold.ThresholdType {
+		threshold.ThresholdType = *req.ThresholdType
+		changed = true
+	}
+	if req.ThresholdValue != nil && *req.ThresholdValue != threshold.ThresholdValue {
+		threshold.ThresholdValue = *req.ThresholdValue
+		changed = true
+	}
+	if req.Enabled != nil {
+		threshold.Enabled = *req.Enabled
+	}
+
+	if err := threshold.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_THRESHOLD",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	if changed {
+		existing, err := h.thresholdRepo.FindByUser(threshold.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": gin.H{
+					"code":    "INTERNAL_ERROR",
+					"message": "Failed to fetch thresholds",
+				},
+			})
+			return
+		}
+		if duplicateThreshold(existing, threshold) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": gin.H{
+					"code":    "THRESHOLD_EXISTS",
+					"message": "A threshold with this type and value already exists",
+				},
+			})
+			return
+		}
+		threshold.LastTriggeredAt = nil
+	}
+
+	if err := h.thresholdRepo.Update(threshold); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "UPDATE_FAILED",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"link_token": token,
-		"expires_in": 300, // 5 minutes
-		"instructions": "Send this token to the bot using /link <token>",
+		"threshold": threshold,
 	})
 }
+
+func (h *UserHandler) DeleteNotificationThreshold(c *gin.Context) {
+	threshold, ok := h.findOwnThreshold(c)
+	if !ok {
+		return
+	}
+
+	if err := h.thresholdRepo.Delete(threshold.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"code":    "DELETE_FAILED",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Threshold deleted successfully",
+	})
+}
+
+// findOwnThreshold loads the threshold in the route of the current user,
+// responding with an error if there is none
+func (h *UserHandler) findOwnThreshold(c *gin.Context) (*models.TelegramThreshold, bool) {
+	userID := c.MustGet("user_id").(uint64)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"code":    "INVALID_ID",
+				"message": "Invalid threshold ID",
+			},
+		})
+		return nil, false
+	}
+
+	threshold, err := h.thresholdRepo.FindByID(id)
+	if err != nil || threshold.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"code":    "THRESHOLD_NOT_FOUND",
+				"message": "Threshold not found",
+			},
+		})
+		return nil, false
+	}
+	return threshold, true
+}
+
+// duplicateThreshold reports whether another of the user's thresholds has the
+// same type and value
+func duplicateThreshold(existing []models.TelegramThreshold, threshold *models.TelegramThreshold) bool {
+	for _, other := range existing {
+		if other.ID != threshold.ID &&
+			other.ThresholdType == threshold.ThresholdType &&
+			other.ThresholdValue == threshold.ThresholdValue {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package jobs
 import (
 	"time"
 
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/alert"
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/service"
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/telegram"
@@ -12,47 +13,83 @@ import (
 )
 
 type JobScheduler struct {
-	db            *gorm.DB
-	accountingSvc service.AccountingService
-	userRepo      repository.UserRepository
-	usageRepo     repository.UsageRepository
-	thresholdRepo *thresholdRepository
-	telegramBot   *telegram.Bot
-	logger        *zap.Logger
-}
-
-type thresholdRepository struct {
-	db *gorm.DB
+	db              *gorm.DB
+	accountingSvc   service.AccountingService
+	subscriptionSvc service.SubscriptionService
+	nodeStatusSvc   service.NodeStatusService
+	nodeHealthSvc   service.NodeHealthService
+	pushDedupSvc    service.PushDedupService
+	nodeUserSvc     service.NodeUserService
+	onlineSvc       service.OnlineUserService
+	thresholdSvc    service.NotificationThresholdService
+	userRepo        repository.UserRepository
+	telegramBot     *telegram.Bot
+	webhook         *alert.Webhook
+	logger          *zap.Logger
 }
 
 func NewJobScheduler(
 	db *gorm.DB,
 	accountingSvc service.AccountingService,
+	subscriptionSvc service.SubscriptionService,
+	nodeStatusSvc service.NodeStatusService,
+	nodeHealthSvc service.NodeHealthService,
+	pushDedupSvc service.PushDedupService,
+	nodeUserSvc service.NodeUserService,
+	onlineSvc service.OnlineUserService,
+	thresholdSvc service.NotificationThresholdService,
 	userRepo repository.UserRepository,
-	usageRepo repository.UsageRepository,
 	telegramBot *telegram.Bot,
+	webhook *alert.Webhook,
 	logger *zap.Logger,
 ) *JobScheduler {
 	return &JobScheduler{
-		db:            db,
-		accountingSvc: accountingSvc,
-		userRepo:      userRepo,
-		usageRepo:     usageRepo,
-		thresholdRepo: &thresholdRepository{db: db},
-		telegramBot:   telegramBot,
-		logger:        logger,
+		db:              db,
+		accountingSvc:   accountingSvc,
+		subscriptionSvc: subscriptionSvc,
+		nodeStatusSvc:   nodeStatusSvc,
+		nodeHealthSvc:   nodeHealthSvc,
+		pushDedupSvc:    pushDedupSvc,
+		nodeUserSvc:     nodeUserSvc,
+		onlineSvc:       onlineSvc,
+		thresholdSvc:    thresholdSvc,
+		userRepo:        userRepo,
+		telegramBot:     telegramBot,
+		webhook:         webhook,
+		logger:          logger,
 	}
 }
 
 func (s *JobScheduler) Start() {
-	// Plan reset job - runs every hour
-	go s.runPeriodic("plan_reset", 1*time.Hour, s.checkPlanResets)
+	// Plan reset job - runs every 10 minutes
+	go s.runPeriodic("plan_reset", 10*time.Minute, s.checkPlanResets)
+
+	// Subscription expiry - runs every 5 minutes
+	go s.runPeriodic("subscription_expiry", 5*time.Minute, s.expireSubscriptions)
 
 	// Telegram notifications - runs every 5 minutes
 	go s.runPeriodic("telegram_notifications", 5*time.Minute, s.checkNotificationThresholds)
 
-	// Online users cleanup - runs every 10 minutes
-	go s.runPeriodic("online_cleanup", 10*time.Minute, s.cleanupStaleOnlineUsers)
+	// Node status snapshots - runs every minute
+	go s.runPeriodic("node_status_snapshot", time.Minute, s.snapshotNodeStatus)
+
+	// Node health - runs every minute
+	go s.runPeriodic("node_health", time.Minute, s.checkNodeHealth)
+
+	// Push receipt cleanup - runs every hour
+	go s.runPeriodic("push_receipt_cleanup", time.Hour, s.cleanupPushReceipts)
+
+	// Traffic batch cleanup - runs every hour
+	go s.runPeriodic("traffic_batch_cleanup", time.Hour, s.cleanupTrafficBatches)
+
+	// User list change cleanup - runs every hour
+	go s.runPeriodic("user_change_cleanup", time.Hour, s.cleanupUserListChanges)
+
+	// User list expiries - runs every minute
+	go s.runPeriodic("user_list_expiries", time.Minute, s.recordUserListExpiries)
+
+	// Online users cleanup - runs every minute
+	go s.runPeriodic("online_cleanup", time.Minute, s.cleanupStaleOnlineUsers)
 
 	s.logger.Info("Background jobs started")
 }
@@ -68,81 +105,210 @@ func (s *JobScheduler) runPeriodic(name string, interval time.Duration, job func
 }
 
 func (s *JobScheduler) checkPlanResets() {
-	// This would check all current periods and reset if needed
-	// For now, it's a placeholder
 	s.logger.Debug("Checking plan resets")
 
-	// TODO: Implement actual plan reset logic
-	// 1. Query all current periods where period_end < now
-	// 2. Close those periods (set is_current = false)
-	// 3. Create new periods for those users
+	if err := s.accountingSvc.CheckAndResetPeriods(); err != nil {
+		s.logger.Error("Failed to reset usage periods", zap.Error(err))
+	}
 }
 
-func (s *JobScheduler) checkNotificationThresholds() {
-	if s.telegramBot == nil {
+func (s *JobScheduler) expireSubscriptions() {
+	s.logger.Debug("Checking subscription expiry")
+
+	results, err := s.subscriptionSvc.ProcessExpired()
+	if err != nil {
+		s.logger.Error("Failed to process expired subscriptions", zap.Error(err))
+	}
+
+	for _, result := range results {
+		s.logger.Info("Subscription expired",
+			zap.Uint64("subscription_id", result.Subscription.ID),
+			zap.Uint64("user_id", result.User.ID),
+			zap.Bool("renewed", result.Renewed),
+		)
+
+		// Users who keep their plan have nothing to act on
+		if s.telegramBot == nil || result.User.TelegramChatID == nil || result.PlanKept {
+			continue
+		}
+
+		var message string
+		if result.Renewed {
+			message = telegram.FormatSubscriptionRenewedNotification(result.Subscription.ExpiresAt)
+		} else {
+			fallback := ""
+			if result.FallbackPlan != nil {
+				fallback = result.FallbackPlan.Name
+			}
+			message = telegram.FormatSubscriptionExpiredNotification(fallback)
+		}
+
+		if err := s.telegramBot.SendNotification(*result.User.TelegramChatID, message, "subscription"); err != nil {
+			s.logger.Error("Failed to send subscription notification",
+				zap.Uint64("user_id", result.User.ID),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+func (s *JobScheduler) snapshotNodeStatus() {
+	if err := s.nodeStatusSvc.Snapshot(); err != nil {
+		s.logger.Error("Failed to snapshot node status", zap.Error(err))
+	}
+}
+
+func (s *JobScheduler) cleanupPushReceipts() {
+	deleted, err := s.pushDedupSvc.CleanupReceipts()
+	if err != nil {
+		s.logger.Error("Failed to clean up push receipts", zap.Error(err))
 		return
 	}
 
-	s.logger.Debug("Checking notification thresholds")
+	s.logger.Debug("Cleaned up push receipts", zap.Int64("deleted", deleted))
+}
+
+func (s *JobScheduler) cleanupTrafficBatches() {
+	deleted, err := s.accountingSvc.CleanupTrafficBatches()
+	if err != nil {
+		s.logger.Error("Failed to clean up traffic batches", zap.Error(err))
+		return
+	}
+
+	s.logger.Debug("Cleaned up traffic batches", zap.Int64("deleted", deleted))
+}
 
-	// Get all users with Telegram linked and active thresholds
-	// This is simplified - in production you'd query more efficiently
-	users, _, err := s.userRepo.List(0, 10000)
+func (s *JobScheduler) cleanupUserListChanges() {
+	deleted, err := s.nodeUserSvc.CleanupChanges()
 	if err != nil {
-		s.logger.Error("Failed to list users for notifications", zap.Error(err))
+		s.logger.Error("Failed to clean up user list changes", zap.Error(err))
 		return
 	}
 
-	for _, user := range users {
-		if user.TelegramChatID == nil {
+	s.logger.Debug("Cleaned up user list changes", zap.Int64("deleted", deleted))
+}
+
+func (s *JobScheduler) recordUserListExpiries() {
+	if err := s.nodeUserSvc.RecordExpiries(); err != nil {
+		s.logger.Error("Failed to record user list expiries", zap.Error(err))
+	}
+}
+
+func (s *JobScheduler) checkNodeHealth() {
+	transitions, err := s.nodeHealthSvc.CheckNodes()
+	if err != nil {
+		s.logger.Error("Failed to check node health", zap.Error(err))
+		return
+	}
+
+	for _, t := range transitions {
+		s.logger.Info("Node health changed",
+			zap.Uint64("node_id", t.Node.ID),
+			zap.String("from", t.From),
+			zap.String("to", t.To),
+		)
+
+		var event, message string
+		switch {
+		case t.IsDown():
+			event = "node.down"
+			message = telegram.FormatNodeDownAlert(t.Node.Name, t.Node.Host, t.Node.LastSeenAt)
+		case t.IsRecovery():
+			event = "node.recovered"
+			message = telegram.FormatNodeRecoveredAlert(t.Node.Name, t.Node.Host, t.Since)
+		default:
 			continue
 		}
 
-		// Get current usage
-		usage, err := s.usageRepo.GetCurrentPeriod(user.ID)
-		if err != nil {
-			continue
+		s.sendAdminAlert(message)
+
+		if err := s.webhook.Send(event, map[string]interface{}{
+			"node_id":      t.Node.ID,
+			"name":         t.Node.Name,
+			"host":         t.Node.Host,
+			"from_state":   t.From,
+			"to_state":     t.To,
+			"last_seen_at": t.Node.LastSeenAt,
+		}); err != nil {
+			s.logger.Error("Failed to send node alert webhook",
+				zap.Uint64("node_id", t.Node.ID),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// sendAdminAlert notifies every admin with a linked Telegram chat
+func (s *JobScheduler) sendAdminAlert(message string) {
+	if s.telegramBot == nil {
+		return
+	}
+
+	admins, err := s.userRepo.FindLinkedAdmins()
+	if err != nil {
+		s.logger.Error("Failed to list admins for alert", zap.Error(err))
+		return
+	}
+
+	for _, admin := range admins {
+		if err := s.telegramBot.SendNotification(*admin.TelegramChatID, message, "node_alert"); err != nil {
+			s.logger.Error("Failed to send node alert",
+				zap.Uint64("user_id", admin.ID),
+				zap.Error(err),
+			)
 		}
+	}
+}
+
+func (s *JobScheduler) checkNotificationThresholds() {
+	if s.telegramBot == nil {
+		return
+	}
+
+	s.logger.Debug("Checking notification thresholds")
+
+	notifications, err := s.thresholdSvc.Due()
+	if err != nil {
+		s.logger.Error("Failed to check notification thresholds", zap.Error(err))
+		return
+	}
 
-		if user.Plan == nil {
+	for i := range notifications {
+		n := &notifications[i]
+		message := telegram.FormatUsageNotification(
+			n.User.Email,
+			n.Period.RealBytesUp,
+			n.Period.RealBytesDown,
+			n.Period.BillableBytesUp,
+			n.Period.BillableBytesDown,
+			n.Quota,
+			n.PercentUsed,
+		)
+
+		// Thresholds stay due until the message goes through
+		if err := s.telegramBot.SendNotification(*n.User.TelegramChatID, message, "threshold"); err != nil {
+			s.logger.Error("Failed to send threshold notification",
+				zap.Uint64("user_id", n.User.ID),
+				zap.Error(err),
+			)
 			continue
 		}
 
-		totalBillable := usage.BillableBytesUp + usage.BillableBytesDown
-		percentUsed := float64(totalBillable) / float64(user.Plan.QuotaBytes) * 100
-
-		// Check if any threshold is crossed
-		// TODO: Load thresholds from database and check against them
-		// For now, we'll use hardcoded thresholds
-		thresholds := []float64{50, 80, 95}
-		for _, threshold := range thresholds {
-			if percentUsed >= threshold && percentUsed < threshold+5 {
-				// Send notification
-				message := telegram.FormatUsageNotification(
-					user.Email,
-					usage.RealBytesUp,
-					usage.RealBytesDown,
-					usage.BillableBytesUp,
-					usage.BillableBytesDown,
-					user.Plan.QuotaBytes,
-					percentUsed,
-				)
-
-				if err := s.telegramBot.SendNotification(*user.TelegramChatID, message, "threshold"); err != nil {
-					s.logger.Error("Failed to send threshold notification",
-						zap.Uint64("user_id", user.ID),
-						zap.Error(err),
-					)
-				}
-			}
+		if err := s.thresholdSvc.MarkTriggered(n); err != nil {
+			s.logger.Error("Failed to mark notification thresholds triggered",
+				zap.Uint64("user_id", n.User.ID),
+				zap.Error(err),
+			)
 		}
 	}
 }
 
 func (s *JobScheduler) cleanupStaleOnlineUsers() {
-	s.logger.Debug("Cleaning up stale online users")
+	deleted, err := s.onlineSvc.Cleanup()
+	if err != nil {
+		s.logger.Error("Failed to clean up stale online users", zap.Error(err))
+		return
+	}
 
-	// Delete online users not seen in the last 5 minutes
-	// This would be implemented in the repository
-	// For now, it's a placeholder
+	s.logger.Debug("Cleaned up stale online users", zap.Int64("deleted", deleted))
 }
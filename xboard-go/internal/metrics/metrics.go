@@ -30,6 +30,14 @@ var (
 		[]string{"node_id"},
 	)
 
+	DuplicateTrafficReportsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "duplicate_traffic_reports_total",
+			Help: "Total number of traffic reports ignored as already received",
+		},
+		[]string{"node_id"},
+	)
+
 	TelegramNotificationsTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "telegram_notifications_total",
@@ -53,12 +61,66 @@ var (
 		[]string{"user_id", "direction", "type"},
 	)
 
+	NodeCPUPercent = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_cpu_percent",
+			Help: "CPU usage reported by each node",
+		},
+		[]string{"node_id"},
+	)
+
+	NodeResourceBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_resource_bytes",
+			Help: "Memory, swap and disk reported by each node",
+		},
+		[]string{"node_id", "resource", "type"},
+	)
+
+	MultiplierCacheRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "multiplier_cache_requests_total",
+			Help: "Total number of multiplier cache lookups by result (hit or miss)",
+		},
+		[]string{"result"},
+	)
+
+	IngestPendingEntries = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "traffic_ingest_pending_entries",
+			Help: "Number of (user, node) traffic entries waiting to be flushed",
+		},
+	)
+
+	IngestFlushDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "traffic_ingest_flush_duration_seconds",
+			Help:    "Duration of buffered traffic flushes in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+
+	NodePushStreams = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "node_push_streams",
+			Help: "Number of open node push streams",
+		},
+	)
+
 	OnlineUsers = promauto.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "online_users_total",
 			Help: "Number of currently online users",
 		},
 	)
+
+	NodeOnlineUsers = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "node_online_users",
+			Help: "Number of users online on each node",
+		},
+		[]string{"node_id"},
+	)
 )
 
 func RecordTraffic(userID uint64, upload, download, billableUp, billableDown uint64) {
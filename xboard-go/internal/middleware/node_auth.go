@@ -4,20 +4,22 @@ import (
 	"net/http"
 	"strconv"
 
-	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/protocol"
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/service"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
-func NodeAuthMiddleware(cfg *config.NodeConfig, nodeRepo repository.NodeRepository) gin.HandlerFunc {
+func NodeAuthMiddleware(nodeKeySvc service.NodeKeyService, nodeRepo repository.NodeRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := c.Query("token")
 		if token == "" {
 			token = c.PostForm("token")
 		}
 
-		if token != cfg.ServerToken {
+		if token == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"message": "Invalid token",
 			})
@@ -48,9 +50,20 @@ func NodeAuthMiddleware(cfg *config.NodeConfig, nodeRepo repository.NodeReposito
 		}
 
 		node, err := nodeRepo.FindByID(nodeID)
-		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{
-				"message": "Server does not exist",
+		if err != nil && err != gorm.ErrRecordNotFound {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to get node",
+			})
+			c.Abort()
+			return
+		}
+
+		// Keys are per node, so the token can only be checked once the
+		// node is known. An unknown node gets the same answer as a bad
+		// token, so node IDs cannot be probed without a key.
+		if node == nil || !nodeKeySvc.Authenticate(node, token) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"message": "Invalid token",
 			})
 			c.Abort()
 			return
@@ -62,7 +75,7 @@ func NodeAuthMiddleware(cfg *config.NodeConfig, nodeRepo repository.NodeReposito
 		}
 
 		// Normalize node type (like Xboard does)
-		normalizedType := normalizeNodeType(nodeType)
+		normalizedType := protocol.NormalizeNodeType(nodeType)
 		if normalizedType != "" && node.NodeType != normalizedType {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"message": "Node type mismatch",
@@ -76,14 +89,3 @@ func NodeAuthMiddleware(cfg *config.NodeConfig, nodeRepo repository.NodeReposito
 		c.Next()
 	}
 }
-
-func normalizeNodeType(nodeType string) string {
-	switch nodeType {
-	case "v2ray":
-		return "vmess"
-	case "hysteria2":
-		return "hysteria"
-	default:
-		return nodeType
-	}
-}
@@ -1,6 +1,11 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strings"
 	"time"
 )
 
@@ -11,39 +16,147 @@ type User struct {
 	Role              string     `gorm:"type:enum('admin','user');default:'user'" json:"role"`
 	PlanID            *uint64    `gorm:"index" json:"plan_id"`
 	Plan              *Plan      `gorm:"foreignKey:PlanID" json:"plan,omitempty"`
-	TelegramChatID    *int64     `gorm:"index" json:"telegram_chat_id"`
+	TelegramChatID    *int64     `gorm:"uniqueIndex" json:"telegram_chat_id"`
 	TelegramLinkedAt  *time.Time `json:"telegram_linked_at"`
 	Banned            bool       `gorm:"default:false" json:"banned"`
-	Balance           int        `gorm:"default:0" json:"balance"`                     // Balance in cents
-	Discount          *int       `json:"discount"`                                     // Discount percentage
-	CommissionType    int        `gorm:"default:0" json:"commission_type"`             // 0: system 1: period 2: onetime
-	CommissionRate    *int       `json:"commission_rate"`                              // Commission rate percentage
-	CommissionBalance int        `gorm:"default:0" json:"commission_balance"`          // Commission balance in cents
-	Token             *string    `gorm:"index;size:32" json:"token,omitempty"`         // User API token
-	LastLoginAt       *time.Time `gorm:"index" json:"last_login_at"`                   // Last login timestamp
-	LastLoginIP       *string    `gorm:"size:45" json:"last_login_ip"`                 // Last login IP address
-	Remarks           *string    `gorm:"type:text" json:"remarks,omitempty"`           // Admin remarks
+	Balance           int        `gorm:"default:0" json:"balance"`             // Balance in cents
+	Discount          *int       `json:"discount"`                             // Discount percentage
+	CommissionType    int        `gorm:"default:0" json:"commission_type"`     // 0: system 1: period 2: onetime
+	CommissionRate    *int       `json:"commission_rate"`                      // Commission rate percentage
+	CommissionBalance int        `gorm:"default:0" json:"commission_balance"`  // Commission balance in cents
+	Token             *string    `gorm:"index;size:32" json:"token,omitempty"` // User API token
+	LastLoginAt       *time.Time `gorm:"index" json:"last_login_at"`           // Last login timestamp
+	LastLoginIP       *string    `gorm:"size:45" json:"last_login_ip"`         // Last login IP address
+	Remarks           *string    `gorm:"type:text" json:"remarks,omitempty"`   // Admin remarks
+	ResetDay          *int       `json:"reset_day"`                            // Day of month (1-31) usage periods reset on
+	ResetWeekday      *int       `json:"reset_weekday"`                        // Weekday (0=Sunday) weekly periods reset on
+	ResetMonth        *int       `json:"reset_month"`                          // Month (1-12) yearly periods reset on
+	ResetTimezone     *string    `gorm:"size:64" json:"reset_timezone"`        // IANA time zone for period boundaries
+	SpeedLimit        *uint64    `json:"speed_limit"`                          // Speed limit override in Mbps, 0 = unlimited
+	DeviceLimit       *uint      `json:"device_limit"`                         // Device limit override, 0 = unlimited
 	CreatedAt         time.Time  `json:"created_at"`
 	UpdatedAt         time.Time  `json:"updated_at"`
 }
 
+// ResetAnchor returns the user's period reset anchor, or nil if periods
+// should stay aligned to the calendar
+func (u *User) ResetAnchor() *ResetAnchor {
+	if u.ResetDay == nil && u.ResetWeekday == nil && u.ResetMonth == nil && u.ResetTimezone == nil {
+		return nil
+	}
+
+	anchor := &ResetAnchor{}
+	if u.ResetDay != nil {
+		anchor.DayOfMonth = *u.ResetDay
+	}
+	if u.ResetWeekday != nil {
+		anchor.Weekday = *u.ResetWeekday
+	}
+	if u.ResetMonth != nil {
+		anchor.Month = *u.ResetMonth
+	}
+	if u.ResetTimezone != nil {
+		anchor.Timezone = *u.ResetTimezone
+	}
+	return anchor
+}
+
+// EffectiveSpeedLimit returns the user's speed limit in Mbps, preferring the
+// per-user override over the plan setting. 0 means unlimited.
+func (u *User) EffectiveSpeedLimit() uint64 {
+	if u.SpeedLimit != nil {
+		return *u.SpeedLimit
+	}
+	if u.Plan != nil {
+		return u.Plan.SpeedLimit
+	}
+	return 0
+}
+
+// EffectiveDeviceLimit returns the user's concurrent device limit, preferring
+// the per-user override over the plan setting. 0 means unlimited.
+func (u *User) EffectiveDeviceLimit() uint {
+	if u.DeviceLimit != nil {
+		return *u.DeviceLimit
+	}
+	if u.Plan != nil {
+		return u.Plan.DeviceLimit
+	}
+	return 0
+}
+
+// ResetAnchor pins usage period boundaries to a user's own reset day instead
+// of the calendar. Zero values fall back to calendar alignment.
+type ResetAnchor struct {
+	DayOfMonth int    `json:"day_of_month"` // 1-31, clamped to the last day of shorter months
+	Weekday    int    `json:"weekday"`      // 0 (Sunday) - 6
+	Month      int    `json:"month"`        // 1-12, used by yearly periods
+	Timezone   string `json:"timezone"`     // IANA zone name, empty for server local time
+}
+
 type Label struct {
 	ID          uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
 	Name        string    `gorm:"uniqueIndex;not null;size:100" json:"name"`
 	Description string    `gorm:"type:text" json:"description"`
+	Priority    int       `gorm:"default:0" json:"priority"` // Higher wins under the priority multiplier strategy
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
 type Plan struct {
-	ID             uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
-	Name           string    `gorm:"uniqueIndex;not null;size:100" json:"name"`
-	QuotaBytes     uint64    `gorm:"default:0" json:"quota_bytes"`
-	ResetPeriod    string    `gorm:"type:enum('none','daily','weekly','monthly','yearly');default:'monthly'" json:"reset_period"`
-	BaseMultiplier float64   `gorm:"type:decimal(10,4);default:1.0" json:"base_multiplier"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
-	Labels         []Label   `gorm:"many2many:plan_labels" json:"labels,omitempty"`
+	ID             uint64  `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name           string  `gorm:"uniqueIndex;not null;size:100" json:"name"`
+	QuotaBytes     uint64  `gorm:"default:0" json:"quota_bytes"`
+	ResetPeriod    string  `gorm:"type:enum('none','daily','weekly','monthly','yearly');default:'monthly'" json:"reset_period"`
+	BaseMultiplier float64 `gorm:"type:decimal(10,4);default:1.0" json:"base_multiplier"`
+	SpeedLimit     uint64  `gorm:"default:0" json:"speed_limit"`  // Mbps, 0 = unlimited
+	DeviceLimit    uint    `gorm:"default:0" json:"device_limit"` // Concurrent devices, 0 = unlimited
+	// MultiplierStrategy combines the multipliers of the plan's labels found
+	// on a node
+	MultiplierStrategy string    `gorm:"type:enum('product','max','min','priority','sum');default:'product'" json:"multiplier_strategy"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+	Labels             []Label   `gorm:"many2many:plan_labels" json:"labels,omitempty"`
+}
+
+// Multiplier strategies for combining label multipliers
+const (
+	MultiplierStrategyProduct  = "product"
+	MultiplierStrategyMax      = "max"
+	MultiplierStrategyMin      = "min"
+	MultiplierStrategyPriority = "priority"
+	MultiplierStrategySum      = "sum"
+)
+
+// AllowsNode reports whether the node carries at least one of the plan's
+// labels. Labels must be preloaded on both.
+func (p *Plan) AllowsNode(node *Node) bool {
+	for _, planLabel := range p.Labels {
+		for _, nodeLabel := range node.Labels {
+			if planLabel.ID == nodeLabel.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type Subscription struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    uint64    `gorm:"index;not null" json:"user_id"`
+	PlanID    uint64    `gorm:"index;not null" json:"plan_id"`
+	Plan      *Plan     `gorm:"foreignKey:PlanID" json:"plan,omitempty"`
+	StartsAt  time.Time `gorm:"not null" json:"starts_at"`
+	ExpiresAt time.Time `gorm:"index;not null" json:"expires_at"`
+	AutoRenew bool      `gorm:"default:false" json:"auto_renew"`
+	Status    string    `gorm:"type:enum('active','expired','cancelled');default:'active';index" json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IsActive reports whether the subscription grants its plan at the given time
+func (s *Subscription) IsActive(at time.Time) bool {
+	return s.Status == "active" && s.ExpiresAt.After(at)
 }
 
 type PlanLabel struct {
@@ -62,6 +175,128 @@ type PlanLabelMultiplier struct {
 	UpdatedAt  time.Time `json:"updated_at"`
 }
 
+// MultiplierSchedule scales the multiplier of a node, a label or a plan
+// during a recurring time window, e.g. 0.5 from 01:00 to 07:00 for an
+// off-peak discount. Exactly one of NodeID, LabelID and PlanID is set.
+type MultiplierSchedule struct {
+	ID       uint64  `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name     string  `gorm:"size:100" json:"name"`
+	NodeID   *uint64 `gorm:"index" json:"node_id,omitempty"`
+	LabelID  *uint64 `gorm:"index" json:"label_id,omitempty"`
+	PlanID   *uint64 `gorm:"index" json:"plan_id,omitempty"`
+	Timezone string  `gorm:"size:64;not null;default:'UTC'" json:"timezone"` // IANA name
+	Weekdays uint8   `gorm:"not null;default:127" json:"weekdays"`           // Bit 0 is Sunday, bit 6 Saturday
+	// StartTime and EndTime are HH:MM wall-clock times. The window includes
+	// its start and excludes its end; an end before the start wraps past
+	// midnight and an equal end covers the whole day.
+	StartTime  string    `gorm:"size:5;not null" json:"start_time"`
+	EndTime    string    `gorm:"size:5;not null" json:"end_time"`
+	Multiplier float64   `gorm:"type:decimal(10,4);not null" json:"multiplier"`
+	Enabled    bool      `gorm:"not null" json:"enabled"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Schedule scopes
+const (
+	ScheduleScopeNode  = "node"
+	ScheduleScopeLabel = "label"
+	ScheduleScopePlan  = "plan"
+)
+
+// AllWeekdays is the weekday mask of a schedule active every day
+const AllWeekdays uint8 = 1<<7 - 1
+
+// Scope returns what the schedule is attached to
+func (s *MultiplierSchedule) Scope() string {
+	switch {
+	case s.NodeID != nil:
+		return ScheduleScopeNode
+	case s.LabelID != nil:
+		return ScheduleScopeLabel
+	default:
+		return ScheduleScopePlan
+	}
+}
+
+// Window parses the schedule's time zone and its start and end as minutes
+// since midnight
+func (s *MultiplierSchedule) Window() (*time.Location, int, int, error) {
+	location, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("invalid timezone %q", s.Timezone)
+	}
+	start, err := parseClock(s.StartTime)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	end, err := parseClock(s.EndTime)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return location, start, end, nil
+}
+
+// Validate checks the schedule is attached to exactly one target and its
+// window and multiplier make sense
+func (s *MultiplierSchedule) Validate() error {
+	targets := 0
+	for _, id := range []*uint64{s.NodeID, s.LabelID, s.PlanID} {
+		if id != nil {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return errors.New("exactly one of node_id, label_id and plan_id must be set")
+	}
+	if s.Weekdays == 0 || s.Weekdays > AllWeekdays {
+		return errors.New("weekdays must be a mask between 1 and 127")
+	}
+	if s.Multiplier <= 0 {
+		return errors.New("multiplier must be positive")
+	}
+	_, _, _, err := s.Window()
+	return err
+}
+
+// ActiveAt reports whether t falls in the schedule's window, given the
+// location, start and end returned by Window. The part of a window past
+// midnight belongs to the weekday it started on.
+func (s *MultiplierSchedule) ActiveAt(t time.Time, location *time.Location, start, end int) bool {
+	if !s.Enabled {
+		return false
+	}
+	local := t.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	weekday := local.Weekday()
+
+	switch {
+	case start == end:
+		return s.onWeekday(weekday)
+	case start < end:
+		return minute >= start && minute < end && s.onWeekday(weekday)
+	case minute >= start:
+		return s.onWeekday(weekday)
+	case minute < end:
+		return s.onWeekday((weekday + 6) % 7)
+	default:
+		return false
+	}
+}
+
+func (s *MultiplierSchedule) onWeekday(weekday time.Weekday) bool {
+	return s.Weekdays&(1<<uint(weekday)) != 0
+}
+
+// parseClock parses an HH:MM time of day into minutes since midnight
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, want HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
 type Node struct {
 	ID             uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
 	Name           string     `gorm:"index;not null;size:100" json:"name"`
@@ -72,9 +307,79 @@ type Node struct {
 	NodeMultiplier float64    `gorm:"type:decimal(10,4);default:1.0" json:"node_multiplier"`
 	Status         string     `gorm:"type:enum('active','inactive','maintenance');default:'active'" json:"status"`
 	LastSeenAt     *time.Time `gorm:"index" json:"last_seen_at"`
-	CreatedAt      time.Time  `json:"created_at"`
-	UpdatedAt      time.Time  `json:"updated_at"`
-	Labels         []Label    `gorm:"many2many:node_labels" json:"labels,omitempty"`
+	// Per-node API key, stored as a SHA-256 hex digest. The previous key
+	// keeps working until PreviousAPIKeyExpiresAt after a rotation.
+	APIKeyHash              *string    `gorm:"size:64" json:"-"`
+	PreviousAPIKeyHash      *string    `gorm:"size:64" json:"-"`
+	PreviousAPIKeyExpiresAt *time.Time `json:"-"`
+	APIKeyRotatedAt         *time.Time `json:"api_key_rotated_at"`
+	// Heartbeat health, classified from LastSeenAt by the node health job
+	HealthState     string     `gorm:"type:enum('unknown','online','degraded','offline');default:'unknown'" json:"health_state"`
+	HealthChangedAt *time.Time `json:"health_changed_at"`
+	// Highest X-Push-Seq accepted from the node, used to drop replayed pushes
+	LastPushSeq uint64    `gorm:"default:0" json:"last_push_seq"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Labels      []Label   `gorm:"many2many:node_labels" json:"labels,omitempty"`
+}
+
+// NodePushReceipt records an X-Request-ID a node sent with a traffic push so
+// a retried push with the same ID is ignored
+type NodePushReceipt struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	NodeID    uint64    `gorm:"uniqueIndex:idx_node_request,priority:1;not null" json:"node_id"`
+	RequestID string    `gorm:"uniqueIndex:idx_node_request,priority:2;size:64;not null" json:"request_id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// NodeStatus is a load report pushed by a node server. Recent reports are
+// kept in memory and snapshotted to the node_status_snapshots table.
+type NodeStatus struct {
+	ID         uint64    `gorm:"primaryKey;autoIncrement" json:"-"`
+	NodeID     uint64    `gorm:"index:idx_node_reported,priority:1;not null" json:"node_id"`
+	CPU        float64   `gorm:"type:decimal(5,2)" json:"cpu"`
+	MemTotal   uint64    `json:"mem_total"`
+	MemUsed    uint64    `json:"mem_used"`
+	SwapTotal  uint64    `json:"swap_total"`
+	SwapUsed   uint64    `json:"swap_used"`
+	DiskTotal  uint64    `json:"disk_total"`
+	DiskUsed   uint64    `json:"disk_used"`
+	ReportedAt time.Time `gorm:"index:idx_node_reported,priority:2;index:idx_reported_at;not null" json:"reported_at"`
+}
+
+func (NodeStatus) TableName() string {
+	return "node_status_snapshots"
+}
+
+// Node heartbeat health states
+const (
+	NodeHealthUnknown  = "unknown"
+	NodeHealthOnline   = "online"
+	NodeHealthDegraded = "degraded"
+	NodeHealthOffline  = "offline"
+)
+
+// NodeEvent records a node's health state transition
+type NodeEvent struct {
+	ID         uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	NodeID     uint64     `gorm:"index;not null" json:"node_id"`
+	FromState  string     `gorm:"size:20;not null" json:"from_state"`
+	ToState    string     `gorm:"size:20;not null" json:"to_state"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+	CreatedAt  time.Time  `gorm:"index" json:"created_at"`
+}
+
+// UserListChange records that node user lists may have changed. Its ID is
+// the user list version nodes sync from. Exactly one of UserID, PlanID and
+// NodeID is set, or none for a change that affects every user on every
+// node.
+type UserListChange struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    *uint64   `json:"user_id,omitempty"`
+	PlanID    *uint64   `json:"plan_id,omitempty"`
+	NodeID    *uint64   `json:"node_id,omitempty"`
+	Reason    string    `gorm:"size:32;not null" json:"reason"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
 }
 
 type NodeLabel struct {
@@ -85,25 +390,37 @@ type NodeLabel struct {
 }
 
 type UsagePeriod struct {
-	ID              uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
-	UserID          uint64    `gorm:"index;not null" json:"user_id"`
-	PlanID          uint64    `gorm:"not null" json:"plan_id"`
-	PeriodStart     time.Time `gorm:"index;not null" json:"period_start"`
-	PeriodEnd       time.Time `gorm:"index;not null" json:"period_end"`
-	RealBytesUp     uint64    `gorm:"default:0" json:"real_bytes_up"`
-	RealBytesDown   uint64    `gorm:"default:0" json:"real_bytes_down"`
-	BillableBytesUp uint64    `gorm:"default:0" json:"billable_bytes_up"`
-	BillableBytesDown uint64  `gorm:"default:0" json:"billable_bytes_down"`
-	IsCurrent       bool      `gorm:"index;default:true" json:"is_current"`
-	CreatedAt       time.Time `json:"created_at"`
-	UpdatedAt       time.Time `json:"updated_at"`
+	ID                uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID            uint64    `gorm:"index;not null" json:"user_id"`
+	PlanID            uint64    `gorm:"not null" json:"plan_id"`
+	PeriodStart       time.Time `gorm:"index;not null" json:"period_start"`
+	PeriodEnd         time.Time `gorm:"index;not null" json:"period_end"`
+	RealBytesUp       uint64    `gorm:"default:0" json:"real_bytes_up"`
+	RealBytesDown     uint64    `gorm:"default:0" json:"real_bytes_down"`
+	BillableBytesUp   uint64    `gorm:"default:0" json:"billable_bytes_up"`
+	BillableBytesDown uint64    `gorm:"default:0" json:"billable_bytes_down"`
+	QuotaBytes        *uint64   `json:"quota_bytes"` // Prorated quota override, nil uses the plan quota
+	IsCurrent         bool      `gorm:"index;default:true" json:"is_current"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// EffectiveQuota returns the quota that applies to this period
+func (p *UsagePeriod) EffectiveQuota(plan *Plan) uint64 {
+	if p.QuotaBytes != nil {
+		return *p.QuotaBytes
+	}
+	if plan == nil {
+		return 0
+	}
+	return plan.QuotaBytes
 }
 
 type NodeUsage struct {
 	ID                uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
-	UserID            uint64    `gorm:"index;not null" json:"user_id"`
-	NodeID            uint64    `gorm:"index;not null" json:"node_id"`
-	PeriodID          uint64    `gorm:"index;not null" json:"period_id"`
+	UserID            uint64    `gorm:"uniqueIndex:unique_user_node_period,priority:1;not null" json:"user_id"`
+	NodeID            uint64    `gorm:"uniqueIndex:unique_user_node_period,priority:2;index;not null" json:"node_id"`
+	PeriodID          uint64    `gorm:"uniqueIndex:unique_user_node_period,priority:3;index;not null" json:"period_id"`
 	RealBytesUp       uint64    `gorm:"default:0" json:"real_bytes_up"`
 	RealBytesDown     uint64    `gorm:"default:0" json:"real_bytes_down"`
 	BillableBytesUp   uint64    `gorm:"default:0" json:"billable_bytes_up"`
@@ -112,17 +429,114 @@ type NodeUsage struct {
 	UpdatedAt         time.Time `json:"updated_at"`
 }
 
+// TrafficPack is an add-on quota that stacks on top of the plan quota. Packs
+// are not reset with usage periods and are consumed only after the period's
+// base quota is exhausted.
+type TrafficPack struct {
+	ID        uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    uint64     `gorm:"index;not null" json:"user_id"`
+	Bytes     uint64     `gorm:"not null" json:"bytes"`
+	UsedBytes uint64     `gorm:"default:0" json:"used_bytes"`
+	Priority  int        `gorm:"default:0" json:"priority"` // Higher priority packs are consumed first
+	Source    string     `gorm:"type:enum('grant','purchase');default:'grant'" json:"source"`
+	Note      string     `gorm:"size:255" json:"note"`
+	ExpiresAt *time.Time `gorm:"index" json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// Remaining returns the unused bytes in the pack
+func (p *TrafficPack) Remaining() uint64 {
+	if p.UsedBytes >= p.Bytes {
+		return 0
+	}
+	return p.Bytes - p.UsedBytes
+}
+
+// IsUsable reports whether the pack can still be consumed at the given time
+func (p *TrafficPack) IsUsable(at time.Time) bool {
+	if p.RevokedAt != nil || p.Remaining() == 0 {
+		return false
+	}
+	return p.ExpiresAt == nil || p.ExpiresAt.After(at)
+}
+
+// TelegramThreshold is a usage level at which the user is notified on
+// Telegram, once per usage period: a percentage of the quota used, or bytes
+// of quota remaining
 type TelegramThreshold struct {
 	ID              uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
 	UserID          uint64     `gorm:"index;not null" json:"user_id"`
 	ThresholdType   string     `gorm:"type:enum('percent','bytes_remaining');not null" json:"threshold_type"`
-	ThresholdValue  float64    `gorm:"type:decimal(10,2);not null" json:"threshold_value"`
-	Enabled         bool       `gorm:"default:true" json:"enabled"`
+	ThresholdValue  float64    `gorm:"type:decimal(20,2);not null" json:"threshold_value"`
+	Enabled         bool       `gorm:"not null" json:"enabled"`
 	LastTriggeredAt *time.Time `json:"last_triggered_at"`
 	CreatedAt       time.Time  `json:"created_at"`
 	UpdatedAt       time.Time  `json:"updated_at"`
 }
 
+const (
+	ThresholdTypePercent        = "percent"
+	ThresholdTypeBytesRemaining = "bytes_remaining"
+)
+
+// DefaultTelegramThresholds returns the thresholds new users start with.
+// UserID is left for the caller to set.
+func DefaultTelegramThresholds() []TelegramThreshold {
+	thresholds := make([]TelegramThreshold, 0, 3)
+	for _, percent := range []float64{50, 80, 95} {
+		thresholds = append(thresholds, TelegramThreshold{
+			ThresholdType:  ThresholdTypePercent,
+			ThresholdValue: percent,
+			Enabled:        true,
+		})
+	}
+	return thresholds
+}
+
+// Validate checks the threshold's value makes sense for its type
+func (t *TelegramThreshold) Validate() error {
+	switch t.ThresholdType {
+	case ThresholdTypePercent:
+		if t.ThresholdValue <= 0 || t.ThresholdValue > 100 {
+			return errors.New("percent threshold must be above 0 and at most 100")
+		}
+	case ThresholdTypeBytesRemaining:
+		if t.ThresholdValue <= 0 || t.ThresholdValue != math.Trunc(t.ThresholdValue) {
+			return errors.New("bytes_remaining threshold must be a positive whole number of bytes")
+		}
+		if t.ThresholdValue >= 1e18 {
+			return errors.New("bytes_remaining threshold is too large")
+		}
+	default:
+		return errors.New("threshold_type must be percent or bytes_remaining")
+	}
+	return nil
+}
+
+// Crossed reports whether usage of used bytes out of quota has reached the
+// threshold
+func (t *TelegramThreshold) Crossed(used, quota uint64) bool {
+	if quota == 0 {
+		return false
+	}
+	if t.ThresholdType == ThresholdTypeBytesRemaining {
+		remaining := uint64(0)
+		if used < quota {
+			remaining = quota - used
+		}
+		return float64(remaining) <= t.ThresholdValue
+	}
+	return float64(used)/float64(quota)*100 >= t.ThresholdValue
+}
+
+// TriggeredSince reports whether the threshold fired at or after the given
+// time, e.g. the start of the current usage period
+func (t *TelegramThreshold) TriggeredSince(since time.Time) bool {
+	return t.LastTriggeredAt != nil && !t.LastTriggeredAt.Before(since)
+}
+
 type UserUUID struct {
 	ID        uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
 	UserID    uint64    `gorm:"uniqueIndex;not null" json:"user_id"`
@@ -131,11 +545,12 @@ type UserUUID struct {
 }
 
 type OnlineUser struct {
-	ID         uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
-	UserID     uint64    `gorm:"index;not null" json:"user_id"`
-	NodeID     uint64    `gorm:"not null" json:"node_id"`
-	IPAddress  string    `gorm:"size:45;not null" json:"ip_address"`
-	LastSeenAt time.Time `gorm:"index;not null" json:"last_seen_at"`
+	ID             uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID         uint64    `gorm:"index;not null" json:"user_id"`
+	NodeID         uint64    `gorm:"not null" json:"node_id"`
+	IPAddress      string    `gorm:"size:45;not null" json:"ip_address"`
+	NodeIdentifier string    `gorm:"size:64;not null;default:''" json:"node_identifier"`
+	LastSeenAt     time.Time `gorm:"index;not null" json:"last_seen_at"`
 }
 
 type RefreshToken struct {
@@ -146,6 +561,17 @@ type RefreshToken struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// TelegramLinkToken is a single-use token a user sends to the bot to link
+// their Telegram chat to their account
+type TelegramLinkToken struct {
+	ID        uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    uint64     `gorm:"index;not null" json:"user_id"`
+	Token     string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"index;not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
 // DTO for traffic reporting
 type TrafficReport struct {
 	UserID   uint64 `json:"user_id"`
@@ -153,6 +579,45 @@ type TrafficReport struct {
 	Download uint64 `json:"download"`
 }
 
+// TrafficDelta is a traffic report tagged with the node that sent it
+type TrafficDelta struct {
+	NodeID   uint64 `json:"node_id"`
+	UserID   uint64 `json:"user_id"`
+	Upload   uint64 `json:"upload"`
+	Download uint64 `json:"download"`
+	// ReceivedAt is when the report reached the panel, to the minute.
+	// Multiplier schedules are evaluated at this time.
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// TrafficBatch records a batch of traffic flushed from the ingest
+// write-ahead log once it is billed, so replaying the batch is a no-op
+type TrafficBatch struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	BatchID   string    `gorm:"uniqueIndex;size:36;not null" json:"batch_id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// UsageIncrement is traffic to add to a user's usage on one node within a
+// period
+type UsageIncrement struct {
+	UserID            uint64
+	NodeID            uint64
+	PeriodID          uint64
+	RealBytesUp       uint64
+	RealBytesDown     uint64
+	BillableBytesUp   uint64
+	BillableBytesDown uint64
+}
+
+// PackCharge is billed traffic beyond a user's quota, drawn from their
+// traffic packs. Uncovered is set to the bytes no pack could cover.
+type PackCharge struct {
+	UserID    uint64
+	Bytes     uint64
+	Uncovered uint64
+}
+
 // DTO for user list response (node protocol)
 type NodeUserDTO struct {
 	ID          uint64 `json:"id"`
@@ -163,19 +628,65 @@ type NodeUserDTO struct {
 
 // DTO for config response (node protocol)
 type NodeConfigDTO struct {
-	Protocol        string                 `json:"protocol"`
-	ListenIP        string                 `json:"listen_ip"`
-	ServerPort      uint                   `json:"server_port"`
-	Network         string                 `json:"network,omitempty"`
-	NetworkSettings map[string]interface{} `json:"networkSettings,omitempty"`
-	TLS             int                    `json:"tls,omitempty"`
-	BaseConfig      map[string]interface{} `json:"base_config"`
-	Routes          []interface{}          `json:"routes,omitempty"`
+	Protocol          string                 `json:"protocol"`
+	ListenIP          string                 `json:"listen_ip"`
+	ServerPort        uint                   `json:"server_port"`
+	Network           string                 `json:"network,omitempty"`
+	NetworkSettings   map[string]interface{} `json:"networkSettings,omitempty"`
+	TLS               int                    `json:"tls,omitempty"`
+	TLSSettings       map[string]interface{} `json:"tls_settings,omitempty"`
+	Host              string                 `json:"host,omitempty"`
+	ServerName        string                 `json:"server_name,omitempty"`
+	Flow              string                 `json:"flow,omitempty"`
+	Cipher            string                 `json:"cipher,omitempty"`
+	ServerKey         string                 `json:"server_key,omitempty"`
+	Obfs              string                 `json:"obfs,omitempty"`
+	ObfsSettings      map[string]interface{} `json:"obfs_settings,omitempty"`
+	ObfsPassword      string                 `json:"obfs-password,omitempty"`
+	Version           int                    `json:"version,omitempty"`
+	UpMbps            uint                   `json:"up_mbps,omitempty"`
+	DownMbps          uint                   `json:"down_mbps,omitempty"`
+	CongestionControl string                 `json:"congestion_control,omitempty"`
+	ZeroRTTHandshake  bool                   `json:"zero_rtt_handshake,omitempty"`
+	ALPN              []string               `json:"alpn,omitempty"`
+	BaseConfig        map[string]interface{} `json:"base_config"`
+	Routes            []interface{}          `json:"routes,omitempty"`
 }
 
 // DTO for online users
 type AliveIPMap map[uint64][]string
 
+// maxNodeIdentifierLength is the size of OnlineUser.NodeIdentifier
+const maxNodeIdentifierLength = 64
+
+// ParseAliveEntry splits an alive entry in Xboard's "IP_nodeIdentifier"
+// format into the normalized IP and the identifier. Entries without a valid
+// IP are rejected; an entry without an identifier is just the IP.
+func ParseAliveEntry(entry string) (string, string, bool) {
+	address, identifier, _ := strings.Cut(entry, "_")
+	ip := net.ParseIP(strings.Trim(address, "[]"))
+	if ip == nil {
+		return "", "", false
+	}
+	if len(identifier) > maxNodeIdentifierLength {
+		identifier = identifier[:maxNodeIdentifierLength]
+	}
+	return ip.String(), identifier, true
+}
+
+// DTO for a user's online IP, with the nodes it was reported by
+type OnlineIPDTO struct {
+	IP         string            `json:"ip"`
+	LastSeenAt time.Time         `json:"last_seen_at"`
+	Nodes      []OnlineIPNodeDTO `json:"nodes"`
+}
+
+type OnlineIPNodeDTO struct {
+	NodeID         uint64    `json:"node_id"`
+	NodeIdentifier string    `json:"node_identifier"`
+	LastSeenAt     time.Time `json:"last_seen_at"`
+}
+
 // DTO for device limit response
 type DeviceLimitDTO struct {
 	Alive map[uint64]uint `json:"alive"`
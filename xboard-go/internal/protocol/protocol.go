@@ -0,0 +1,218 @@
+// Package protocol defines the typed protocol_config stored on nodes and
+// renders it into the config returned to node servers.
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+)
+
+// Supported node types, as stored in Node.NodeType
+const (
+	TypeVMess       = "vmess"
+	TypeVLess       = "vless"
+	TypeTrojan      = "trojan"
+	TypeShadowsocks = "shadowsocks"
+	TypeHysteria    = "hysteria"
+	TypeTUIC        = "tuic"
+)
+
+// Config is the parsed protocol_config of a node
+type Config interface {
+	// Defaults fills in the fields left unset
+	Defaults()
+	// Validate checks the config, after Defaults
+	Validate() error
+	// Apply copies the config into the node server's config response
+	Apply(dto *models.NodeConfigDTO)
+}
+
+// NormalizeNodeType maps the aliases node servers report (like Xboard) to
+// the stored node type
+func NormalizeNodeType(nodeType string) string {
+	switch nodeType {
+	case "v2ray":
+		return TypeVMess
+	case "hysteria2":
+		return TypeHysteria
+	default:
+		return nodeType
+	}
+}
+
+// Parse decodes and validates a node's protocol_config. An empty config
+// yields the protocol defaults. Unknown fields are rejected, so admins see
+// typos when they save a node.
+func Parse(nodeType, raw string) (Config, error) {
+	config, err := newConfig(nodeType)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(raw) != "" {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(raw)))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(config); err != nil {
+			return nil, fmt.Errorf("invalid %s protocol_config: %w", nodeType, err)
+		}
+	}
+
+	config.Defaults()
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s protocol_config: %w", nodeType, err)
+	}
+	return config, nil
+}
+
+// Load decodes a stored protocol_config for serving. Configs are validated
+// when admins save them, so Load does not validate again, and it ignores
+// unknown fields, which configs saved before validation existed may have.
+// The keys it ignored are returned so callers can log them.
+func Load(nodeType, raw string) (Config, []string, error) {
+	config, err := newConfig(nodeType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var unknown []string
+	if strings.TrimSpace(raw) != "" {
+		if err := json.Unmarshal([]byte(raw), config); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s protocol_config: %w", nodeType, err)
+		}
+		unknown = unknownKeys(config, raw)
+	}
+
+	config.Defaults()
+	return config, unknown, nil
+}
+
+func newConfig(nodeType string) (Config, error) {
+	var config Config
+	switch nodeType {
+	case TypeVMess:
+		config = &VMessConfig{}
+	case TypeVLess:
+		config = &VLessConfig{}
+	case TypeTrojan:
+		config = &TrojanConfig{}
+	case TypeShadowsocks:
+		config = &ShadowsocksConfig{}
+	case TypeHysteria:
+		config = &HysteriaConfig{}
+	case TypeTUIC:
+		config = &TUICConfig{}
+	default:
+		return nil, fmt.Errorf("unsupported node type %q", nodeType)
+	}
+	return config, nil
+}
+
+// unknownKeys lists the top-level keys of raw that config has no field for.
+// Like encoding/json, keys match field names case-insensitively.
+func unknownKeys(config Config, raw string) []string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+		return nil
+	}
+
+	known := make(map[string]bool)
+	collectKeys(reflect.TypeOf(config).Elem(), known)
+
+	var unknown []string
+	for key := range fields {
+		if !known[strings.ToLower(key)] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func collectKeys(t reflect.Type, known map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			collectKeys(field.Type, known)
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" {
+			name = field.Name
+		}
+		known[strings.ToLower(name)] = true
+	}
+}
+
+// Transport is the stream transport shared by the V2Ray-family protocols
+type Transport struct {
+	Network         string                 `json:"network"`
+	NetworkSettings map[string]interface{} `json:"network_settings,omitempty"`
+	// XboardNetworkSettings accepts the key Xboard stores network
+	// settings under, so configs copied from Xboard keep working
+	XboardNetworkSettings map[string]interface{} `json:"networkSettings,omitempty"`
+}
+
+func (t *Transport) defaults() {
+	if t.Network == "" {
+		t.Network = "tcp"
+	}
+	if t.NetworkSettings == nil {
+		t.NetworkSettings = t.XboardNetworkSettings
+		t.XboardNetworkSettings = nil
+	}
+}
+
+func (t *Transport) validate() error {
+	if t.XboardNetworkSettings != nil {
+		return fmt.Errorf("set network_settings or networkSettings, not both")
+	}
+
+	switch t.Network {
+	case "tcp", "h2":
+	case "ws", "httpupgrade":
+		if path := t.Path(); path != "" && !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("network_settings.path must start with /")
+		}
+	case "grpc":
+		if t.ServiceName() == "" {
+			return fmt.Errorf("network_settings.serviceName is required for grpc")
+		}
+	default:
+		return fmt.Errorf("unsupported network %q", t.Network)
+	}
+	return nil
+}
+
+func (t *Transport) apply(dto *models.NodeConfigDTO) {
+	dto.Network = t.Network
+	dto.NetworkSettings = t.NetworkSettings
+}
+
+// Path returns the ws/httpupgrade path, if set
+func (t *Transport) Path() string {
+	path, _ := t.NetworkSettings["path"].(string)
+	return path
+}
+
+// Host returns the Host header for ws/httpupgrade, if set
+func (t *Transport) Host() string {
+	if headers, ok := t.NetworkSettings["headers"].(map[string]interface{}); ok {
+		if host, ok := headers["Host"].(string); ok {
+			return host
+		}
+	}
+	host, _ := t.NetworkSettings["host"].(string)
+	return host
+}
+
+// ServiceName returns the gRPC service name, if set
+func (t *Transport) ServiceName() string {
+	name, _ := t.NetworkSettings["serviceName"].(string)
+	return name
+}
@@ -0,0 +1,145 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+)
+
+const (
+	testPrivateKey = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8"
+	testPublicKey  = "ICEiIyQlJicoKSorLC0uLzAxMjM0NTY3ODk6Ozw9Pj8"
+	testKey16      = "AAAAAAAAAAAAAAAAAAAAAA=="
+	testKey32      = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+)
+
+// Test parsing and validating configs of every protocol
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		nodeType string
+		raw      string
+		wantErr  bool
+	}{
+		// vmess
+		{"vmess defaults", TypeVMess, "", false},
+		{"vmess ws", TypeVMess, `{"network":"ws","network_settings":{"path":"/ws"},"tls":1}`, false},
+		{"vmess xboard networkSettings", TypeVMess, `{"network":"ws","networkSettings":{"path":"/ws"}}`, false},
+		{"vmess both network settings", TypeVMess, `{"network":"ws","network_settings":{},"networkSettings":{}}`, true},
+		{"vmess ws path without slash", TypeVMess, `{"network":"ws","network_settings":{"path":"ws"}}`, true},
+		{"vmess reality", TypeVMess, `{"tls":2}`, true},
+		{"vmess unknown network", TypeVMess, `{"network":"kcp"}`, true},
+		{"vmess grpc", TypeVMess, `{"network":"grpc","network_settings":{"serviceName":"proxy"}}`, false},
+		{"vmess grpc without serviceName", TypeVMess, `{"network":"grpc"}`, true},
+		{"vmess unknown field", TypeVMess, `{"netwrok":"ws"}`, true},
+
+		// vless
+		{"vless vision", TypeVLess, `{"flow":"xtls-rprx-vision","tls":1}`, false},
+		{"vless vision over ws", TypeVLess, `{"network":"ws","flow":"xtls-rprx-vision"}`, true},
+		{"vless unknown flow", TypeVLess, `{"flow":"xtls-rprx-direct"}`, true},
+		{"vless reality", TypeVLess, `{"tls":2,"server_name":"example.com","reality":{"dest":"example.com:443","private_key":"` + testPrivateKey + `","public_key":"` + testPublicKey + `","short_id":"0123abcd"}}`, false},
+		{"vless reality without short_id", TypeVLess, `{"tls":2,"server_name":"example.com","reality":{"dest":"example.com:443","private_key":"` + testPrivateKey + `","public_key":"` + testPublicKey + `"}}`, false},
+		{"vless reality settings without tls 2", TypeVLess, `{"tls":1,"reality":{"dest":"example.com:443"}}`, true},
+		{"vless reality without settings", TypeVLess, `{"tls":2,"server_name":"example.com"}`, true},
+		{"vless reality without server_name", TypeVLess, `{"tls":2,"reality":{"dest":"example.com:443","private_key":"` + testPrivateKey + `","public_key":"` + testPublicKey + `"}}`, true},
+		{"vless reality without dest", TypeVLess, `{"tls":2,"server_name":"example.com","reality":{"private_key":"` + testPrivateKey + `","public_key":"` + testPublicKey + `"}}`, true},
+		{"vless reality short key", TypeVLess, `{"tls":2,"server_name":"example.com","reality":{"dest":"example.com:443","private_key":"AAEC","public_key":"` + testPublicKey + `"}}`, true},
+		{"vless reality odd short_id", TypeVLess, `{"tls":2,"server_name":"example.com","reality":{"dest":"example.com:443","private_key":"` + testPrivateKey + `","public_key":"` + testPublicKey + `","short_id":"abc"}}`, true},
+		{"vless reality long short_id", TypeVLess, `{"tls":2,"server_name":"example.com","reality":{"dest":"example.com:443","private_key":"` + testPrivateKey + `","public_key":"` + testPublicKey + `","short_id":"0123456789abcdef01"}}`, true},
+		{"vless reality non-hex short_id", TypeVLess, `{"tls":2,"server_name":"example.com","reality":{"dest":"example.com:443","private_key":"` + testPrivateKey + `","public_key":"` + testPublicKey + `","short_id":"zz"}}`, true},
+		{"vless bad tls", TypeVLess, `{"tls":3}`, true},
+
+		// trojan
+		{"trojan defaults", TypeTrojan, "", false},
+		{"trojan grpc", TypeTrojan, `{"network":"grpc","network_settings":{"serviceName":"proxy"},"server_name":"example.com"}`, false},
+		{"trojan grpc without serviceName", TypeTrojan, `{"network":"grpc","network_settings":{}}`, true},
+
+		// shadowsocks
+		{"shadowsocks defaults", TypeShadowsocks, "", false},
+		{"shadowsocks 2022 aes-128", TypeShadowsocks, `{"cipher":"2022-blake3-aes-128-gcm","server_key":"` + testKey16 + `"}`, false},
+		{"shadowsocks 2022 aes-256", TypeShadowsocks, `{"cipher":"2022-blake3-aes-256-gcm","server_key":"` + testKey32 + `"}`, false},
+		{"shadowsocks 2022 short key", TypeShadowsocks, `{"cipher":"2022-blake3-aes-256-gcm","server_key":"` + testKey16 + `"}`, true},
+		{"shadowsocks 2022 without key", TypeShadowsocks, `{"cipher":"2022-blake3-chacha20-poly1305"}`, true},
+		{"shadowsocks key for legacy cipher", TypeShadowsocks, `{"cipher":"aes-256-gcm","server_key":"` + testKey32 + `"}`, true},
+		{"shadowsocks unknown cipher", TypeShadowsocks, `{"cipher":"rc4-md5"}`, true},
+		{"shadowsocks http obfs", TypeShadowsocks, `{"obfs":"http","obfs_settings":{"host":"example.com"}}`, false},
+		{"shadowsocks unknown obfs", TypeShadowsocks, `{"obfs":"tls"}`, true},
+
+		// hysteria
+		{"hysteria defaults", TypeHysteria, "", false},
+		{"hysteria salamander", TypeHysteria, `{"obfs":"salamander","obfs_password":"secret","up_mbps":100}`, false},
+		{"hysteria salamander without password", TypeHysteria, `{"obfs":"salamander"}`, true},
+		{"hysteria password without obfs", TypeHysteria, `{"obfs_password":"secret"}`, true},
+		{"hysteria unknown obfs", TypeHysteria, `{"obfs":"xor","obfs_password":"secret"}`, true},
+
+		// tuic
+		{"tuic defaults", TypeTUIC, "", false},
+		{"tuic cubic", TypeTUIC, `{"congestion_control":"cubic","alpn":["h3","spdy/3.1"]}`, false},
+		{"tuic unknown congestion control", TypeTUIC, `{"congestion_control":"vegas"}`, true},
+
+		{"unsupported node type", "wireguard", "", true},
+		{"malformed json", TypeVMess, `{"network":`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.nodeType, tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Test that defaults are filled in and Xboard's networkSettings key is moved
+// to network_settings
+func TestParseDefaults(t *testing.T) {
+	config, err := Parse(TypeVMess, `{"network":"ws","networkSettings":{"path":"/ws"}}`)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	vmess := config.(*VMessConfig)
+	if vmess.Path() != "/ws" || vmess.XboardNetworkSettings != nil {
+		t.Errorf("Transport = %+v, want networkSettings moved to network_settings", vmess.Transport)
+	}
+
+	config, err = Parse(TypeTUIC, "")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	tuic := config.(*TUICConfig)
+	if tuic.CongestionControl != "bbr" || !reflect.DeepEqual(tuic.ALPN, []string{"h3"}) {
+		t.Errorf("TUIC defaults = %+v, want bbr and h3", tuic)
+	}
+}
+
+// Test that stored configs are served even if they would no longer pass
+// validation, and that unknown keys are reported
+func TestLoad(t *testing.T) {
+	// A grpc transport without serviceName fails Parse but is still served
+	config, unknown, err := Load(TypeVMess, `{"network":"grpc","tls":1}`)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if config.(*VMessConfig).Network != "grpc" || len(unknown) != 0 {
+		t.Errorf("Load() = %+v, %v, want grpc and no unknown keys", config, unknown)
+	}
+
+	// Unknown keys are ignored and reported, sorted
+	config, unknown, err = Load(TypeVLess, `{"Flow":"xtls-rprx-vision","legacy":true,"alpn":["h2"]}`)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if config.(*VLessConfig).Flow != "xtls-rprx-vision" {
+		t.Errorf("Flow = %q, want the case-insensitive match", config.(*VLessConfig).Flow)
+	}
+	if want := []string{"alpn", "legacy"}; !reflect.DeepEqual(unknown, want) {
+		t.Errorf("unknown keys = %v, want %v", unknown, want)
+	}
+
+	if _, _, err := Load(TypeVMess, `{"network":`); err == nil {
+		t.Error("Load() should fail on malformed json")
+	}
+	if _, _, err := Load("wireguard", ""); err == nil {
+		t.Error("Load() should fail on an unsupported node type")
+	}
+}
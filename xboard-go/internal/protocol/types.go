@@ -0,0 +1,306 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+)
+
+// TLS modes for vmess and vless
+const (
+	TLSNone    = 0
+	TLSEnabled = 1
+	TLSReality = 2
+)
+
+type VMessConfig struct {
+	Transport
+	TLS        int    `json:"tls"`
+	ServerName string `json:"server_name,omitempty"`
+}
+
+func (c *VMessConfig) Defaults() {
+	c.Transport.defaults()
+}
+
+func (c *VMessConfig) Validate() error {
+	if c.TLS != TLSNone && c.TLS != TLSEnabled {
+		return fmt.Errorf("tls must be 0 or 1")
+	}
+	return c.Transport.validate()
+}
+
+func (c *VMessConfig) Apply(dto *models.NodeConfigDTO) {
+	c.Transport.apply(dto)
+	dto.TLS = c.TLS
+	dto.ServerName = c.ServerName
+}
+
+type VLessConfig struct {
+	Transport
+	TLS        int            `json:"tls"`
+	ServerName string         `json:"server_name,omitempty"`
+	Flow       string         `json:"flow,omitempty"`
+	Insecure   bool           `json:"insecure,omitempty"`
+	Reality    *RealityConfig `json:"reality,omitempty"`
+}
+
+// RealityConfig holds the REALITY keys for vless with tls = 2
+type RealityConfig struct {
+	Dest       string `json:"dest"`
+	PrivateKey string `json:"private_key"`
+	PublicKey  string `json:"public_key"`
+	ShortID    string `json:"short_id,omitempty"`
+}
+
+func (c *VLessConfig) Defaults() {
+	c.Transport.defaults()
+}
+
+func (c *VLessConfig) Validate() error {
+	if err := c.Transport.validate(); err != nil {
+		return err
+	}
+
+	switch c.Flow {
+	case "":
+	case "xtls-rprx-vision":
+		if c.Network != "tcp" {
+			return fmt.Errorf("flow xtls-rprx-vision requires network tcp")
+		}
+	default:
+		return fmt.Errorf("unsupported flow %q", c.Flow)
+	}
+
+	switch c.TLS {
+	case TLSNone, TLSEnabled:
+		if c.Reality != nil {
+			return fmt.Errorf("reality requires tls 2")
+		}
+	case TLSReality:
+		if c.Reality == nil {
+			return fmt.Errorf("reality settings are required for tls 2")
+		}
+		if c.ServerName == "" {
+			return fmt.Errorf("server_name is required for reality")
+		}
+		if err := c.Reality.validate(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("tls must be 0, 1 or 2")
+	}
+	return nil
+}
+
+func (r *RealityConfig) validate() error {
+	if r.Dest == "" {
+		return fmt.Errorf("reality.dest is required")
+	}
+	for name, key := range map[string]string{"private_key": r.PrivateKey, "public_key": r.PublicKey} {
+		decoded, err := base64.RawURLEncoding.DecodeString(key)
+		if err != nil || len(decoded) != 32 {
+			return fmt.Errorf("reality.%s must be a base64url encoded X25519 key", name)
+		}
+	}
+	if len(r.ShortID) > 16 || len(r.ShortID)%2 != 0 {
+		return fmt.Errorf("reality.short_id must be an even number of hex digits, at most 16")
+	}
+	if _, err := hex.DecodeString(r.ShortID); err != nil {
+		return fmt.Errorf("reality.short_id must be hex")
+	}
+	return nil
+}
+
+func (c *VLessConfig) Apply(dto *models.NodeConfigDTO) {
+	c.Transport.apply(dto)
+	dto.TLS = c.TLS
+	dto.ServerName = c.ServerName
+	dto.Flow = c.Flow
+
+	switch c.TLS {
+	case TLSEnabled:
+		dto.TLSSettings = map[string]interface{}{
+			"server_name":    c.ServerName,
+			"allow_insecure": c.Insecure,
+		}
+	case TLSReality:
+		if c.Reality == nil {
+			break
+		}
+		dto.TLSSettings = map[string]interface{}{
+			"server_name": c.ServerName,
+			"dest":        c.Reality.Dest,
+			"private_key": c.Reality.PrivateKey,
+			"public_key":  c.Reality.PublicKey,
+			"short_id":    c.Reality.ShortID,
+		}
+	}
+}
+
+type TrojanConfig struct {
+	Transport
+	ServerName string `json:"server_name,omitempty"`
+	Host       string `json:"host,omitempty"`
+	Insecure   bool   `json:"insecure,omitempty"`
+}
+
+func (c *TrojanConfig) Defaults() {
+	c.Transport.defaults()
+}
+
+func (c *TrojanConfig) Validate() error {
+	return c.Transport.validate()
+}
+
+func (c *TrojanConfig) Apply(dto *models.NodeConfigDTO) {
+	c.Transport.apply(dto)
+	dto.ServerName = c.ServerName
+	dto.Host = c.Host
+}
+
+// shadowsocksKeyLengths maps supported ciphers to their key length.
+// Ciphers with a zero length derive the key from the password.
+var shadowsocksKeyLengths = map[string]int{
+	"aes-128-gcm":                   0,
+	"aes-192-gcm":                   0,
+	"aes-256-gcm":                   0,
+	"chacha20-ietf-poly1305":        0,
+	"2022-blake3-aes-128-gcm":       16,
+	"2022-blake3-aes-256-gcm":       32,
+	"2022-blake3-chacha20-poly1305": 32,
+}
+
+type ShadowsocksConfig struct {
+	Cipher       string            `json:"cipher"`
+	ServerKey    string            `json:"server_key,omitempty"`
+	Obfs         string            `json:"obfs,omitempty"`
+	ObfsSettings map[string]string `json:"obfs_settings,omitempty"`
+}
+
+func (c *ShadowsocksConfig) Defaults() {
+	if c.Cipher == "" {
+		c.Cipher = "aes-128-gcm"
+	}
+}
+
+func (c *ShadowsocksConfig) Validate() error {
+	keyLen, ok := shadowsocksKeyLengths[c.Cipher]
+	if !ok {
+		return fmt.Errorf("unsupported cipher %q", c.Cipher)
+	}
+
+	if keyLen > 0 {
+		key, err := base64.StdEncoding.DecodeString(c.ServerKey)
+		if err != nil || len(key) != keyLen {
+			return fmt.Errorf("server_key must be a base64 encoded %d-byte key for %s", keyLen, c.Cipher)
+		}
+	} else if c.ServerKey != "" {
+		return fmt.Errorf("server_key is only used by 2022 ciphers")
+	}
+
+	switch c.Obfs {
+	case "", "http":
+	default:
+		return fmt.Errorf("unsupported obfs %q", c.Obfs)
+	}
+	return nil
+}
+
+// UserPassword returns the password a user connects with. 2022 ciphers
+// combine the server key with a per-user key cut from the user's UUID.
+func (c *ShadowsocksConfig) UserPassword(uuid string) string {
+	keyLen := shadowsocksKeyLengths[c.Cipher]
+	if keyLen == 0 {
+		return uuid
+	}
+	if len(uuid) > keyLen {
+		uuid = uuid[:keyLen]
+	}
+	return c.ServerKey + ":" + base64.StdEncoding.EncodeToString([]byte(uuid))
+}
+
+func (c *ShadowsocksConfig) Apply(dto *models.NodeConfigDTO) {
+	dto.Cipher = c.Cipher
+	dto.ServerKey = c.ServerKey
+	dto.Obfs = c.Obfs
+	if len(c.ObfsSettings) > 0 {
+		dto.ObfsSettings = make(map[string]interface{}, len(c.ObfsSettings))
+		for k, v := range c.ObfsSettings {
+			dto.ObfsSettings[k] = v
+		}
+	}
+}
+
+// HysteriaConfig is a Hysteria 2 node
+type HysteriaConfig struct {
+	ServerName   string `json:"server_name,omitempty"`
+	UpMbps       uint   `json:"up_mbps,omitempty"`
+	DownMbps     uint   `json:"down_mbps,omitempty"`
+	Obfs         string `json:"obfs,omitempty"`
+	ObfsPassword string `json:"obfs_password,omitempty"`
+	Insecure     bool   `json:"insecure,omitempty"`
+}
+
+func (c *HysteriaConfig) Defaults() {}
+
+func (c *HysteriaConfig) Validate() error {
+	switch c.Obfs {
+	case "":
+		if c.ObfsPassword != "" {
+			return fmt.Errorf("obfs_password requires obfs salamander")
+		}
+	case "salamander":
+		if c.ObfsPassword == "" {
+			return fmt.Errorf("obfs_password is required for salamander")
+		}
+	default:
+		return fmt.Errorf("unsupported obfs %q", c.Obfs)
+	}
+	return nil
+}
+
+func (c *HysteriaConfig) Apply(dto *models.NodeConfigDTO) {
+	dto.Version = 2
+	dto.ServerName = c.ServerName
+	dto.UpMbps = c.UpMbps
+	dto.DownMbps = c.DownMbps
+	dto.Obfs = c.Obfs
+	dto.ObfsPassword = c.ObfsPassword
+}
+
+type TUICConfig struct {
+	ServerName        string   `json:"server_name,omitempty"`
+	CongestionControl string   `json:"congestion_control,omitempty"`
+	ZeroRTTHandshake  bool     `json:"zero_rtt_handshake,omitempty"`
+	ALPN              []string `json:"alpn,omitempty"`
+	Insecure          bool     `json:"insecure,omitempty"`
+}
+
+func (c *TUICConfig) Defaults() {
+	if c.CongestionControl == "" {
+		c.CongestionControl = "bbr"
+	}
+	if len(c.ALPN) == 0 {
+		c.ALPN = []string{"h3"}
+	}
+}
+
+func (c *TUICConfig) Validate() error {
+	switch c.CongestionControl {
+	case "bbr", "cubic", "new_reno":
+	default:
+		return fmt.Errorf("unsupported congestion_control %q", c.CongestionControl)
+	}
+	return nil
+}
+
+func (c *TUICConfig) Apply(dto *models.NodeConfigDTO) {
+	dto.Version = 5
+	dto.ServerName = c.ServerName
+	dto.CongestionControl = c.CongestionControl
+	dto.ZeroRTTHandshake = c.ZeroRTTHandshake
+	dto.ALPN = c.ALPN
+}
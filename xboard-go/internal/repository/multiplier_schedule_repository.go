@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type MultiplierScheduleRepository interface {
+	Create(schedule *models.MultiplierSchedule) error
+	FindByID(id uint64) (*models.MultiplierSchedule, error)
+	Update(schedule *models.MultiplierSchedule) error
+	Delete(id uint64) error
+	List() ([]models.MultiplierSchedule, error)
+	FindApplicable(planID, nodeID uint64, labelIDs []uint64) ([]models.MultiplierSchedule, error)
+}
+
+type multiplierScheduleRepository struct {
+	db *gorm.DB
+}
+
+func NewMultiplierScheduleRepository(db *gorm.DB) MultiplierScheduleRepository {
+	return &multiplierScheduleRepository{db: db}
+}
+
+func (r *multiplierScheduleRepository) Create(schedule *models.MultiplierSchedule) error {
+	return r.db.Create(schedule).Error
+}
+
+func (r *multiplierScheduleRepository) FindByID(id uint64) (*models.MultiplierSchedule, error) {
+	var schedule models.MultiplierSchedule
+	err := r.db.First(&schedule, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+func (r *multiplierScheduleRepository) Update(schedule *models.MultiplierSchedule) error {
+	return r.db.Save(schedule).Error
+}
+
+func (r *multiplierScheduleRepository) Delete(id uint64) error {
+	return r.db.Delete(&models.MultiplierSchedule{}, id).Error
+}
+
+func (r *multiplierScheduleRepository) List() ([]models.MultiplierSchedule, error) {
+	var schedules []models.MultiplierSchedule
+	err := r.db.Order("id").Find(&schedules).Error
+	return schedules, err
+}
+
+// FindApplicable returns the enabled schedules attached to the plan, the node
+// or any of the given labels
+func (r *multiplierScheduleRepository) FindApplicable(planID, nodeID uint64, labelIDs []uint64) ([]models.MultiplierSchedule, error) {
+	query := r.db.Where("enabled = ?", true)
+	if len(labelIDs) > 0 {
+		query = query.Where("(plan_id = ? OR node_id = ? OR label_id IN ?)", planID, nodeID, labelIDs)
+	} else {
+		query = query.Where("(plan_id = ? OR node_id = ?)", planID, nodeID)
+	}
+
+	var schedules []models.MultiplierSchedule
+	err := query.Order("id").Find(&schedules).Error
+	return schedules, err
+}
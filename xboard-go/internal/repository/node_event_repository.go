@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type NodeEventRepository interface {
+	Create(event *models.NodeEvent) error
+	ListByNode(nodeID uint64, limit int) ([]models.NodeEvent, error)
+}
+
+type nodeEventRepository struct {
+	db *gorm.DB
+}
+
+func NewNodeEventRepository(db *gorm.DB) NodeEventRepository {
+	return &nodeEventRepository{db: db}
+}
+
+func (r *nodeEventRepository) Create(event *models.NodeEvent) error {
+	return r.db.Create(event).Error
+}
+
+// ListByNode returns the node's most recent events, newest first
+func (r *nodeEventRepository) ListByNode(nodeID uint64, limit int) ([]models.NodeEvent, error) {
+	var events []models.NodeEvent
+	err := r.db.Where("node_id = ?", nodeID).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&events).Error
+	return events, err
+}
@@ -2,20 +2,26 @@ package repository
 
 import (
 	"time"
+
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type NodeRepository interface {
 	Create(node *models.Node) error
 	FindByID(id uint64) (*models.Node, error)
 	Update(node *models.Node) error
+	UpdateAPIKey(node *models.Node) error
 	Delete(id uint64) error
 	List(offset, limit int) ([]models.Node, int64, error)
 	FindByIDWithLabels(id uint64) (*models.Node, error)
 	FindActiveNodes() ([]models.Node, error)
 	UpdateLastSeen(nodeID uint64) error
+	UpdateHealthState(nodeID uint64, state string, at time.Time) error
+	ClaimPushSeq(nodeID, seq uint64, reset bool) (bool, uint64, error)
+	ReleasePushSeq(nodeID, seq, previous uint64) error
 	AddLabel(nodeID, labelID uint64) error
 	RemoveLabel(nodeID, labelID uint64) error
 	GetLabels(nodeID uint64) ([]models.Label, error)
@@ -51,8 +57,20 @@ func (r *nodeRepository) FindByIDWithLabels(id uint64) (*models.Node, error) {
 	return &node, nil
 }
 
+// Update writes the node's admin-editable columns. Keys, health, heartbeats
+// and the push sequence have their own writers, so a node loaded earlier
+// cannot roll them back.
 func (r *nodeRepository) Update(node *models.Node) error {
-	return r.db.Save(node).Error
+	return r.db.Model(node).
+		Select("name", "node_type", "host", "port", "protocol_config", "node_multiplier", "status").
+		Updates(node).Error
+}
+
+// UpdateAPIKey writes the node's key columns
+func (r *nodeRepository) UpdateAPIKey(node *models.Node) error {
+	return r.db.Model(node).
+		Select("api_key_hash", "previous_api_key_hash", "previous_api_key_expires_at", "api_key_rotated_at").
+		Updates(node).Error
 }
 
 func (r *nodeRepository) Delete(id uint64) error {
@@ -82,6 +100,44 @@ func (r *nodeRepository) UpdateLastSeen(nodeID uint64) error {
 	return r.db.Model(&models.Node{}).Where("id = ?", nodeID).Update("last_seen_at", now).Error
 }
 
+func (r *nodeRepository) UpdateHealthState(nodeID uint64, state string, at time.Time) error {
+	return r.db.Model(&models.Node{}).Where("id = ?", nodeID).Updates(map[string]interface{}{
+		"health_state":      state,
+		"health_changed_at": at,
+	}).Error
+}
+
+// ClaimPushSeq advances the node's last push sequence to seq if seq is newer,
+// or sets it to seq regardless with reset. It reports whether it did, along
+// with the previous sequence.
+func (r *nodeRepository) ClaimPushSeq(nodeID, seq uint64, reset bool) (bool, uint64, error) {
+	claimed := false
+	var previous uint64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var node models.Node
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "last_push_seq").
+			First(&node, nodeID).Error; err != nil {
+			return err
+		}
+
+		previous = node.LastPushSeq
+		if seq <= previous && !reset {
+			return nil
+		}
+		claimed = true
+		return tx.Model(&models.Node{}).Where("id = ?", nodeID).UpdateColumn("last_push_seq", seq).Error
+	})
+	return claimed, previous, err
+}
+
+// ReleasePushSeq undoes a claim unless a newer sequence was claimed since
+func (r *nodeRepository) ReleasePushSeq(nodeID, seq, previous uint64) error {
+	return r.db.Model(&models.Node{}).
+		Where("id = ? AND last_push_seq = ?", nodeID, seq).
+		UpdateColumn("last_push_seq", previous).Error
+}
+
 func (r *nodeRepository) AddLabel(nodeID, labelID uint64) error {
 	nodeLabel := &models.NodeLabel{
 		NodeID:  nodeID,
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type NodeStatusRepository interface {
+	CreateBatch(statuses []models.NodeStatus) error
+	ListSince(since time.Time) ([]models.NodeStatus, error)
+	DeleteBefore(before time.Time) (int64, error)
+}
+
+type nodeStatusRepository struct {
+	db *gorm.DB
+}
+
+func NewNodeStatusRepository(db *gorm.DB) NodeStatusRepository {
+	return &nodeStatusRepository{db: db}
+}
+
+func (r *nodeStatusRepository) CreateBatch(statuses []models.NodeStatus) error {
+	if len(statuses) == 0 {
+		return nil
+	}
+	return r.db.CreateInBatches(statuses, 500).Error
+}
+
+// ListSince returns snapshots reported at or after since, oldest first
+func (r *nodeStatusRepository) ListSince(since time.Time) ([]models.NodeStatus, error) {
+	var statuses []models.NodeStatus
+	err := r.db.Where("reported_at >= ?", since).
+		Order("reported_at ASC, id ASC").
+		Find(&statuses).Error
+	return statuses, err
+}
+
+func (r *nodeStatusRepository) DeleteBefore(before time.Time) (int64, error) {
+	result := r.db.Where("reported_at < ?", before).Delete(&models.NodeStatus{})
+	return result.RowsAffected, result.Error
+}
@@ -2,17 +2,27 @@ package repository
 
 import (
 	"time"
+
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type OnlineUserRepository interface {
-	UpsertOnlineUser(userID, nodeID uint64, ipAddress string) error
+	UpsertOnlineUser(userID, nodeID uint64, ipAddress, nodeIdentifier string) error
 	GetOnlineDeviceCount(userID uint64) (uint, error)
+	// GetAllOnlineDeviceCounts counts each user's distinct IPs across nodes
 	GetAllOnlineDeviceCounts() (map[uint64]uint, error)
-	CleanupStaleOnlineUsers(before time.Time) error
+	CleanupStaleOnlineUsers(before time.Time) (int64, error)
 	DeleteByUser(userID uint64) error
+	ListByUser(userID uint64) ([]models.OnlineUser, error)
+	// ReplaceNode replaces everything online on the node with the given rows
+	ReplaceNode(nodeID uint64, onlineUsers []models.OnlineUser) error
+	// CountOnlineUsers counts distinct online users across nodes
+	CountOnlineUsers() (int64, error)
+	// GetOnlineCountsByNode counts distinct online users per node
+	GetOnlineCountsByNode() (map[uint64]uint, error)
 }
 
 type onlineUserRepository struct {
@@ -23,16 +33,17 @@ func NewOnlineUserRepository(db *gorm.DB) OnlineUserRepository {
 	return &onlineUserRepository{db: db}
 }
 
-func (r *onlineUserRepository) UpsertOnlineUser(userID, nodeID uint64, ipAddress string) error {
+func (r *onlineUserRepository) UpsertOnlineUser(userID, nodeID uint64, ipAddress, nodeIdentifier string) error {
 	var onlineUser models.OnlineUser
-	result := r.db.Where("user_id = ? AND node_id = ? AND ip_address = ?", userID, nodeID, ipAddress).First(&onlineUser)
+	result := r.db.Where("user_id = ? AND node_id = ? AND ip_address = ? AND node_identifier = ?", userID, nodeID, ipAddress, nodeIdentifier).First(&onlineUser)
 
 	if result.Error == gorm.ErrRecordNotFound {
 		onlineUser = models.OnlineUser{
-			UserID:     userID,
-			NodeID:     nodeID,
-			IPAddress:  ipAddress,
-			LastSeenAt: time.Now(),
+			UserID:         userID,
+			NodeID:         nodeID,
+			IPAddress:      ipAddress,
+			NodeIdentifier: nodeIdentifier,
+			LastSeenAt:     time.Now(),
 		}
 		return r.db.Create(&onlineUser).Error
 	}
@@ -77,10 +88,60 @@ func (r *onlineUserRepository) GetAllOnlineDeviceCounts() (map[uint64]uint, erro
 	return counts, nil
 }
 
-func (r *onlineUserRepository) CleanupStaleOnlineUsers(before time.Time) error {
-	return r.db.Where("last_seen_at < ?", before).Delete(&models.OnlineUser{}).Error
+func (r *onlineUserRepository) CleanupStaleOnlineUsers(before time.Time) (int64, error) {
+	result := r.db.Where("last_seen_at < ?", before).Delete(&models.OnlineUser{})
+	return result.RowsAffected, result.Error
 }
 
 func (r *onlineUserRepository) DeleteByUser(userID uint64) error {
 	return r.db.Where("user_id = ?", userID).Delete(&models.OnlineUser{}).Error
 }
+
+func (r *onlineUserRepository) ReplaceNode(nodeID uint64, onlineUsers []models.OnlineUser) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("node_id = ?", nodeID).Delete(&models.OnlineUser{}).Error; err != nil {
+			return err
+		}
+		if len(onlineUsers) == 0 {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(onlineUsers, 500).Error
+	})
+}
+
+func (r *onlineUserRepository) CountOnlineUsers() (int64, error) {
+	var count int64
+	err := r.db.Model(&models.OnlineUser{}).
+		Distinct("user_id").
+		Count(&count).Error
+	return count, err
+}
+
+func (r *onlineUserRepository) GetOnlineCountsByNode() (map[uint64]uint, error) {
+	var results []struct {
+		NodeID uint64
+		Count  uint
+	}
+
+	err := r.db.Model(&models.OnlineUser{}).
+		Select("node_id, COUNT(DISTINCT user_id) as count").
+		Group("node_id").
+		Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uint64]uint, len(results))
+	for _, result := range results {
+		counts[result.NodeID] = result.Count
+	}
+	return counts, nil
+}
+
+func (r *onlineUserRepository) ListByUser(userID uint64) ([]models.OnlineUser, error) {
+	var onlineUsers []models.OnlineUser
+	err := r.db.Where("user_id = ?", userID).
+		Order("last_seen_at DESC, id").
+		Find(&onlineUsers).Error
+	return onlineUsers, err
+}
@@ -15,10 +15,12 @@ type PlanRepository interface {
 	List(offset, limit int) ([]models.Plan, int64, error)
 	AddLabel(planID, labelID uint64) error
 	RemoveLabel(planID, labelID uint64) error
+	SetLabels(planID uint64, labelIDs []uint64) error
 	GetLabels(planID uint64) ([]models.Label, error)
 	SetLabelMultiplier(planID, labelID uint64, multiplier float64) error
 	GetLabelMultiplier(planID, labelID uint64) (float64, error)
 	GetAllLabelMultipliers(planID uint64) (map[uint64]float64, error)
+	DeleteLabelMultiplier(planID, labelID uint64) error
 }
 
 type planRepository struct {
@@ -83,6 +85,42 @@ func (r *planRepository) RemoveLabel(planID, labelID uint64) error {
 	return r.db.Where("plan_id = ? AND label_id = ?", planID, labelID).Delete(&models.PlanLabel{}).Error
 }
 
+// SetLabels replaces the plan's labels in one transaction. The plan's
+// multipliers for labels it no longer has are deleted with them.
+func (r *planRepository) SetLabels(planID uint64, labelIDs []uint64) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		removed := tx.Where("plan_id = ?", planID)
+		if len(labelIDs) > 0 {
+			removed = removed.Where("label_id NOT IN ?", labelIDs)
+		}
+		if err := removed.Session(&gorm.Session{}).Delete(&models.PlanLabel{}).Error; err != nil {
+			return err
+		}
+		if err := removed.Session(&gorm.Session{}).Delete(&models.PlanLabelMultiplier{}).Error; err != nil {
+			return err
+		}
+
+		var existing []uint64
+		if err := tx.Model(&models.PlanLabel{}).Where("plan_id = ?", planID).Pluck("label_id", &existing).Error; err != nil {
+			return err
+		}
+		has := make(map[uint64]bool, len(existing))
+		for _, id := range existing {
+			has[id] = true
+		}
+		for _, labelID := range labelIDs {
+			if has[labelID] {
+				continue
+			}
+			has[labelID] = true
+			if err := tx.Create(&models.PlanLabel{PlanID: planID, LabelID: labelID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (r *planRepository) GetLabels(planID uint64) ([]models.Label, error) {
 	var plan models.Plan
 	err := r.db.Preload("Labels").First(&plan, planID).Error
@@ -138,3 +176,9 @@ func (r *planRepository) GetAllLabelMultipliers(planID uint64) (map[uint64]float
 	}
 	return result, nil
 }
+
+// DeleteLabelMultiplier removes the plan's multiplier for the label, so the
+// label counts as 1.0 again
+func (r *planRepository) DeleteLabelMultiplier(planID, labelID uint64) error {
+	return r.db.Where("plan_id = ? AND label_id = ?", planID, labelID).Delete(&models.PlanLabelMultiplier{}).Error
+}
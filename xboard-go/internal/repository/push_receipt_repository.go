@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PushReceiptRepository interface {
+	Create(receipt *models.NodePushReceipt) (bool, error)
+	Delete(nodeID uint64, requestID string) error
+	DeleteBefore(before time.Time) (int64, error)
+}
+
+type pushReceiptRepository struct {
+	db *gorm.DB
+}
+
+func NewPushReceiptRepository(db *gorm.DB) PushReceiptRepository {
+	return &pushReceiptRepository{db: db}
+}
+
+// Create records a receipt. It returns false without error if the node
+// already sent the same request ID.
+func (r *pushReceiptRepository) Create(receipt *models.NodePushReceipt) (bool, error) {
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(receipt)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *pushReceiptRepository) Delete(nodeID uint64, requestID string) error {
+	return r.db.Where("node_id = ? AND request_id = ?", nodeID, requestID).
+		Delete(&models.NodePushReceipt{}).Error
+}
+
+func (r *pushReceiptRepository) DeleteBefore(before time.Time) (int64, error) {
+	result := r.db.Where("created_at < ?", before).Delete(&models.NodePushReceipt{})
+	return result.RowsAffected, result.Error
+}
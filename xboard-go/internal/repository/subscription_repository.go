@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type SubscriptionRepository interface {
+	Create(sub *models.Subscription) error
+	FindByID(id uint64) (*models.Subscription, error)
+	Update(sub *models.Subscription) error
+	ListByUser(userID uint64) ([]models.Subscription, error)
+	FindActiveByUser(userID uint64) (*models.Subscription, error)
+	FindDue(before time.Time, afterID uint64, limit int) ([]models.Subscription, error)
+	FindUsersExpiring(after, upTo time.Time) ([]uint64, error)
+}
+
+type subscriptionRepository struct {
+	db *gorm.DB
+}
+
+func NewSubscriptionRepository(db *gorm.DB) SubscriptionRepository {
+	return &subscriptionRepository{db: db}
+}
+
+func (r *subscriptionRepository) Create(sub *models.Subscription) error {
+	return r.db.Create(sub).Error
+}
+
+func (r *subscriptionRepository) FindByID(id uint64) (*models.Subscription, error) {
+	var sub models.Subscription
+	err := r.db.Preload("Plan").First(&sub, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func (r *subscriptionRepository) Update(sub *models.Subscription) error {
+	return r.db.Omit("Plan").Save(sub).Error
+}
+
+func (r *subscriptionRepository) ListByUser(userID uint64) ([]models.Subscription, error) {
+	var subs []models.Subscription
+	err := r.db.Preload("Plan").
+		Where("user_id = ?", userID).
+		Order("starts_at DESC").
+		Find(&subs).Error
+	return subs, err
+}
+
+// FindActiveByUser returns the user's active subscription, even if it has
+// lapsed and is waiting for the expiry job
+func (r *subscriptionRepository) FindActiveByUser(userID uint64) (*models.Subscription, error) {
+	var sub models.Subscription
+	err := r.db.Preload("Plan").
+		Where("user_id = ? AND status = ?", userID, "active").
+		Order("expires_at DESC").
+		First(&sub).Error
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+// FindDue returns active subscriptions that expired before the given time,
+// ordered by ID and starting after afterID so callers can page through them
+func (r *subscriptionRepository) FindDue(before time.Time, afterID uint64, limit int) ([]models.Subscription, error) {
+	var subs []models.Subscription
+	err := r.db.Where("status = ? AND expires_at <= ? AND id > ?", "active", before, afterID).
+		Order("id ASC").
+		Limit(limit).
+		Find(&subs).Error
+	return subs, err
+}
+
+// FindUsersExpiring returns the users with an active subscription expiring
+// in (after, upTo]
+func (r *subscriptionRepository) FindUsersExpiring(after, upTo time.Time) ([]uint64, error) {
+	var ids []uint64
+	err := r.db.Model(&models.Subscription{}).
+		Where("status = ? AND expires_at > ? AND expires_at <= ?", "active", after, upTo).
+		Distinct().
+		Pluck("user_id", &ids).Error
+	return ids, err
+}
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type TelegramLinkTokenRepository interface {
+	// Create stores a token, replacing the user's earlier tokens
+	Create(token *models.TelegramLinkToken) error
+	// Consume marks an unused, unexpired token used and returns it. It
+	// returns gorm.ErrRecordNotFound for any other token.
+	Consume(token string, at time.Time) (*models.TelegramLinkToken, error)
+}
+
+type telegramLinkTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewTelegramLinkTokenRepository(db *gorm.DB) TelegramLinkTokenRepository {
+	return &telegramLinkTokenRepository{db: db}
+}
+
+func (r *telegramLinkTokenRepository) Create(token *models.TelegramLinkToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// Expired tokens of other users are cleaned up along the way
+		if err := tx.Where("user_id = ? OR expires_at < ?", token.UserID, time.Now()).
+			Delete(&models.TelegramLinkToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+func (r *telegramLinkTokenRepository) Consume(token string, at time.Time) (*models.TelegramLinkToken, error) {
+	// The conditional update lets only one of concurrent attempts win
+	result := r.db.Model(&models.TelegramLinkToken{}).
+		Where("token = ? AND used_at IS NULL AND expires_at > ?", token, at).
+		Update("used_at", at)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var linkToken models.TelegramLinkToken
+	if err := r.db.Where("token = ?", token).First(&linkToken).Error; err != nil {
+		return nil, err
+	}
+	return &linkToken, nil
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type TelegramThresholdRepository interface {
+	Create(threshold *models.TelegramThreshold) error
+	FindByID(id uint64) (*models.TelegramThreshold, error)
+	FindByUser(userID uint64) ([]models.TelegramThreshold, error)
+	Update(threshold *models.TelegramThreshold) error
+	Delete(id uint64) error
+	FindEnabledForLinkedUsers() ([]models.TelegramThreshold, error)
+	MarkTriggered(ids []uint64, at time.Time) error
+}
+
+type telegramThresholdRepository struct {
+	db *gorm.DB
+}
+
+func NewTelegramThresholdRepository(db *gorm.DB) TelegramThresholdRepository {
+	return &telegramThresholdRepository{db: db}
+}
+
+func (r *telegramThresholdRepository) Create(threshold *models.TelegramThreshold) error {
+	return r.db.Create(threshold).Error
+}
+
+func (r *telegramThresholdRepository) FindByID(id uint64) (*models.TelegramThreshold, error) {
+	var threshold models.TelegramThreshold
+	err := r.db.First(&threshold, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &threshold, nil
+}
+
+func (r *telegramThresholdRepository) FindByUser(userID uint64) ([]models.TelegramThreshold, error) {
+	var thresholds []models.TelegramThreshold
+	err := r.db.Where("user_id = ?", userID).
+		Order("threshold_type, threshold_value").
+		Find(&thresholds).Error
+	return thresholds, err
+}
+
+func (r *telegramThresholdRepository) Update(threshold *models.TelegramThreshold) error {
+	return r.db.Save(threshold).Error
+}
+
+func (r *telegramThresholdRepository) Delete(id uint64) error {
+	return r.db.Delete(&models.TelegramThreshold{}, id).Error
+}
+
+// FindEnabledForLinkedUsers returns the enabled thresholds of users who can
+// be notified: not banned and with a linked Telegram chat
+func (r *telegramThresholdRepository) FindEnabledForLinkedUsers() ([]models.TelegramThreshold, error) {
+	var thresholds []models.TelegramThreshold
+	err := r.db.Joins("JOIN users ON users.id = telegram_thresholds.user_id").
+		Where("telegram_thresholds.enabled = ?", true).
+		Where("users.telegram_chat_id IS NOT NULL AND users.banned = ?", false).
+		Order("telegram_thresholds.user_id, telegram_thresholds.id").
+		Find(&thresholds).Error
+	return thresholds, err
+}
+
+func (r *telegramThresholdRepository) MarkTriggered(ids []uint64, at time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.Model(&models.TelegramThreshold{}).
+		Where("id IN ?", ids).
+		Update("last_triggered_at", at).Error
+}
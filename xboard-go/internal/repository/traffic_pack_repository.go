@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TrafficPackRepository interface {
+	Create(pack *models.TrafficPack) error
+	FindByID(id uint64) (*models.TrafficPack, error)
+	ListByUser(userID uint64) ([]models.TrafficPack, error)
+	Revoke(id uint64) error
+	GetRemaining(userID uint64, at time.Time) (uint64, error)
+	GetAllRemaining(at time.Time) (map[uint64]uint64, error)
+	Consume(userID uint64, bytes uint64, at time.Time) (uint64, error)
+	FindUsersExpiring(after, upTo time.Time) ([]uint64, error)
+}
+
+type trafficPackRepository struct {
+	db *gorm.DB
+}
+
+func NewTrafficPackRepository(db *gorm.DB) TrafficPackRepository {
+	return &trafficPackRepository{db: db}
+}
+
+func (r *trafficPackRepository) Create(pack *models.TrafficPack) error {
+	return r.db.Create(pack).Error
+}
+
+func (r *trafficPackRepository) FindByID(id uint64) (*models.TrafficPack, error) {
+	var pack models.TrafficPack
+	err := r.db.First(&pack, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &pack, nil
+}
+
+func (r *trafficPackRepository) ListByUser(userID uint64) ([]models.TrafficPack, error) {
+	var packs []models.TrafficPack
+	err := r.db.Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&packs).Error
+	return packs, err
+}
+
+func (r *trafficPackRepository) Revoke(id uint64) error {
+	return r.db.Model(&models.TrafficPack{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now()).Error
+}
+
+// usable limits a query to packs that can still be consumed at the given time
+func usable(db *gorm.DB, at time.Time) *gorm.DB {
+	return db.Where("revoked_at IS NULL AND used_bytes < bytes AND (expires_at IS NULL OR expires_at > ?)", at)
+}
+
+func (r *trafficPackRepository) GetRemaining(userID uint64, at time.Time) (uint64, error) {
+	var remaining uint64
+	err := usable(r.db.Model(&models.TrafficPack{}), at).
+		Where("user_id = ?", userID).
+		Select("COALESCE(SUM(bytes - used_bytes), 0)").
+		Scan(&remaining).Error
+	return remaining, err
+}
+
+// GetAllRemaining maps user IDs to their total unused pack bytes
+func (r *trafficPackRepository) GetAllRemaining(at time.Time) (map[uint64]uint64, error) {
+	var results []struct {
+		UserID    uint64
+		Remaining uint64
+	}
+
+	err := usable(r.db.Model(&models.TrafficPack{}), at).
+		Select("user_id, SUM(bytes - used_bytes) as remaining").
+		Group("user_id").
+		Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := make(map[uint64]uint64)
+	for _, row := range results {
+		remaining[row.UserID] = row.Remaining
+	}
+	return remaining, nil
+}
+
+// Consume charges bytes against the user's usable packs, highest priority
+// first and then soonest to expire. It returns the bytes that no pack could
+// cover.
+func (r *trafficPackRepository) Consume(userID uint64, bytes uint64, at time.Time) (uint64, error) {
+	var remaining uint64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		remaining, err = consumePacks(tx, userID, bytes, at)
+		return err
+	})
+	if err != nil {
+		return bytes, err
+	}
+	return remaining, nil
+}
+
+// consumePacks does the work of Consume within the caller's transaction
+func consumePacks(tx *gorm.DB, userID uint64, bytes uint64, at time.Time) (uint64, error) {
+	var packs []models.TrafficPack
+	if err := usable(tx.Clauses(clause.Locking{Strength: "UPDATE"}), at).
+		Where("user_id = ?", userID).
+		Order("priority DESC, expires_at IS NULL, expires_at ASC, id ASC").
+		Find(&packs).Error; err != nil {
+		return bytes, err
+	}
+
+	remaining := bytes
+	for _, pack := range packs {
+		if remaining == 0 {
+			break
+		}
+
+		take := pack.Remaining()
+		if take > remaining {
+			take = remaining
+		}
+
+		if err := tx.Model(&models.TrafficPack{}).
+			Where("id = ?", pack.ID).
+			Update("used_bytes", gorm.Expr("used_bytes + ?", take)).Error; err != nil {
+			return bytes, err
+		}
+		remaining -= take
+	}
+	return remaining, nil
+}
+
+// FindUsersExpiring returns the users with a pack that is not revoked or
+// used up and expires in (after, upTo]
+func (r *trafficPackRepository) FindUsersExpiring(after, upTo time.Time) ([]uint64, error) {
+	var ids []uint64
+	err := r.db.Model(&models.TrafficPack{}).
+		Where("revoked_at IS NULL AND used_bytes < bytes AND expires_at > ? AND expires_at <= ?", after, upTo).
+		Distinct().
+		Pluck("user_id", &ids).Error
+	return ids, err
+}
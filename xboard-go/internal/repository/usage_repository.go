@@ -1,23 +1,35 @@
 package repository
 
 import (
+	"strings"
 	"time"
+
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// usageBatchSize bounds the rows written per statement by ApplyIncrements
+const usageBatchSize = 500
+
 type UsageRepository interface {
 	GetCurrentPeriod(userID uint64) (*models.UsagePeriod, error)
+	GetCurrentPeriods(userIDs []uint64) (map[uint64]*models.UsagePeriod, error)
 	CreatePeriod(period *models.UsagePeriod) error
 	UpdatePeriod(period *models.UsagePeriod) error
-	ClosePeriod(periodID uint64) error
+	ClosePeriod(periodID uint64, at time.Time) error
+	FindExpiredCurrentPeriods(before time.Time, afterID uint64, limit int) ([]models.UsagePeriod, error)
+	RolloverPeriod(periodID uint64, next *models.UsagePeriod) (bool, error)
 	GetPeriodHistory(userID uint64, start, end time.Time) ([]models.UsagePeriod, error)
 	GetNodeUsage(periodID uint64) ([]models.NodeUsage, error)
 	GetNodeUsageByUserAndNode(userID, nodeID, periodID uint64) (*models.NodeUsage, error)
 	CreateNodeUsage(usage *models.NodeUsage) error
 	UpdateNodeUsage(usage *models.NodeUsage) error
 	IncrementUsage(userID, nodeID uint64, realUp, realDown, billableUp, billableDown uint64) error
+	ApplyIncrements(batchID string, increments []models.UsageIncrement, charges []models.PackCharge, at time.Time) (bool, error)
+	BatchApplied(batchID string) (bool, error)
+	DeleteBatchesBefore(before time.Time) (int64, error)
 }
 
 type usageRepository struct {
@@ -37,6 +49,24 @@ func (r *usageRepository) GetCurrentPeriod(userID uint64) (*models.UsagePeriod,
 	return &period, nil
 }
 
+// GetCurrentPeriods returns the current period of each given user, keyed by
+// user ID. Users without a current period are absent from the map.
+func (r *usageRepository) GetCurrentPeriods(userIDs []uint64) (map[uint64]*models.UsagePeriod, error) {
+	result := make(map[uint64]*models.UsagePeriod, len(userIDs))
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+
+	var periods []models.UsagePeriod
+	if err := r.db.Where("user_id IN ? AND is_current = ?", userIDs, true).Find(&periods).Error; err != nil {
+		return nil, err
+	}
+	for i := range periods {
+		result[periods[i].UserID] = &periods[i]
+	}
+	return result, nil
+}
+
 func (r *usageRepository) CreatePeriod(period *models.UsagePeriod) error {
 	return r.db.Create(period).Error
 }
@@ -45,8 +75,73 @@ func (r *usageRepository) UpdatePeriod(period *models.UsagePeriod) error {
 	return r.db.Save(period).Error
 }
 
-func (r *usageRepository) ClosePeriod(periodID uint64) error {
-	return r.db.Model(&models.UsagePeriod{}).Where("id = ?", periodID).Update("is_current", false).Error
+// ClosePeriod ends the period, truncating it to end at the given time if it
+// would run past it
+func (r *usageRepository) ClosePeriod(periodID uint64, at time.Time) error {
+	return r.db.Model(&models.UsagePeriod{}).
+		Where("id = ?", periodID).
+		Updates(map[string]interface{}{
+			"is_current": false,
+			"period_end": truncatedPeriodEnd(at),
+		}).Error
+}
+
+// truncatedPeriodEnd moves period_end back to at if it is later, but never
+// before period_start
+func truncatedPeriodEnd(at time.Time) clause.Expr {
+	return gorm.Expr("GREATEST(period_start, LEAST(period_end, ?))", at)
+}
+
+// FindExpiredCurrentPeriods returns current periods that ended before the given
+// time, ordered by ID and starting after afterID so callers can page through them.
+func (r *usageRepository) FindExpiredCurrentPeriods(before time.Time, afterID uint64, limit int) ([]models.UsagePeriod, error) {
+	var periods []models.UsagePeriod
+	err := r.db.Where("is_current = ? AND period_end <= ? AND id > ?", true, before, afterID).
+		Order("id ASC").
+		Limit(limit).
+		Find(&periods).Error
+	return periods, err
+}
+
+// RolloverPeriod closes the given period and opens next in a single transaction.
+// It returns false without changes if the period was already closed, so it is
+// safe to call again after a partially completed run. If another current period
+// already exists for the user, the old one is closed and next is not created.
+// A period that would overlap next is truncated to end where next starts, but
+// never before its own start.
+func (r *usageRepository) RolloverPeriod(periodID uint64, next *models.UsagePeriod) (bool, error) {
+	rolled := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.UsagePeriod{}).
+			Where("id = ? AND is_current = ?", periodID, true).
+			Updates(map[string]interface{}{
+				"is_current": false,
+				"period_end": truncatedPeriodEnd(next.PeriodStart),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		rolled = true
+
+		var existing int64
+		if err := tx.Model(&models.UsagePeriod{}).
+			Where("user_id = ? AND is_current = ?", next.UserID, true).
+			Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return nil
+		}
+
+		return tx.Create(next).Error
+	})
+	if err != nil {
+		return false, err
+	}
+	return rolled, nil
 }
 
 func (r *usageRepository) GetPeriodHistory(userID uint64, start, end time.Time) ([]models.UsagePeriod, error) {
@@ -127,3 +222,127 @@ func (r *usageRepository) IncrementUsage(userID, nodeID uint64, realUp, realDown
 		}).Error
 	})
 }
+
+// ApplyIncrements adds coalesced traffic to period totals and node usage in
+// one transaction, using a single UPDATE per batch of periods and a bulk
+// upsert on the (user_id, node_id, period_id) key for node usage. A non-empty
+// batchID is recorded in the same transaction; if it was recorded before,
+// nothing is written and false is returned. Charges are drawn from traffic
+// packs in the same transaction too, so a batch is never recorded without
+// its pack usage.
+func (r *usageRepository) ApplyIncrements(batchID string, increments []models.UsageIncrement, charges []models.PackCharge, at time.Time) (bool, error) {
+	if len(increments) == 0 && batchID == "" {
+		return true, nil
+	}
+
+	totals := make(map[uint64]*models.UsageIncrement)
+	var periodIDs []uint64
+	rows := make([]models.NodeUsage, 0, len(increments))
+	for _, inc := range increments {
+		total, ok := totals[inc.PeriodID]
+		if !ok {
+			total = &models.UsageIncrement{PeriodID: inc.PeriodID}
+			totals[inc.PeriodID] = total
+			periodIDs = append(periodIDs, inc.PeriodID)
+		}
+		total.RealBytesUp += inc.RealBytesUp
+		total.RealBytesDown += inc.RealBytesDown
+		total.BillableBytesUp += inc.BillableBytesUp
+		total.BillableBytesDown += inc.BillableBytesDown
+
+		rows = append(rows, models.NodeUsage{
+			UserID:            inc.UserID,
+			NodeID:            inc.NodeID,
+			PeriodID:          inc.PeriodID,
+			RealBytesUp:       inc.RealBytesUp,
+			RealBytesDown:     inc.RealBytesDown,
+			BillableBytesUp:   inc.BillableBytesUp,
+			BillableBytesDown: inc.BillableBytesDown,
+		})
+	}
+
+	applied := true
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if batchID != "" {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.TrafficBatch{BatchID: batchID})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				applied = false
+				return nil
+			}
+		}
+		for i := range charges {
+			uncovered, err := consumePacks(tx, charges[i].UserID, charges[i].Bytes, at)
+			if err != nil {
+				return err
+			}
+			charges[i].Uncovered = uncovered
+		}
+
+		if len(rows) == 0 {
+			return nil
+		}
+
+		for start := 0; start < len(periodIDs); start += usageBatchSize {
+			end := start + usageBatchSize
+			if end > len(periodIDs) {
+				end = len(periodIDs)
+			}
+			ids := periodIDs[start:end]
+
+			if err := tx.Model(&models.UsagePeriod{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+				"real_bytes_up":       addByID("real_bytes_up", ids, totals, func(t *models.UsageIncrement) uint64 { return t.RealBytesUp }),
+				"real_bytes_down":     addByID("real_bytes_down", ids, totals, func(t *models.UsageIncrement) uint64 { return t.RealBytesDown }),
+				"billable_bytes_up":   addByID("billable_bytes_up", ids, totals, func(t *models.UsageIncrement) uint64 { return t.BillableBytesUp }),
+				"billable_bytes_down": addByID("billable_bytes_down", ids, totals, func(t *models.UsageIncrement) uint64 { return t.BillableBytesDown }),
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "node_id"}, {Name: "period_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"real_bytes_up":       gorm.Expr("real_bytes_up + VALUES(real_bytes_up)"),
+				"real_bytes_down":     gorm.Expr("real_bytes_down + VALUES(real_bytes_down)"),
+				"billable_bytes_up":   gorm.Expr("billable_bytes_up + VALUES(billable_bytes_up)"),
+				"billable_bytes_down": gorm.Expr("billable_bytes_down + VALUES(billable_bytes_down)"),
+			}),
+		}).CreateInBatches(rows, usageBatchSize).Error
+	})
+	if err != nil {
+		return false, err
+	}
+	return applied, nil
+}
+
+// BatchApplied reports whether ApplyIncrements recorded the batch ID
+func (r *usageRepository) BatchApplied(batchID string) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.TrafficBatch{}).Where("batch_id = ?", batchID).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *usageRepository) DeleteBatchesBefore(before time.Time) (int64, error) {
+	result := r.db.Where("created_at < ?", before).Delete(&models.TrafficBatch{})
+	return result.RowsAffected, result.Error
+}
+
+// addByID builds "column + CASE id WHEN ... END" so one UPDATE can add a
+// different amount to each period
+func addByID(column string, ids []uint64, totals map[uint64]*models.UsageIncrement, amount func(*models.UsageIncrement) uint64) clause.Expr {
+	var sql strings.Builder
+	args := make([]interface{}, 0, len(ids)*2)
+
+	sql.WriteString(column)
+	sql.WriteString(" + CASE id")
+	for _, id := range ids {
+		sql.WriteString(" WHEN ? THEN ?")
+		args = append(args, id, amount(totals[id]))
+	}
+	sql.WriteString(" ELSE 0 END")
+
+	return gorm.Expr(sql.String(), args...)
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type UserListChangeRepository interface {
+	Create(changes []models.UserListChange) error
+	// Between returns changes with versions in (after, upTo], oldest first
+	Between(after, upTo uint64, limit int) ([]models.UserListChange, error)
+	// Bounds returns the oldest and latest retained versions, 0 if none
+	Bounds() (uint64, uint64, error)
+	DeleteBefore(before time.Time) (int64, error)
+}
+
+type userListChangeRepository struct {
+	db *gorm.DB
+}
+
+func NewUserListChangeRepository(db *gorm.DB) UserListChangeRepository {
+	return &userListChangeRepository{db: db}
+}
+
+func (r *userListChangeRepository) Create(changes []models.UserListChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	return r.db.CreateInBatches(changes, 500).Error
+}
+
+func (r *userListChangeRepository) Between(after, upTo uint64, limit int) ([]models.UserListChange, error) {
+	var changes []models.UserListChange
+	err := r.db.Where("id > ? AND id <= ?", after, upTo).
+		Order("id").
+		Limit(limit).
+		Find(&changes).Error
+	return changes, err
+}
+
+func (r *userListChangeRepository) Bounds() (uint64, uint64, error) {
+	var bounds struct {
+		Oldest uint64
+		Latest uint64
+	}
+	err := r.db.Model(&models.UserListChange{}).
+		Select("COALESCE(MIN(id), 0) AS oldest, COALESCE(MAX(id), 0) AS latest").
+		Scan(&bounds).Error
+	return bounds.Oldest, bounds.Latest, err
+}
+
+// DeleteBefore removes changes created before the given time. The latest
+// change is always kept so the version does not go back to 0.
+func (r *userListChangeRepository) DeleteBefore(before time.Time) (int64, error) {
+	_, latest, err := r.Bounds()
+	if err != nil || latest == 0 {
+		return 0, err
+	}
+	result := r.db.Where("created_at < ? AND id < ?", before, latest).Delete(&models.UserListChange{})
+	return result.RowsAffected, result.Error
+}
@@ -1,6 +1,9 @@
 package repository
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
 
 	"gorm.io/gorm"
@@ -8,12 +11,19 @@ import (
 
 type UserRepository interface {
 	Create(user *models.User) error
+	CreateWithThresholds(user *models.User, thresholds []models.TelegramThreshold) error
 	FindByID(id uint64) (*models.User, error)
+	FindByIDs(ids []uint64) ([]models.User, error)
 	FindByEmail(email string) (*models.User, error)
 	Update(user *models.User) error
 	Delete(id uint64) error
 	List(offset, limit int) ([]models.User, int64, error)
 	FindByTelegramChatID(chatID int64) (*models.User, error)
+	FindByToken(token string) (*models.User, error)
+	FindLinkedAdmins() ([]models.User, error)
+	FindLinkedUsers() ([]models.User, error)
+	FindNodeUsers(nodeID uint64, at time.Time, userIDs []uint64) ([]models.NodeUserDTO, error)
+	FindIDsByPlans(planIDs []uint64) ([]uint64, error)
 }
 
 type userRepository struct {
@@ -28,6 +38,23 @@ func (r *userRepository) Create(user *models.User) error {
 	return r.db.Create(user).Error
 }
 
+// CreateWithThresholds creates the user and their notification thresholds in
+// one transaction
+func (r *userRepository) CreateWithThresholds(user *models.User, thresholds []models.TelegramThreshold) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		if len(thresholds) == 0 {
+			return nil
+		}
+		for i := range thresholds {
+			thresholds[i].UserID = user.ID
+		}
+		return tx.Create(&thresholds).Error
+	})
+}
+
 func (r *userRepository) FindByID(id uint64) (*models.User, error) {
 	var user models.User
 	err := r.db.Preload("Plan").First(&user, id).Error
@@ -37,6 +64,17 @@ func (r *userRepository) FindByID(id uint64) (*models.User, error) {
 	return &user, nil
 }
 
+// FindByIDs loads several users with their plans in one query. Missing IDs
+// are skipped.
+func (r *userRepository) FindByIDs(ids []uint64) ([]models.User, error) {
+	var users []models.User
+	if len(ids) == 0 {
+		return users, nil
+	}
+	err := r.db.Preload("Plan").Where("id IN ?", ids).Find(&users).Error
+	return users, err
+}
+
 func (r *userRepository) FindByEmail(email string) (*models.User, error) {
 	var user models.User
 	err := r.db.Preload("Plan").Where("email = ?", email).First(&user).Error
@@ -68,9 +106,98 @@ func (r *userRepository) List(offset, limit int) ([]models.User, int64, error) {
 
 func (r *userRepository) FindByTelegramChatID(chatID int64) (*models.User, error) {
 	var user models.User
-	err := r.db.Where("telegram_chat_id = ?", chatID).First(&user).Error
+	err := r.db.Preload("Plan").Where("telegram_chat_id = ?", chatID).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
+
+func (r *userRepository) FindByToken(token string) (*models.User, error) {
+	var user models.User
+	err := r.db.Preload("Plan").Where("token = ?", token).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// FindLinkedAdmins returns admins with a linked Telegram chat
+func (r *userRepository) FindLinkedAdmins() ([]models.User, error) {
+	var users []models.User
+	err := r.db.Where("role = ? AND telegram_chat_id IS NOT NULL AND banned = ?", "admin", false).
+		Find(&users).Error
+	return users, err
+}
+
+// FindLinkedUsers returns users who are not banned and have a linked
+// Telegram chat
+func (r *userRepository) FindLinkedUsers() ([]models.User, error) {
+	var users []models.User
+	err := r.db.Where("telegram_chat_id IS NOT NULL AND banned = ?", false).
+		Order("id").
+		Find(&users).Error
+	return users, err
+}
+
+// nodeUsersQuery selects the users a node serves: those on a plan sharing a
+// label with the node, not banned, with a UUID, within quota or holding a
+// usable traffic pack, and without a lapsed subscription
+const nodeUsersQuery = `
+SELECT u.id, uu.uuid,
+    COALESCE(u.speed_limit, p.speed_limit, 0) AS speed_limit,
+    COALESCE(u.device_limit, p.device_limit, 0) AS device_limit
+FROM (
+    SELECT DISTINCT pl.plan_id
+    FROM node_labels nl
+    JOIN plan_labels pl ON pl.label_id = nl.label_id
+    WHERE nl.node_id = @node
+) allowed
+JOIN plans p ON p.id = allowed.plan_id
+JOIN users u ON u.plan_id = allowed.plan_id AND u.banned = FALSE
+JOIN user_uuids uu ON uu.user_id = u.id
+LEFT JOIN usage_periods up ON up.user_id = u.id AND up.is_current = TRUE
+WHERE (
+    up.id IS NULL
+    OR up.billable_bytes_up + up.billable_bytes_down < COALESCE(up.quota_bytes, p.quota_bytes)
+    OR EXISTS (
+        SELECT 1 FROM traffic_packs tp
+        WHERE tp.user_id = u.id AND tp.revoked_at IS NULL AND tp.used_bytes < tp.bytes
+            AND (tp.expires_at IS NULL OR tp.expires_at > @at)
+    )
+)
+AND (
+    NOT EXISTS (SELECT 1 FROM subscriptions s WHERE s.user_id = u.id AND s.status = 'active')
+    OR EXISTS (SELECT 1 FROM subscriptions s WHERE s.user_id = u.id AND s.status = 'active' AND s.expires_at > @at)
+)
+%s
+ORDER BY u.id`
+
+// FindNodeUsers returns the users the node should serve at the given time in
+// a single query, ordered by user ID. A non-nil userIDs limits the result to
+// those users.
+func (r *userRepository) FindNodeUsers(nodeID uint64, at time.Time, userIDs []uint64) ([]models.NodeUserDTO, error) {
+	users := []models.NodeUserDTO{}
+	args := map[string]interface{}{"node": nodeID, "at": at}
+	filter := ""
+	if userIDs != nil {
+		if len(userIDs) == 0 {
+			return users, nil
+		}
+		filter = "AND u.id IN @users"
+		args["users"] = userIDs
+	}
+
+	err := r.db.Raw(fmt.Sprintf(nodeUsersQuery, filter), args).Scan(&users).Error
+	return users, err
+}
+
+// FindIDsByPlans returns the IDs of users on any of the given plans
+func (r *userRepository) FindIDsByPlans(planIDs []uint64) ([]uint64, error) {
+	var ids []uint64
+	if len(planIDs) == 0 {
+		return ids, nil
+	}
+	err := r.db.Model(&models.User{}).Where("plan_id IN ?", planIDs).Pluck("id", &ids).Error
+	return ids, err
+}
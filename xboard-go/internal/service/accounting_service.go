@@ -13,19 +13,28 @@ import (
 
 type AccountingService interface {
 	ProcessTrafficReport(nodeID uint64, reports []models.TrafficReport) error
-	CalculateMultiplier(userID, nodeID uint64) (float64, error)
+	// ProcessTrafficBatch bills traffic. A batch with a non-empty batchID
+	// is billed at most once.
+	ProcessTrafficBatch(batchID string, deltas []models.TrafficDelta) error
+	BatchBilled(batchID string) (bool, error)
+	CleanupTrafficBatches() (int64, error)
+	CalculateMultiplier(userID, nodeID uint64, at time.Time) (float64, error)
 	GetCurrentUsage(userID uint64) (*models.UsagePeriod, error)
 	CheckAndResetPeriods() error
 	InitializeUserPeriod(userID uint64) error
+	SplitCurrentPeriod(userID uint64) (*models.UsagePeriod, error)
+	RestartPeriod(userID uint64) error
 }
 
 type accountingService struct {
-	userRepo   repository.UserRepository
-	nodeRepo   repository.NodeRepository
-	planRepo   repository.PlanRepository
-	usageRepo  repository.UsageRepository
-	uuidRepo   repository.UUIDRepository
-	logger     *zap.Logger
+	userRepo    repository.UserRepository
+	nodeRepo    repository.NodeRepository
+	planRepo    repository.PlanRepository
+	usageRepo   repository.UsageRepository
+	uuidRepo    repository.UUIDRepository
+	multipliers MultiplierResolver
+	nodeUsers   NodeUserService
+	logger      *zap.Logger
 }
 
 func NewAccountingService(
@@ -34,148 +43,316 @@ func NewAccountingService(
 	planRepo repository.PlanRepository,
 	usageRepo repository.UsageRepository,
 	uuidRepo repository.UUIDRepository,
+	multipliers MultiplierResolver,
+	nodeUsers NodeUserService,
 	logger *zap.Logger,
 ) AccountingService {
 	return &accountingService{
-		userRepo:  userRepo,
-		nodeRepo:  nodeRepo,
-		planRepo:  planRepo,
-		usageRepo: usageRepo,
-		logger:    logger,
+		userRepo:    userRepo,
+		nodeRepo:    nodeRepo,
+		planRepo:    planRepo,
+		usageRepo:   usageRepo,
+		multipliers: multipliers,
+		nodeUsers:   nodeUsers,
+		logger:      logger,
 	}
 }
 
 func (s *accountingService) ProcessTrafficReport(nodeID uint64, reports []models.TrafficReport) error {
-	node, err := s.nodeRepo.FindByIDWithLabels(nodeID)
-	if err != nil {
-		return err
+	receivedAt := time.Now()
+	deltas := make([]models.TrafficDelta, 0, len(reports))
+	for _, report := range reports {
+		deltas = append(deltas, models.TrafficDelta{
+			NodeID:     nodeID,
+			UserID:     report.UserID,
+			Upload:     report.Upload,
+			Download:   report.Download,
+			ReceivedAt: receivedAt,
+		})
 	}
+	return s.ProcessTrafficBatch("", deltas)
+}
 
-	for _, report := range reports {
-		if err := s.processUserTraffic(node, report); err != nil {
-			s.logger.Error("Failed to process user traffic",
-				zap.Uint64("user_id", report.UserID),
-				zap.Uint64("node_id", nodeID),
-				zap.Error(err),
-			)
-			continue
-		}
+// ProcessTrafficBatch bills traffic from any number of nodes. Users and their
+// current periods are loaded in one query each and usage is written in bulk,
+// so the cost does not grow with a query per user. Traffic of unknown,
+// banned or planless users is dropped; any other failure fails the batch so
+// it can be retried.
+func (s *accountingService) ProcessTrafficBatch(batchID string, deltas []models.TrafficDelta) error {
+	if len(deltas) == 0 {
+		return nil
 	}
 
-	return nil
-}
+	var userIDs []uint64
+	seen := make(map[uint64]bool)
+	for _, delta := range deltas {
+		if !seen[delta.UserID] {
+			seen[delta.UserID] = true
+			userIDs = append(userIDs, delta.UserID)
+		}
+	}
 
-func (s *accountingService) processUserTraffic(node *models.Node, report models.TrafficReport) error {
-	user, err := s.userRepo.FindByID(report.UserID)
+	users, err := s.userRepo.FindByIDs(userIDs)
 	if err != nil {
 		return err
 	}
-
-	if user.Banned {
-		return errors.New("user is banned")
+	usersByID := make(map[uint64]*models.User, len(users))
+	for i := range users {
+		usersByID[users[i].ID] = &users[i]
 	}
 
-	if user.PlanID == nil {
-		return errors.New("user has no plan")
+	periods, err := s.usageRepo.GetCurrentPeriods(userIDs)
+	if err != nil {
+		return err
 	}
 
-	// Ensure user has a current period
-	_, err = s.usageRepo.GetCurrentPeriod(user.ID)
-	if err == gorm.ErrRecordNotFound {
-		if err := s.InitializeUserPeriod(user.ID); err != nil {
+	now := time.Now()
+	billed := make(map[uint64]uint64)
+	increments := make([]models.UsageIncrement, 0, len(deltas))
+
+	for _, delta := range deltas {
+		user, ok := usersByID[delta.UserID]
+		if !ok || user.Banned || user.Plan == nil {
+			s.logger.Debug("Dropping traffic of unbillable user",
+				zap.Uint64("user_id", delta.UserID),
+				zap.Uint64("node_id", delta.NodeID),
+			)
+			continue
+		}
+
+		// Failing here fails the whole batch so it is retried rather than
+		// recorded as billed without this user's traffic
+		period, err := s.currentPeriod(user.ID, periods[user.ID], now)
+		if err != nil {
 			return err
 		}
-		_, err = s.usageRepo.GetCurrentPeriod(user.ID)
+		periods[user.ID] = period
+
+		receivedAt := delta.ReceivedAt
+		if receivedAt.IsZero() {
+			receivedAt = now
+		}
+		multiplier, err := s.multipliers.Resolve(user.Plan.ID, delta.NodeID, receivedAt)
 		if err != nil {
 			return err
 		}
-	} else if err != nil {
-		return err
+
+		increment := models.UsageIncrement{
+			UserID:            user.ID,
+			NodeID:            delta.NodeID,
+			PeriodID:          period.ID,
+			RealBytesUp:       delta.Upload,
+			RealBytesDown:     delta.Download,
+			BillableBytesUp:   uint64(float64(delta.Upload) * multiplier),
+			BillableBytesDown: uint64(float64(delta.Download) * multiplier),
+		}
+		increments = append(increments, increment)
+		billed[user.ID] += increment.BillableBytesUp + increment.BillableBytesDown
+	}
+
+	// Anything beyond the base quota is drawn from traffic packs, in the
+	// same transaction as the batch
+	var charges []models.PackCharge
+	for userID, delta := range billed {
+		period := periods[userID]
+		used := period.BillableBytesUp + period.BillableBytesDown
+		quota := period.EffectiveQuota(usersByID[userID].Plan)
+		if overflow := quotaOverflow(used, delta, quota); overflow > 0 {
+			charges = append(charges, models.PackCharge{UserID: userID, Bytes: overflow})
+		}
 	}
 
-	// Calculate multiplier
-	multiplier, err := s.CalculateMultiplier(user.ID, node.ID)
+	applied, err := s.usageRepo.ApplyIncrements(batchID, increments, charges, now)
 	if err != nil {
 		return err
 	}
+	if !applied {
+		s.logger.Info("Skipping traffic batch billed before", zap.String("batch_id", batchID))
+		return nil
+	}
 
-	// Calculate billable traffic
-	billableUp := uint64(float64(report.Upload) * multiplier)
-	billableDown := uint64(float64(report.Download) * multiplier)
+	// Users who ran out of quota and packs in this batch drop off node user
+	// lists
+	var exhausted []uint64
+	for _, charge := range charges {
+		if charge.Uncovered == 0 {
+			continue
+		}
+		s.logger.Debug("Traffic exceeds quota and packs",
+			zap.Uint64("user_id", charge.UserID),
+			zap.Uint64("uncovered_bytes", charge.Uncovered),
+		)
+		period := periods[charge.UserID]
+		used := period.BillableBytesUp + period.BillableBytesDown
+		quota := period.EffectiveQuota(usersByID[charge.UserID].Plan)
+		if charge.Bytes > charge.Uncovered || used < quota {
+			exhausted = append(exhausted, charge.UserID)
+		}
+	}
+	if len(exhausted) > 0 {
+		s.nodeUsers.UsersChanged("quota_exhausted", exhausted...)
+	}
 
-	// Increment usage
-	return s.usageRepo.IncrementUsage(
-		user.ID,
-		node.ID,
-		report.Upload,
-		report.Download,
-		billableUp,
-		billableDown,
-	)
+	return nil
 }
 
-func (s *accountingService) CalculateMultiplier(userID, nodeID uint64) (float64, error) {
-	user, err := s.userRepo.FindByID(userID)
-	if err != nil {
-		return 0, err
+// currentPeriod returns the user's current period, opening one if there is
+// none and rolling it over if it expired before the reset job got to it, so
+// the traffic is billed to the right period
+func (s *accountingService) currentPeriod(userID uint64, period *models.UsagePeriod, now time.Time) (*models.UsagePeriod, error) {
+	if period == nil {
+		if err := s.InitializeUserPeriod(userID); err != nil {
+			return nil, err
+		}
+		return s.usageRepo.GetCurrentPeriod(userID)
 	}
 
-	if user.PlanID == nil {
-		return 1.0, nil
+	if !period.PeriodEnd.After(now) {
+		if _, err := s.rolloverPeriod(period, now); err != nil {
+			return nil, err
+		}
+		return s.usageRepo.GetCurrentPeriod(userID)
 	}
 
-	node, err := s.nodeRepo.FindByIDWithLabels(nodeID)
-	if err != nil {
-		return 0, err
-	}
+	return period, nil
+}
 
-	plan, err := s.planRepo.FindByIDWithLabels(*user.PlanID)
-	if err != nil {
-		return 0, err
+// quotaOverflow returns the part of delta that falls beyond quota when used
+// bytes have already been billed in the period.
+func quotaOverflow(used, delta, quota uint64) uint64 {
+	if used >= quota {
+		return delta
 	}
+	if used+delta <= quota {
+		return 0
+	}
+	return used + delta - quota
+}
 
-	// Start with node multiplier
-	multiplier := node.NodeMultiplier
-
-	// Apply plan base multiplier
-	multiplier *= plan.BaseMultiplier
-
-	// Get plan label multipliers
-	labelMultipliers, err := s.planRepo.GetAllLabelMultipliers(plan.ID)
+// CalculateMultiplier returns the multiplier the user's traffic on the node
+// is billed at when received at the given time
+func (s *accountingService) CalculateMultiplier(userID, nodeID uint64, at time.Time) (float64, error) {
+	user, err := s.userRepo.FindByID(userID)
 	if err != nil {
 		return 0, err
 	}
 
-	// Apply label-specific multipliers for matching labels
-	// Strategy: multiply all matching label multipliers
-	for _, label := range node.Labels {
-		if labelMult, exists := labelMultipliers[label.ID]; exists {
-			multiplier *= labelMult
-		}
+	if user.PlanID == nil {
+		return 1.0, nil
 	}
 
-	return multiplier, nil
+	return s.multipliers.Resolve(*user.PlanID, nodeID, at)
 }
 
 func (s *accountingService) GetCurrentUsage(userID uint64) (*models.UsagePeriod, error) {
 	return s.usageRepo.GetCurrentPeriod(userID)
 }
 
+// trafficBatchRetention is how long billed batch IDs are kept. A batch is
+// only replayed on the first start after a crash, so a week is plenty.
+const trafficBatchRetention = 7 * 24 * time.Hour
+
+func (s *accountingService) BatchBilled(batchID string) (bool, error) {
+	return s.usageRepo.BatchApplied(batchID)
+}
+
+func (s *accountingService) CleanupTrafficBatches() (int64, error) {
+	return s.usageRepo.DeleteBatchesBefore(time.Now().Add(-trafficBatchRetention))
+}
+
+// periodRolloverBatchSize bounds how many expired periods are loaded per query
+const periodRolloverBatchSize = 500
+
 func (s *accountingService) CheckAndResetPeriods() error {
-	// This is a background job that checks all users with current periods
-	// and resets them if the period has ended
+	now := time.Now()
 
-	// For simplicity, we'll implement a basic version
-	// In production, you'd want to batch this and use more efficient queries
+	var afterID uint64
+	rolled, closed, failed := 0, 0, 0
 
-	s.logger.Info("Checking and resetting periods")
+	for {
+		periods, err := s.usageRepo.FindExpiredCurrentPeriods(now, afterID, periodRolloverBatchSize)
+		if err != nil {
+			return err
+		}
 
-	// This would need to query all active periods and check if they need reset
-	// For now, this is a placeholder that should be enhanced
+		for i := range periods {
+			period := &periods[i]
+			afterID = period.ID
+
+			renewed, err := s.rolloverPeriod(period, now)
+			if err != nil {
+				failed++
+				s.logger.Error("Failed to roll over usage period",
+					zap.Uint64("period_id", period.ID),
+					zap.Uint64("user_id", period.UserID),
+					zap.Error(err),
+				)
+				continue
+			}
+
+			if renewed {
+				rolled++
+			} else {
+				closed++
+			}
+		}
+
+		if len(periods) < periodRolloverBatchSize {
+			break
+		}
+	}
+
+	s.logger.Info("Usage periods checked",
+		zap.Int("rolled_over", rolled),
+		zap.Int("closed", closed),
+		zap.Int("failed", failed),
+	)
 
 	return nil
 }
 
+// rolloverPeriod closes an expired period and, if the user still has a plan,
+// opens the next one. It reports whether a new period was opened.
+func (s *accountingService) rolloverPeriod(period *models.UsagePeriod, now time.Time) (bool, error) {
+	user, err := s.userRepo.FindByID(period.UserID)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return false, err
+	}
+
+	if user == nil || user.PlanID == nil {
+		return false, s.usageRepo.ClosePeriod(period.ID, now)
+	}
+
+	plan := user.Plan
+	if plan == nil {
+		plan, err = s.planRepo.FindByID(*user.PlanID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	periodStart, periodEnd := s.calculatePeriodBounds(now, plan.ResetPeriod, user.ResetAnchor())
+
+	next := &models.UsagePeriod{
+		UserID:      user.ID,
+		PlanID:      plan.ID,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+		IsCurrent:   true,
+	}
+
+	renewed, err := s.usageRepo.RolloverPeriod(period.ID, next)
+	if err != nil {
+		return false, err
+	}
+	// A fresh quota may put the user back on node user lists. A period
+	// another instance already rolled over was recorded there.
+	if renewed {
+		s.nodeUsers.UsersChanged("period_rollover", user.ID)
+	}
+	return renewed, nil
+}
+
 func (s *accountingService) InitializeUserPeriod(userID uint64) error {
 	user, err := s.userRepo.FindByID(userID)
 	if err != nil {
@@ -192,7 +369,7 @@ func (s *accountingService) InitializeUserPeriod(userID uint64) error {
 	}
 
 	now := time.Now()
-	periodStart, periodEnd := s.calculatePeriodBounds(now, plan.ResetPeriod)
+	periodStart, periodEnd := s.calculatePeriodBounds(now, plan.ResetPeriod, user.ResetAnchor())
 
 	period := &models.UsagePeriod{
 		UserID:      user.ID,
@@ -205,24 +382,179 @@ func (s *accountingService) InitializeUserPeriod(userID uint64) error {
 	return s.usageRepo.CreatePeriod(period)
 }
 
-func (s *accountingService) calculatePeriodBounds(now time.Time, resetPeriod string) (time.Time, time.Time) {
+// RestartPeriod closes the user's current period now and opens a fresh one
+// for their current plan, e.g. after the plan changed. Like a split, the new
+// period starts now and runs to the plan's next reset boundary with the quota
+// prorated to its share of a full period. Users without a plan are left
+// without a current period.
+func (s *accountingService) RestartPeriod(userID uint64) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+
+	current, err := s.usageRepo.GetCurrentPeriod(userID)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	now := time.Now()
+	if user.PlanID == nil {
+		if current == nil {
+			return nil
+		}
+		return s.usageRepo.ClosePeriod(current.ID, now)
+	}
+
+	plan, err := s.planRepo.FindByID(*user.PlanID)
+	if err != nil {
+		return err
+	}
+
+	anchoredStart, anchoredEnd := s.calculatePeriodBounds(now, plan.ResetPeriod, user.ResetAnchor())
+	next := &models.UsagePeriod{
+		UserID:      user.ID,
+		PlanID:      plan.ID,
+		PeriodStart: now,
+		PeriodEnd:   anchoredEnd,
+		IsCurrent:   true,
+	}
+	if anchoredStart.Before(now) {
+		quota := proratedQuota(plan.QuotaBytes, now, anchoredStart, anchoredEnd)
+		next.QuotaBytes = &quota
+	}
+
+	if current == nil {
+		return s.usageRepo.CreatePeriod(next)
+	}
+	if _, err := s.usageRepo.RolloverPeriod(current.ID, next); err != nil {
+		return err
+	}
+	s.nodeUsers.UsersChanged("period_restart", user.ID)
+	return nil
+}
+
+// SplitCurrentPeriod realigns a user's current period to their reset anchor.
+// The current period is closed now and a bridging period runs until the next
+// anchored boundary. Its quota is what was left of the current period's,
+// prorated to the bridging period's share of a full period, so moving the
+// anchor never hands out quota already used. It returns the user's current
+// period after the split.
+func (s *accountingService) SplitCurrentPeriod(userID uint64) (*models.UsagePeriod, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.PlanID == nil {
+		return nil, nil
+	}
+
+	current, err := s.usageRepo.GetCurrentPeriod(user.ID)
+	if err == gorm.ErrRecordNotFound {
+		if err := s.InitializeUserPeriod(user.ID); err != nil {
+			return nil, err
+		}
+		return s.usageRepo.GetCurrentPeriod(user.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := s.planRepo.FindByID(*user.PlanID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	anchoredStart, anchoredEnd := s.calculatePeriodBounds(now, plan.ResetPeriod, user.ResetAnchor())
+	if plan.ResetPeriod == "none" || anchoredEnd.Equal(current.PeriodEnd) {
+		return current, nil
+	}
+
+	var remaining uint64
+	used := current.BillableBytesUp + current.BillableBytesDown
+	if total := current.EffectiveQuota(plan); used < total {
+		remaining = total - used
+	}
+	quota := proratedQuota(remaining, now, anchoredStart, anchoredEnd)
+	next := &models.UsagePeriod{
+		UserID:      user.ID,
+		PlanID:      plan.ID,
+		PeriodStart: now,
+		PeriodEnd:   anchoredEnd,
+		QuotaBytes:  &quota,
+		IsCurrent:   true,
+	}
+
+	if _, err := s.usageRepo.RolloverPeriod(current.ID, next); err != nil {
+		return nil, err
+	}
+	s.nodeUsers.UsersChanged("period_split", user.ID)
+
+	return s.usageRepo.GetCurrentPeriod(user.ID)
+}
+
+// proratedQuota scales quota by the share of [start, end) remaining after at
+func proratedQuota(quota uint64, at, start, end time.Time) uint64 {
+	full := end.Sub(start)
+	if full <= 0 || !at.Before(end) {
+		return 0
+	}
+	if !at.After(start) {
+		return quota
+	}
+	return uint64(float64(quota) * float64(end.Sub(at)) / float64(full))
+}
+
+// calculatePeriodBounds returns the period containing now. Without an anchor,
+// periods are calendar aligned (midnight, Sunday, the 1st, January 1st). With
+// an anchor, boundaries fall on the anchor's weekday or day of month in its
+// time zone, clamping days past the end of shorter months to the last day.
+func (s *accountingService) calculatePeriodBounds(now time.Time, resetPeriod string, anchor *models.ResetAnchor) (time.Time, time.Time) {
 	var start, end time.Time
 
+	if anchor == nil {
+		anchor = &models.ResetAnchor{}
+	}
+
+	loc := now.Location()
+	if anchor.Timezone != "" {
+		if l, err := time.LoadLocation(anchor.Timezone); err == nil {
+			loc = l
+		}
+	}
+	now = now.In(loc)
+
+	day := anchor.DayOfMonth
+	if day < 1 {
+		day = 1
+	}
+	month := time.Month(anchor.Month)
+	if month < time.January {
+		month = time.January
+	}
+
 	switch resetPeriod {
 	case "daily":
-		start = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		start = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
 		end = start.AddDate(0, 0, 1)
 	case "weekly":
-		weekday := int(now.Weekday())
-		start = now.AddDate(0, 0, -weekday)
-		start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+		offset := (int(now.Weekday()) - anchor.Weekday + 7) % 7
+		start = time.Date(now.Year(), now.Month(), now.Day()-offset, 0, 0, 0, 0, loc)
 		end = start.AddDate(0, 0, 7)
 	case "monthly":
-		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
-		end = start.AddDate(0, 1, 0)
+		start = clampedDate(now.Year(), now.Month(), day, loc)
+		if start.After(now) {
+			start = clampedDate(now.Year(), now.Month()-1, day, loc)
+		}
+		end = clampedDate(start.Year(), start.Month()+1, day, loc)
 	case "yearly":
-		start = time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location())
-		end = start.AddDate(1, 0, 0)
+		start = clampedDate(now.Year(), month, day, loc)
+		if start.After(now) {
+			start = clampedDate(now.Year()-1, month, day, loc)
+		}
+		end = clampedDate(start.Year()+1, month, day, loc)
 	default: // "none"
 		start = now
 		end = now.AddDate(100, 0, 0) // Far future
@@ -230,3 +562,14 @@ func (s *accountingService) calculatePeriodBounds(now time.Time, resetPeriod str
 
 	return start, end
 }
+
+// clampedDate returns midnight on the given day, or on the last day of the
+// month if the month is shorter. Month values outside 1-12 roll over years.
+func clampedDate(year int, month time.Month, day int, loc *time.Location) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	lastDay := first.AddDate(0, 1, -1).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(first.Year(), first.Month(), day, 0, 0, 0, 0, loc)
+}
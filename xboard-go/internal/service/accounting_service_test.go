@@ -4,19 +4,56 @@ import (
 	"testing"
 	"time"
 
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
 
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 // Mock repositories for testing
-type mockUserRepo struct{}
+type mockUserRepo struct {
+	// users overrides the default user returned by FindByID when set
+	users map[uint64]*models.User
+}
 type mockNodeRepo struct{}
 type mockPlanRepo struct{}
-type mockUsageRepo struct{}
+type mockUsageRepo struct {
+	periods []models.UsagePeriod
+	// applied collects increments passed to ApplyIncrements
+	applied []models.UsageIncrement
+	// batches holds the batch IDs passed to ApplyIncrements
+	batches map[string]bool
+	// failRollover makes RolloverPeriod fail for the given period IDs
+	failRollover map[uint64]bool
+	// packs receives the pack charges of applied batches when set
+	packs *mockPackRepo
+}
 type mockUUIDRepo struct{}
 
+// mockPackRepo keeps traffic packs in memory
+type mockPackRepo struct {
+	packs []models.TrafficPack
+}
+type mockScheduleRepo struct {
+	schedules []models.MultiplierSchedule
+}
+
+// mockChangeRepo keeps the user list change log in memory
+type mockChangeRepo struct {
+	changes []models.UserListChange
+	nextID  uint64
+}
+
 func (m *mockUserRepo) FindByID(id uint64) (*models.User, error) {
+	if m.users != nil {
+		user, ok := m.users[id]
+		if !ok {
+			return nil, gorm.ErrRecordNotFound
+		}
+		return user, nil
+	}
+
 	planID := uint64(1)
 	return &models.User{
 		ID:     id,
@@ -24,6 +61,39 @@ func (m *mockUserRepo) FindByID(id uint64) (*models.User, error) {
 	}, nil
 }
 
+func (m *mockUserRepo) FindByIDs(ids []uint64) ([]models.User, error) {
+	var users []models.User
+	for _, id := range ids {
+		if user, err := m.FindByID(id); err == nil {
+			users = append(users, *user)
+		}
+	}
+	return users, nil
+}
+
+func (m *mockUserRepo) Create(user *models.User) error { return nil }
+func (m *mockUserRepo) CreateWithThresholds(user *models.User, thresholds []models.TelegramThreshold) error {
+	return nil
+}
+func (m *mockUserRepo) FindByEmail(email string) (*models.User, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+func (m *mockUserRepo) Update(user *models.User) error                       { return nil }
+func (m *mockUserRepo) Delete(id uint64) error                               { return nil }
+func (m *mockUserRepo) List(offset, limit int) ([]models.User, int64, error) { return nil, 0, nil }
+func (m *mockUserRepo) FindByTelegramChatID(chatID int64) (*models.User, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+func (m *mockUserRepo) FindByToken(token string) (*models.User, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+func (m *mockUserRepo) FindLinkedAdmins() ([]models.User, error) { return nil, nil }
+func (m *mockUserRepo) FindLinkedUsers() ([]models.User, error)  { return nil, nil }
+func (m *mockUserRepo) FindNodeUsers(nodeID uint64, at time.Time, userIDs []uint64) ([]models.NodeUserDTO, error) {
+	return nil, nil
+}
+func (m *mockUserRepo) FindIDsByPlans(planIDs []uint64) ([]uint64, error) { return nil, nil }
+
 func (m *mockNodeRepo) FindByIDWithLabels(id uint64) (*models.Node, error) {
 	return &models.Node{
 		ID:             id,
@@ -35,6 +105,34 @@ func (m *mockNodeRepo) FindByIDWithLabels(id uint64) (*models.Node, error) {
 	}, nil
 }
 
+func (m *mockNodeRepo) Create(node *models.Node) error                       { return nil }
+func (m *mockNodeRepo) FindByID(id uint64) (*models.Node, error)             { return m.FindByIDWithLabels(id) }
+func (m *mockNodeRepo) Update(node *models.Node) error                       { return nil }
+func (m *mockNodeRepo) UpdateAPIKey(node *models.Node) error                 { return nil }
+func (m *mockNodeRepo) Delete(id uint64) error                               { return nil }
+func (m *mockNodeRepo) List(offset, limit int) ([]models.Node, int64, error) { return nil, 0, nil }
+func (m *mockNodeRepo) FindActiveNodes() ([]models.Node, error)              { return nil, nil }
+func (m *mockNodeRepo) UpdateLastSeen(nodeID uint64) error                   { return nil }
+func (m *mockNodeRepo) UpdateHealthState(nodeID uint64, state string, at time.Time) error {
+	return nil
+}
+func (m *mockNodeRepo) ClaimPushSeq(nodeID, seq uint64, reset bool) (bool, uint64, error) {
+	return true, 0, nil
+}
+func (m *mockNodeRepo) ReleasePushSeq(nodeID, seq, previous uint64) error { return nil }
+func (m *mockNodeRepo) AddLabel(nodeID, labelID uint64) error             { return nil }
+func (m *mockNodeRepo) RemoveLabel(nodeID, labelID uint64) error          { return nil }
+func (m *mockNodeRepo) GetLabels(nodeID uint64) ([]models.Label, error)   { return nil, nil }
+
+func (m *mockPlanRepo) FindByID(id uint64) (*models.Plan, error) {
+	return &models.Plan{
+		ID:             id,
+		QuotaBytes:     100 * 1024 * 1024 * 1024,
+		ResetPeriod:    "monthly",
+		BaseMultiplier: 1.0,
+	}, nil
+}
+
 func (m *mockPlanRepo) FindByIDWithLabels(id uint64) (*models.Plan, error) {
 	return &models.Plan{
 		ID:             id,
@@ -51,23 +149,279 @@ func (m *mockPlanRepo) GetAllLabelMultipliers(planID uint64) (map[uint64]float64
 	}, nil
 }
 
+func (m *mockPlanRepo) Create(plan *models.Plan) error                       { return nil }
+func (m *mockPlanRepo) Update(plan *models.Plan) error                       { return nil }
+func (m *mockPlanRepo) Delete(id uint64) error                               { return nil }
+func (m *mockPlanRepo) List(offset, limit int) ([]models.Plan, int64, error) { return nil, 0, nil }
+func (m *mockPlanRepo) AddLabel(planID, labelID uint64) error                { return nil }
+func (m *mockPlanRepo) RemoveLabel(planID, labelID uint64) error             { return nil }
+func (m *mockPlanRepo) SetLabels(planID uint64, labelIDs []uint64) error     { return nil }
+func (m *mockPlanRepo) GetLabels(planID uint64) ([]models.Label, error)      { return nil, nil }
+func (m *mockPlanRepo) SetLabelMultiplier(planID, labelID uint64, multiplier float64) error {
+	return nil
+}
+func (m *mockPlanRepo) GetLabelMultiplier(planID, labelID uint64) (float64, error) { return 1.0, nil }
+func (m *mockPlanRepo) DeleteLabelMultiplier(planID, labelID uint64) error         { return nil }
+
 func (m *mockUsageRepo) GetCurrentPeriod(userID uint64) (*models.UsagePeriod, error) {
-	return &models.UsagePeriod{
-		ID:                1,
-		UserID:            userID,
-		PlanID:            1,
-		RealBytesUp:       0,
-		RealBytesDown:     0,
-		BillableBytesUp:   0,
-		BillableBytesDown: 0,
-		IsCurrent:         true,
-	}, nil
+	if m.periods == nil {
+		return &models.UsagePeriod{
+			ID:                1,
+			UserID:            userID,
+			PlanID:            1,
+			RealBytesUp:       0,
+			RealBytesDown:     0,
+			BillableBytesUp:   0,
+			BillableBytesDown: 0,
+			IsCurrent:         true,
+		}, nil
+	}
+
+	for i := range m.periods {
+		if m.periods[i].UserID == userID && m.periods[i].IsCurrent {
+			period := m.periods[i]
+			return &period, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
 }
 
+func (m *mockUsageRepo) GetCurrentPeriods(userIDs []uint64) (map[uint64]*models.UsagePeriod, error) {
+	result := make(map[uint64]*models.UsagePeriod)
+	for _, userID := range userIDs {
+		if period, err := m.GetCurrentPeriod(userID); err == nil {
+			result[userID] = period
+		}
+	}
+	return result, nil
+}
+
+func (m *mockUsageRepo) CreatePeriod(period *models.UsagePeriod) error {
+	period.ID = uint64(len(m.periods) + 1)
+	m.periods = append(m.periods, *period)
+	return nil
+}
+
+func (m *mockUsageRepo) UpdatePeriod(period *models.UsagePeriod) error { return nil }
+
+func (m *mockUsageRepo) ClosePeriod(periodID uint64, at time.Time) error {
+	for i := range m.periods {
+		if m.periods[i].ID == periodID {
+			m.periods[i].IsCurrent = false
+			m.truncate(i, at)
+		}
+	}
+	return nil
+}
+
+// truncate mirrors the repository: period_end moves back to at, but never
+// before period_start
+func (m *mockUsageRepo) truncate(i int, at time.Time) {
+	period := &m.periods[i]
+	if period.PeriodEnd.After(at) {
+		period.PeriodEnd = at
+	}
+	if period.PeriodEnd.Before(period.PeriodStart) {
+		period.PeriodEnd = period.PeriodStart
+	}
+}
+
+func (m *mockUsageRepo) FindExpiredCurrentPeriods(before time.Time, afterID uint64, limit int) ([]models.UsagePeriod, error) {
+	var result []models.UsagePeriod
+	for _, period := range m.periods {
+		if period.IsCurrent && !period.PeriodEnd.After(before) && period.ID > afterID {
+			result = append(result, period)
+			if len(result) == limit {
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+func (m *mockUsageRepo) RolloverPeriod(periodID uint64, next *models.UsagePeriod) (bool, error) {
+	if m.failRollover[periodID] {
+		return false, gorm.ErrInvalidTransaction
+	}
+
+	for i := range m.periods {
+		if m.periods[i].ID != periodID {
+			continue
+		}
+		if !m.periods[i].IsCurrent {
+			return false, nil
+		}
+		m.periods[i].IsCurrent = false
+		m.truncate(i, next.PeriodStart)
+		if _, err := m.GetCurrentPeriod(next.UserID); err == nil {
+			return true, nil
+		}
+		return true, m.CreatePeriod(next)
+	}
+	return false, nil
+}
+
+func (m *mockUsageRepo) GetPeriodHistory(userID uint64, start, end time.Time) ([]models.UsagePeriod, error) {
+	return nil, nil
+}
+func (m *mockUsageRepo) GetNodeUsage(periodID uint64) ([]models.NodeUsage, error) { return nil, nil }
+func (m *mockUsageRepo) GetNodeUsageByUserAndNode(userID, nodeID, periodID uint64) (*models.NodeUsage, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+func (m *mockUsageRepo) CreateNodeUsage(usage *models.NodeUsage) error { return nil }
+func (m *mockUsageRepo) UpdateNodeUsage(usage *models.NodeUsage) error { return nil }
+
 func (m *mockUsageRepo) IncrementUsage(userID, nodeID uint64, realUp, realDown, billableUp, billableDown uint64) error {
 	return nil
 }
 
+func (m *mockUsageRepo) ApplyIncrements(batchID string, increments []models.UsageIncrement, charges []models.PackCharge, at time.Time) (bool, error) {
+	if batchID != "" {
+		if m.batches[batchID] {
+			return false, nil
+		}
+		if m.batches == nil {
+			m.batches = make(map[string]bool)
+		}
+		m.batches[batchID] = true
+	}
+	m.applied = append(m.applied, increments...)
+	for i := range charges {
+		charges[i].Uncovered = charges[i].Bytes
+		if m.packs != nil {
+			charges[i].Uncovered, _ = m.packs.Consume(charges[i].UserID, charges[i].Bytes, at)
+		}
+	}
+	return true, nil
+}
+
+func (m *mockUsageRepo) BatchApplied(batchID string) (bool, error) { return m.batches[batchID], nil }
+func (m *mockUsageRepo) DeleteBatchesBefore(before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (m *mockScheduleRepo) Create(schedule *models.MultiplierSchedule) error { return nil }
+func (m *mockScheduleRepo) FindByID(id uint64) (*models.MultiplierSchedule, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+func (m *mockScheduleRepo) Update(schedule *models.MultiplierSchedule) error { return nil }
+func (m *mockScheduleRepo) Delete(id uint64) error                           { return nil }
+func (m *mockScheduleRepo) List() ([]models.MultiplierSchedule, error)       { return m.schedules, nil }
+func (m *mockScheduleRepo) FindApplicable(planID, nodeID uint64, labelIDs []uint64) ([]models.MultiplierSchedule, error) {
+	return m.schedules, nil
+}
+
+func (m *mockChangeRepo) Create(changes []models.UserListChange) error {
+	for _, change := range changes {
+		m.nextID++
+		change.ID = m.nextID
+		change.CreatedAt = time.Now()
+		m.changes = append(m.changes, change)
+	}
+	return nil
+}
+func (m *mockChangeRepo) Between(after, upTo uint64, limit int) ([]models.UserListChange, error) {
+	var changes []models.UserListChange
+	for _, change := range m.changes {
+		if change.ID > after && change.ID <= upTo && len(changes) < limit {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+func (m *mockChangeRepo) Bounds() (uint64, uint64, error) {
+	if len(m.changes) == 0 {
+		return 0, 0, nil
+	}
+	return m.changes[0].ID, m.changes[len(m.changes)-1].ID, nil
+}
+func (m *mockChangeRepo) DeleteBefore(before time.Time) (int64, error) {
+	var kept []models.UserListChange
+	for i, change := range m.changes {
+		if change.CreatedAt.Before(before) && i < len(m.changes)-1 {
+			continue
+		}
+		kept = append(kept, change)
+	}
+	deleted := int64(len(m.changes) - len(kept))
+	m.changes = kept
+	return deleted, nil
+}
+
+// newMockNodeUsers returns a node user service recording changes in memory
+func newMockNodeUsers() NodeUserService {
+	return NewNodeUserService(&config.NodeConfig{}, &mockUserRepo{}, &mockChangeRepo{}, &mockPackRepo{}, &mockSubscriptionRepo{}, NewNodePushService(), zap.NewNop())
+}
+
+func (m *mockPackRepo) Create(pack *models.TrafficPack) error {
+	pack.ID = uint64(len(m.packs) + 1)
+	m.packs = append(m.packs, *pack)
+	return nil
+}
+func (m *mockPackRepo) FindByID(id uint64) (*models.TrafficPack, error) {
+	for i := range m.packs {
+		if m.packs[i].ID == id {
+			return &m.packs[i], nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+func (m *mockPackRepo) ListByUser(userID uint64) ([]models.TrafficPack, error) { return nil, nil }
+func (m *mockPackRepo) Revoke(id uint64) error                                 { return nil }
+
+// usable mirrors the repository's usable packs
+func (m *mockPackRepo) usable(userID uint64, at time.Time) []*models.TrafficPack {
+	var packs []*models.TrafficPack
+	for i := range m.packs {
+		pack := &m.packs[i]
+		if pack.UserID == userID && pack.RevokedAt == nil && pack.UsedBytes < pack.Bytes &&
+			(pack.ExpiresAt == nil || pack.ExpiresAt.After(at)) {
+			packs = append(packs, pack)
+		}
+	}
+	return packs
+}
+
+func (m *mockPackRepo) GetRemaining(userID uint64, at time.Time) (uint64, error) {
+	var remaining uint64
+	for _, pack := range m.usable(userID, at) {
+		remaining += pack.Remaining()
+	}
+	return remaining, nil
+}
+func (m *mockPackRepo) GetAllRemaining(at time.Time) (map[uint64]uint64, error) {
+	return map[uint64]uint64{}, nil
+}
+func (m *mockPackRepo) Consume(userID uint64, bytes uint64, at time.Time) (uint64, error) {
+	for _, pack := range m.usable(userID, at) {
+		take := pack.Remaining()
+		if take > bytes {
+			take = bytes
+		}
+		pack.UsedBytes += take
+		bytes -= take
+	}
+	return bytes, nil
+}
+func (m *mockPackRepo) FindUsersExpiring(after, upTo time.Time) ([]uint64, error) {
+	var ids []uint64
+	for _, pack := range m.packs {
+		if pack.RevokedAt == nil && pack.UsedBytes < pack.Bytes && pack.ExpiresAt != nil &&
+			pack.ExpiresAt.After(after) && !pack.ExpiresAt.After(upTo) {
+			ids = append(ids, pack.UserID)
+		}
+	}
+	return ids, nil
+}
+
+func (m *mockUUIDRepo) Create(userUUID *models.UserUUID) error { return nil }
+func (m *mockUUIDRepo) FindByUUID(uuid string) (*models.UserUUID, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+func (m *mockUUIDRepo) FindByUserID(userID uint64) (*models.UserUUID, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
 func (m *mockUUIDRepo) GetAllUserUUIDs() (map[uint64]string, error) {
 	return map[uint64]string{}, nil
 }
@@ -77,18 +431,20 @@ func TestCalculateMultiplier(t *testing.T) {
 	logger, _ := zap.NewDevelopment()
 
 	service := &accountingService{
-		userRepo:  &mockUserRepo{},
-		nodeRepo:  &mockNodeRepo{},
-		planRepo:  &mockPlanRepo{},
-		usageRepo: &mockUsageRepo{},
-		uuidRepo:  &mockUUIDRepo{},
-		logger:    logger,
+		userRepo:    &mockUserRepo{},
+		nodeRepo:    &mockNodeRepo{},
+		planRepo:    &mockPlanRepo{},
+		usageRepo:   &mockUsageRepo{},
+		uuidRepo:    &mockUUIDRepo{},
+		multipliers: NewMultiplierResolver(&mockNodeRepo{}, &mockPlanRepo{}, &mockScheduleRepo{}),
+		logger:      logger,
+		nodeUsers:   newMockNodeUsers(),
 	}
 
 	tests := []struct {
-		name             string
-		userID           uint64
-		nodeID           uint64
+		name               string
+		userID             uint64
+		nodeID             uint64
 		expectedMultiplier float64
 	}{
 		{
@@ -102,7 +458,7 @@ func TestCalculateMultiplier(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			multiplier, err := service.CalculateMultiplier(tt.userID, tt.nodeID)
+			multiplier, err := service.CalculateMultiplier(tt.userID, tt.nodeID, time.Now())
 			if err != nil {
 				t.Fatalf("CalculateMultiplier() error = %v", err)
 			}
@@ -118,38 +474,39 @@ func TestCalculatePeriodBounds(t *testing.T) {
 	logger, _ := zap.NewDevelopment()
 
 	service := &accountingService{
-		logger: logger,
+		logger:    logger,
+		nodeUsers: newMockNodeUsers(),
 	}
 
 	now := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
 
 	tests := []struct {
-		name         string
-		resetPeriod  string
+		name          string
+		resetPeriod   string
 		expectedStart time.Time
 		expectedEnd   time.Time
 	}{
 		{
-			name:         "Daily period",
-			resetPeriod:  "daily",
+			name:          "Daily period",
+			resetPeriod:   "daily",
 			expectedStart: time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC),
 			expectedEnd:   time.Date(2025, 1, 16, 0, 0, 0, 0, time.UTC),
 		},
 		{
-			name:         "Weekly period",
-			resetPeriod:  "weekly",
-			expectedStart: time.Date(2025, 1, 11, 0, 0, 0, 0, time.UTC), // Sunday
-			expectedEnd:   time.Date(2025, 1, 18, 0, 0, 0, 0, time.UTC),
+			name:          "Weekly period",
+			resetPeriod:   "weekly",
+			expectedStart: time.Date(2025, 1, 12, 0, 0, 0, 0, time.UTC), // Sunday
+			expectedEnd:   time.Date(2025, 1, 19, 0, 0, 0, 0, time.UTC),
 		},
 		{
-			name:         "Monthly period",
-			resetPeriod:  "monthly",
+			name:          "Monthly period",
+			resetPeriod:   "monthly",
 			expectedStart: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
 			expectedEnd:   time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC),
 		},
 		{
-			name:         "Yearly period",
-			resetPeriod:  "yearly",
+			name:          "Yearly period",
+			resetPeriod:   "yearly",
 			expectedStart: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
 			expectedEnd:   time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
 		},
@@ -157,7 +514,7 @@ func TestCalculatePeriodBounds(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			start, end := service.calculatePeriodBounds(now, tt.resetPeriod)
+			start, end := service.calculatePeriodBounds(now, tt.resetPeriod, nil)
 			if !start.Equal(tt.expectedStart) {
 				t.Errorf("Period start = %v, want %v", start, tt.expectedStart)
 			}
@@ -168,6 +525,471 @@ func TestCalculatePeriodBounds(t *testing.T) {
 	}
 }
 
+// Test period bounds with a per-user reset anchor
+func TestCalculateAnchoredPeriodBounds(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+
+	service := &accountingService{
+		logger:    logger,
+		nodeUsers: newMockNodeUsers(),
+	}
+
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		now           time.Time
+		resetPeriod   string
+		anchor        *models.ResetAnchor
+		expectedStart time.Time
+		expectedEnd   time.Time
+	}{
+		{
+			name:          "Monthly on the 15th, after anchor",
+			now:           time.Date(2025, 1, 20, 12, 0, 0, 0, time.UTC),
+			resetPeriod:   "monthly",
+			anchor:        &models.ResetAnchor{DayOfMonth: 15},
+			expectedStart: time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC),
+			expectedEnd:   time.Date(2025, 2, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "Monthly on the 15th, before anchor",
+			now:           time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC),
+			resetPeriod:   "monthly",
+			anchor:        &models.ResetAnchor{DayOfMonth: 15},
+			expectedStart: time.Date(2024, 12, 15, 0, 0, 0, 0, time.UTC),
+			expectedEnd:   time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "Monthly on the 31st clamps to February",
+			now:           time.Date(2025, 2, 10, 12, 0, 0, 0, time.UTC),
+			resetPeriod:   "monthly",
+			anchor:        &models.ResetAnchor{DayOfMonth: 31},
+			expectedStart: time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC),
+			expectedEnd:   time.Date(2025, 2, 28, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "Monthly on the 31st after clamped February anchor",
+			now:           time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+			resetPeriod:   "monthly",
+			anchor:        &models.ResetAnchor{DayOfMonth: 31},
+			expectedStart: time.Date(2025, 2, 28, 0, 0, 0, 0, time.UTC),
+			expectedEnd:   time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "Monthly on the 29th in a leap year",
+			now:           time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC),
+			resetPeriod:   "monthly",
+			anchor:        &models.ResetAnchor{DayOfMonth: 29},
+			expectedStart: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+			expectedEnd:   time.Date(2024, 3, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "Weekly on Wednesday",
+			now:           time.Date(2025, 1, 14, 12, 0, 0, 0, time.UTC), // Tuesday
+			resetPeriod:   "weekly",
+			anchor:        &models.ResetAnchor{Weekday: 3},
+			expectedStart: time.Date(2025, 1, 8, 0, 0, 0, 0, time.UTC),
+			expectedEnd:   time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "Yearly on March 10th",
+			now:           time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC),
+			resetPeriod:   "yearly",
+			anchor:        &models.ResetAnchor{DayOfMonth: 10, Month: 3},
+			expectedStart: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC),
+			expectedEnd:   time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "Daily in anchor time zone",
+			now:           time.Date(2025, 1, 15, 20, 0, 0, 0, time.UTC), // 04:00 on the 16th in Shanghai
+			resetPeriod:   "daily",
+			anchor:        &models.ResetAnchor{Timezone: "Asia/Shanghai"},
+			expectedStart: time.Date(2025, 1, 16, 0, 0, 0, 0, shanghai),
+			expectedEnd:   time.Date(2025, 1, 17, 0, 0, 0, 0, shanghai),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := service.calculatePeriodBounds(tt.now, tt.resetPeriod, tt.anchor)
+			if !start.Equal(tt.expectedStart) {
+				t.Errorf("Period start = %v, want %v", start, tt.expectedStart)
+			}
+			if !end.Equal(tt.expectedEnd) {
+				t.Errorf("Period end = %v, want %v", end, tt.expectedEnd)
+			}
+		})
+	}
+}
+
+// Test quota proration for split periods
+func TestProratedQuota(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2025, 1, 11, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		expected uint64
+	}{
+		{"At start", start, 1000},
+		{"Halfway", start.AddDate(0, 0, 5), 500},
+		{"Nine tenths elapsed", start.AddDate(0, 0, 9), 100},
+		{"At end", end, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := proratedQuota(1000, tt.at, start, end); got != tt.expected {
+				t.Errorf("proratedQuota() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// Test which part of a traffic delta is charged to traffic packs
+func TestQuotaOverflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		used     uint64
+		delta    uint64
+		expected uint64
+	}{
+		{"Within quota", 100, 200, 0},
+		{"Exactly reaches quota", 800, 200, 0},
+		{"Crosses quota", 900, 200, 100},
+		{"Already over quota", 1200, 200, 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := quotaOverflow(tt.used, tt.delta, 1000); got != tt.expected {
+				t.Errorf("quotaOverflow() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// Test billing a batch of traffic from several nodes
+func TestProcessTrafficBatch(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+
+	planID := uint64(1)
+	plan := &models.Plan{ID: planID, QuotaBytes: 1 << 40, BaseMultiplier: 1.0}
+	usageRepo := &mockUsageRepo{
+		periods: []models.UsagePeriod{
+			{ID: 7, UserID: 1, PlanID: planID, PeriodEnd: time.Now().Add(time.Hour), IsCurrent: true},
+		},
+	}
+	service := &accountingService{
+		userRepo: &mockUserRepo{users: map[uint64]*models.User{
+			1: {ID: 1, PlanID: &planID, Plan: plan},
+			2: {ID: 2, PlanID: &planID, Plan: plan, Banned: true},
+		}},
+		nodeRepo:    &mockNodeRepo{},
+		planRepo:    &mockPlanRepo{},
+		usageRepo:   usageRepo,
+		multipliers: NewMultiplierResolver(&mockNodeRepo{}, &mockPlanRepo{}, &mockScheduleRepo{}),
+		logger:      logger,
+		nodeUsers:   newMockNodeUsers(),
+	}
+
+	err := service.ProcessTrafficBatch("batch-1", []models.TrafficDelta{
+		{NodeID: 1, UserID: 1, Upload: 1000, Download: 2000},
+		{NodeID: 2, UserID: 1, Upload: 10, Download: 0},
+		{NodeID: 1, UserID: 2, Upload: 1000, Download: 1000},
+		{NodeID: 1, UserID: 3, Upload: 1000, Download: 1000},
+	})
+	if err != nil {
+		t.Fatalf("ProcessTrafficBatch() error = %v", err)
+	}
+
+	// Banned and unknown users are dropped
+	if len(usageRepo.applied) != 2 {
+		t.Fatalf("Applied %d increments, want 2", len(usageRepo.applied))
+	}
+
+	// node_multiplier (1.5) × plan_base (1.0) × label_premium (2.0) = 3.0
+	got := usageRepo.applied[0]
+	if got.PeriodID != 7 || got.RealBytesUp != 1000 || got.BillableBytesUp != 3000 || got.BillableBytesDown != 6000 {
+		t.Errorf("Increment = %+v, want period 7 billed at 3x", got)
+	}
+
+	// A batch replayed with the same ID is not billed again
+	if err := service.ProcessTrafficBatch("batch-1", []models.TrafficDelta{
+		{NodeID: 1, UserID: 1, Upload: 1000, Download: 2000},
+	}); err != nil {
+		t.Fatalf("ProcessTrafficBatch() error = %v", err)
+	}
+	if len(usageRepo.applied) != 2 {
+		t.Errorf("Applied %d increments after replay, want 2", len(usageRepo.applied))
+	}
+}
+
+// Test that traffic beyond the quota is drawn from packs with the batch, and
+// only once when the batch is replayed
+func TestProcessTrafficBatchConsumesPacks(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+
+	planID := uint64(1)
+	plan := &models.Plan{ID: planID, QuotaBytes: 1000, BaseMultiplier: 1.0}
+	packs := &mockPackRepo{}
+	packs.Create(&models.TrafficPack{UserID: 1, Bytes: 10000})
+	usageRepo := &mockUsageRepo{
+		periods: []models.UsagePeriod{
+			{ID: 7, UserID: 1, PlanID: planID, PeriodEnd: time.Now().Add(time.Hour), BillableBytesDown: 1000, IsCurrent: true},
+		},
+		packs: packs,
+	}
+	service := &accountingService{
+		userRepo: &mockUserRepo{users: map[uint64]*models.User{
+			1: {ID: 1, PlanID: &planID, Plan: plan},
+		}},
+		planRepo:    &mockPlanRepo{},
+		usageRepo:   usageRepo,
+		multipliers: NewMultiplierResolver(&mockNodeRepo{}, &mockPlanRepo{}, &mockScheduleRepo{}),
+		logger:      logger,
+		nodeUsers:   newMockNodeUsers(),
+	}
+
+	deltas := []models.TrafficDelta{{NodeID: 1, UserID: 1, Upload: 100, Download: 100}}
+	for i := 0; i < 2; i++ {
+		if err := service.ProcessTrafficBatch("batch-1", deltas); err != nil {
+			t.Fatalf("ProcessTrafficBatch() error = %v", err)
+		}
+	}
+
+	// Billed at 3x, all of it beyond the quota
+	if used := packs.packs[0].UsedBytes; used != 600 {
+		t.Errorf("Pack used bytes = %d, want 600", used)
+	}
+}
+
+// failingResolver fails every multiplier lookup
+type failingResolver struct {
+	MultiplierResolver
+}
+
+func (failingResolver) Resolve(planID, nodeID uint64, at time.Time) (float64, error) {
+	return 0, gorm.ErrInvalidTransaction
+}
+
+// Test that a lookup failure fails the batch instead of billing it without
+// the traffic
+func TestProcessTrafficBatchFailsOnLookupError(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+
+	planID := uint64(1)
+	plan := &models.Plan{ID: planID, QuotaBytes: 1 << 40, BaseMultiplier: 1.0}
+	usageRepo := &mockUsageRepo{
+		periods: []models.UsagePeriod{
+			{ID: 7, UserID: 1, PlanID: planID, PeriodEnd: time.Now().Add(time.Hour), IsCurrent: true},
+		},
+	}
+	service := &accountingService{
+		userRepo: &mockUserRepo{users: map[uint64]*models.User{
+			1: {ID: 1, PlanID: &planID, Plan: plan},
+		}},
+		planRepo:    &mockPlanRepo{},
+		usageRepo:   usageRepo,
+		multipliers: failingResolver{},
+		logger:      logger,
+		nodeUsers:   newMockNodeUsers(),
+	}
+
+	err := service.ProcessTrafficBatch("batch-1", []models.TrafficDelta{
+		{NodeID: 1, UserID: 1, Upload: 1000, Download: 2000},
+	})
+	if err == nil {
+		t.Fatal("ProcessTrafficBatch() should fail when the multiplier lookup fails")
+	}
+	if usageRepo.batches["batch-1"] || len(usageRepo.applied) != 0 {
+		t.Errorf("Failed batch was recorded as billed")
+	}
+}
+
+// Test splitting the current period after an anchor change
+func TestSplitCurrentPeriod(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+
+	planID := uint64(1)
+	day := time.Now().AddDate(0, 0, 3).Day()
+	userRepo := &mockUserRepo{users: map[uint64]*models.User{
+		1: {ID: 1, PlanID: &planID, ResetDay: &day},
+	}}
+	usageRepo := &mockUsageRepo{}
+	now := time.Now()
+	usageRepo.CreatePeriod(&models.UsagePeriod{
+		UserID:            1,
+		PlanID:            planID,
+		PeriodStart:       now.AddDate(0, 0, -10),
+		PeriodEnd:         now.AddDate(0, 0, 20).Add(time.Hour),
+		BillableBytesDown: 1234,
+		IsCurrent:         true,
+	})
+
+	service := &accountingService{
+		userRepo:  userRepo,
+		planRepo:  &mockPlanRepo{},
+		usageRepo: usageRepo,
+		logger:    logger,
+		nodeUsers: newMockNodeUsers(),
+	}
+
+	current, err := service.SplitCurrentPeriod(1)
+	if err != nil {
+		t.Fatalf("SplitCurrentPeriod() error = %v", err)
+	}
+
+	old := usageRepo.periods[0]
+	if old.IsCurrent {
+		t.Errorf("old period is still current")
+	}
+	if old.BillableBytesDown != 1234 {
+		t.Errorf("old period usage = %v, want 1234", old.BillableBytesDown)
+	}
+	if !old.PeriodEnd.Equal(current.PeriodStart) {
+		t.Errorf("old period end %v != new period start %v", old.PeriodEnd, current.PeriodStart)
+	}
+	if current.PeriodEnd.Day() != day {
+		t.Errorf("new period ends on day %d, want %d", current.PeriodEnd.Day(), day)
+	}
+	if current.QuotaBytes == nil {
+		t.Fatalf("new period has no prorated quota")
+	}
+	plan, _ := (&mockPlanRepo{}).FindByID(planID)
+	if *current.QuotaBytes == 0 || *current.QuotaBytes >= plan.QuotaBytes {
+		t.Errorf("prorated quota = %v, want between 0 and %v", *current.QuotaBytes, plan.QuotaBytes)
+	}
+
+	// A second call with the same anchor is a no-op
+	again, err := service.SplitCurrentPeriod(1)
+	if err != nil {
+		t.Fatalf("second SplitCurrentPeriod() error = %v", err)
+	}
+	if again.ID != current.ID || len(usageRepo.periods) != 2 {
+		t.Errorf("second split created a new period")
+	}
+}
+
+// Test that moving the anchor after heavy usage does not hand the used
+// quota out again
+func TestSplitCurrentPeriodAfterUsage(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+
+	planID := uint64(1)
+	plan, _ := (&mockPlanRepo{}).FindByID(planID)
+	day := time.Now().AddDate(0, 0, 15).Day()
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		used      uint64
+		wantBelow uint64
+	}{
+		{"quota used up", plan.QuotaBytes, 1},
+		{"three quarters used", plan.QuotaBytes / 4 * 3, plan.QuotaBytes/4 + 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userRepo := &mockUserRepo{users: map[uint64]*models.User{
+				1: {ID: 1, PlanID: &planID, ResetDay: &day},
+			}}
+			usageRepo := &mockUsageRepo{}
+			usageRepo.CreatePeriod(&models.UsagePeriod{
+				UserID:            1,
+				PlanID:            planID,
+				PeriodStart:       now.AddDate(0, 0, -15),
+				PeriodEnd:         now.AddDate(0, 0, 15).Add(time.Hour),
+				BillableBytesUp:   tt.used / 2,
+				BillableBytesDown: tt.used - tt.used/2,
+				IsCurrent:         true,
+			})
+
+			service := &accountingService{
+				userRepo:  userRepo,
+				planRepo:  &mockPlanRepo{},
+				usageRepo: usageRepo,
+				logger:    logger,
+				nodeUsers: newMockNodeUsers(),
+			}
+
+			current, err := service.SplitCurrentPeriod(1)
+			if err != nil {
+				t.Fatalf("SplitCurrentPeriod() error = %v", err)
+			}
+			if current.QuotaBytes == nil || *current.QuotaBytes >= tt.wantBelow {
+				t.Errorf("bridging quota = %v, want below %v", current.QuotaBytes, tt.wantBelow)
+			}
+		})
+	}
+}
+
+// Test that a mid-period plan change closes the old period now and opens a
+// prorated one, keeping the usage so far in the old period
+func TestRestartPeriodMidPeriod(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+
+	planID := uint64(2)
+	userRepo := &mockUserRepo{users: map[uint64]*models.User{
+		1: {ID: 1, PlanID: &planID},
+	}}
+	usageRepo := &mockUsageRepo{}
+	service := &accountingService{
+		userRepo:  userRepo,
+		planRepo:  &mockPlanRepo{},
+		usageRepo: usageRepo,
+		logger:    logger,
+		nodeUsers: newMockNodeUsers(),
+	}
+
+	start, end := service.calculatePeriodBounds(time.Now(), "monthly", nil)
+	usageRepo.CreatePeriod(&models.UsagePeriod{
+		UserID:            1,
+		PlanID:            1,
+		PeriodStart:       start,
+		PeriodEnd:         end,
+		BillableBytesDown: 1234,
+		IsCurrent:         true,
+	})
+
+	if err := service.RestartPeriod(1); err != nil {
+		t.Fatalf("RestartPeriod() error = %v", err)
+	}
+	if len(usageRepo.periods) != 2 {
+		t.Fatalf("Got %d periods, want 2", len(usageRepo.periods))
+	}
+
+	old, current := usageRepo.periods[0], usageRepo.periods[1]
+	if old.IsCurrent || !current.IsCurrent {
+		t.Fatalf("old period current = %v, new period current = %v", old.IsCurrent, current.IsCurrent)
+	}
+	if old.BillableBytesDown != 1234 || current.BillableBytesDown != 0 {
+		t.Errorf("usage old = %v, new = %v, want 1234 and 0", old.BillableBytesDown, current.BillableBytesDown)
+	}
+	if !current.PeriodStart.After(old.PeriodStart) {
+		t.Errorf("new period starts %v, not after the old start %v", current.PeriodStart, old.PeriodStart)
+	}
+	if !old.PeriodEnd.Equal(current.PeriodStart) {
+		t.Errorf("old period ends %v, want the new start %v", old.PeriodEnd, current.PeriodStart)
+	}
+	if !current.PeriodEnd.Equal(end) {
+		t.Errorf("new period ends %v, want %v", current.PeriodEnd, end)
+	}
+	if current.PlanID != planID {
+		t.Errorf("new period plan = %d, want %d", current.PlanID, planID)
+	}
+	plan, _ := (&mockPlanRepo{}).FindByID(planID)
+	if current.QuotaBytes == nil || *current.QuotaBytes >= plan.QuotaBytes {
+		t.Errorf("new period quota = %v, want prorated below %v", current.QuotaBytes, plan.QuotaBytes)
+	}
+}
+
 // Test traffic calculation with multipliers
 func TestTrafficCalculation(t *testing.T) {
 	tests := []struct {
@@ -198,9 +1020,9 @@ func TestTrafficCalculation(t *testing.T) {
 			name:             "All multipliers",
 			realBytes:        1000000,
 			nodeMultiplier:   1.5,
-			planMultiplier:   1.2,
+			planMultiplier:   1.25,
 			labelMultiplier:  2.0,
-			expectedBillable: 3600000, // 1000000 × 1.5 × 1.2 × 2.0
+			expectedBillable: 3750000, // 1000000 × 1.5 × 1.25 × 2.0
 		},
 		{
 			name:             "Fractional result rounds down",
@@ -229,16 +1051,251 @@ func BenchmarkCalculateMultiplier(b *testing.B) {
 	logger, _ := zap.NewDevelopment()
 
 	service := &accountingService{
-		userRepo:  &mockUserRepo{},
-		nodeRepo:  &mockNodeRepo{},
-		planRepo:  &mockPlanRepo{},
-		usageRepo: &mockUsageRepo{},
-		uuidRepo:  &mockUUIDRepo{},
-		logger:    logger,
+		userRepo:    &mockUserRepo{},
+		nodeRepo:    &mockNodeRepo{},
+		planRepo:    &mockPlanRepo{},
+		usageRepo:   &mockUsageRepo{},
+		uuidRepo:    &mockUUIDRepo{},
+		multipliers: NewMultiplierResolver(&mockNodeRepo{}, &mockPlanRepo{}, &mockScheduleRepo{}),
+		logger:      logger,
+		nodeUsers:   newMockNodeUsers(),
 	}
 
+	now := time.Now()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		service.CalculateMultiplier(1, 1)
+		service.CalculateMultiplier(1, 1, now)
+	}
+}
+
+// Test period rollover
+func TestCheckAndResetPeriods(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+
+	planID := uint64(1)
+	monthly := &models.Plan{ID: planID, ResetPeriod: "monthly"}
+	now := time.Now()
+	expiredEnd := now.Add(-time.Hour)
+
+	newRepos := func() (*mockUserRepo, *mockUsageRepo) {
+		userRepo := &mockUserRepo{users: map[uint64]*models.User{
+			1: {ID: 1, PlanID: &planID, Plan: monthly},
+			2: {ID: 2, PlanID: &planID, Plan: monthly},
+			3: {ID: 3}, // plan removed
+		}}
+		usageRepo := &mockUsageRepo{}
+		for _, p := range []models.UsagePeriod{
+			{UserID: 1, PlanID: planID, PeriodStart: expiredEnd.AddDate(0, -1, 0), PeriodEnd: expiredEnd, BillableBytesDown: 500, IsCurrent: true},
+			{UserID: 2, PlanID: planID, PeriodStart: now.Add(-time.Hour), PeriodEnd: now.Add(time.Hour), IsCurrent: true},
+			{UserID: 3, PlanID: planID, PeriodStart: expiredEnd.AddDate(0, -1, 0), PeriodEnd: expiredEnd, IsCurrent: true},
+		} {
+			period := p
+			usageRepo.CreatePeriod(&period)
+		}
+		return userRepo, usageRepo
+	}
+
+	t.Run("Expired periods roll over", func(t *testing.T) {
+		userRepo, usageRepo := newRepos()
+		service := &accountingService{
+			userRepo:  userRepo,
+			planRepo:  &mockPlanRepo{},
+			usageRepo: usageRepo,
+			logger:    logger,
+			nodeUsers: newMockNodeUsers(),
+		}
+
+		if err := service.CheckAndResetPeriods(); err != nil {
+			t.Fatalf("CheckAndResetPeriods() error = %v", err)
+		}
+
+		if usageRepo.periods[0].IsCurrent {
+			t.Errorf("expired period for user 1 is still current")
+		}
+		current, err := usageRepo.GetCurrentPeriod(1)
+		if err != nil {
+			t.Fatalf("user 1 has no current period after rollover: %v", err)
+		}
+		if current.ID == usageRepo.periods[0].ID {
+			t.Errorf("user 1 current period was not replaced")
+		}
+		if current.BillableBytesDown != 0 {
+			t.Errorf("new period usage = %v, want 0", current.BillableBytesDown)
+		}
+		if !current.PeriodEnd.After(now) {
+			t.Errorf("new period end %v is not after now", current.PeriodEnd)
+		}
+
+		if current, err := usageRepo.GetCurrentPeriod(2); err != nil || current.ID != usageRepo.periods[1].ID {
+			t.Errorf("unexpired period for user 2 was modified")
+		}
+
+		if _, err := usageRepo.GetCurrentPeriod(3); err != gorm.ErrRecordNotFound {
+			t.Errorf("user 3 without plan should have no current period, got err = %v", err)
+		}
+	})
+
+	t.Run("Re-running is idempotent", func(t *testing.T) {
+		userRepo, usageRepo := newRepos()
+		service := &accountingService{
+			userRepo:  userRepo,
+			planRepo:  &mockPlanRepo{},
+			usageRepo: usageRepo,
+			logger:    logger,
+			nodeUsers: newMockNodeUsers(),
+		}
+
+		if err := service.CheckAndResetPeriods(); err != nil {
+			t.Fatalf("CheckAndResetPeriods() error = %v", err)
+		}
+		count := len(usageRepo.periods)
+
+		if err := service.CheckAndResetPeriods(); err != nil {
+			t.Fatalf("second CheckAndResetPeriods() error = %v", err)
+		}
+		if len(usageRepo.periods) != count {
+			t.Errorf("second run created %d extra periods", len(usageRepo.periods)-count)
+		}
+	})
+
+	t.Run("Failed rollover is retried on next run", func(t *testing.T) {
+		userRepo, usageRepo := newRepos()
+		usageRepo.failRollover = map[uint64]bool{1: true}
+		service := &accountingService{
+			userRepo:  userRepo,
+			planRepo:  &mockPlanRepo{},
+			usageRepo: usageRepo,
+			logger:    logger,
+			nodeUsers: newMockNodeUsers(),
+		}
+
+		if err := service.CheckAndResetPeriods(); err != nil {
+			t.Fatalf("CheckAndResetPeriods() error = %v", err)
+		}
+		if !usageRepo.periods[0].IsCurrent {
+			t.Fatalf("failed rollover should leave the period current")
+		}
+
+		usageRepo.failRollover = nil
+		if err := service.CheckAndResetPeriods(); err != nil {
+			t.Fatalf("CheckAndResetPeriods() error = %v", err)
+		}
+		if usageRepo.periods[0].IsCurrent {
+			t.Errorf("period was not rolled over on retry")
+		}
+		if _, err := usageRepo.GetCurrentPeriod(1); err != nil {
+			t.Errorf("user 1 has no current period after retry: %v", err)
+		}
+	})
+
+	t.Run("Existing current period is not duplicated", func(t *testing.T) {
+		userRepo, usageRepo := newRepos()
+		// Simulate a crash that left a fresh period next to the expired one
+		usageRepo.CreatePeriod(&models.UsagePeriod{UserID: 1, PlanID: planID, PeriodStart: now, PeriodEnd: now.AddDate(0, 1, 0), IsCurrent: true})
+		usageRepo.periods[0].IsCurrent = true
+		service := &accountingService{
+			userRepo:  userRepo,
+			planRepo:  &mockPlanRepo{},
+			usageRepo: usageRepo,
+			logger:    logger,
+			nodeUsers: newMockNodeUsers(),
+		}
+
+		if err := service.CheckAndResetPeriods(); err != nil {
+			t.Fatalf("CheckAndResetPeriods() error = %v", err)
+		}
+
+		current := 0
+		for _, p := range usageRepo.periods {
+			if p.UserID == 1 && p.IsCurrent {
+				current++
+			}
+		}
+		if current != 1 {
+			t.Errorf("user 1 has %d current periods, want 1", current)
+		}
+	})
+}
+
+// Test that paging through expired periods covers more than one batch
+func TestCheckAndResetPeriodsBatches(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+
+	planID := uint64(1)
+	plan := &models.Plan{ID: planID, ResetPeriod: "daily"}
+	expiredEnd := time.Now().Add(-time.Minute)
+
+	userRepo := &mockUserRepo{users: map[uint64]*models.User{}}
+	usageRepo := &mockUsageRepo{}
+	total := periodRolloverBatchSize*2 + 7
+	for i := 1; i <= total; i++ {
+		userRepo.users[uint64(i)] = &models.User{ID: uint64(i), PlanID: &planID, Plan: plan}
+		usageRepo.CreatePeriod(&models.UsagePeriod{
+			UserID:      uint64(i),
+			PlanID:      planID,
+			PeriodStart: expiredEnd.AddDate(0, 0, -1),
+			PeriodEnd:   expiredEnd,
+			IsCurrent:   true,
+		})
+	}
+
+	service := &accountingService{
+		userRepo:  userRepo,
+		planRepo:  &mockPlanRepo{},
+		usageRepo: usageRepo,
+		logger:    logger,
+		nodeUsers: newMockNodeUsers(),
+	}
+
+	if err := service.CheckAndResetPeriods(); err != nil {
+		t.Fatalf("CheckAndResetPeriods() error = %v", err)
+	}
+
+	for i := 0; i < total; i++ {
+		if usageRepo.periods[i].IsCurrent {
+			t.Fatalf("period %d was not rolled over", usageRepo.periods[i].ID)
+		}
+	}
+	if len(usageRepo.periods) != total*2 {
+		t.Errorf("periods = %d, want %d", len(usageRepo.periods), total*2)
+	}
+}
+
+// Test that only the rollover that renews a period records a user list change
+func TestRolloverPeriodRecordsChangeOnce(t *testing.T) {
+	planID := uint64(1)
+	plan := &models.Plan{ID: planID, ResetPeriod: "daily"}
+	expiredEnd := time.Now().Add(-time.Minute)
+
+	usageRepo := &mockUsageRepo{}
+	usageRepo.CreatePeriod(&models.UsagePeriod{
+		UserID:      1,
+		PlanID:      planID,
+		PeriodStart: expiredEnd.AddDate(0, 0, -1),
+		PeriodEnd:   expiredEnd,
+		IsCurrent:   true,
+	})
+	stale := usageRepo.periods[0]
+
+	changes := &mockChangeRepo{}
+	service := &accountingService{
+		userRepo:  &mockUserRepo{users: map[uint64]*models.User{1: {ID: 1, PlanID: &planID, Plan: plan}}},
+		planRepo:  &mockPlanRepo{},
+		usageRepo: usageRepo,
+		logger:    zap.NewNop(),
+		nodeUsers: NewNodeUserService(&config.NodeConfig{}, &mockUserRepo{}, changes, &mockPackRepo{}, &mockSubscriptionRepo{}, NewNodePushService(), zap.NewNop()),
+	}
+
+	for i, want := range []bool{true, false} {
+		renewed, err := service.rolloverPeriod(&stale, time.Now())
+		if err != nil {
+			t.Fatalf("rolloverPeriod() error = %v", err)
+		}
+		if renewed != want {
+			t.Errorf("Rollover %d renewed = %v, want %v", i+1, renewed, want)
+		}
+	}
+	if len(changes.changes) != 1 {
+		t.Errorf("Recorded %d user list changes, want 1", len(changes.changes))
 	}
 }
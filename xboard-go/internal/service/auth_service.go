@@ -22,7 +22,7 @@ type AuthService interface {
 	ValidateToken(tokenString string) (*Claims, error)
 	HashPassword(password string) (string, error)
 	ComparePassword(hashedPassword, password string) error
-	GenerateTelegramLinkToken() (string, error)
+	ResetSubscribeToken(user *models.User) (string, error)
 }
 
 type authService struct {
@@ -66,7 +66,8 @@ func (s *authService) Register(email, password, role string) (*models.User, erro
 		Role:         role,
 	}
 
-	if err := s.userRepo.Create(user); err != nil {
+	// Start with the default usage notifications, which the user can change
+	if err := s.userRepo.CreateWithThresholds(user, models.DefaultTelegramThresholds()); err != nil {
 		return nil, err
 	}
 
@@ -192,10 +193,27 @@ func (s *authService) generateRefreshToken(user *models.User) (string, error) {
 	return tokenString, nil
 }
 
-func (s *authService) GenerateTelegramLinkToken() (string, error) {
+// generateSubscribeToken returns a random subscription token, sized for the
+// 32 character users.token column
+func generateSubscribeToken() (string, error) {
 	tokenBytes := make([]byte, 16)
 	if _, err := rand.Read(tokenBytes); err != nil {
 		return "", err
 	}
 	return hex.EncodeToString(tokenBytes), nil
 }
+
+// ResetSubscribeToken gives the user a new subscription token, invalidating
+// any subscription URL issued before
+func (s *authService) ResetSubscribeToken(user *models.User) (string, error) {
+	token, err := generateSubscribeToken()
+	if err != nil {
+		return "", err
+	}
+
+	user.Token = &token
+	if err := s.userRepo.Update(user); err != nil {
+		return "", err
+	}
+	return token, nil
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"sort"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+)
+
+// MultiplierExplanation breaks a multiplier down into the factors it was
+// computed from. The node, base and label multipliers include the schedules
+// active at the evaluated time.
+type MultiplierExplanation struct {
+	At             time.Time `json:"at"`
+	NodeMultiplier float64   `json:"node_multiplier"`
+	BaseMultiplier float64   `json:"base_multiplier"`
+	Strategy       string    `json:"strategy"`
+	// Labels are the node's labels the plan has a multiplier for or an
+	// active schedule is attached to, highest priority first
+	Labels          []LabelFactor    `json:"labels"`
+	LabelMultiplier float64          `json:"label_multiplier"`
+	Schedules       []ScheduleFactor `json:"schedules"`
+	Multiplier      float64          `json:"multiplier"`
+}
+
+// LabelFactor is a label multiplier considered for a node
+type LabelFactor struct {
+	LabelID    uint64  `json:"label_id"`
+	Name       string  `json:"name"`
+	Priority   int     `json:"priority"`
+	Multiplier float64 `json:"multiplier"`
+	// Applied is false for labels the strategy ignored
+	Applied bool `json:"applied"`
+}
+
+// ScheduleFactor is a multiplier schedule active at the evaluated time
+type ScheduleFactor struct {
+	ScheduleID uint64  `json:"schedule_id"`
+	Name       string  `json:"name"`
+	Scope      string  `json:"scope"`
+	TargetID   uint64  `json:"target_id"`
+	Multiplier float64 `json:"multiplier"`
+}
+
+// scheduleRule is a multiplier schedule with its window parsed
+type scheduleRule struct {
+	schedule models.MultiplierSchedule
+	location *time.Location
+	start    int
+	end      int
+}
+
+// newScheduleRules parses schedules, skipping any that do not validate
+func newScheduleRules(schedules []models.MultiplierSchedule) []scheduleRule {
+	rules := make([]scheduleRule, 0, len(schedules))
+	for _, schedule := range schedules {
+		if schedule.Validate() != nil {
+			continue
+		}
+		location, start, end, _ := schedule.Window()
+		rules = append(rules, scheduleRule{schedule: schedule, location: location, start: start, end: end})
+	}
+	return rules
+}
+
+func (r *scheduleRule) factor() ScheduleFactor {
+	factor := ScheduleFactor{
+		ScheduleID: r.schedule.ID,
+		Name:       r.schedule.Name,
+		Scope:      r.schedule.Scope(),
+		Multiplier: r.schedule.Multiplier,
+	}
+	for _, id := range []*uint64{r.schedule.NodeID, r.schedule.LabelID, r.schedule.PlanID} {
+		if id != nil {
+			factor.TargetID = *id
+		}
+	}
+	return factor
+}
+
+// explainMultiplier computes node multiplier × plan base multiplier × the
+// plan's label multipliers for the node's labels, combined with the plan's
+// strategy:
+//
+//   - product: all label multipliers multiplied together
+//   - max, min: the largest or smallest label multiplier
+//   - priority: the multiplier of the highest-priority label
+//   - sum: 1 plus the sum of each label multiplier's difference from 1
+//
+// Without matching labels the label factor is 1.
+//
+// Schedules active at the given time scale the multiplier of what they are
+// attached to before the strategy is applied. A label with an active schedule
+// counts as priced at 1 if the plan has no multiplier for it.
+func explainMultiplier(node *models.Node, plan *models.Plan, labelMultipliers map[uint64]float64, schedules []scheduleRule, at time.Time) *MultiplierExplanation {
+	strategy := plan.MultiplierStrategy
+	if strategy == "" {
+		strategy = models.MultiplierStrategyProduct
+	}
+
+	explanation := &MultiplierExplanation{
+		At:              at,
+		NodeMultiplier:  node.NodeMultiplier,
+		BaseMultiplier:  plan.BaseMultiplier,
+		Strategy:        strategy,
+		Labels:          []LabelFactor{},
+		LabelMultiplier: 1.0,
+		Schedules:       []ScheduleFactor{},
+	}
+
+	scheduled := make(map[uint64]float64)
+	for i := range schedules {
+		rule := &schedules[i]
+		if !rule.schedule.ActiveAt(at, rule.location, rule.start, rule.end) {
+			continue
+		}
+		switch s := rule.schedule; {
+		case s.NodeID != nil && *s.NodeID == node.ID:
+			explanation.NodeMultiplier *= s.Multiplier
+		case s.PlanID != nil && *s.PlanID == plan.ID:
+			explanation.BaseMultiplier *= s.Multiplier
+		case s.LabelID != nil:
+			if _, ok := scheduled[*s.LabelID]; !ok {
+				scheduled[*s.LabelID] = 1.0
+			}
+			scheduled[*s.LabelID] *= s.Multiplier
+		default:
+			continue
+		}
+		explanation.Schedules = append(explanation.Schedules, rule.factor())
+	}
+
+	for _, label := range node.Labels {
+		multiplier, priced := labelMultipliers[label.ID]
+		factor, isScheduled := scheduled[label.ID]
+		if !priced && !isScheduled {
+			continue
+		}
+		if !priced {
+			multiplier = 1.0
+		}
+		if isScheduled {
+			multiplier *= factor
+		}
+		explanation.Labels = append(explanation.Labels, LabelFactor{
+			LabelID:    label.ID,
+			Name:       label.Name,
+			Priority:   label.Priority,
+			Multiplier: multiplier,
+		})
+	}
+	sort.SliceStable(explanation.Labels, func(i, j int) bool {
+		a, b := explanation.Labels[i], explanation.Labels[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		return a.LabelID < b.LabelID
+	})
+
+	if labels := explanation.Labels; len(labels) > 0 {
+		switch strategy {
+		case models.MultiplierStrategyMax, models.MultiplierStrategyMin:
+			chosen := 0
+			for i := range labels {
+				if (strategy == models.MultiplierStrategyMax && labels[i].Multiplier > labels[chosen].Multiplier) ||
+					(strategy == models.MultiplierStrategyMin && labels[i].Multiplier < labels[chosen].Multiplier) {
+					chosen = i
+				}
+			}
+			labels[chosen].Applied = true
+			explanation.LabelMultiplier = labels[chosen].Multiplier
+		case models.MultiplierStrategyPriority:
+			labels[0].Applied = true
+			explanation.LabelMultiplier = labels[0].Multiplier
+		case models.MultiplierStrategySum:
+			sum := 1.0
+			for i := range labels {
+				labels[i].Applied = true
+				sum += labels[i].Multiplier - 1
+			}
+			if sum < 0 {
+				sum = 0
+			}
+			explanation.LabelMultiplier = sum
+		default: // product
+			for i := range labels {
+				labels[i].Applied = true
+				explanation.LabelMultiplier *= labels[i].Multiplier
+			}
+		}
+	}
+
+	explanation.Multiplier = explanation.NodeMultiplier * explanation.BaseMultiplier * explanation.LabelMultiplier
+	return explanation
+}
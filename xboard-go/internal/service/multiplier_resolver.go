@@ -0,0 +1,161 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/metrics"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
+)
+
+// multiplierCacheTTL bounds how long an entry is used without invalidation,
+// as a safety net for changes made outside the admin API
+const multiplierCacheTTL = 10 * time.Minute
+
+// MultiplierResolver resolves the traffic multiplier of a plan on a node at a
+// point in time. The node's labels, the plan's base multiplier, its label
+// multipliers and the schedules that apply are cached per (plan, node) until
+// an admin change invalidates them.
+type MultiplierResolver interface {
+	Resolve(planID, nodeID uint64, at time.Time) (float64, error)
+	Explain(planID, nodeID uint64, at time.Time) (*MultiplierExplanation, error)
+	InvalidateNode(nodeID uint64)
+	InvalidatePlan(planID uint64)
+	InvalidateAll()
+}
+
+type multiplierKey struct {
+	planID uint64
+	nodeID uint64
+}
+
+// multiplierEntry holds everything the multiplier of a (plan, node) pair is
+// computed from
+type multiplierEntry struct {
+	node             *models.Node
+	plan             *models.Plan
+	labelMultipliers map[uint64]float64
+	schedules        []scheduleRule
+	loadedAt         time.Time
+}
+
+type multiplierResolver struct {
+	nodeRepo     repository.NodeRepository
+	planRepo     repository.PlanRepository
+	scheduleRepo repository.MultiplierScheduleRepository
+
+	mu      sync.RWMutex
+	entries map[multiplierKey]*multiplierEntry
+	// generation changes on every invalidation, so an entry loaded while
+	// one happened is not cached
+	generation uint64
+}
+
+func NewMultiplierResolver(
+	nodeRepo repository.NodeRepository,
+	planRepo repository.PlanRepository,
+	scheduleRepo repository.MultiplierScheduleRepository,
+) MultiplierResolver {
+	return &multiplierResolver{
+		nodeRepo:     nodeRepo,
+		planRepo:     planRepo,
+		scheduleRepo: scheduleRepo,
+		entries:      make(map[multiplierKey]*multiplierEntry),
+	}
+}
+
+func (r *multiplierResolver) Resolve(planID, nodeID uint64, at time.Time) (float64, error) {
+	explanation, err := r.Explain(planID, nodeID, at)
+	if err != nil {
+		return 0, err
+	}
+	return explanation.Multiplier, nil
+}
+
+func (r *multiplierResolver) Explain(planID, nodeID uint64, at time.Time) (*MultiplierExplanation, error) {
+	entry, err := r.entry(planID, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	return explainMultiplier(entry.node, entry.plan, entry.labelMultipliers, entry.schedules, at), nil
+}
+
+func (r *multiplierResolver) entry(planID, nodeID uint64) (*multiplierEntry, error) {
+	key := multiplierKey{planID: planID, nodeID: nodeID}
+
+	r.mu.RLock()
+	entry, ok := r.entries[key]
+	generation := r.generation
+	r.mu.RUnlock()
+	if ok && time.Since(entry.loadedAt) < multiplierCacheTTL {
+		metrics.MultiplierCacheRequestsTotal.WithLabelValues("hit").Inc()
+		return entry, nil
+	}
+	metrics.MultiplierCacheRequestsTotal.WithLabelValues("miss").Inc()
+
+	node, err := r.nodeRepo.FindByIDWithLabels(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := r.planRepo.FindByID(planID)
+	if err != nil {
+		return nil, err
+	}
+	labelMultipliers, err := r.planRepo.GetAllLabelMultipliers(planID)
+	if err != nil {
+		return nil, err
+	}
+	labelIDs := make([]uint64, 0, len(node.Labels))
+	for _, label := range node.Labels {
+		labelIDs = append(labelIDs, label.ID)
+	}
+	schedules, err := r.scheduleRepo.FindApplicable(planID, nodeID, labelIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	entry = &multiplierEntry{
+		node:             node,
+		plan:             plan,
+		labelMultipliers: labelMultipliers,
+		schedules:        newScheduleRules(schedules),
+		loadedAt:         time.Now(),
+	}
+
+	r.mu.Lock()
+	if r.generation == generation {
+		r.entries[key] = entry
+	}
+	r.mu.Unlock()
+	return entry, nil
+}
+
+func (r *multiplierResolver) InvalidateNode(nodeID uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generation++
+	for key := range r.entries {
+		if key.nodeID == nodeID {
+			delete(r.entries, key)
+		}
+	}
+}
+
+func (r *multiplierResolver) InvalidatePlan(planID uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generation++
+	for key := range r.entries {
+		if key.planID == planID {
+			delete(r.entries, key)
+		}
+	}
+}
+
+func (r *multiplierResolver) InvalidateAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generation++
+	r.entries = make(map[multiplierKey]*multiplierEntry)
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+)
+
+// countingNodeRepo counts node loads to observe cache hits
+type countingNodeRepo struct {
+	mockNodeRepo
+	loads int
+}
+
+func (m *countingNodeRepo) FindByIDWithLabels(id uint64) (*models.Node, error) {
+	m.loads++
+	return m.mockNodeRepo.FindByIDWithLabels(id)
+}
+
+// Test that multipliers are cached per (plan, node) until invalidated
+func TestMultiplierResolverCache(t *testing.T) {
+	nodeRepo := &countingNodeRepo{}
+	resolver := NewMultiplierResolver(nodeRepo, &mockPlanRepo{}, &mockScheduleRepo{})
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		multiplier, err := resolver.Resolve(1, 1, now)
+		if err != nil {
+			t.Fatalf("Resolve() error = %v", err)
+		}
+		if multiplier != 3.0 {
+			t.Errorf("Resolve() = %v, want 3.0", multiplier)
+		}
+	}
+	if nodeRepo.loads != 1 {
+		t.Errorf("Loaded node %d times, want 1", nodeRepo.loads)
+	}
+
+	resolver.Resolve(2, 1, now)
+	resolver.Resolve(1, 2, now)
+	if nodeRepo.loads != 3 {
+		t.Errorf("Loaded node %d times, want 3 for distinct pairs", nodeRepo.loads)
+	}
+
+	resolver.InvalidatePlan(2)
+	resolver.Resolve(1, 1, now)
+	resolver.Resolve(2, 1, now)
+	if nodeRepo.loads != 4 {
+		t.Errorf("Loaded node %d times, want 4 after invalidating plan 2", nodeRepo.loads)
+	}
+
+	resolver.InvalidateNode(1)
+	resolver.Resolve(1, 1, now)
+	resolver.Resolve(1, 2, now)
+	if nodeRepo.loads != 5 {
+		t.Errorf("Loaded node %d times, want 5 after invalidating node 1", nodeRepo.loads)
+	}
+
+	resolver.InvalidateAll()
+	resolver.Resolve(1, 2, now)
+	if nodeRepo.loads != 6 {
+		t.Errorf("Loaded node %d times, want 6 after invalidating all", nodeRepo.loads)
+	}
+}
+
+// Test each strategy for combining label multipliers
+func TestExplainMultiplierStrategies(t *testing.T) {
+	node := &models.Node{
+		NodeMultiplier: 2.0,
+		Labels: []models.Label{
+			{ID: 1, Name: "Premium", Priority: 1},
+			{ID: 2, Name: "Streaming", Priority: 5},
+			{ID: 3, Name: "US"},
+			{ID: 4, Name: "Unpriced", Priority: 10},
+		},
+	}
+	labelMultipliers := map[uint64]float64{1: 2.0, 2: 1.5, 3: 0.5}
+
+	tests := []struct {
+		strategy string
+		expected float64
+	}{
+		{"", 3.0},                                // 2 × (2 × 1.5 × 0.5)
+		{models.MultiplierStrategyProduct, 3.0},  // 2 × (2 × 1.5 × 0.5)
+		{models.MultiplierStrategyMax, 4.0},      // 2 × 2
+		{models.MultiplierStrategyMin, 1.0},      // 2 × 0.5
+		{models.MultiplierStrategyPriority, 3.0}, // 2 × 1.5 (Streaming)
+		{models.MultiplierStrategySum, 4.0},      // 2 × (1 + 1 + 0.5 - 0.5)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			plan := &models.Plan{BaseMultiplier: 1.0, MultiplierStrategy: tt.strategy}
+			explanation := explainMultiplier(node, plan, labelMultipliers, nil, time.Now())
+			if explanation.Multiplier != tt.expected {
+				t.Errorf("Multiplier = %v, want %v", explanation.Multiplier, tt.expected)
+			}
+			if len(explanation.Labels) != 3 || explanation.Labels[0].LabelID != 2 {
+				t.Errorf("Labels = %+v, want 3 priced labels with Streaming first", explanation.Labels)
+			}
+		})
+	}
+}
+
+// Test that schedules scale what they are attached to inside their window
+func TestMultiplierSchedules(t *testing.T) {
+	nodeID, labelID, planID := uint64(1), uint64(2), uint64(1)
+	scheduleRepo := &mockScheduleRepo{schedules: []models.MultiplierSchedule{
+		// Off-peak on weekdays in Shanghai
+		{ID: 1, NodeID: &nodeID, Timezone: "Asia/Shanghai", Weekdays: 0b0111110,
+			StartTime: "01:00", EndTime: "07:00", Multiplier: 0.5, Enabled: true},
+		// Friday night into Saturday for a label the plan does not price
+		{ID: 2, LabelID: &labelID, Timezone: "UTC", Weekdays: 1 << time.Friday,
+			StartTime: "22:00", EndTime: "02:00", Multiplier: 3.0, Enabled: true},
+		// Disabled schedules never apply
+		{ID: 3, PlanID: &planID, Timezone: "UTC", Weekdays: models.AllWeekdays,
+			StartTime: "00:00", EndTime: "00:00", Multiplier: 10.0},
+	}}
+	resolver := NewMultiplierResolver(&mockNodeRepo{}, &mockPlanRepo{}, scheduleRepo)
+
+	tests := []struct {
+		name      string
+		at        string
+		expected  float64
+		schedules int
+	}{
+		// node 1.5 × Premium 2.0
+		{"Peak", "2026-10-14T12:00:00Z", 3.0, 0},
+		// 03:00 Wednesday in Shanghai: node 1.5 × 0.5 × Premium 2.0
+		{"Off-peak", "2026-10-13T19:00:00Z", 1.5, 1},
+		// 03:00 Sunday in Shanghai
+		{"Off-peak weekend", "2026-10-17T19:00:00Z", 3.0, 0},
+		// Friday 23:00 UTC: node 1.5 × Premium 2.0 × US 3.0
+		{"Before midnight", "2026-10-16T23:00:00Z", 9.0, 1},
+		// Saturday 01:00 UTC still belongs to Friday's window
+		{"After midnight", "2026-10-17T01:00:00Z", 9.0, 1},
+		{"Window end", "2026-10-17T02:00:00Z", 3.0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, _ := time.Parse(time.RFC3339, tt.at)
+			explanation, err := resolver.Explain(planID, nodeID, at)
+			if err != nil {
+				t.Fatalf("Explain() error = %v", err)
+			}
+			if explanation.Multiplier != tt.expected {
+				t.Errorf("Multiplier = %v, want %v", explanation.Multiplier, tt.expected)
+			}
+			if len(explanation.Schedules) != tt.schedules {
+				t.Errorf("Got %d active schedules, want %d", len(explanation.Schedules), tt.schedules)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/metrics"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+type NodeHealthService interface {
+	CheckNodes() ([]NodeTransition, error)
+}
+
+// NodeTransition is a node whose health state changed in a check
+type NodeTransition struct {
+	Node models.Node
+	From string
+	To   string
+	// Since is when the node entered the From state, if known
+	Since *time.Time
+}
+
+// IsDown reports whether the node just went offline
+func (t NodeTransition) IsDown() bool {
+	return t.To == models.NodeHealthOffline
+}
+
+// IsRecovery reports whether the node came back after being offline
+func (t NodeTransition) IsRecovery() bool {
+	return t.From == models.NodeHealthOffline && t.To != models.NodeHealthOffline
+}
+
+type nodeHealthService struct {
+	cfg       *config.NodeConfig
+	nodeRepo  repository.NodeRepository
+	eventRepo repository.NodeEventRepository
+	logger    *zap.Logger
+}
+
+func NewNodeHealthService(
+	cfg *config.NodeConfig,
+	nodeRepo repository.NodeRepository,
+	eventRepo repository.NodeEventRepository,
+	logger *zap.Logger,
+) NodeHealthService {
+	return &nodeHealthService{
+		cfg:       cfg,
+		nodeRepo:  nodeRepo,
+		eventRepo: eventRepo,
+		logger:    logger,
+	}
+}
+
+// CheckNodes classifies every active node by heartbeat age, records state
+// changes as events and returns them
+func (s *nodeHealthService) CheckNodes() ([]NodeTransition, error) {
+	nodes, err := s.nodeRepo.FindActiveNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var transitions []NodeTransition
+	reachable := 0
+	for _, node := range nodes {
+		state := s.classify(node.LastSeenAt, now)
+		if state == models.NodeHealthOnline || state == models.NodeHealthDegraded {
+			reachable++
+		}
+
+		previous := node.HealthState
+		if previous == "" {
+			previous = models.NodeHealthUnknown
+		}
+		if state == previous {
+			continue
+		}
+
+		if err := s.nodeRepo.UpdateHealthState(node.ID, state, now); err != nil {
+			s.logger.Error("Failed to update node health",
+				zap.Uint64("node_id", node.ID),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		event := &models.NodeEvent{
+			NodeID:     node.ID,
+			FromState:  previous,
+			ToState:    state,
+			LastSeenAt: node.LastSeenAt,
+		}
+		if err := s.eventRepo.Create(event); err != nil {
+			s.logger.Error("Failed to record node event",
+				zap.Uint64("node_id", node.ID),
+				zap.Error(err),
+			)
+		}
+
+		since := node.HealthChangedAt
+		node.HealthState = state
+		node.HealthChangedAt = &now
+		transitions = append(transitions, NodeTransition{Node: node, From: previous, To: state, Since: since})
+	}
+
+	metrics.ActiveNodes.Set(float64(reachable))
+	return transitions, nil
+}
+
+func (s *nodeHealthService) classify(lastSeenAt *time.Time, now time.Time) string {
+	if lastSeenAt == nil {
+		return models.NodeHealthUnknown
+	}
+
+	age := now.Sub(*lastSeenAt)
+	switch {
+	case age > s.cfg.GetOfflineAfter():
+		return models.NodeHealthOffline
+	case age > s.cfg.GetDegradedAfter():
+		return models.NodeHealthDegraded
+	default:
+		return models.NodeHealthOnline
+	}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
+)
+
+type NodeKeyService interface {
+	Authenticate(node *models.Node, key string) bool
+	RotateKey(node *models.Node, grace time.Duration) (string, error)
+	RevokePreviousKey(node *models.Node) error
+}
+
+type nodeKeyService struct {
+	cfg      *config.NodeConfig
+	nodeRepo repository.NodeRepository
+}
+
+func NewNodeKeyService(cfg *config.NodeConfig, nodeRepo repository.NodeRepository) NodeKeyService {
+	return &nodeKeyService{
+		cfg:      cfg,
+		nodeRepo: nodeRepo,
+	}
+}
+
+// Authenticate checks a key presented by a node. Nodes with their own key
+// accept only that key, or the previous one during a rotation grace period.
+// Nodes without a key fall back to the global server token unless that is
+// disabled.
+func (s *nodeKeyService) Authenticate(node *models.Node, key string) bool {
+	if key == "" {
+		return false
+	}
+
+	if node.APIKeyHash == nil {
+		return !s.cfg.DisableGlobalToken && s.cfg.ServerToken != "" &&
+			subtle.ConstantTimeCompare([]byte(key), []byte(s.cfg.ServerToken)) == 1
+	}
+
+	hash := hashNodeKey(key)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(*node.APIKeyHash)) == 1 {
+		return true
+	}
+
+	return node.PreviousAPIKeyHash != nil &&
+		node.PreviousAPIKeyExpiresAt != nil &&
+		time.Now().Before(*node.PreviousAPIKeyExpiresAt) &&
+		subtle.ConstantTimeCompare([]byte(hash), []byte(*node.PreviousAPIKeyHash)) == 1
+}
+
+// RotateKey issues a new key for the node and returns it in plain text. It
+// is not stored and cannot be shown again. With a positive grace period the
+// old key keeps working until it ends.
+func (s *nodeKeyService) RotateKey(node *models.Node, grace time.Duration) (string, error) {
+	keyBytes := make([]byte, 32)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(keyBytes)
+	hash := hashNodeKey(key)
+	now := time.Now()
+
+	node.PreviousAPIKeyHash = nil
+	node.PreviousAPIKeyExpiresAt = nil
+	if node.APIKeyHash != nil && grace > 0 {
+		expiresAt := now.Add(grace)
+		node.PreviousAPIKeyHash = node.APIKeyHash
+		node.PreviousAPIKeyExpiresAt = &expiresAt
+	}
+	node.APIKeyHash = &hash
+	node.APIKeyRotatedAt = &now
+
+	if err := s.nodeRepo.UpdateAPIKey(node); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// RevokePreviousKey ends a rotation grace period early
+func (s *nodeKeyService) RevokePreviousKey(node *models.Node) error {
+	node.PreviousAPIKeyHash = nil
+	node.PreviousAPIKeyExpiresAt = nil
+	return s.nodeRepo.UpdateAPIKey(node)
+}
+
+func hashNodeKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+)
+
+// Test node authentication across key rotation and the global token fallback
+func TestNodeKeyAuthenticate(t *testing.T) {
+	cfg := &config.NodeConfig{ServerToken: "global-token"}
+	svc := NewNodeKeyService(cfg, &mockNodeRepo{})
+
+	node := &models.Node{ID: 1}
+	if !svc.Authenticate(node, "global-token") {
+		t.Error("Node without a key should accept the global token")
+	}
+
+	cfg.DisableGlobalToken = true
+	if svc.Authenticate(node, "global-token") {
+		t.Error("Global token should be rejected when disabled")
+	}
+	cfg.DisableGlobalToken = false
+
+	oldKey, err := svc.RotateKey(node, 0)
+	if err != nil {
+		t.Fatalf("RotateKey() error = %v", err)
+	}
+	if svc.Authenticate(node, "global-token") {
+		t.Error("Node with a key should reject the global token")
+	}
+	if !svc.Authenticate(node, oldKey) {
+		t.Error("Node should accept its key")
+	}
+
+	newKey, err := svc.RotateKey(node, time.Hour)
+	if err != nil {
+		t.Fatalf("RotateKey() error = %v", err)
+	}
+	if !svc.Authenticate(node, newKey) || !svc.Authenticate(node, oldKey) {
+		t.Error("Both keys should work during the grace period")
+	}
+
+	expired := time.Now().Add(-time.Second)
+	node.PreviousAPIKeyExpiresAt = &expired
+	if svc.Authenticate(node, oldKey) {
+		t.Error("Old key should be rejected after the grace period")
+	}
+
+	if _, err := svc.RotateKey(node, 0); err != nil {
+		t.Fatalf("RotateKey() error = %v", err)
+	}
+	if svc.Authenticate(node, newKey) {
+		t.Error("Rotation without grace should invalidate the old key immediately")
+	}
+	if svc.Authenticate(node, "") {
+		t.Error("Empty key should be rejected")
+	}
+}
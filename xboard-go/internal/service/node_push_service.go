@@ -0,0 +1,121 @@
+package service
+
+import (
+	"sync"
+)
+
+// NodePushService notifies nodes holding a push stream open that their user
+// list or config changed. Notifications carry no data; streams fetch what
+// changed from the same services the REST routes use, so those stay the
+// source of truth.
+type NodePushService interface {
+	// Subscribe registers a stream of a node. The returned function must be
+	// called once the stream ends.
+	Subscribe(nodeID uint64) (*NodePushSubscription, func())
+	// UsersChanged notifies every stream, since a user change may concern
+	// any node
+	UsersChanged()
+	ConfigChanged(nodeID uint64)
+	// Disconnect ends the node's streams, e.g. after its key was revoked
+	Disconnect(nodeID uint64)
+	// Close ends all streams on shutdown
+	Close()
+}
+
+// NodePushSubscription delivers notifications to one stream. Notifications
+// of the same kind coalesce while the stream is busy, so a slow node never
+// blocks the notifier.
+type NodePushSubscription struct {
+	Users  <-chan struct{}
+	Config <-chan struct{}
+	// Done is closed when the stream must end
+	Done <-chan struct{}
+}
+
+type nodePushSubscriber struct {
+	nodeID uint64
+	users  chan struct{}
+	config chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (s *nodePushSubscriber) end() {
+	s.once.Do(func() { close(s.done) })
+}
+
+type nodePushService struct {
+	mu          sync.Mutex
+	subscribers map[*nodePushSubscriber]struct{}
+}
+
+func NewNodePushService() NodePushService {
+	return &nodePushService{
+		subscribers: make(map[*nodePushSubscriber]struct{}),
+	}
+}
+
+func (s *nodePushService) Subscribe(nodeID uint64) (*NodePushSubscription, func()) {
+	sub := &nodePushSubscriber{
+		nodeID: nodeID,
+		users:  make(chan struct{}, 1),
+		config: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	s.subscribers[sub] = struct{}{}
+	s.mu.Unlock()
+
+	unsubscribe := func() {
+		s.mu.Lock()
+		delete(s.subscribers, sub)
+		s.mu.Unlock()
+		sub.end()
+	}
+	return &NodePushSubscription{Users: sub.users, Config: sub.config, Done: sub.done}, unsubscribe
+}
+
+func (s *nodePushService) UsersChanged() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sub := range s.subscribers {
+		notifyPending(sub.users)
+	}
+}
+
+func (s *nodePushService) ConfigChanged(nodeID uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sub := range s.subscribers {
+		if sub.nodeID == nodeID {
+			notifyPending(sub.config)
+		}
+	}
+}
+
+func (s *nodePushService) Disconnect(nodeID uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sub := range s.subscribers {
+		if sub.nodeID == nodeID {
+			sub.end()
+		}
+	}
+}
+
+func (s *nodePushService) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sub := range s.subscribers {
+		sub.end()
+	}
+}
+
+// notifyPending signals ch unless a signal is already pending
+func notifyPending(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,56 @@
+package service
+
+import "testing"
+
+// Test that notifications coalesce per stream and reach only the right nodes
+func TestNodePushService(t *testing.T) {
+	push := NewNodePushService()
+	first, unsubscribeFirst := push.Subscribe(1)
+	second, unsubscribeSecond := push.Subscribe(2)
+	defer unsubscribeSecond()
+
+	push.UsersChanged()
+	push.UsersChanged()
+	push.ConfigChanged(1)
+
+	for name, sub := range map[string]*NodePushSubscription{"node 1": first, "node 2": second} {
+		select {
+		case <-sub.Users:
+		default:
+			t.Errorf("%s got no user notification", name)
+		}
+		select {
+		case <-sub.Users:
+			t.Errorf("%s got user notifications that should have coalesced", name)
+		default:
+		}
+	}
+
+	select {
+	case <-second.Config:
+		t.Error("Config change of node 1 reached node 2")
+	default:
+	}
+	select {
+	case <-first.Config:
+	default:
+		t.Error("Node 1 got no config notification")
+	}
+
+	push.Disconnect(1)
+	select {
+	case <-first.Done:
+	default:
+		t.Error("Disconnect did not end the stream of node 1")
+	}
+	select {
+	case <-second.Done:
+		t.Error("Disconnect of node 1 ended the stream of node 2")
+	default:
+	}
+
+	// Ending an already disconnected stream is safe
+	unsubscribeFirst()
+	push.Close()
+	<-second.Done
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/metrics"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+type NodeStatusService interface {
+	Record(status models.NodeStatus)
+	Latest(nodeID uint64) (*models.NodeStatus, bool)
+	History(nodeID uint64, since time.Time) []models.NodeStatus
+	Load() error
+	Snapshot() error
+}
+
+type nodeStatusService struct {
+	cfg        *config.NodeConfig
+	statusRepo repository.NodeStatusRepository
+	logger     *zap.Logger
+
+	mu      sync.RWMutex
+	history map[uint64]*statusRing
+	// pending holds the latest report per node since the last snapshot
+	pending map[uint64]models.NodeStatus
+}
+
+func NewNodeStatusService(
+	cfg *config.NodeConfig,
+	statusRepo repository.NodeStatusRepository,
+	logger *zap.Logger,
+) NodeStatusService {
+	return &nodeStatusService{
+		cfg:        cfg,
+		statusRepo: statusRepo,
+		logger:     logger,
+		history:    make(map[uint64]*statusRing),
+		pending:    make(map[uint64]models.NodeStatus),
+	}
+}
+
+func (s *nodeStatusService) Record(status models.NodeStatus) {
+	s.mu.Lock()
+	s.push(status)
+	s.pending[status.NodeID] = status
+	s.mu.Unlock()
+
+	nodeID := strconv.FormatUint(status.NodeID, 10)
+	metrics.NodeCPUPercent.WithLabelValues(nodeID).Set(status.CPU)
+	metrics.NodeResourceBytes.WithLabelValues(nodeID, "mem", "total").Set(float64(status.MemTotal))
+	metrics.NodeResourceBytes.WithLabelValues(nodeID, "mem", "used").Set(float64(status.MemUsed))
+	metrics.NodeResourceBytes.WithLabelValues(nodeID, "swap", "total").Set(float64(status.SwapTotal))
+	metrics.NodeResourceBytes.WithLabelValues(nodeID, "swap", "used").Set(float64(status.SwapUsed))
+	metrics.NodeResourceBytes.WithLabelValues(nodeID, "disk", "total").Set(float64(status.DiskTotal))
+	metrics.NodeResourceBytes.WithLabelValues(nodeID, "disk", "used").Set(float64(status.DiskUsed))
+}
+
+// push appends to the node's history. Callers must hold the lock.
+func (s *nodeStatusService) push(status models.NodeStatus) {
+	ring, ok := s.history[status.NodeID]
+	if !ok {
+		ring = newStatusRing(s.cfg.GetStatusHistorySize())
+		s.history[status.NodeID] = ring
+	}
+	ring.push(status)
+}
+
+func (s *nodeStatusService) Latest(nodeID uint64) (*models.NodeStatus, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ring, ok := s.history[nodeID]
+	if !ok {
+		return nil, false
+	}
+	return ring.latest()
+}
+
+// History returns the node's buffered reports since the given time, oldest
+// first
+func (s *nodeStatusService) History(nodeID uint64, since time.Time) []models.NodeStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ring, ok := s.history[nodeID]
+	if !ok {
+		return []models.NodeStatus{}
+	}
+
+	statuses := []models.NodeStatus{}
+	for _, status := range ring.items() {
+		if !status.ReportedAt.Before(since) {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+// Load warms the in-memory history from snapshots after a restart.
+// Snapshots are taken once a minute, so the buffer covers that many minutes.
+func (s *nodeStatusService) Load() error {
+	window := time.Duration(s.cfg.GetStatusHistorySize()) * time.Minute
+	statuses, err := s.statusRepo.ListSince(time.Now().Add(-window))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, status := range statuses {
+		s.push(status)
+	}
+
+	s.logger.Info("Loaded node status history", zap.Int("snapshots", len(statuses)))
+	return nil
+}
+
+// Snapshot writes the latest report of every node that reported since the
+// previous snapshot, and drops snapshots past the retention period
+func (s *nodeStatusService) Snapshot() error {
+	s.mu.Lock()
+	batch := make([]models.NodeStatus, 0, len(s.pending))
+	for _, status := range s.pending {
+		status.ID = 0
+		batch = append(batch, status)
+	}
+	s.pending = make(map[uint64]models.NodeStatus)
+	s.mu.Unlock()
+
+	if err := s.statusRepo.CreateBatch(batch); err != nil {
+		// Put the reports back unless the node has sent a newer one
+		s.mu.Lock()
+		for _, status := range batch {
+			if _, ok := s.pending[status.NodeID]; !ok {
+				s.pending[status.NodeID] = status
+			}
+		}
+		s.mu.Unlock()
+		return err
+	}
+
+	deleted, err := s.statusRepo.DeleteBefore(time.Now().Add(-s.cfg.GetStatusRetention()))
+	if err != nil {
+		return err
+	}
+
+	s.logger.Debug("Snapshotted node status",
+		zap.Int("nodes", len(batch)),
+		zap.Int64("pruned", deleted),
+	)
+	return nil
+}
+
+// statusRing is a fixed-size buffer of a node's most recent reports
+type statusRing struct {
+	buf  []models.NodeStatus
+	next int
+	full bool
+}
+
+func newStatusRing(size int) *statusRing {
+	return &statusRing{buf: make([]models.NodeStatus, size)}
+}
+
+func (r *statusRing) push(status models.NodeStatus) {
+	r.buf[r.next] = status
+	r.next = (r.next + 1) % len(r.buf)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *statusRing) latest() (*models.NodeStatus, bool) {
+	if !r.full && r.next == 0 {
+		return nil, false
+	}
+	status := r.buf[(r.next-1+len(r.buf))%len(r.buf)]
+	return &status, true
+}
+
+// items returns the buffered reports, oldest first
+func (r *statusRing) items() []models.NodeStatus {
+	if !r.full {
+		return append([]models.NodeStatus(nil), r.buf[:r.next]...)
+	}
+	items := make([]models.NodeStatus, 0, len(r.buf))
+	items = append(items, r.buf[r.next:]...)
+	return append(items, r.buf[:r.next]...)
+}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// maxUserListDelta bounds the changes and users a delta is computed from;
+// beyond it the full list is cheaper
+const maxUserListDelta = 10000
+
+// NodeUserService builds the list of users a node serves. Events that may
+// change node user lists are recorded in a change log whose latest ID is the
+// list version, so nodes can fetch only what changed since the version they
+// hold.
+type NodeUserService interface {
+	// ListUsers returns the node's users and the version they reflect
+	ListUsers(nodeID uint64) ([]models.NodeUserDTO, uint64, error)
+	// Delta returns what changed on the node's list since a version, or the
+	// full list if the changes are no longer known
+	Delta(nodeID, since uint64) (*NodeUserDelta, error)
+
+	// RecordExpiries records a change for users whose traffic pack or
+	// subscription expired since the last call. Those lose access with the
+	// clock rather than an event, so without it nodes on deltas would keep
+	// serving them.
+	RecordExpiries() error
+
+	UsersChanged(reason string, userIDs ...uint64)
+	PlanChanged(reason string, planID uint64)
+	NodeChanged(reason string, nodeID uint64)
+	AllChanged(reason string)
+
+	CleanupChanges() (int64, error)
+}
+
+// NodeUserDelta is a node's user list relative to a version it already has.
+// With Full set, Users replaces the node's list; otherwise Added holds users
+// that are new or changed and Removed the IDs of users to drop.
+type NodeUserDelta struct {
+	Version uint64
+	Full    bool
+	Users   []models.NodeUserDTO
+	Added   []models.NodeUserDTO
+	Removed []uint64
+}
+
+type nodeUserService struct {
+	cfg        *config.NodeConfig
+	userRepo   repository.UserRepository
+	changeRepo repository.UserListChangeRepository
+	packRepo   repository.TrafficPackRepository
+	subRepo    repository.SubscriptionRepository
+	push       NodePushService
+	logger     *zap.Logger
+
+	expiryMu sync.Mutex
+	// expiriesUntil is the time expiries were last recorded up to
+	expiriesUntil time.Time
+}
+
+func NewNodeUserService(
+	cfg *config.NodeConfig,
+	userRepo repository.UserRepository,
+	changeRepo repository.UserListChangeRepository,
+	packRepo repository.TrafficPackRepository,
+	subRepo repository.SubscriptionRepository,
+	push NodePushService,
+	logger *zap.Logger,
+) NodeUserService {
+	return &nodeUserService{
+		cfg:        cfg,
+		userRepo:   userRepo,
+		changeRepo: changeRepo,
+		packRepo:   packRepo,
+		subRepo:    subRepo,
+		push:       push,
+		logger:     logger,
+	}
+}
+
+// ListUsers returns the node's users, ordered by ID so the list and its ETag
+// are stable between polls. The version is read first, so a change racing
+// with the query is delivered again rather than lost.
+func (s *nodeUserService) ListUsers(nodeID uint64) ([]models.NodeUserDTO, uint64, error) {
+	_, version, err := s.changeRepo.Bounds()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	users, err := s.userRepo.FindNodeUsers(nodeID, time.Now(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, version, nil
+}
+
+func (s *nodeUserService) Delta(nodeID, since uint64) (*NodeUserDelta, error) {
+	oldest, latest, err := s.changeRepo.Bounds()
+	if err != nil {
+		return nil, err
+	}
+
+	if since == latest && since != 0 {
+		return &NodeUserDelta{Version: latest, Added: []models.NodeUserDTO{}, Removed: []uint64{}}, nil
+	}
+	// Version 0, a version from the future or one whose changes were pruned
+	if since == 0 || since > latest || since+1 < oldest {
+		return s.full(nodeID, latest)
+	}
+
+	changes, err := s.changeRepo.Between(since, latest, maxUserListDelta+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) > maxUserListDelta {
+		return s.full(nodeID, latest)
+	}
+
+	affected := make(map[uint64]bool)
+	var planIDs []uint64
+	for _, change := range changes {
+		switch {
+		case change.UserID != nil:
+			affected[*change.UserID] = true
+		case change.PlanID != nil:
+			planIDs = append(planIDs, *change.PlanID)
+		case change.NodeID != nil:
+			if *change.NodeID == nodeID {
+				return s.full(nodeID, latest)
+			}
+		default:
+			return s.full(nodeID, latest)
+		}
+	}
+
+	if len(planIDs) > 0 {
+		userIDs, err := s.userRepo.FindIDsByPlans(planIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range userIDs {
+			affected[id] = true
+		}
+	}
+	if len(affected) > maxUserListDelta {
+		return s.full(nodeID, latest)
+	}
+
+	userIDs := make([]uint64, 0, len(affected))
+	for id := range affected {
+		userIDs = append(userIDs, id)
+	}
+	sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+
+	present, err := s.userRepo.FindNodeUsers(nodeID, time.Now(), userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	delta := &NodeUserDelta{Version: latest, Added: present, Removed: []uint64{}}
+	for _, user := range present {
+		delete(affected, user.ID)
+	}
+	for _, id := range userIDs {
+		if affected[id] {
+			delta.Removed = append(delta.Removed, id)
+		}
+	}
+	return delta, nil
+}
+
+func (s *nodeUserService) full(nodeID, version uint64) (*NodeUserDelta, error) {
+	users, err := s.userRepo.FindNodeUsers(nodeID, time.Now(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &NodeUserDelta{Version: version, Full: true, Users: users}, nil
+}
+
+// RecordExpiries scans from where the last call stopped. The first call
+// after a start goes back as far as changes are kept, since nodes holding an
+// older version get the full list anyway; recording an expiry twice only
+// costs a change.
+func (s *nodeUserService) RecordExpiries() error {
+	s.expiryMu.Lock()
+	defer s.expiryMu.Unlock()
+
+	now := time.Now()
+	after := s.expiriesUntil
+	if after.IsZero() {
+		after = now.Add(-s.cfg.GetUserChangeRetention())
+	}
+
+	packUsers, err := s.packRepo.FindUsersExpiring(after, now)
+	if err != nil {
+		return err
+	}
+	subUsers, err := s.subRepo.FindUsersExpiring(after, now)
+	if err != nil {
+		return err
+	}
+
+	s.UsersChanged("pack_expired", packUsers...)
+	s.UsersChanged("subscription_lapsed", subUsers...)
+	s.expiriesUntil = now
+	return nil
+}
+
+func (s *nodeUserService) UsersChanged(reason string, userIDs ...uint64) {
+	changes := make([]models.UserListChange, 0, len(userIDs))
+	for _, id := range userIDs {
+		userID := id
+		changes = append(changes, models.UserListChange{UserID: &userID, Reason: reason})
+	}
+	s.record(changes)
+}
+
+func (s *nodeUserService) PlanChanged(reason string, planID uint64) {
+	s.record([]models.UserListChange{{PlanID: &planID, Reason: reason}})
+}
+
+func (s *nodeUserService) NodeChanged(reason string, nodeID uint64) {
+	s.record([]models.UserListChange{{NodeID: &nodeID, Reason: reason}})
+}
+
+func (s *nodeUserService) AllChanged(reason string) {
+	s.record([]models.UserListChange{{Reason: reason}})
+}
+
+// record logs changes and notifies nodes holding a push stream open. A
+// change that fails to record is only seen by nodes on their next full list,
+// so it is logged rather than failing the caller.
+func (s *nodeUserService) record(changes []models.UserListChange) {
+	if len(changes) == 0 {
+		return
+	}
+	if err := s.changeRepo.Create(changes); err != nil {
+		s.logger.Error("Failed to record user list change",
+			zap.String("reason", changes[0].Reason),
+			zap.Error(err),
+		)
+		return
+	}
+	s.push.UsersChanged()
+}
+
+// CleanupChanges removes changes past the retention period. Nodes holding an
+// older version get the full list.
+func (s *nodeUserService) CleanupChanges() (int64, error) {
+	return s.changeRepo.DeleteBefore(time.Now().Add(-s.cfg.GetUserChangeRetention()))
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeNodeUserRepo returns a fixed node user list, standing in for the
+// joined query
+type fakeNodeUserRepo struct {
+	mockUserRepo
+	users []models.NodeUserDTO
+	// plans maps user IDs to their plan
+	plans map[uint64]uint64
+}
+
+func (m *fakeNodeUserRepo) FindNodeUsers(nodeID uint64, at time.Time, userIDs []uint64) ([]models.NodeUserDTO, error) {
+	if userIDs == nil {
+		return m.users, nil
+	}
+	wanted := make(map[uint64]bool)
+	for _, id := range userIDs {
+		wanted[id] = true
+	}
+	users := []models.NodeUserDTO{}
+	for _, user := range m.users {
+		if wanted[user.ID] {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (m *fakeNodeUserRepo) FindIDsByPlans(planIDs []uint64) ([]uint64, error) {
+	var ids []uint64
+	for userID, planID := range m.plans {
+		for _, id := range planIDs {
+			if planID == id {
+				ids = append(ids, userID)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// Test that nodes get only the users that changed since their version, and
+// the full list when the version is unknown or its changes were pruned
+func TestNodeUserDelta(t *testing.T) {
+	repo := &fakeNodeUserRepo{
+		users: []models.NodeUserDTO{{ID: 1}, {ID: 2}, {ID: 3}},
+		plans: map[uint64]uint64{1: 10, 2: 10, 3: 20},
+	}
+	changes := &mockChangeRepo{}
+	svc := NewNodeUserService(&config.NodeConfig{UserChangeRetentionHours: 1}, repo, changes, &mockPackRepo{}, &mockSubscriptionRepo{}, NewNodePushService(), zap.NewNop())
+
+	delta, err := svc.Delta(1, 0)
+	if err != nil {
+		t.Fatalf("Delta() error = %v", err)
+	}
+	if !delta.Full || len(delta.Users) != 3 {
+		t.Fatalf("Delta from version 0 = %+v, want the full list", delta)
+	}
+
+	svc.UsersChanged("user_created", 1)
+	_, version, _ := svc.ListUsers(1)
+
+	// User 3 is banned and user 4 deleted
+	repo.users = repo.users[:2]
+	svc.UsersChanged("user_updated", 3)
+	svc.UsersChanged("user_deleted", 4)
+	svc.PlanChanged("plan_updated", 10)
+
+	delta, err = svc.Delta(1, version)
+	if err != nil {
+		t.Fatalf("Delta() error = %v", err)
+	}
+	if delta.Full || delta.Version != version+3 {
+		t.Fatalf("Delta = %+v, want a delta to version %d", delta, version+3)
+	}
+	if len(delta.Added) != 2 || delta.Added[0].ID != 1 || delta.Added[1].ID != 2 {
+		t.Errorf("Added = %+v, want users 1 and 2", delta.Added)
+	}
+	if len(delta.Removed) != 2 || delta.Removed[0] != 3 || delta.Removed[1] != 4 {
+		t.Errorf("Removed = %v, want [3 4]", delta.Removed)
+	}
+
+	// Up to date
+	delta, _ = svc.Delta(1, delta.Version)
+	if delta.Full || len(delta.Added) != 0 || len(delta.Removed) != 0 {
+		t.Errorf("Delta at the latest version = %+v, want no changes", delta)
+	}
+
+	// Changes to another node do not concern this one, its own do
+	svc.NodeChanged("node_updated", 2)
+	if delta, _ = svc.Delta(1, version+3); delta.Full {
+		t.Error("Change to node 2 returned the full list for node 1")
+	}
+	svc.NodeChanged("node_updated", 1)
+	if delta, _ = svc.Delta(1, version+3); !delta.Full {
+		t.Error("Change to node 1 should return its full list")
+	}
+
+	// Pruned changes fall back to the full list
+	for i := range changes.changes {
+		changes.changes[i].CreatedAt = time.Now().Add(-2 * time.Hour)
+	}
+	if _, err := svc.CleanupChanges(); err != nil {
+		t.Fatalf("CleanupChanges() error = %v", err)
+	}
+	delta, _ = svc.Delta(1, version)
+	if !delta.Full || delta.Version != version+5 {
+		t.Errorf("Delta from a pruned version = %+v, want the full list at version %d", delta, version+5)
+	}
+}
+
+// Test that a pack expiring between two versions removes its user from the
+// next delta
+func TestNodeUserDeltaPackExpiry(t *testing.T) {
+	repo := &fakeNodeUserRepo{
+		users: []models.NodeUserDTO{{ID: 1}, {ID: 2}},
+	}
+	packs := &mockPackRepo{}
+	svc := NewNodeUserService(&config.NodeConfig{}, repo, &mockChangeRepo{}, packs, &mockSubscriptionRepo{}, NewNodePushService(), zap.NewNop())
+
+	svc.UsersChanged("user_created", 1, 2)
+	if err := svc.RecordExpiries(); err != nil {
+		t.Fatalf("RecordExpiries() error = %v", err)
+	}
+	_, version, _ := svc.ListUsers(1)
+
+	// User 2 is over quota and only served while their pack lasts
+	expiresAt := time.Now()
+	packs.Create(&models.TrafficPack{UserID: 2, Bytes: 1 << 30, ExpiresAt: &expiresAt})
+	time.Sleep(time.Millisecond)
+	repo.users = repo.users[:1]
+
+	if err := svc.RecordExpiries(); err != nil {
+		t.Fatalf("RecordExpiries() error = %v", err)
+	}
+	delta, err := svc.Delta(1, version)
+	if err != nil {
+		t.Fatalf("Delta() error = %v", err)
+	}
+	if delta.Full || delta.Version != version+1 {
+		t.Fatalf("Delta = %+v, want a delta to version %d", delta, version+1)
+	}
+	if len(delta.Removed) != 1 || delta.Removed[0] != 2 {
+		t.Errorf("Removed = %v, want [2]", delta.Removed)
+	}
+
+	// The expiry is recorded once
+	if err := svc.RecordExpiries(); err != nil {
+		t.Fatalf("RecordExpiries() error = %v", err)
+	}
+	if _, latest, _ := svc.ListUsers(1); latest != version+1 {
+		t.Errorf("Version = %d after another scan, want %d", latest, version+1)
+	}
+}
+
+// Benchmark building and encoding the user list of a node serving 100k
+// users. Filtering happens in the database, so this covers what is left for
+// the panel on every poll.
+func BenchmarkNodeUserList(b *testing.B) {
+	users := make([]models.NodeUserDTO, 100000)
+	for i := range users {
+		users[i] = models.NodeUserDTO{
+			ID:          uint64(i + 1),
+			UUID:        fmt.Sprintf("00000000-0000-4000-8000-%012d", i+1),
+			SpeedLimit:  100,
+			DeviceLimit: 3,
+		}
+	}
+	svc := NewNodeUserService(&config.NodeConfig{}, &fakeNodeUserRepo{users: users}, &mockChangeRepo{}, &mockPackRepo{}, &mockSubscriptionRepo{}, NewNodePushService(), zap.NewNop())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		list, _, err := svc.ListUsers(1)
+		if err != nil {
+			b.Fatalf("ListUsers() error = %v", err)
+		}
+		if _, err := json.Marshal(map[string]interface{}{"users": list}); err != nil {
+			b.Fatalf("Marshal() error = %v", err)
+		}
+	}
+}
+
+// BenchmarkNodeUserListMySQL measures the joined node user query against a
+// real MySQL seeded with 100k users. It runs only when
+// XBOARD_BENCH_MYSQL_DSN points at a migrated database it may write to, e.g.
+//
+//	XBOARD_BENCH_MYSQL_DSN='root@tcp(127.0.0.1:3306)/xboard_bench?parseTime=true' \
+//	    go test -run '^$' -bench NodeUserListMySQL ./internal/service
+func BenchmarkNodeUserListMySQL(b *testing.B) {
+	dsn := os.Getenv("XBOARD_BENCH_MYSQL_DSN")
+	if dsn == "" {
+		b.Skip("XBOARD_BENCH_MYSQL_DSN is not set")
+	}
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		b.Fatalf("Failed to connect: %v", err)
+	}
+
+	const userCount = 100000
+	nodeID := seedNodeUsers(b, db, userCount)
+
+	svc := NewNodeUserService(&config.NodeConfig{}, repository.NewUserRepository(db),
+		repository.NewUserListChangeRepository(db), repository.NewTrafficPackRepository(db),
+		repository.NewSubscriptionRepository(db), nil, zap.NewNop())
+
+	users, _, err := svc.ListUsers(nodeID)
+	if err != nil {
+		b.Fatalf("ListUsers() error = %v", err)
+	}
+	// Every tenth user is over quota and drops off the list
+	if want := userCount - userCount/10; len(users) != want {
+		b.Fatalf("Got %d users, want %d", len(users), want)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := svc.ListUsers(nodeID); err != nil {
+			b.Fatalf("ListUsers() error = %v", err)
+		}
+	}
+}
+
+// seedNodeUsers creates a node and a plan sharing a label, and n users on
+// the plan with a UUID and a current period, every tenth over quota. The
+// rows are removed when the benchmark ends.
+func seedNodeUsers(b *testing.B, db *gorm.DB, n int) uint64 {
+	b.Helper()
+	run := time.Now().UnixNano()
+	const batchSize = 1000
+
+	label := &models.Label{Name: fmt.Sprintf("bench-%d", run)}
+	plan := &models.Plan{Name: fmt.Sprintf("bench-%d", run), QuotaBytes: 1 << 30, ResetPeriod: "monthly"}
+	node := &models.Node{Name: fmt.Sprintf("bench-%d", run), NodeType: "vmess", Host: "127.0.0.1", Port: 443, ProtocolConfig: "{}"}
+	for _, row := range []interface{}{label, plan, node} {
+		if err := db.Create(row).Error; err != nil {
+			b.Fatalf("Failed to seed: %v", err)
+		}
+	}
+	if err := db.Create(&models.PlanLabel{PlanID: plan.ID, LabelID: label.ID}).Error; err != nil {
+		b.Fatalf("Failed to seed: %v", err)
+	}
+	if err := db.Create(&models.NodeLabel{NodeID: node.ID, LabelID: label.ID}).Error; err != nil {
+		b.Fatalf("Failed to seed: %v", err)
+	}
+
+	b.Cleanup(func() {
+		users := db.Model(&models.User{}).Select("id").Where("plan_id = ?", plan.ID)
+		db.Where("user_id IN (?)", users).Delete(&models.UsagePeriod{})
+		db.Where("user_id IN (?)", users).Delete(&models.UserUUID{})
+		db.Where("plan_id = ?", plan.ID).Delete(&models.User{})
+		db.Where("plan_id = ?", plan.ID).Delete(&models.PlanLabel{})
+		db.Where("node_id = ?", node.ID).Delete(&models.NodeLabel{})
+		db.Delete(node)
+		db.Delete(plan)
+		db.Delete(label)
+	})
+
+	now := time.Now()
+	for start := 0; start < n; start += batchSize {
+		end := start + batchSize
+		if end > n {
+			end = n
+		}
+
+		users := make([]models.User, 0, end-start)
+		for i := start; i < end; i++ {
+			users = append(users, models.User{
+				Email:        fmt.Sprintf("bench-%d-%d@example.com", run, i),
+				PasswordHash: "-",
+				Role:         "user",
+				PlanID:       &plan.ID,
+			})
+		}
+		if err := db.Create(&users).Error; err != nil {
+			b.Fatalf("Failed to seed users: %v", err)
+		}
+
+		uuids := make([]models.UserUUID, 0, len(users))
+		periods := make([]models.UsagePeriod, 0, len(users))
+		for i, user := range users {
+			uuids = append(uuids, models.UserUUID{UserID: user.ID, UUID: uuid.NewString()})
+			used := uint64(0)
+			if (start+i)%10 == 0 {
+				used = plan.QuotaBytes
+			}
+			periods = append(periods, models.UsagePeriod{
+				UserID:            user.ID,
+				PlanID:            plan.ID,
+				PeriodStart:       now.AddDate(0, 0, -1),
+				PeriodEnd:         now.AddDate(0, 1, 0),
+				BillableBytesDown: used,
+				IsCurrent:         true,
+			})
+		}
+		if err := db.Create(&uuids).Error; err != nil {
+			b.Fatalf("Failed to seed UUIDs: %v", err)
+		}
+		if err := db.Create(&periods).Error; err != nil {
+			b.Fatalf("Failed to seed periods: %v", err)
+		}
+	}
+	return node.ID
+}
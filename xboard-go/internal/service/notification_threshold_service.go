@@ -0,0 +1,131 @@
+package service
+
+import (
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
+)
+
+// NotificationThresholdService finds the usage thresholds users should be
+// notified about. Each threshold fires once per usage period.
+type NotificationThresholdService interface {
+	// Due returns, per user, the enabled thresholds their current usage has
+	// crossed and that have not fired yet in the current period
+	Due() ([]ThresholdNotification, error)
+	// MarkTriggered records that the notification was sent, so its
+	// thresholds stay quiet until the next period
+	MarkTriggered(notification *ThresholdNotification) error
+}
+
+// ThresholdNotification is a usage notification owed to a user
+type ThresholdNotification struct {
+	User   models.User
+	Period *models.UsagePeriod
+	// Quota is the traffic available in the period: the plan quota plus
+	// traffic packs, as the user's usage endpoint reports it
+	Quota       uint64
+	PercentUsed float64
+	Thresholds  []models.TelegramThreshold
+}
+
+type notificationThresholdService struct {
+	thresholdRepo repository.TelegramThresholdRepository
+	userRepo      repository.UserRepository
+	usageRepo     repository.UsageRepository
+	packRepo      repository.TrafficPackRepository
+}
+
+func NewNotificationThresholdService(
+	thresholdRepo repository.TelegramThresholdRepository,
+	userRepo repository.UserRepository,
+	usageRepo repository.UsageRepository,
+	packRepo repository.TrafficPackRepository,
+) NotificationThresholdService {
+	return &notificationThresholdService{
+		thresholdRepo: thresholdRepo,
+		userRepo:      userRepo,
+		usageRepo:     usageRepo,
+		packRepo:      packRepo,
+	}
+}
+
+func (s *notificationThresholdService) Due() ([]ThresholdNotification, error) {
+	thresholds, err := s.thresholdRepo.FindEnabledForLinkedUsers()
+	if err != nil {
+		return nil, err
+	}
+	if len(thresholds) == 0 {
+		return nil, nil
+	}
+
+	byUser := make(map[uint64][]models.TelegramThreshold)
+	var userIDs []uint64
+	for _, threshold := range thresholds {
+		if _, ok := byUser[threshold.UserID]; !ok {
+			userIDs = append(userIDs, threshold.UserID)
+		}
+		byUser[threshold.UserID] = append(byUser[threshold.UserID], threshold)
+	}
+
+	users, err := s.userRepo.FindByIDs(userIDs)
+	if err != nil {
+		return nil, err
+	}
+	periods, err := s.usageRepo.GetCurrentPeriods(userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var notifications []ThresholdNotification
+	for _, user := range users {
+		period, ok := periods[user.ID]
+		if !ok || user.Plan == nil || user.TelegramChatID == nil {
+			continue
+		}
+		quota := period.EffectiveQuota(user.Plan)
+		if quota == 0 {
+			continue
+		}
+		used := period.BillableBytesUp + period.BillableBytesDown
+
+		// Usage past the plan quota was drawn from packs, so what is
+		// available is what was used plus the quota and packs left
+		remaining, err := s.packRepo.GetRemaining(user.ID, now)
+		if err != nil {
+			return nil, err
+		}
+		if used < quota {
+			remaining += quota - used
+		}
+		quota = used + remaining
+
+		var crossed []models.TelegramThreshold
+		for _, threshold := range byUser[user.ID] {
+			if threshold.Crossed(used, quota) && !threshold.TriggeredSince(period.PeriodStart) {
+				crossed = append(crossed, threshold)
+			}
+		}
+		if len(crossed) == 0 {
+			continue
+		}
+
+		notifications = append(notifications, ThresholdNotification{
+			User:        user,
+			Period:      period,
+			Quota:       quota,
+			PercentUsed: float64(used) / float64(quota) * 100,
+			Thresholds:  crossed,
+		})
+	}
+	return notifications, nil
+}
+
+func (s *notificationThresholdService) MarkTriggered(notification *ThresholdNotification) error {
+	ids := make([]uint64, 0, len(notification.Thresholds))
+	for _, threshold := range notification.Thresholds {
+		ids = append(ids, threshold.ID)
+	}
+	return s.thresholdRepo.MarkTriggered(ids, time.Now())
+}
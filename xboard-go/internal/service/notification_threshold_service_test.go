@@ -0,0 +1,201 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// mockThresholdRepo keeps thresholds in memory
+type mockThresholdRepo struct {
+	repository.TelegramThresholdRepository
+	thresholds []models.TelegramThreshold
+}
+
+func (m *mockThresholdRepo) FindEnabledForLinkedUsers() ([]models.TelegramThreshold, error) {
+	var enabled []models.TelegramThreshold
+	for _, threshold := range m.thresholds {
+		if threshold.Enabled {
+			enabled = append(enabled, threshold)
+		}
+	}
+	return enabled, nil
+}
+
+func (m *mockThresholdRepo) MarkTriggered(ids []uint64, at time.Time) error {
+	for _, id := range ids {
+		for i := range m.thresholds {
+			if m.thresholds[i].ID == id {
+				triggeredAt := at
+				m.thresholds[i].LastTriggeredAt = &triggeredAt
+			}
+		}
+	}
+	return nil
+}
+
+// Test that crossed thresholds fire once per usage period
+func TestNotificationThresholdsFireOncePerPeriod(t *testing.T) {
+	const gib = uint64(1 << 30)
+	chatID := int64(42)
+	periodStart := time.Now().Add(-24 * time.Hour)
+
+	userRepo := &mockUserRepo{users: map[uint64]*models.User{
+		1: {ID: 1, TelegramChatID: &chatID, Plan: &models.Plan{QuotaBytes: 100 * gib}},
+	}}
+	usageRepo := &mockUsageRepo{periods: []models.UsagePeriod{
+		{ID: 1, UserID: 1, PeriodStart: periodStart, BillableBytesDown: 85 * gib, IsCurrent: true},
+	}}
+	thresholdRepo := &mockThresholdRepo{thresholds: []models.TelegramThreshold{
+		{ID: 1, UserID: 1, ThresholdType: models.ThresholdTypePercent, ThresholdValue: 50, Enabled: true},
+		{ID: 2, UserID: 1, ThresholdType: models.ThresholdTypePercent, ThresholdValue: 95, Enabled: true},
+		{ID: 3, UserID: 1, ThresholdType: models.ThresholdTypeBytesRemaining, ThresholdValue: float64(20 * gib), Enabled: true},
+		{ID: 4, UserID: 1, ThresholdType: models.ThresholdTypePercent, ThresholdValue: 80, Enabled: false},
+	}}
+	svc := NewNotificationThresholdService(thresholdRepo, userRepo, usageRepo, &mockPackRepo{})
+
+	due, err := svc.Due()
+	if err != nil {
+		t.Fatalf("Due() error = %v", err)
+	}
+	if len(due) != 1 {
+		t.Fatalf("Got %d notifications, want 1", len(due))
+	}
+	if got := len(due[0].Thresholds); got != 2 {
+		t.Fatalf("Got %d crossed thresholds, want 2 (50%% and 20 GiB remaining)", got)
+	}
+	if due[0].PercentUsed != 85 {
+		t.Errorf("PercentUsed = %v, want 85", due[0].PercentUsed)
+	}
+
+	if err := svc.MarkTriggered(&due[0]); err != nil {
+		t.Fatalf("MarkTriggered() error = %v", err)
+	}
+	due, err = svc.Due()
+	if err != nil {
+		t.Fatalf("Due() error = %v", err)
+	}
+	if len(due) != 0 {
+		t.Fatalf("Got %d notifications after triggering, want 0", len(due))
+	}
+
+	// A new period lets them fire again
+	usageRepo.periods[0].PeriodStart = time.Now().Add(time.Second)
+	due, err = svc.Due()
+	if err != nil {
+		t.Fatalf("Due() error = %v", err)
+	}
+	if len(due) != 1 || len(due[0].Thresholds) != 2 {
+		t.Errorf("Got %+v in a new period, want both thresholds again", due)
+	}
+}
+
+// Test that thresholds which fired in a period fire again once an admin
+// restarts the period
+func TestNotificationThresholdsFireAfterPeriodRestart(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	const gib = uint64(1 << 30)
+	chatID := int64(42)
+	planID := uint64(1)
+
+	userRepo := &mockUserRepo{users: map[uint64]*models.User{
+		1: {ID: 1, PlanID: &planID, TelegramChatID: &chatID, Plan: &models.Plan{ID: planID, QuotaBytes: 100 * gib}},
+	}}
+	usageRepo := &mockUsageRepo{}
+	accounting := &accountingService{
+		userRepo:  userRepo,
+		planRepo:  &mockPlanRepo{},
+		usageRepo: usageRepo,
+		logger:    logger,
+		nodeUsers: newMockNodeUsers(),
+	}
+
+	start, end := accounting.calculatePeriodBounds(time.Now(), "monthly", nil)
+	usageRepo.CreatePeriod(&models.UsagePeriod{
+		UserID:            1,
+		PlanID:            planID,
+		PeriodStart:       start,
+		PeriodEnd:         end,
+		BillableBytesDown: 85 * gib,
+		IsCurrent:         true,
+	})
+	thresholdRepo := &mockThresholdRepo{thresholds: []models.TelegramThreshold{
+		{ID: 1, UserID: 1, ThresholdType: models.ThresholdTypePercent, ThresholdValue: 50, Enabled: true, LastTriggeredAt: &start},
+		{ID: 2, UserID: 1, ThresholdType: models.ThresholdTypePercent, ThresholdValue: 80, Enabled: true, LastTriggeredAt: &start},
+	}}
+	svc := NewNotificationThresholdService(thresholdRepo, userRepo, usageRepo, &mockPackRepo{})
+
+	due, err := svc.Due()
+	if err != nil {
+		t.Fatalf("Due() error = %v", err)
+	}
+	if len(due) != 0 {
+		t.Fatalf("Got %d notifications before the restart, want 0", len(due))
+	}
+
+	if err := accounting.RestartPeriod(1); err != nil {
+		t.Fatalf("RestartPeriod() error = %v", err)
+	}
+	current := &usageRepo.periods[len(usageRepo.periods)-1]
+	if current.QuotaBytes == nil {
+		t.Fatal("Restarted period has no prorated quota")
+	}
+	current.BillableBytesDown = *current.QuotaBytes
+
+	due, err = svc.Due()
+	if err != nil {
+		t.Fatalf("Due() error = %v", err)
+	}
+	if len(due) != 1 || len(due[0].Thresholds) != 2 {
+		t.Errorf("Got %+v after the restart, want both thresholds again", due)
+	}
+}
+
+// Test that traffic packs count toward the quota thresholds are measured
+// against, as they do in the user's usage
+func TestNotificationThresholdsCountPacks(t *testing.T) {
+	const gib = uint64(1 << 30)
+	chatID := int64(42)
+	periodStart := time.Now().Add(-24 * time.Hour)
+
+	userRepo := &mockUserRepo{users: map[uint64]*models.User{
+		1: {ID: 1, TelegramChatID: &chatID, Plan: &models.Plan{QuotaBytes: 100 * gib}},
+	}}
+	usageRepo := &mockUsageRepo{periods: []models.UsagePeriod{
+		{ID: 1, UserID: 1, PeriodStart: periodStart, BillableBytesDown: 85 * gib, IsCurrent: true},
+	}}
+	packRepo := &mockPackRepo{packs: []models.TrafficPack{
+		{ID: 1, UserID: 1, Bytes: 100 * gib},
+	}}
+	thresholdRepo := &mockThresholdRepo{thresholds: []models.TelegramThreshold{
+		{ID: 1, UserID: 1, ThresholdType: models.ThresholdTypePercent, ThresholdValue: 50, Enabled: true},
+		{ID: 2, UserID: 1, ThresholdType: models.ThresholdTypeBytesRemaining, ThresholdValue: float64(20 * gib), Enabled: true},
+	}}
+	svc := NewNotificationThresholdService(thresholdRepo, userRepo, usageRepo, packRepo)
+
+	due, err := svc.Due()
+	if err != nil {
+		t.Fatalf("Due() error = %v", err)
+	}
+	if len(due) != 0 {
+		t.Fatalf("Got %+v with 115 GiB of 200 GiB left, want no notifications", due)
+	}
+
+	// 50 GiB past the plan quota were drawn from the pack
+	usageRepo.periods[0].BillableBytesDown = 150 * gib
+	packRepo.packs[0].UsedBytes = 50 * gib
+	due, err = svc.Due()
+	if err != nil {
+		t.Fatalf("Due() error = %v", err)
+	}
+	if len(due) != 1 || len(due[0].Thresholds) != 1 || due[0].Thresholds[0].ID != 1 {
+		t.Fatalf("Got %+v, want the 50%% threshold", due)
+	}
+	if due[0].Quota != 200*gib || due[0].PercentUsed != 75 {
+		t.Errorf("Quota = %d, PercentUsed = %v, want 200 GiB and 75", due[0].Quota, due[0].PercentUsed)
+	}
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/metrics"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// OnlineUserService tracks the devices online on each node from their alive
+// reports, for device limits and the online metrics
+type OnlineUserService interface {
+	// Report replaces what is online on the node with an alive report
+	Report(nodeID uint64, alive models.AliveIPMap) error
+	// DeviceCounts returns each online user's distinct IPs across nodes
+	DeviceCounts() (map[uint64]uint, error)
+	// Cleanup removes devices no alive report listed within the staleness
+	// window, e.g. of nodes that stopped reporting, and refreshes the metrics
+	Cleanup() (int64, error)
+}
+
+type onlineUserService struct {
+	cfg        *config.NodeConfig
+	onlineRepo repository.OnlineUserRepository
+	logger     *zap.Logger
+}
+
+func NewOnlineUserService(
+	cfg *config.NodeConfig,
+	onlineRepo repository.OnlineUserRepository,
+	logger *zap.Logger,
+) OnlineUserService {
+	return &onlineUserService{
+		cfg:        cfg,
+		onlineRepo: onlineRepo,
+		logger:     logger,
+	}
+}
+
+func (s *onlineUserService) Report(nodeID uint64, alive models.AliveIPMap) error {
+	type entryKey struct {
+		userID     uint64
+		ip         string
+		identifier string
+	}
+
+	now := time.Now()
+	seen := make(map[entryKey]bool)
+	onlineUsers := make([]models.OnlineUser, 0, len(alive))
+	users := 0
+
+	for userID, entries := range alive {
+		online := false
+		for _, entry := range entries {
+			ip, identifier, ok := models.ParseAliveEntry(entry)
+			if !ok {
+				s.logger.Debug("Skipping invalid alive entry",
+					zap.Uint64("node_id", nodeID),
+					zap.Uint64("user_id", userID),
+					zap.String("entry", entry),
+				)
+				continue
+			}
+
+			key := entryKey{userID: userID, ip: ip, identifier: identifier}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			online = true
+
+			onlineUsers = append(onlineUsers, models.OnlineUser{
+				UserID:         userID,
+				NodeID:         nodeID,
+				IPAddress:      ip,
+				NodeIdentifier: identifier,
+				LastSeenAt:     now,
+			})
+		}
+		if online {
+			users++
+		}
+	}
+
+	if err := s.onlineRepo.ReplaceNode(nodeID, onlineUsers); err != nil {
+		return err
+	}
+
+	metrics.NodeOnlineUsers.WithLabelValues(strconv.FormatUint(nodeID, 10)).Set(float64(users))
+	return nil
+}
+
+func (s *onlineUserService) DeviceCounts() (map[uint64]uint, error) {
+	return s.onlineRepo.GetAllOnlineDeviceCounts()
+}
+
+func (s *onlineUserService) Cleanup() (int64, error) {
+	deleted, err := s.onlineRepo.CleanupStaleOnlineUsers(time.Now().Add(-s.cfg.GetOnlineStaleAfter()))
+	if err != nil {
+		return 0, err
+	}
+
+	total, err := s.onlineRepo.CountOnlineUsers()
+	if err != nil {
+		return deleted, err
+	}
+	metrics.OnlineUsers.Set(float64(total))
+
+	counts, err := s.onlineRepo.GetOnlineCountsByNode()
+	if err != nil {
+		return deleted, err
+	}
+	// Reset so nodes with nobody left online, or deleted, drop to nothing
+	metrics.NodeOnlineUsers.Reset()
+	for nodeID, count := range counts {
+		metrics.NodeOnlineUsers.WithLabelValues(strconv.FormatUint(nodeID, 10)).Set(float64(count))
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// mockOnlineRepo keeps online users in memory
+type mockOnlineRepo struct {
+	repository.OnlineUserRepository
+	rows []models.OnlineUser
+}
+
+func (m *mockOnlineRepo) ReplaceNode(nodeID uint64, onlineUsers []models.OnlineUser) error {
+	var kept []models.OnlineUser
+	for _, row := range m.rows {
+		if row.NodeID != nodeID {
+			kept = append(kept, row)
+		}
+	}
+	m.rows = append(kept, onlineUsers...)
+	return nil
+}
+
+func (m *mockOnlineRepo) GetAllOnlineDeviceCounts() (map[uint64]uint, error) {
+	ips := make(map[uint64]map[string]bool)
+	for _, row := range m.rows {
+		if ips[row.UserID] == nil {
+			ips[row.UserID] = make(map[string]bool)
+		}
+		ips[row.UserID][row.IPAddress] = true
+	}
+	counts := make(map[uint64]uint)
+	for userID, set := range ips {
+		counts[userID] = uint(len(set))
+	}
+	return counts, nil
+}
+
+func (m *mockOnlineRepo) CleanupStaleOnlineUsers(before time.Time) (int64, error) {
+	var kept []models.OnlineUser
+	for _, row := range m.rows {
+		if !row.LastSeenAt.Before(before) {
+			kept = append(kept, row)
+		}
+	}
+	deleted := int64(len(m.rows) - len(kept))
+	m.rows = kept
+	return deleted, nil
+}
+
+func (m *mockOnlineRepo) CountOnlineUsers() (int64, error) {
+	counts, _ := m.GetAllOnlineDeviceCounts()
+	return int64(len(counts)), nil
+}
+
+func (m *mockOnlineRepo) GetOnlineCountsByNode() (map[uint64]uint, error) {
+	return map[uint64]uint{}, nil
+}
+
+// Test that alive reports replace the node's snapshot and devices count once
+// per IP across nodes
+func TestOnlineUserReport(t *testing.T) {
+	repo := &mockOnlineRepo{}
+	svc := NewOnlineUserService(&config.NodeConfig{}, repo, zap.NewNop())
+
+	if err := svc.Report(1, models.AliveIPMap{
+		10: {"203.0.113.7_node1", "203.0.113.7_node1", "[2001:db8::1]_node1", "not-an-ip_node1"},
+		11: {"198.51.100.2"},
+	}); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if err := svc.Report(2, models.AliveIPMap{
+		10: {"::ffff:203.0.113.7_hk-2"},
+	}); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+
+	counts, _ := svc.DeviceCounts()
+	if counts[10] != 2 || counts[11] != 1 {
+		t.Errorf("Device counts = %v, want user 10: 2, user 11: 1", counts)
+	}
+
+	// The next report of node 1 no longer lists user 11
+	if err := svc.Report(1, models.AliveIPMap{10: {"203.0.113.7_node1"}}); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	counts, _ = svc.DeviceCounts()
+	if _, ok := counts[11]; ok || counts[10] != 1 {
+		t.Errorf("Device counts after replacing node 1 = %v, want only user 10 with 1", counts)
+	}
+
+	// Devices of a node that stopped reporting go stale
+	for i := range repo.rows {
+		if repo.rows[i].NodeID == 2 {
+			repo.rows[i].LastSeenAt = time.Now().Add(-time.Hour)
+		}
+	}
+	if deleted, err := svc.Cleanup(); err != nil || deleted != 1 {
+		t.Errorf("Cleanup() = %d, %v, want 1 deleted", deleted, err)
+	}
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// PushDedupService detects traffic pushes a node already delivered. Nodes can
+// send a request ID, remembered for a retention period, and/or a sequence
+// number that must increase with every push. A sequence that goes back with a
+// request ID not seen before is taken as the node restarting its count, so it
+// is accepted and becomes the node's sequence. Pushes with neither are always
+// accepted.
+type PushDedupService interface {
+	// Claim reports whether the push is new and, if so, records it
+	Claim(nodeID uint64, requestID string, seq uint64) (*PushClaim, bool, error)
+	// Release forgets a claimed push that could not be recorded, so the
+	// node's retry is accepted
+	Release(claim *PushClaim) error
+	CleanupReceipts() (int64, error)
+}
+
+// PushClaim is what Claim recorded for a push
+type PushClaim struct {
+	NodeID      uint64
+	RequestID   string
+	Seq         uint64
+	PreviousSeq uint64
+}
+
+type pushDedupService struct {
+	cfg         *config.NodeConfig
+	nodeRepo    repository.NodeRepository
+	receiptRepo repository.PushReceiptRepository
+	logger      *zap.Logger
+}
+
+func NewPushDedupService(
+	cfg *config.NodeConfig,
+	nodeRepo repository.NodeRepository,
+	receiptRepo repository.PushReceiptRepository,
+	logger *zap.Logger,
+) PushDedupService {
+	return &pushDedupService{
+		cfg:         cfg,
+		nodeRepo:    nodeRepo,
+		receiptRepo: receiptRepo,
+		logger:      logger,
+	}
+}
+
+func (s *pushDedupService) Claim(nodeID uint64, requestID string, seq uint64) (*PushClaim, bool, error) {
+	claim := &PushClaim{NodeID: nodeID}
+
+	if requestID != "" {
+		created, err := s.receiptRepo.Create(&models.NodePushReceipt{
+			NodeID:    nodeID,
+			RequestID: requestID,
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		if !created {
+			s.logger.Warn("Dropping traffic push with a known request ID",
+				zap.Uint64("node_id", nodeID),
+				zap.String("request_id", requestID),
+			)
+			return nil, false, nil
+		}
+		claim.RequestID = requestID
+	}
+
+	if seq > 0 {
+		// A new request ID vouches for the push being new
+		reset := claim.RequestID != ""
+		claimed, previous, err := s.nodeRepo.ClaimPushSeq(nodeID, seq, reset)
+		if err != nil {
+			if releaseErr := s.Release(claim); releaseErr != nil {
+				s.logger.Error("Failed to claim traffic push sequence", zap.Error(err))
+				return nil, false, releaseErr
+			}
+			return nil, false, err
+		}
+		if !claimed {
+			s.logger.Warn("Dropping traffic push with a stale sequence",
+				zap.Uint64("node_id", nodeID),
+				zap.Uint64("seq", seq),
+				zap.Uint64("last_seq", previous),
+			)
+			// The push is dropped, so its request ID must not block a
+			// retry that is accepted
+			if err := s.Release(claim); err != nil {
+				return nil, false, err
+			}
+			return nil, false, nil
+		}
+		if seq <= previous {
+			s.logger.Warn("Traffic push sequence went back, assuming the node restarted its count",
+				zap.Uint64("node_id", nodeID),
+				zap.Uint64("seq", seq),
+				zap.Uint64("last_seq", previous),
+			)
+		}
+		claim.Seq = seq
+		claim.PreviousSeq = previous
+	}
+
+	return claim, true, nil
+}
+
+func (s *pushDedupService) Release(claim *PushClaim) error {
+	if claim.Seq > 0 {
+		if err := s.nodeRepo.ReleasePushSeq(claim.NodeID, claim.Seq, claim.PreviousSeq); err != nil {
+			return err
+		}
+	}
+	if claim.RequestID != "" {
+		return s.receiptRepo.Delete(claim.NodeID, claim.RequestID)
+	}
+	return nil
+}
+
+// CleanupReceipts drops request IDs past the retention period. A push retried
+// after that is billed again.
+func (s *pushDedupService) CleanupReceipts() (int64, error) {
+	return s.receiptRepo.DeleteBefore(time.Now().Add(-s.cfg.GetPushReceiptRetention()))
+}
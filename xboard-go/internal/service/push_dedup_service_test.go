@@ -0,0 +1,152 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// mockPushReceiptRepo keeps push receipts in memory
+type mockPushReceiptRepo struct {
+	repository.PushReceiptRepository
+	receipts map[string]bool
+}
+
+func (m *mockPushReceiptRepo) Create(receipt *models.NodePushReceipt) (bool, error) {
+	if m.receipts[receipt.RequestID] {
+		return false, nil
+	}
+	m.receipts[receipt.RequestID] = true
+	return true, nil
+}
+
+func (m *mockPushReceiptRepo) Delete(nodeID uint64, requestID string) error {
+	delete(m.receipts, requestID)
+	return nil
+}
+
+// seqNodeRepo keeps the last push sequence of a single node
+type seqNodeRepo struct {
+	mockNodeRepo
+	lastSeq uint64
+}
+
+func (m *seqNodeRepo) ClaimPushSeq(nodeID, seq uint64, reset bool) (bool, uint64, error) {
+	previous := m.lastSeq
+	if seq <= previous && !reset {
+		return false, previous, nil
+	}
+	m.lastSeq = seq
+	return true, previous, nil
+}
+
+func (m *seqNodeRepo) ReleasePushSeq(nodeID, seq, previous uint64) error {
+	if m.lastSeq == seq {
+		m.lastSeq = previous
+	}
+	return nil
+}
+
+func newTestPushDedup() (PushDedupService, *mockPushReceiptRepo, *seqNodeRepo) {
+	receipts := &mockPushReceiptRepo{receipts: make(map[string]bool)}
+	nodes := &seqNodeRepo{}
+	return NewPushDedupService(&config.NodeConfig{}, nodes, receipts, zap.NewNop()), receipts, nodes
+}
+
+// Test that a push is claimed once per request ID and sequence number
+func TestPushDedupClaim(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+		seq       uint64
+		want      bool
+	}{
+		{"new request ID", "a", 0, true},
+		{"duplicate request ID", "a", 0, false},
+		{"new sequence", "", 5, true},
+		{"equal sequence", "", 5, false},
+		{"stale sequence", "", 4, false},
+		{"both headers", "b", 6, true},
+		{"both headers, duplicate request ID", "b", 7, false},
+		{"both headers, duplicate sequence", "c", 6, true},
+		{"no headers", "", 0, true},
+	}
+
+	svc, _, nodes := newTestPushDedup()
+	for _, tt := range tests {
+		_, fresh, err := svc.Claim(1, tt.requestID, tt.seq)
+		if err != nil {
+			t.Fatalf("%s: Claim() error = %v", tt.name, err)
+		}
+		if fresh != tt.want {
+			t.Errorf("%s: fresh = %v, want %v", tt.name, fresh, tt.want)
+		}
+	}
+
+	if nodes.lastSeq != 6 {
+		t.Errorf("last sequence = %d, want 6", nodes.lastSeq)
+	}
+}
+
+// Test that a node restarting its count is accepted when it sends request
+// IDs, while a stale sequence alone is still dropped and keeps no request ID
+func TestPushDedupSeqReset(t *testing.T) {
+	svc, receipts, nodes := newTestPushDedup()
+	if _, fresh, _ := svc.Claim(1, "a", 40); !fresh {
+		t.Fatal("First push should be accepted")
+	}
+
+	// The node restarted and counts from 1 again
+	if _, fresh, _ := svc.Claim(1, "b", 1); !fresh {
+		t.Fatal("Push after a restart should be accepted")
+	}
+	if nodes.lastSeq != 1 {
+		t.Errorf("last sequence = %d, want 1", nodes.lastSeq)
+	}
+	if _, fresh, _ := svc.Claim(1, "c", 2); !fresh {
+		t.Error("Next push after a restart should be accepted")
+	}
+	if _, fresh, _ := svc.Claim(1, "b", 1); fresh {
+		t.Error("Retry of a push after a restart should be a duplicate")
+	}
+
+	// Without a request ID there is nothing to tell a restart from a replay
+	if _, fresh, _ := svc.Claim(1, "", 2); fresh {
+		t.Error("Stale sequence without a request ID should be dropped")
+	}
+	if len(receipts.receipts) != 3 || nodes.lastSeq != 2 {
+		t.Errorf("After a dropped push, %d receipts and last sequence %d, want 3 and 2", len(receipts.receipts), nodes.lastSeq)
+	}
+}
+
+// Test that a push released after its traffic could not be recorded is
+// accepted when the node retries it
+func TestPushDedupRelease(t *testing.T) {
+	svc, receipts, nodes := newTestPushDedup()
+	if _, fresh, _ := svc.Claim(1, "a", 3); !fresh {
+		t.Fatal("First push should be accepted")
+	}
+
+	claim, fresh, err := svc.Claim(1, "b", 4)
+	if err != nil || !fresh {
+		t.Fatalf("Claim() = %v, %v, want a fresh claim", fresh, err)
+	}
+	// Ingest failed
+	if err := svc.Release(claim); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if receipts.receipts["b"] || nodes.lastSeq != 3 {
+		t.Errorf("After release, receipt kept = %v and last sequence = %d, want false and 3", receipts.receipts["b"], nodes.lastSeq)
+	}
+
+	if _, fresh, _ := svc.Claim(1, "b", 4); !fresh {
+		t.Error("Retry of a released push should be accepted")
+	}
+	if _, fresh, _ := svc.Claim(1, "b", 4); fresh {
+		t.Error("Second retry after the push was recorded should be a duplicate")
+	}
+}
@@ -0,0 +1,362 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type SubscriptionService interface {
+	Subscribe(userID, planID uint64, duration time.Duration, autoRenew bool) (*models.Subscription, error)
+	Cancel(subscriptionID uint64) error
+	AssignPlan(userID, planID uint64) error
+	GetCurrent(userID uint64) (*models.Subscription, error)
+	ProcessExpired() ([]SubscriptionExpiry, error)
+}
+
+// SubscriptionExpiry describes what happened to a subscription that reached
+// its expiry date
+type SubscriptionExpiry struct {
+	Subscription models.Subscription
+	User         *models.User
+	Renewed      bool
+	// FallbackPlan is the plan the user was moved to, nil if none
+	FallbackPlan *models.Plan
+	// PlanKept is set when the user had been moved to another plan since
+	// subscribing and keeps it
+	PlanKept bool
+}
+
+type subscriptionService struct {
+	cfg           *config.SubscriptionConfig
+	subRepo       repository.SubscriptionRepository
+	userRepo      repository.UserRepository
+	planRepo      repository.PlanRepository
+	accountingSvc AccountingService
+	nodeUsers     NodeUserService
+	logger        *zap.Logger
+}
+
+func NewSubscriptionService(
+	cfg *config.SubscriptionConfig,
+	subRepo repository.SubscriptionRepository,
+	userRepo repository.UserRepository,
+	planRepo repository.PlanRepository,
+	accountingSvc AccountingService,
+	nodeUsers NodeUserService,
+	logger *zap.Logger,
+) SubscriptionService {
+	return &subscriptionService{
+		cfg:           cfg,
+		subRepo:       subRepo,
+		userRepo:      userRepo,
+		planRepo:      planRepo,
+		accountingSvc: accountingSvc,
+		nodeUsers:     nodeUsers,
+		logger:        logger,
+	}
+}
+
+// Subscribe puts the user on a plan for the given duration. Subscribing to
+// the plan the user already has extends the active subscription; any other
+// plan replaces it and starts a fresh usage period.
+func (s *subscriptionService) Subscribe(userID, planID uint64, duration time.Duration, autoRenew bool) (*models.Subscription, error) {
+	if duration <= 0 {
+		return nil, errors.New("subscription duration must be positive")
+	}
+
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.planRepo.FindByID(planID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	current, err := s.subRepo.FindActiveByUser(userID)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	if current != nil && current.IsActive(now) && current.PlanID == planID {
+		current.ExpiresAt = current.ExpiresAt.Add(duration)
+		current.AutoRenew = autoRenew
+		if err := s.subRepo.Update(current); err != nil {
+			return nil, err
+		}
+		s.nodeUsers.UsersChanged("subscription_extended", userID)
+		return current, nil
+	}
+
+	if current != nil {
+		if current.IsActive(now) {
+			current.Status = "cancelled"
+		} else {
+			current.Status = "expired"
+		}
+		if err := s.subRepo.Update(current); err != nil {
+			return nil, err
+		}
+	}
+
+	sub := &models.Subscription{
+		UserID:    userID,
+		PlanID:    planID,
+		StartsAt:  now,
+		ExpiresAt: now.Add(duration),
+		AutoRenew: autoRenew,
+		Status:    "active",
+	}
+	if err := s.subRepo.Create(sub); err != nil {
+		return nil, err
+	}
+
+	if err := s.assignPlan(user, &planID); err != nil {
+		return nil, err
+	}
+	s.nodeUsers.UsersChanged("subscription_created", userID)
+
+	return sub, nil
+}
+
+// Cancel ends a subscription immediately and moves the user to the fallback
+// plan if it was the subscription granting their current plan
+func (s *subscriptionService) Cancel(subscriptionID uint64) error {
+	sub, err := s.subRepo.FindByID(subscriptionID)
+	if err != nil {
+		return err
+	}
+
+	if sub.Status != "active" {
+		return nil
+	}
+
+	sub.Status = "cancelled"
+	if err := s.subRepo.Update(sub); err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.FindByID(sub.UserID)
+	if err != nil {
+		return err
+	}
+
+	// An admin may have moved the user to another plan since they
+	// subscribed, which cancelling this subscription does not take away
+	if !samePlan(user.PlanID, &sub.PlanID) {
+		return nil
+	}
+
+	if _, err := s.fallBack(user); err != nil {
+		return err
+	}
+	s.nodeUsers.UsersChanged("subscription_cancelled", user.ID)
+	return nil
+}
+
+// AssignPlan puts the user on a plan chosen by an admin. An active
+// subscription for another plan no longer grants the user's plan, so it is
+// cancelled as if the user had subscribed to the new plan.
+func (s *subscriptionService) AssignPlan(userID, planID uint64) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.planRepo.FindByID(planID); err != nil {
+		return err
+	}
+
+	current, err := s.subRepo.FindActiveByUser(userID)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	if current != nil && current.PlanID != planID {
+		if current.IsActive(time.Now()) {
+			current.Status = "cancelled"
+		} else {
+			current.Status = "expired"
+		}
+		if err := s.subRepo.Update(current); err != nil {
+			return err
+		}
+	}
+
+	if samePlan(user.PlanID, &planID) {
+		return nil
+	}
+	if err := s.assignPlan(user, &planID); err != nil {
+		return err
+	}
+	s.nodeUsers.UsersChanged("plan_assigned", userID)
+	return nil
+}
+
+func (s *subscriptionService) GetCurrent(userID uint64) (*models.Subscription, error) {
+	sub, err := s.subRepo.FindActiveByUser(userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return sub, err
+}
+
+// subscriptionExpiryBatchSize bounds how many due subscriptions are loaded per query
+const subscriptionExpiryBatchSize = 200
+
+// ProcessExpired renews or expires every active subscription past its expiry
+// date. Auto-renewing subscriptions are extended by their original term;
+// others are expired and the user is moved to the configured default plan.
+func (s *subscriptionService) ProcessExpired() ([]SubscriptionExpiry, error) {
+	now := time.Now()
+
+	var results []SubscriptionExpiry
+	var afterID uint64
+
+	for {
+		subs, err := s.subRepo.FindDue(now, afterID, subscriptionExpiryBatchSize)
+		if err != nil {
+			return results, err
+		}
+
+		for i := range subs {
+			sub := subs[i]
+			afterID = sub.ID
+
+			result, err := s.expire(&sub, now)
+			if err != nil {
+				s.logger.Error("Failed to process expired subscription",
+					zap.Uint64("subscription_id", sub.ID),
+					zap.Uint64("user_id", sub.UserID),
+					zap.Error(err),
+				)
+				continue
+			}
+			if result != nil {
+				results = append(results, *result)
+			}
+		}
+
+		if len(subs) < subscriptionExpiryBatchSize {
+			break
+		}
+	}
+
+	return results, nil
+}
+
+// expire renews or expires a due subscription. A subscription whose user no
+// longer exists is expired without a result, so the job does not pick it up
+// again on every run.
+func (s *subscriptionService) expire(sub *models.Subscription, now time.Time) (*SubscriptionExpiry, error) {
+	user, err := s.userRepo.FindByID(sub.UserID)
+	if err == gorm.ErrRecordNotFound {
+		s.logger.Warn("Expiring subscription of deleted user",
+			zap.Uint64("subscription_id", sub.ID),
+			zap.Uint64("user_id", sub.UserID),
+		)
+		sub.Status = "expired"
+		return nil, s.subRepo.Update(sub)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	term := sub.ExpiresAt.Sub(sub.StartsAt)
+	if sub.AutoRenew && term > 0 {
+		for !sub.ExpiresAt.After(now) {
+			sub.StartsAt = sub.ExpiresAt
+			sub.ExpiresAt = sub.ExpiresAt.Add(term)
+		}
+		if err := s.subRepo.Update(sub); err != nil {
+			return nil, err
+		}
+		return &SubscriptionExpiry{Subscription: *sub, User: user, Renewed: true}, nil
+	}
+
+	sub.Status = "expired"
+	if err := s.subRepo.Update(sub); err != nil {
+		return nil, err
+	}
+
+	// Like Cancel, leave a plan an admin assigned after the subscription
+	// started alone
+	result := &SubscriptionExpiry{Subscription: *sub, User: user}
+	if !samePlan(user.PlanID, &sub.PlanID) {
+		result.PlanKept = true
+		return result, nil
+	}
+
+	result.FallbackPlan, err = s.fallBack(user)
+	if err != nil {
+		return nil, err
+	}
+	s.nodeUsers.UsersChanged("subscription_expired", user.ID)
+
+	return result, nil
+}
+
+// fallBack moves a user whose subscription ended to their remaining active
+// subscription, the configured default plan, or no plan
+func (s *subscriptionService) fallBack(user *models.User) (*models.Plan, error) {
+	var planID *uint64
+
+	next, err := s.subRepo.FindActiveByUser(user.ID)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	if next != nil && next.IsActive(time.Now()) {
+		planID = &next.PlanID
+	} else if s.cfg.DefaultPlanID != 0 {
+		defaultID := s.cfg.DefaultPlanID
+		planID = &defaultID
+	}
+
+	var plan *models.Plan
+	if planID != nil {
+		plan, err = s.planRepo.FindByID(*planID)
+		if err != nil {
+			s.logger.Warn("Fallback plan not found, removing plan",
+				zap.Uint64("plan_id", *planID),
+				zap.Error(err),
+			)
+			planID = nil
+			plan = nil
+		}
+	}
+
+	return plan, s.assignPlan(user, planID)
+}
+
+// assignPlan sets the user's plan and starts a new usage period if it changed
+func (s *subscriptionService) assignPlan(user *models.User, planID *uint64) error {
+	if samePlan(user.PlanID, planID) {
+		return nil
+	}
+
+	user.PlanID = planID
+	// Drop the preloaded association so Save does not restore the old plan_id
+	user.Plan = nil
+	if err := s.userRepo.Update(user); err != nil {
+		return err
+	}
+
+	return s.accountingSvc.RestartPeriod(user.ID)
+}
+
+func samePlan(a, b *uint64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
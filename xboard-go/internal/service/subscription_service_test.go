@@ -0,0 +1,331 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// mockSubscriptionRepo keeps subscriptions in memory
+type mockSubscriptionRepo struct {
+	subs []models.Subscription
+}
+
+func (m *mockSubscriptionRepo) Create(sub *models.Subscription) error {
+	sub.ID = uint64(len(m.subs) + 1)
+	m.subs = append(m.subs, *sub)
+	return nil
+}
+
+func (m *mockSubscriptionRepo) FindByID(id uint64) (*models.Subscription, error) {
+	for i := range m.subs {
+		if m.subs[i].ID == id {
+			sub := m.subs[i]
+			return &sub, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (m *mockSubscriptionRepo) Update(sub *models.Subscription) error {
+	for i := range m.subs {
+		if m.subs[i].ID == sub.ID {
+			m.subs[i] = *sub
+		}
+	}
+	return nil
+}
+
+func (m *mockSubscriptionRepo) ListByUser(userID uint64) ([]models.Subscription, error) {
+	var subs []models.Subscription
+	for _, sub := range m.subs {
+		if sub.UserID == userID {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+
+func (m *mockSubscriptionRepo) FindActiveByUser(userID uint64) (*models.Subscription, error) {
+	var found *models.Subscription
+	for i := range m.subs {
+		sub := m.subs[i]
+		if sub.UserID == userID && sub.Status == "active" && (found == nil || sub.ExpiresAt.After(found.ExpiresAt)) {
+			found = &sub
+		}
+	}
+	if found == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return found, nil
+}
+
+func (m *mockSubscriptionRepo) FindDue(before time.Time, afterID uint64, limit int) ([]models.Subscription, error) {
+	var subs []models.Subscription
+	for _, sub := range m.subs {
+		if sub.Status == "active" && !sub.ExpiresAt.After(before) && sub.ID > afterID && len(subs) < limit {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+
+func (m *mockSubscriptionRepo) FindUsersExpiring(after, upTo time.Time) ([]uint64, error) {
+	var ids []uint64
+	for _, sub := range m.subs {
+		if sub.Status == "active" && sub.ExpiresAt.After(after) && !sub.ExpiresAt.After(upTo) {
+			ids = append(ids, sub.UserID)
+		}
+	}
+	return ids, nil
+}
+
+// mockRestartAccounting records the users whose period was restarted
+type mockRestartAccounting struct {
+	AccountingService
+	restarted []uint64
+}
+
+func (m *mockRestartAccounting) RestartPeriod(userID uint64) error {
+	m.restarted = append(m.restarted, userID)
+	return nil
+}
+
+func newTestSubscriptionService(defaultPlanID uint64, users map[uint64]*models.User, subRepo *mockSubscriptionRepo, accounting AccountingService) SubscriptionService {
+	return NewSubscriptionService(
+		&config.SubscriptionConfig{DefaultPlanID: defaultPlanID},
+		subRepo,
+		&mockUserRepo{users: users},
+		&mockPlanRepo{},
+		accounting,
+		newMockNodeUsers(),
+		zap.NewNop(),
+	)
+}
+
+// Test that subscribing to the current plan extends the subscription and a
+// different plan replaces it
+func TestSubscribe(t *testing.T) {
+	users := map[uint64]*models.User{1: {ID: 1}}
+	subRepo := &mockSubscriptionRepo{}
+	accounting := &mockRestartAccounting{}
+	service := newTestSubscriptionService(0, users, subRepo, accounting)
+
+	first, err := service.Subscribe(1, 2, 30*24*time.Hour, false)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if users[1].PlanID == nil || *users[1].PlanID != 2 {
+		t.Fatalf("User plan = %v, want 2", users[1].PlanID)
+	}
+	if len(accounting.restarted) != 1 {
+		t.Errorf("Restarted %d periods, want 1", len(accounting.restarted))
+	}
+
+	extended, err := service.Subscribe(1, 2, 10*24*time.Hour, true)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if extended.ID != first.ID || !extended.ExpiresAt.Equal(first.ExpiresAt.Add(10*24*time.Hour)) {
+		t.Errorf("Subscription = %+v, want %d extended by 10 days", extended, first.ID)
+	}
+	if len(accounting.restarted) != 1 {
+		t.Errorf("Extending restarted the period")
+	}
+
+	replaced, err := service.Subscribe(1, 3, 30*24*time.Hour, false)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if replaced.ID == first.ID || *users[1].PlanID != 3 {
+		t.Errorf("Subscribing to another plan did not replace the subscription")
+	}
+	if old, _ := subRepo.FindByID(first.ID); old.Status != "cancelled" {
+		t.Errorf("Replaced subscription status = %q, want cancelled", old.Status)
+	}
+	if len(accounting.restarted) != 2 {
+		t.Errorf("Restarted %d periods, want 2", len(accounting.restarted))
+	}
+
+	if _, err := service.Subscribe(1, 3, 0, false); err == nil {
+		t.Error("Subscribe() should reject a zero duration")
+	}
+}
+
+// Test renewing, expiring and falling back when subscriptions reach their
+// expiry date
+func TestProcessExpired(t *testing.T) {
+	now := time.Now()
+	planID := uint64(2)
+	users := map[uint64]*models.User{
+		1: {ID: 1, PlanID: &planID},
+		2: {ID: 2, PlanID: &planID},
+		3: {ID: 3, PlanID: &planID},
+	}
+	subRepo := &mockSubscriptionRepo{subs: []models.Subscription{
+		// Renews by whole two-hour terms until it is in the future again
+		{ID: 1, UserID: 1, PlanID: 2, StartsAt: now.Add(-3 * time.Hour), ExpiresAt: now.Add(-time.Hour), AutoRenew: true, Status: "active"},
+		// Expires to the default plan
+		{ID: 2, UserID: 2, PlanID: 2, StartsAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour), Status: "active"},
+		// Expires to the user's other running subscription
+		{ID: 3, UserID: 3, PlanID: 2, StartsAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour), Status: "active"},
+		{ID: 4, UserID: 3, PlanID: 4, StartsAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(time.Hour), Status: "active"},
+		// Not due yet
+		{ID: 5, UserID: 1, PlanID: 2, StartsAt: now, ExpiresAt: now.Add(time.Hour), Status: "active"},
+	}}
+	service := newTestSubscriptionService(9, users, subRepo, &mockRestartAccounting{})
+
+	results, err := service.ProcessExpired()
+	if err != nil {
+		t.Fatalf("ProcessExpired() error = %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Got %d results, want 3", len(results))
+	}
+
+	renewed, _ := subRepo.FindByID(1)
+	if !results[0].Renewed || renewed.Status != "active" || !renewed.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Auto-renewing subscription = %+v, want active until %v", renewed, now.Add(time.Hour))
+	}
+	if *users[1].PlanID != 2 {
+		t.Errorf("Renewed user plan = %d, want 2", *users[1].PlanID)
+	}
+
+	expired, _ := subRepo.FindByID(2)
+	if results[1].Renewed || expired.Status != "expired" {
+		t.Errorf("Subscription status = %q, want expired", expired.Status)
+	}
+	if results[1].FallbackPlan == nil || *users[2].PlanID != 9 {
+		t.Errorf("User plan = %v, want the default plan 9", users[2].PlanID)
+	}
+
+	if *users[3].PlanID != 4 {
+		t.Errorf("User plan = %d, want 4 from the remaining subscription", *users[3].PlanID)
+	}
+}
+
+// Test that cancelling only takes away the plan the subscription granted
+func TestCancel(t *testing.T) {
+	now := time.Now()
+	planID, otherPlanID := uint64(2), uint64(5)
+	users := map[uint64]*models.User{
+		1: {ID: 1, PlanID: &planID},
+		2: {ID: 2, PlanID: &otherPlanID},
+	}
+	subRepo := &mockSubscriptionRepo{subs: []models.Subscription{
+		{ID: 1, UserID: 1, PlanID: 2, StartsAt: now, ExpiresAt: now.Add(time.Hour), Status: "active"},
+		{ID: 2, UserID: 2, PlanID: 2, StartsAt: now, ExpiresAt: now.Add(time.Hour), Status: "active"},
+	}}
+	accounting := &mockRestartAccounting{}
+	service := newTestSubscriptionService(9, users, subRepo, accounting)
+
+	if err := service.Cancel(1); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	if sub, _ := subRepo.FindByID(1); sub.Status != "cancelled" {
+		t.Errorf("Subscription status = %q, want cancelled", sub.Status)
+	}
+	if *users[1].PlanID != 9 {
+		t.Errorf("User plan = %d, want the default plan 9", *users[1].PlanID)
+	}
+
+	// The admin moved this user to another plan after they subscribed
+	if err := service.Cancel(2); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	if sub, _ := subRepo.FindByID(2); sub.Status != "cancelled" {
+		t.Errorf("Subscription status = %q, want cancelled", sub.Status)
+	}
+	if *users[2].PlanID != otherPlanID {
+		t.Errorf("User plan = %d, want %d kept", *users[2].PlanID, otherPlanID)
+	}
+	if len(accounting.restarted) != 1 {
+		t.Errorf("Restarted %d periods, want 1", len(accounting.restarted))
+	}
+}
+
+// Test that expiry leaves a plan an admin assigned after subscribing alone
+// and expires subscriptions of deleted users
+func TestProcessExpiredKeepsAdminPlan(t *testing.T) {
+	now := time.Now()
+	otherPlanID := uint64(5)
+	users := map[uint64]*models.User{
+		1: {ID: 1, PlanID: &otherPlanID},
+	}
+	subRepo := &mockSubscriptionRepo{subs: []models.Subscription{
+		{ID: 1, UserID: 1, PlanID: 2, StartsAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour), Status: "active"},
+		{ID: 2, UserID: 99, PlanID: 2, StartsAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour), Status: "active"},
+	}}
+	accounting := &mockRestartAccounting{}
+	service := newTestSubscriptionService(9, users, subRepo, accounting)
+
+	results, err := service.ProcessExpired()
+	if err != nil {
+		t.Fatalf("ProcessExpired() error = %v", err)
+	}
+	if len(results) != 1 || !results[0].PlanKept || results[0].FallbackPlan != nil {
+		t.Fatalf("Results = %+v, want one with the plan kept", results)
+	}
+	if *users[1].PlanID != otherPlanID || len(accounting.restarted) != 0 {
+		t.Errorf("User plan = %d, want %d kept without a restart", *users[1].PlanID, otherPlanID)
+	}
+
+	for _, id := range []uint64{1, 2} {
+		if sub, _ := subRepo.FindByID(id); sub.Status != "expired" {
+			t.Errorf("Subscription %d status = %q, want expired", id, sub.Status)
+		}
+	}
+}
+
+// Test that an admin assigning a plan restarts the period and cancels a
+// subscription for another plan, but keeps one for the same plan
+func TestAssignPlan(t *testing.T) {
+	now := time.Now()
+	planID := uint64(2)
+	users := map[uint64]*models.User{
+		1: {ID: 1, PlanID: &planID},
+		2: {ID: 2, PlanID: &planID},
+	}
+	subRepo := &mockSubscriptionRepo{subs: []models.Subscription{
+		{ID: 1, UserID: 1, PlanID: 2, StartsAt: now, ExpiresAt: now.Add(time.Hour), Status: "active"},
+		{ID: 2, UserID: 2, PlanID: 5, StartsAt: now, ExpiresAt: now.Add(time.Hour), Status: "active"},
+	}}
+	accounting := &mockRestartAccounting{}
+	service := newTestSubscriptionService(9, users, subRepo, accounting)
+
+	if err := service.AssignPlan(1, 3); err != nil {
+		t.Fatalf("AssignPlan() error = %v", err)
+	}
+	if *users[1].PlanID != 3 {
+		t.Errorf("User plan = %d, want 3", *users[1].PlanID)
+	}
+	if sub, _ := subRepo.FindByID(1); sub.Status != "cancelled" {
+		t.Errorf("Subscription status = %q, want cancelled", sub.Status)
+	}
+	if len(accounting.restarted) != 1 || accounting.restarted[0] != 1 {
+		t.Errorf("Restarted periods = %v, want [1]", accounting.restarted)
+	}
+
+	if err := service.AssignPlan(2, 5); err != nil {
+		t.Fatalf("AssignPlan() error = %v", err)
+	}
+	if *users[2].PlanID != 5 {
+		t.Errorf("User plan = %d, want 5", *users[2].PlanID)
+	}
+	if sub, _ := subRepo.FindByID(2); sub.Status != "active" {
+		t.Errorf("Subscription for the assigned plan status = %q, want active", sub.Status)
+	}
+
+	// Assigning the plan the user already has leaves the period alone
+	if err := service.AssignPlan(2, 5); err != nil {
+		t.Fatalf("AssignPlan() error = %v", err)
+	}
+	if len(accounting.restarted) != 2 {
+		t.Errorf("Restarted %d periods, want 2", len(accounting.restarted))
+	}
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+// TelegramLinkTokenTTL is how long a link token can be used for
+const TelegramLinkTokenTTL = 5 * time.Minute
+
+var (
+	ErrInvalidLinkToken  = errors.New("link token is invalid, used or expired")
+	ErrChatAlreadyLinked = errors.New("chat is already linked to an account")
+)
+
+// TelegramLinkService links Telegram chats to accounts. A user creates a
+// single-use token in the dashboard and sends it to the bot from the chat to
+// link; each chat can be linked to one account only.
+type TelegramLinkService interface {
+	CreateToken(userID uint64) (*models.TelegramLinkToken, error)
+	Link(token string, chatID int64) (*models.User, error)
+	Unlink(chatID int64) (*models.User, error)
+}
+
+type telegramLinkService struct {
+	userRepo  repository.UserRepository
+	tokenRepo repository.TelegramLinkTokenRepository
+}
+
+func NewTelegramLinkService(
+	userRepo repository.UserRepository,
+	tokenRepo repository.TelegramLinkTokenRepository,
+) TelegramLinkService {
+	return &telegramLinkService{
+		userRepo:  userRepo,
+		tokenRepo: tokenRepo,
+	}
+}
+
+// CreateToken issues a link token for the user, invalidating earlier ones
+func (s *telegramLinkService) CreateToken(userID uint64) (*models.TelegramLinkToken, error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, err
+	}
+
+	token := &models.TelegramLinkToken{
+		UserID:    userID,
+		Token:     hex.EncodeToString(tokenBytes),
+		ExpiresAt: time.Now().Add(TelegramLinkTokenTTL),
+	}
+	if err := s.tokenRepo.Create(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Link consumes the token and links the chat to its user, replacing any chat
+// the user linked before. A chat that is already linked must be unlinked
+// first; the token is then left unused.
+func (s *telegramLinkService) Link(token string, chatID int64) (*models.User, error) {
+	if _, err := s.userRepo.FindByTelegramChatID(chatID); err == nil {
+		return nil, ErrChatAlreadyLinked
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	now := time.Now()
+	linkToken, err := s.tokenRepo.Consume(token, now)
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrInvalidLinkToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.FindByID(linkToken.UserID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrInvalidLinkToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user.TelegramChatID = &chatID
+	user.TelegramLinkedAt = &now
+	if err := s.userRepo.Update(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Unlink removes the chat's link and returns the user it was linked to
+func (s *telegramLinkService) Unlink(chatID int64) (*models.User, error) {
+	user, err := s.userRepo.FindByTelegramChatID(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	user.TelegramChatID = nil
+	user.TelegramLinkedAt = nil
+	if err := s.userRepo.Update(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
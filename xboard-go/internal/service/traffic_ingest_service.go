@@ -0,0 +1,458 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/metrics"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// TrafficIngestService buffers traffic pushed by nodes and bills it in bulk.
+// Reports are appended to a write-ahead log before they are acknowledged and
+// coalesced in memory per (user, node, minute received) until the next
+// flush, which resolves each user's current period and writes everything in
+// a few statements. Each flush ends its segment with the ID the batch is
+// billed under, so a segment left behind by a crash after billing is not
+// billed again on replay. Traffic is never copied between segments: segments
+// holding traffic that is still unbilled stay on disk and the marker of the
+// flush that bills them lists them as covered.
+type TrafficIngestService interface {
+	// Submit buffers a node's traffic report. It returns once the report is
+	// durable in the write-ahead log.
+	Submit(nodeID uint64, reports []models.TrafficReport) error
+	// Start replays traffic left in the write-ahead log by a previous run and
+	// starts the flush loop
+	Start() error
+	Flush() error
+	// Stop flushes what is buffered and closes the write-ahead log
+	Stop()
+}
+
+// walMarker is the log record ending a flushed segment. Traffic records are
+// JSON arrays, markers are objects. Covers lists older segments whose
+// traffic is part of the same batch, so they are skipped along with the
+// segment once the batch is billed.
+type walMarker struct {
+	BatchID string   `json:"batch_id"`
+	Covers  []uint64 `json:"covers,omitempty"`
+}
+
+type trafficKey struct {
+	userID uint64
+	nodeID uint64
+	// receivedAt keeps traffic received in different minutes apart so
+	// multiplier schedules are evaluated at the right time
+	receivedAt int64
+}
+
+type trafficIngestService struct {
+	cfg           *config.IngestConfig
+	accountingSvc AccountingService
+	logger        *zap.Logger
+
+	mu      sync.Mutex
+	pending map[trafficKey]*models.TrafficDelta
+	wal     *os.File
+	walSeq  uint64
+	// carried are segments kept on disk whose traffic is buffered but not
+	// billed yet, after a failed flush or a replay
+	carried []walSegment
+
+	// flushMu serializes flushes so segments are removed in order
+	flushMu  sync.Mutex
+	trigger  chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	started  bool
+	stopOnce sync.Once
+}
+
+func NewTrafficIngestService(
+	cfg *config.IngestConfig,
+	accountingSvc AccountingService,
+	logger *zap.Logger,
+) TrafficIngestService {
+	return &trafficIngestService{
+		cfg:           cfg,
+		accountingSvc: accountingSvc,
+		logger:        logger,
+		pending:       make(map[trafficKey]*models.TrafficDelta),
+		trigger:       make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+func (s *trafficIngestService) Submit(nodeID uint64, reports []models.TrafficReport) error {
+	receivedAt := time.Now().Truncate(time.Minute)
+	deltas := make([]models.TrafficDelta, 0, len(reports))
+	for _, report := range reports {
+		if report.Upload == 0 && report.Download == 0 {
+			continue
+		}
+		deltas = append(deltas, models.TrafficDelta{
+			NodeID:     nodeID,
+			UserID:     report.UserID,
+			Upload:     report.Upload,
+			Download:   report.Download,
+			ReceivedAt: receivedAt,
+		})
+	}
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.appendWAL(deltas); err != nil {
+		return err
+	}
+	s.add(deltas)
+
+	metrics.IngestPendingEntries.Set(float64(len(s.pending)))
+	if len(s.pending) >= s.cfg.GetMaxPendingEntries() {
+		select {
+		case s.trigger <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// add coalesces deltas into the buffer. Callers must hold the lock.
+func (s *trafficIngestService) add(deltas []models.TrafficDelta) {
+	for _, delta := range deltas {
+		key := trafficKey{userID: delta.UserID, nodeID: delta.NodeID, receivedAt: delta.ReceivedAt.Unix()}
+		if existing, ok := s.pending[key]; ok {
+			existing.Upload += delta.Upload
+			existing.Download += delta.Download
+			continue
+		}
+		d := delta
+		s.pending[key] = &d
+	}
+}
+
+// appendWAL writes deltas as one line to the active segment and syncs it.
+// Callers must hold the lock.
+func (s *trafficIngestService) appendWAL(deltas []models.TrafficDelta) error {
+	return s.appendRecord(deltas)
+}
+
+// appendMarker ends the active segment with the ID its traffic is billed
+// under and the carried segments billed with it. Callers must hold the lock.
+func (s *trafficIngestService) appendMarker(batchID string, covers []walSegment) error {
+	marker := walMarker{BatchID: batchID}
+	for _, segment := range covers {
+		marker.Covers = append(marker.Covers, segment.seq)
+	}
+	return s.appendRecord(marker)
+}
+
+func (s *trafficIngestService) appendRecord(record interface{}) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := s.wal.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.wal.Sync()
+}
+
+func (s *trafficIngestService) Start() error {
+	path := s.cfg.GetWALPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	contents := make([]walContent, len(segments))
+	for i, segment := range segments {
+		if contents[i], err = s.readSegment(segment.path); err != nil {
+			return err
+		}
+		if segment.seq > s.walSeq {
+			s.walSeq = segment.seq
+		}
+	}
+
+	billed := make(map[uint64]bool)
+	for i, segment := range segments {
+		batchID := contents[i].marker.BatchID
+		if batchID == "" {
+			continue
+		}
+		ok, err := s.accountingSvc.BatchBilled(batchID)
+		if err != nil {
+			return err
+		}
+		if ok {
+			billed[segment.seq] = true
+			for _, seq := range contents[i].marker.Covers {
+				billed[seq] = true
+			}
+		}
+	}
+
+	// Replayed segments stay on disk until the flush billing their traffic
+	// succeeds. Covered segments are older than the one covering them, so
+	// removing oldest first never leaves a covered segment without its cover.
+	for i, segment := range segments {
+		if billed[segment.seq] || len(contents[i].deltas) == 0 {
+			if billed[segment.seq] {
+				s.logger.Info("Skipping traffic log segment billed before",
+					zap.String("segment", segment.path),
+				)
+			}
+			if err := os.Remove(segment.path); err != nil {
+				return err
+			}
+			continue
+		}
+		s.add(contents[i].deltas)
+		s.carried = append(s.carried, segment)
+	}
+
+	if err := s.openSegment(); err != nil {
+		return err
+	}
+
+	if len(segments) > 0 {
+		s.logger.Info("Replayed traffic write-ahead log",
+			zap.Int("segments", len(segments)),
+			zap.Int("carried", len(s.carried)),
+			zap.Int("entries", len(s.pending)),
+		)
+	}
+	metrics.IngestPendingEntries.Set(float64(len(s.pending)))
+
+	s.started = true
+	go s.run()
+	return nil
+}
+
+// walContent is what a segment holds: its traffic and the last marker
+type walContent struct {
+	deltas []models.TrafficDelta
+	marker walMarker
+}
+
+// readSegment reads a segment. A torn last line from a crash mid-write was
+// never acknowledged and is skipped.
+func (s *trafficIngestService) readSegment(path string) (walContent, error) {
+	var content walContent
+
+	file, err := os.Open(path)
+	if err != nil {
+		return content, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var err error
+		if bytes.HasPrefix(line, []byte("{")) {
+			var marker walMarker
+			if err = json.Unmarshal(line, &marker); err == nil {
+				content.marker = marker
+			}
+		} else {
+			var deltas []models.TrafficDelta
+			if err = json.Unmarshal(line, &deltas); err == nil {
+				content.deltas = append(content.deltas, deltas...)
+			}
+		}
+		if err != nil {
+			s.logger.Warn("Skipping corrupt traffic log record",
+				zap.String("segment", path),
+				zap.Error(err),
+			)
+		}
+	}
+	return content, scanner.Err()
+}
+
+func (s *trafficIngestService) run() {
+	ticker := time.NewTicker(s.cfg.GetFlushInterval())
+	defer ticker.Stop()
+	defer close(s.done)
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.trigger:
+		case <-s.stop:
+			s.flushAndLog()
+			return
+		}
+		s.flushAndLog()
+	}
+}
+
+func (s *trafficIngestService) flushAndLog() {
+	if err := s.Flush(); err != nil {
+		metrics.AccountingErrorsTotal.Inc()
+		s.logger.Error("Failed to flush traffic", zap.Error(err))
+	}
+}
+
+// Flush bills everything buffered so far. The active segment is marked with
+// the batch ID and rotated first, so pushes keep being accepted into a new
+// one while the batch is written, and the old segment is removed once the
+// batch is billed. On failure the batch goes back into the buffer for the
+// next flush and its segment stays on disk as the only durable copy.
+func (s *trafficIngestService) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	if len(s.pending) == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	deltas := s.pendingDeltas()
+	covers := s.carried
+	batchID := uuid.NewString()
+	if err := s.appendMarker(batchID, covers); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	flushed := walSegment{path: s.wal.Name(), seq: s.walSeq}
+	if err := s.openSegment(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.pending = make(map[trafficKey]*models.TrafficDelta)
+	s.carried = nil
+	metrics.IngestPendingEntries.Set(0)
+	s.mu.Unlock()
+
+	start := time.Now()
+	err := s.accountingSvc.ProcessTrafficBatch(batchID, deltas)
+	metrics.IngestFlushDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		s.mu.Lock()
+		s.add(deltas)
+		s.carried = append(covers, flushed)
+		metrics.IngestPendingEntries.Set(float64(len(s.pending)))
+		s.mu.Unlock()
+		return err
+	}
+
+	// A crash before the flushed segment is removed leaves it behind; its
+	// marker keeps the replay from billing it or the segments it covers
+	// again. It goes last so it outlives every segment it covers.
+	for _, segment := range append(covers, flushed) {
+		if err := os.Remove(segment.path); err != nil {
+			return err
+		}
+	}
+
+	s.logger.Debug("Flushed traffic",
+		zap.Int("entries", len(deltas)),
+		zap.Int("segments", len(covers)+1),
+		zap.Duration("duration", time.Since(start)),
+	)
+	return nil
+}
+
+// Stop may be called more than once and without a successful Start, in
+// which case there is no flush loop to wait for
+func (s *trafficIngestService) Stop() {
+	s.stopOnce.Do(func() {
+		s.mu.Lock()
+		started := s.started
+		s.mu.Unlock()
+
+		if started {
+			close(s.stop)
+			<-s.done
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.wal != nil {
+			s.wal.Close()
+		}
+	})
+}
+
+// pendingDeltas returns the buffer as a slice. Callers must hold the lock.
+func (s *trafficIngestService) pendingDeltas() []models.TrafficDelta {
+	deltas := make([]models.TrafficDelta, 0, len(s.pending))
+	for _, delta := range s.pending {
+		deltas = append(deltas, *delta)
+	}
+	return deltas
+}
+
+// openSegment closes the active segment, if any, and starts the next one.
+// Callers must hold the lock.
+func (s *trafficIngestService) openSegment() error {
+	s.walSeq++
+	path := fmt.Sprintf("%s.%d", s.cfg.GetWALPath(), s.walSeq)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		s.walSeq--
+		return err
+	}
+
+	if s.wal != nil {
+		s.wal.Close()
+	}
+	s.wal = file
+	return nil
+}
+
+type walSegment struct {
+	path string
+	seq  uint64
+}
+
+// segments lists existing write-ahead log segments, oldest first
+func (s *trafficIngestService) segments() ([]walSegment, error) {
+	prefix := s.cfg.GetWALPath() + "."
+	matches, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []walSegment
+	for _, match := range matches {
+		seq, err := strconv.ParseUint(strings.TrimPrefix(match, prefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, walSegment{path: match, seq: seq})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].seq < segments[j].seq
+	})
+	return segments, nil
+}
@@ -0,0 +1,265 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+
+	"go.uber.org/zap"
+)
+
+// mockAccountingService records traffic batches
+type mockAccountingService struct {
+	AccountingService
+	batches [][]models.TrafficDelta
+	billed  map[string]bool
+	fail    bool
+	// onBill runs after a batch is billed
+	onBill func()
+}
+
+func (m *mockAccountingService) ProcessTrafficBatch(batchID string, deltas []models.TrafficDelta) error {
+	if m.fail {
+		return errors.New("database unavailable")
+	}
+	if m.billed[batchID] {
+		return nil
+	}
+	if m.billed == nil {
+		m.billed = make(map[string]bool)
+	}
+	m.billed[batchID] = true
+	m.batches = append(m.batches, deltas)
+	if m.onBill != nil {
+		m.onBill()
+	}
+	return nil
+}
+
+func (m *mockAccountingService) BatchBilled(batchID string) (bool, error) {
+	return m.billed[batchID], nil
+}
+
+// Test that buffered traffic is coalesced and survives a restart
+func TestTrafficIngestReplay(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	cfg := &config.IngestConfig{
+		FlushIntervalSeconds: 3600,
+		WALPath:              filepath.Join(t.TempDir(), "traffic.wal"),
+	}
+
+	accounting := &mockAccountingService{fail: true}
+	first := NewTrafficIngestService(cfg, accounting, logger)
+	if err := first.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	first.Submit(1, []models.TrafficReport{{UserID: 10, Upload: 100, Download: 200}})
+	first.Submit(1, []models.TrafficReport{{UserID: 10, Upload: 1, Download: 2}, {UserID: 11}})
+	first.Submit(2, []models.TrafficReport{{UserID: 10, Upload: 5, Download: 5}})
+
+	// A failed flush keeps the traffic in the log
+	if err := first.Flush(); err == nil {
+		t.Fatal("Flush() should fail while accounting fails")
+	}
+
+	// Simulate a crash: the first service is never stopped
+	accounting.fail = false
+	second := NewTrafficIngestService(cfg, accounting, logger)
+	if err := second.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer second.Stop()
+
+	if err := second.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(accounting.batches) != 1 {
+		t.Fatalf("Got %d batches, want 1", len(accounting.batches))
+	}
+
+	// Reports may straddle a minute and stay apart, so sum per (user, node)
+	totals := make(map[trafficKey]models.TrafficDelta)
+	for _, delta := range accounting.batches[0] {
+		if delta.ReceivedAt.IsZero() {
+			t.Errorf("Delta %+v has no receipt time", delta)
+		}
+		key := trafficKey{userID: delta.UserID, nodeID: delta.NodeID}
+		total := totals[key]
+		total.Upload += delta.Upload
+		total.Download += delta.Download
+		totals[key] = total
+	}
+	if len(totals) != 2 {
+		t.Fatalf("Got %d entries, want 2 (empty reports are skipped)", len(totals))
+	}
+	if got := totals[trafficKey{userID: 10, nodeID: 1}]; got.Upload != 101 || got.Download != 202 {
+		t.Errorf("User 10 on node 1 = %+v, want 101 up / 202 down", got)
+	}
+
+	// Nothing is billed twice
+	if err := second.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(accounting.batches) != 1 {
+		t.Errorf("Got %d batches after an empty flush, want 1", len(accounting.batches))
+	}
+}
+
+// Test that a segment left behind by a crash after billing is not billed again
+func TestTrafficIngestSkipsBilledSegment(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	cfg := &config.IngestConfig{
+		FlushIntervalSeconds: 3600,
+		WALPath:              filepath.Join(t.TempDir(), "traffic.wal"),
+	}
+
+	// Keep a copy of the segments as they are while the batch is billed,
+	// which is what a crash before their removal leaves behind
+	segments := make(map[string][]byte)
+	accounting := &mockAccountingService{}
+	accounting.onBill = func() {
+		matches, _ := filepath.Glob(cfg.WALPath + ".*")
+		for _, match := range matches {
+			data, err := os.ReadFile(match)
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			segments[match] = data
+		}
+	}
+
+	first := NewTrafficIngestService(cfg, accounting, logger)
+	if err := first.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	first.Submit(1, []models.TrafficReport{{UserID: 10, Upload: 100, Download: 200}})
+	if err := first.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	for path, data := range segments {
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	accounting.onBill = nil
+	second := NewTrafficIngestService(cfg, accounting, logger)
+	if err := second.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer second.Stop()
+
+	if err := second.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(accounting.batches) != 1 {
+		t.Errorf("Got %d batches after replay, want 1", len(accounting.batches))
+	}
+}
+
+// Test that traffic carried over a failed flush and a restart is billed once
+// even when a crash after billing leaves every segment behind
+func TestTrafficIngestCarriedSegmentsBilledOnce(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	cfg := &config.IngestConfig{
+		FlushIntervalSeconds: 3600,
+		WALPath:              filepath.Join(t.TempDir(), "traffic.wal"),
+	}
+
+	segments := make(map[string][]byte)
+	snapshot := func() {
+		matches, _ := filepath.Glob(cfg.WALPath + ".*")
+		for _, match := range matches {
+			data, err := os.ReadFile(match)
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			segments[match] = data
+		}
+	}
+	restore := func() {
+		for path, data := range segments {
+			if err := os.WriteFile(path, data, 0o600); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+		}
+	}
+
+	// A failed flush keeps its segment, then the process crashes
+	accounting := &mockAccountingService{fail: true}
+	first := NewTrafficIngestService(cfg, accounting, logger)
+	if err := first.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	first.Submit(1, []models.TrafficReport{{UserID: 10, Upload: 100, Download: 200}})
+	if err := first.Flush(); err == nil {
+		t.Fatal("Flush() should fail while accounting fails")
+	}
+	first.Submit(1, []models.TrafficReport{{UserID: 10, Upload: 1, Download: 2}})
+
+	// The restart carries the traffic over and fails to flush once more
+	second := NewTrafficIngestService(cfg, accounting, logger)
+	if err := second.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := second.Flush(); err == nil {
+		t.Fatal("Flush() should fail while accounting fails")
+	}
+	second.Submit(2, []models.TrafficReport{{UserID: 10, Upload: 5, Download: 5}})
+
+	// The next flush bills everything, then crashes before any segment is
+	// removed
+	accounting.fail = false
+	accounting.onBill = snapshot
+	if err := second.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(accounting.batches) != 1 {
+		t.Fatalf("Got %d batches, want 1", len(accounting.batches))
+	}
+	var upload uint64
+	for _, delta := range accounting.batches[0] {
+		upload += delta.Upload
+	}
+	if upload != 106 {
+		t.Errorf("Billed %d bytes up, want 106", upload)
+	}
+	restore()
+
+	accounting.onBill = nil
+	third := NewTrafficIngestService(cfg, accounting, logger)
+	if err := third.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer third.Stop()
+
+	if err := third.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(accounting.batches) != 1 {
+		t.Errorf("Got %d batches after replay, want 1", len(accounting.batches))
+	}
+	if matches, _ := filepath.Glob(cfg.WALPath + ".*"); len(matches) != 1 {
+		t.Errorf("Got %d segments after replay, want only the active one", len(matches))
+	}
+}
+
+// Test that Stop returns without a Start and can be called twice
+func TestTrafficIngestStop(t *testing.T) {
+	cfg := &config.IngestConfig{WALPath: filepath.Join(t.TempDir(), "traffic.wal")}
+
+	unstarted := NewTrafficIngestService(cfg, &mockAccountingService{}, zap.NewNop())
+	unstarted.Stop()
+
+	started := NewTrafficIngestService(cfg, &mockAccountingService{}, zap.NewNop())
+	if err := started.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	started.Stop()
+	started.Stop()
+}
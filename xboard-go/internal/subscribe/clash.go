@@ -0,0 +1,183 @@
+package subscribe
+
+import (
+	"bytes"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/protocol"
+
+	"gopkg.in/yaml.v3"
+)
+
+const proxyGroupName = "Proxy"
+
+type clashConfig struct {
+	MixedPort   int               `yaml:"mixed-port"`
+	AllowLAN    bool              `yaml:"allow-lan"`
+	Mode        string            `yaml:"mode"`
+	Proxies     []clashProxy      `yaml:"proxies"`
+	ProxyGroups []clashProxyGroup `yaml:"proxy-groups"`
+	Rules       []string          `yaml:"rules"`
+}
+
+type clashProxy struct {
+	Name              string            `yaml:"name"`
+	Type              string            `yaml:"type"`
+	Server            string            `yaml:"server"`
+	Port              uint              `yaml:"port"`
+	UUID              string            `yaml:"uuid,omitempty"`
+	Password          string            `yaml:"password,omitempty"`
+	AlterID           *int              `yaml:"alterId,omitempty"`
+	Cipher            string            `yaml:"cipher,omitempty"`
+	UDP               bool              `yaml:"udp"`
+	TLS               bool              `yaml:"tls,omitempty"`
+	ServerName        string            `yaml:"servername,omitempty"`
+	SNI               string            `yaml:"sni,omitempty"`
+	SkipCertVerify    bool              `yaml:"skip-cert-verify,omitempty"`
+	Flow              string            `yaml:"flow,omitempty"`
+	Network           string            `yaml:"network,omitempty"`
+	WSOpts            *clashWSOpts      `yaml:"ws-opts,omitempty"`
+	GRPCOpts          map[string]string `yaml:"grpc-opts,omitempty"`
+	ALPN              []string          `yaml:"alpn,omitempty"`
+	Congestion        string            `yaml:"congestion-controller,omitempty"`
+	Obfs              string            `yaml:"obfs,omitempty"`
+	ObfsPassword      string            `yaml:"obfs-password,omitempty"`
+	ClientFingerprint string            `yaml:"client-fingerprint,omitempty"`
+	RealityOpts       map[string]string `yaml:"reality-opts,omitempty"`
+}
+
+type clashWSOpts struct {
+	Path    string            `yaml:"path,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+type clashProxyGroup struct {
+	Name    string   `yaml:"name"`
+	Type    string   `yaml:"type"`
+	Proxies []string `yaml:"proxies"`
+}
+
+func renderClash(profile *Profile) ([]byte, error) {
+	config := clashConfig{
+		MixedPort: 7890,
+		Mode:      "rule",
+		Proxies:   []clashProxy{},
+		Rules:     []string{"MATCH," + proxyGroupName},
+	}
+
+	names := []string{}
+	for i := range profile.Nodes {
+		proxy, ok := clashProxyFor(&profile.Nodes[i], profile.UUID)
+		if !ok {
+			continue
+		}
+		config.Proxies = append(config.Proxies, proxy)
+		names = append(names, proxy.Name)
+	}
+
+	config.ProxyGroups = []clashProxyGroup{
+		{Name: proxyGroupName, Type: "select", Proxies: append(names, "DIRECT")},
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&config); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func clashProxyFor(node *models.Node, uuid string) (clashProxy, bool) {
+	opts, ok := parseOptions(node, uuid)
+	if !ok {
+		return clashProxy{}, false
+	}
+
+	proxy := clashProxy{
+		Name:   node.Name,
+		Server: node.Host,
+		Port:   node.Port,
+		UDP:    true,
+	}
+
+	switch node.NodeType {
+	case "vmess":
+		alterID := 0
+		proxy.Type = "vmess"
+		proxy.UUID = uuid
+		proxy.AlterID = &alterID
+		proxy.Cipher = "auto"
+		setClashTransport(&proxy, opts)
+		if opts.TLS == protocol.TLSEnabled {
+			proxy.TLS = true
+			proxy.ServerName = opts.serverName(node)
+		}
+	case "vless":
+		proxy.Type = "vless"
+		proxy.UUID = uuid
+		proxy.Flow = opts.Flow
+		setClashTransport(&proxy, opts)
+		switch opts.TLS {
+		case protocol.TLSEnabled:
+			proxy.TLS = true
+			proxy.ServerName = opts.serverName(node)
+			proxy.SkipCertVerify = opts.Insecure
+		case protocol.TLSReality:
+			proxy.TLS = true
+			proxy.ServerName = opts.ServerName
+			proxy.ClientFingerprint = "chrome"
+			proxy.RealityOpts = map[string]string{
+				"public-key": opts.RealityPublicKey,
+				"short-id":   opts.RealityShortID,
+			}
+		}
+	case "trojan":
+		proxy.Type = "trojan"
+		proxy.Password = opts.Password
+		proxy.SNI = opts.serverName(node)
+		proxy.SkipCertVerify = opts.Insecure
+		if opts.Network != "tcp" {
+			setClashTransport(&proxy, opts)
+		}
+	case "shadowsocks":
+		proxy.Type = "ss"
+		proxy.Cipher = opts.Cipher
+		proxy.Password = opts.Password
+	case "hysteria":
+		proxy.Type = "hysteria2"
+		proxy.Password = opts.Password
+		proxy.SNI = opts.serverName(node)
+		proxy.SkipCertVerify = opts.Insecure
+		proxy.Obfs = opts.Obfs
+		proxy.ObfsPassword = opts.ObfsPassword
+	case "tuic":
+		proxy.Type = "tuic"
+		proxy.UUID = uuid
+		proxy.Password = opts.Password
+		proxy.SNI = opts.serverName(node)
+		proxy.SkipCertVerify = opts.Insecure
+		proxy.ALPN = opts.ALPN
+		proxy.Congestion = opts.CongestionControl
+	default:
+		return proxy, false
+	}
+
+	return proxy, true
+}
+
+func setClashTransport(proxy *clashProxy, opts nodeOptions) {
+	proxy.Network = opts.Network
+	switch opts.Network {
+	case "ws":
+		proxy.WSOpts = &clashWSOpts{Path: opts.Path}
+		if opts.Host != "" {
+			proxy.WSOpts.Headers = map[string]string{"Host": opts.Host}
+		}
+	case "grpc":
+		proxy.GRPCOpts = map[string]string{"grpc-service-name": opts.ServiceName}
+	}
+}
@@ -0,0 +1,164 @@
+package subscribe
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/protocol"
+)
+
+// renderBase64 encodes one share link per node, as read by v2rayN and
+// most mobile clients
+func renderBase64(profile *Profile) []byte {
+	var links []string
+	for i := range profile.Nodes {
+		if link := shareLink(&profile.Nodes[i], profile.UUID); link != "" {
+			links = append(links, link)
+		}
+	}
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n")))
+	return []byte(encoded)
+}
+
+func shareLink(node *models.Node, uuid string) string {
+	opts, ok := parseOptions(node, uuid)
+	if !ok {
+		return ""
+	}
+	addr := net.JoinHostPort(node.Host, strconv.FormatUint(uint64(node.Port), 10))
+	fragment := "#" + url.PathEscape(node.Name)
+
+	switch node.NodeType {
+	case "vmess":
+		return vmessLink(node, uuid, opts)
+	case "vless":
+		query := url.Values{}
+		query.Set("encryption", "none")
+		query.Set("type", opts.Network)
+		setTransportQuery(query, opts)
+		if opts.Flow != "" {
+			query.Set("flow", opts.Flow)
+		}
+		switch opts.TLS {
+		case protocol.TLSEnabled:
+			query.Set("security", "tls")
+			query.Set("sni", opts.serverName(node))
+		case protocol.TLSReality:
+			query.Set("security", "reality")
+			query.Set("sni", opts.ServerName)
+			query.Set("pbk", opts.RealityPublicKey)
+			query.Set("sid", opts.RealityShortID)
+			query.Set("fp", "chrome")
+		}
+		return "vless://" + uuid + "@" + addr + "?" + query.Encode() + fragment
+	case "trojan":
+		query := url.Values{}
+		query.Set("sni", opts.serverName(node))
+		if opts.Network != "tcp" {
+			query.Set("type", opts.Network)
+			setTransportQuery(query, opts)
+		}
+		if opts.Insecure {
+			query.Set("allowInsecure", "1")
+		}
+		return "trojan://" + url.PathEscape(opts.Password) + "@" + addr + "?" + query.Encode() + fragment
+	case "shadowsocks":
+		// SIP002 leaves 2022 ciphers' userinfo percent-encoded instead of
+		// base64, as their passwords are already base64
+		userInfo := base64.RawURLEncoding.EncodeToString([]byte(opts.Cipher + ":" + opts.Password))
+		if strings.HasPrefix(opts.Cipher, "2022-") {
+			userInfo = url.UserPassword(opts.Cipher, opts.Password).String()
+		}
+		link := "ss://" + userInfo + "@" + addr
+		if opts.Obfs != "" {
+			query := url.Values{}
+			query.Set("plugin", obfsPlugin(opts))
+			link += "/?" + query.Encode()
+		}
+		return link + fragment
+	case "hysteria":
+		query := url.Values{}
+		query.Set("sni", opts.serverName(node))
+		if opts.Obfs != "" {
+			query.Set("obfs", opts.Obfs)
+			query.Set("obfs-password", opts.ObfsPassword)
+		}
+		if opts.Insecure {
+			query.Set("insecure", "1")
+		}
+		return "hysteria2://" + url.PathEscape(opts.Password) + "@" + addr + "?" + query.Encode() + fragment
+	case "tuic":
+		query := url.Values{}
+		query.Set("sni", opts.serverName(node))
+		query.Set("congestion_control", opts.CongestionControl)
+		query.Set("alpn", strings.Join(opts.ALPN, ","))
+		if opts.Insecure {
+			query.Set("allow_insecure", "1")
+		}
+		return "tuic://" + uuid + ":" + url.PathEscape(opts.Password) + "@" + addr + "?" + query.Encode() + fragment
+	default:
+		return ""
+	}
+}
+
+// obfsPlugin returns the simple-obfs plugin option of a SIP002 link
+func obfsPlugin(opts nodeOptions) string {
+	plugin := "obfs-local;obfs=" + opts.Obfs
+	if host := opts.ObfsSettings["host"]; host != "" {
+		plugin += ";obfs-host=" + host
+	}
+	if path := opts.ObfsSettings["path"]; path != "" {
+		plugin += ";obfs-uri=" + path
+	}
+	return plugin
+}
+
+func setTransportQuery(query url.Values, opts nodeOptions) {
+	switch opts.Network {
+	case "ws", "httpupgrade":
+		if opts.Path != "" {
+			query.Set("path", opts.Path)
+		}
+		if opts.Host != "" {
+			query.Set("host", opts.Host)
+		}
+	case "grpc":
+		if opts.ServiceName != "" {
+			query.Set("serviceName", opts.ServiceName)
+		}
+	}
+}
+
+// vmessLink uses the v2rayN JSON link format
+func vmessLink(node *models.Node, uuid string, opts nodeOptions) string {
+	config := map[string]string{
+		"v":    "2",
+		"ps":   node.Name,
+		"add":  node.Host,
+		"port": fmt.Sprint(node.Port),
+		"id":   uuid,
+		"aid":  "0",
+		"net":  opts.Network,
+		"type": "none",
+		"host": opts.Host,
+		"path": opts.Path,
+		"tls":  "",
+	}
+	if opts.Network == "grpc" {
+		config["path"] = opts.ServiceName
+	}
+	if opts.TLS == protocol.TLSEnabled {
+		config["tls"] = "tls"
+		config["sni"] = opts.serverName(node)
+	}
+
+	data, _ := json.Marshal(config)
+	return "vmess://" + base64.StdEncoding.EncodeToString(data)
+}
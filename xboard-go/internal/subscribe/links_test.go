@@ -0,0 +1,52 @@
+package subscribe
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+)
+
+const testUUID = "11111111-2222-3333-4444-555555555555"
+
+// Test Shadowsocks share links for legacy and 2022 ciphers and with obfs
+func TestShadowsocksLink(t *testing.T) {
+	legacyUserInfo := base64.RawURLEncoding.EncodeToString([]byte("aes-128-gcm:" + testUUID))
+
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{
+			"legacy cipher",
+			`{"cipher":"aes-128-gcm"}`,
+			"ss://" + legacyUserInfo + "@example.com:8388#Node",
+		},
+		{
+			"2022 cipher",
+			`{"cipher":"2022-blake3-aes-128-gcm","server_key":"AAAAAAAAAAAAAAAAAAAAAA=="}`,
+			"ss://2022-blake3-aes-128-gcm:AAAAAAAAAAAAAAAAAAAAAA==%3AMTExMTExMTEtMjIyMi0zMw==@example.com:8388#Node",
+		},
+		{
+			"http obfs",
+			`{"cipher":"aes-128-gcm","obfs":"http","obfs_settings":{"host":"cdn.example.com","path":"/obfs"}}`,
+			"ss://" + legacyUserInfo + "@example.com:8388/?plugin=obfs-local%3Bobfs%3Dhttp%3Bobfs-host%3Dcdn.example.com%3Bobfs-uri%3D%2Fobfs#Node",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &models.Node{
+				Name:           "Node",
+				NodeType:       "shadowsocks",
+				Host:           "example.com",
+				Port:           8388,
+				ProtocolConfig: tt.config,
+			}
+			if got := shareLink(node, testUUID); got != tt.want {
+				t.Errorf("shareLink() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package subscribe
+
+import (
+	"encoding/json"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/protocol"
+)
+
+const selectorTag = "proxy"
+
+type singBoxConfig struct {
+	Outbounds []map[string]interface{} `json:"outbounds"`
+	Route     map[string]interface{}   `json:"route"`
+}
+
+func renderSingBox(profile *Profile) ([]byte, error) {
+	var outbounds []map[string]interface{}
+	tags := []string{}
+	for i := range profile.Nodes {
+		outbound, ok := singBoxOutboundFor(&profile.Nodes[i], profile.UUID)
+		if !ok {
+			continue
+		}
+		outbounds = append(outbounds, outbound)
+		tags = append(tags, profile.Nodes[i].Name)
+	}
+
+	config := singBoxConfig{
+		Route: map[string]interface{}{"final": selectorTag},
+	}
+	config.Outbounds = append(config.Outbounds, map[string]interface{}{
+		"type":      "selector",
+		"tag":       selectorTag,
+		"outbounds": append(tags, "direct"),
+	})
+	config.Outbounds = append(config.Outbounds, outbounds...)
+	config.Outbounds = append(config.Outbounds, map[string]interface{}{
+		"type": "direct",
+		"tag":  "direct",
+	})
+
+	return json.MarshalIndent(config, "", "  ")
+}
+
+func singBoxOutboundFor(node *models.Node, uuid string) (map[string]interface{}, bool) {
+	opts, ok := parseOptions(node, uuid)
+	if !ok {
+		return nil, false
+	}
+
+	outbound := map[string]interface{}{
+		"tag":         node.Name,
+		"server":      node.Host,
+		"server_port": node.Port,
+	}
+
+	tls := map[string]interface{}{
+		"enabled":     true,
+		"server_name": opts.serverName(node),
+		"insecure":    opts.Insecure,
+	}
+
+	switch node.NodeType {
+	case "vmess":
+		outbound["type"] = "vmess"
+		outbound["uuid"] = uuid
+		outbound["security"] = "auto"
+		outbound["alter_id"] = 0
+		setSingBoxTransport(outbound, opts)
+		if opts.TLS == protocol.TLSEnabled {
+			outbound["tls"] = tls
+		}
+	case "vless":
+		outbound["type"] = "vless"
+		outbound["uuid"] = uuid
+		if opts.Flow != "" {
+			outbound["flow"] = opts.Flow
+		}
+		setSingBoxTransport(outbound, opts)
+		switch opts.TLS {
+		case protocol.TLSEnabled:
+			outbound["tls"] = tls
+		case protocol.TLSReality:
+			outbound["tls"] = map[string]interface{}{
+				"enabled":     true,
+				"server_name": opts.ServerName,
+				"utls": map[string]interface{}{
+					"enabled":     true,
+					"fingerprint": "chrome",
+				},
+				"reality": map[string]interface{}{
+					"enabled":    true,
+					"public_key": opts.RealityPublicKey,
+					"short_id":   opts.RealityShortID,
+				},
+			}
+		}
+	case "trojan":
+		outbound["type"] = "trojan"
+		outbound["password"] = opts.Password
+		outbound["tls"] = tls
+		setSingBoxTransport(outbound, opts)
+	case "shadowsocks":
+		outbound["type"] = "shadowsocks"
+		outbound["method"] = opts.Cipher
+		outbound["password"] = opts.Password
+	case "hysteria":
+		outbound["type"] = "hysteria2"
+		outbound["password"] = opts.Password
+		outbound["tls"] = tls
+		if opts.Obfs != "" {
+			outbound["obfs"] = map[string]interface{}{
+				"type":     opts.Obfs,
+				"password": opts.ObfsPassword,
+			}
+		}
+	case "tuic":
+		tls["alpn"] = opts.ALPN
+		outbound["type"] = "tuic"
+		outbound["uuid"] = uuid
+		outbound["password"] = opts.Password
+		outbound["congestion_control"] = opts.CongestionControl
+		outbound["tls"] = tls
+	default:
+		return nil, false
+	}
+
+	return outbound, true
+}
+
+func setSingBoxTransport(outbound map[string]interface{}, opts nodeOptions) {
+	switch opts.Network {
+	case "ws":
+		transport := map[string]interface{}{"type": "ws", "path": opts.Path}
+		if opts.Host != "" {
+			transport["headers"] = map[string]string{"Host": opts.Host}
+		}
+		outbound["transport"] = transport
+	case "httpupgrade":
+		outbound["transport"] = map[string]interface{}{
+			"type": "httpupgrade",
+			"path": opts.Path,
+			"host": opts.Host,
+		}
+	case "grpc":
+		outbound["transport"] = map[string]interface{}{
+			"type":         "grpc",
+			"service_name": opts.ServiceName,
+		}
+	}
+}
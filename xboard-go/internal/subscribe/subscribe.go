@@ -0,0 +1,140 @@
+package subscribe
+
+import (
+	"strings"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/protocol"
+)
+
+// Format is a client configuration format a subscription can be rendered to
+type Format string
+
+const (
+	FormatBase64  Format = "base64"
+	FormatClash   Format = "clash"
+	FormatSingBox Format = "singbox"
+)
+
+// Profile is everything needed to render one user's subscription
+type Profile struct {
+	UUID  string
+	Nodes []models.Node
+}
+
+// DetectFormat picks the output format from the ?flag= parameter, falling
+// back to the client's User-Agent and then to a base64 link list
+func DetectFormat(flag, userAgent string) Format {
+	for _, hint := range []string{flag, userAgent} {
+		hint = strings.ToLower(hint)
+		switch {
+		case hint == "":
+			continue
+		case strings.Contains(hint, "clash"), strings.Contains(hint, "mihomo"), strings.Contains(hint, "stash"):
+			return FormatClash
+		case strings.Contains(hint, "sing-box"), strings.Contains(hint, "singbox"), strings.Contains(hint, "sfa"), strings.Contains(hint, "sfi"):
+			return FormatSingBox
+		case strings.Contains(hint, "base64"), strings.Contains(hint, "v2ray"):
+			return FormatBase64
+		}
+	}
+	return FormatBase64
+}
+
+// Render returns the subscription body and its content type
+func Render(format Format, profile *Profile) ([]byte, string, error) {
+	switch format {
+	case FormatClash:
+		data, err := renderClash(profile)
+		return data, "text/yaml; charset=utf-8", err
+	case FormatSingBox:
+		data, err := renderSingBox(profile)
+		return data, "application/json; charset=utf-8", err
+	default:
+		return renderBase64(profile), "text/plain; charset=utf-8", nil
+	}
+}
+
+// nodeOptions holds the protocol settings clients need, flattened from the
+// node's typed protocol config
+type nodeOptions struct {
+	Password          string
+	Network           string
+	Path              string
+	Host              string
+	ServiceName       string
+	TLS               int
+	ServerName        string
+	Insecure          bool
+	Flow              string
+	RealityPublicKey  string
+	RealityShortID    string
+	Cipher            string
+	Obfs              string
+	ObfsPassword      string
+	ObfsSettings      map[string]string
+	CongestionControl string
+	ALPN              []string
+}
+
+// parseOptions reads the node's protocol config. Nodes whose config does
+// not parse are left out of the subscription.
+func parseOptions(node *models.Node, uuid string) (nodeOptions, bool) {
+	config, _, err := protocol.Load(node.NodeType, node.ProtocolConfig)
+	if err != nil {
+		return nodeOptions{}, false
+	}
+
+	opts := nodeOptions{Password: uuid, Network: "tcp"}
+	setTransport := func(t *protocol.Transport) {
+		opts.Network = t.Network
+		opts.Path = t.Path()
+		opts.Host = t.Host()
+		opts.ServiceName = t.ServiceName()
+	}
+
+	switch c := config.(type) {
+	case *protocol.VMessConfig:
+		setTransport(&c.Transport)
+		opts.TLS = c.TLS
+		opts.ServerName = c.ServerName
+	case *protocol.VLessConfig:
+		setTransport(&c.Transport)
+		opts.TLS = c.TLS
+		opts.ServerName = c.ServerName
+		opts.Insecure = c.Insecure
+		opts.Flow = c.Flow
+		if c.Reality != nil {
+			opts.RealityPublicKey = c.Reality.PublicKey
+			opts.RealityShortID = c.Reality.ShortID
+		}
+	case *protocol.TrojanConfig:
+		setTransport(&c.Transport)
+		opts.ServerName = c.ServerName
+		opts.Insecure = c.Insecure
+	case *protocol.ShadowsocksConfig:
+		opts.Cipher = c.Cipher
+		opts.Password = c.UserPassword(uuid)
+		opts.Obfs = c.Obfs
+		opts.ObfsSettings = c.ObfsSettings
+	case *protocol.HysteriaConfig:
+		opts.ServerName = c.ServerName
+		opts.Insecure = c.Insecure
+		opts.Obfs = c.Obfs
+		opts.ObfsPassword = c.ObfsPassword
+	case *protocol.TUICConfig:
+		opts.ServerName = c.ServerName
+		opts.Insecure = c.Insecure
+		opts.CongestionControl = c.CongestionControl
+		opts.ALPN = c.ALPN
+	}
+	return opts, true
+}
+
+// serverName returns the TLS SNI, defaulting to the node host
+func (o nodeOptions) serverName(node *models.Node) string {
+	if o.ServerName != "" {
+		return o.ServerName
+	}
+	return node.Host
+}
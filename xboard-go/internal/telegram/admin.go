@@ -0,0 +1,233 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+const adminHelp = "Admin commands:\n" +
+	"/user <email> - Look up a user\n" +
+	"/ban <email> - Ban a user\n" +
+	"/unban <email> - Unban a user\n" +
+	"/grant <email> <GiB> [days] - Grant a traffic pack, expiring after the given days\n" +
+	"/offline - List active nodes that are not online\n" +
+	"/broadcast <message> - Send an announcement to all linked users"
+
+// adminFor returns the admin linked to the chat, or nil if the chat does not
+// belong to one. Admin commands are only answered in private chats.
+func (b *Bot) adminFor(chat *tgbotapi.Chat) *models.User {
+	if !chat.IsPrivate() {
+		return nil
+	}
+	user, err := b.userRepo.FindByTelegramChatID(chat.ID)
+	if err != nil || user.Role != "admin" || user.Banned {
+		return nil
+	}
+	return user
+}
+
+// handleAdminCommand answers admin commands. Other chats get the same reply
+// as for an unknown command, so the commands are not advertised.
+func (b *Bot) handleAdminCommand(message *tgbotapi.Message) {
+	admin := b.adminFor(message.Chat)
+	if admin == nil {
+		b.sendUnknownCommand(message.Chat.ID)
+		return
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	switch message.Command() {
+	case "admin":
+		b.sendMessage(message.Chat.ID, adminHelp)
+	case "user":
+		if len(args) != 1 {
+			b.sendMessage(message.Chat.ID, "Usage: /user <email>")
+			return
+		}
+		b.sendMessage(message.Chat.ID, b.lookupUser(args[0]))
+	case "ban", "unban":
+		if len(args) != 1 {
+			b.sendMessage(message.Chat.ID, fmt.Sprintf("Usage: /%s <email>", message.Command()))
+			return
+		}
+		b.sendMessage(message.Chat.ID, b.setBanned(admin, args[0], message.Command() == "ban"))
+	case "grant":
+		b.sendMessage(message.Chat.ID, b.grantTraffic(admin, args))
+	case "offline":
+		b.sendMessage(message.Chat.ID, b.offlineNodes())
+	case "broadcast":
+		b.prepareBroadcast(message)
+	}
+}
+
+func (b *Bot) lookupUser(email string) string {
+	user, err := b.userRepo.FindByEmail(email)
+	if err != nil {
+		return fmt.Sprintf("No user with email %s.", email)
+	}
+
+	status := "active"
+	if user.Banned {
+		status = "banned"
+	}
+	plan := "none"
+	if user.Plan != nil {
+		plan = user.Plan.Name
+	}
+	telegramStatus := "not linked"
+	if user.TelegramChatID != nil {
+		telegramStatus = "linked"
+	}
+
+	text := fmt.Sprintf(
+		"User #%d: %s\n\n"+
+			"Role: %s\n"+
+			"Status: %s\n"+
+			"Plan: %s\n"+
+			"Telegram: %s",
+		user.ID,
+		user.Email,
+		user.Role,
+		status,
+		plan,
+		telegramStatus,
+	)
+
+	if usage, err := b.accountingSvc.GetCurrentUsage(user.ID); err == nil {
+		quota := usage.EffectiveQuota(user.Plan)
+		billable := usage.BillableBytesUp + usage.BillableBytesDown
+		text += fmt.Sprintf("\nUsage: %s of %s", formatBytes(billable), formatBytes(quota))
+		if quota > 0 {
+			text += fmt.Sprintf(" (%.1f%%)", float64(billable)/float64(quota)*100)
+		}
+		text += fmt.Sprintf(", resets %s", usage.PeriodEnd.Format("2006-01-02 15:04 MST"))
+	}
+	if packRemaining, err := b.packRepo.GetRemaining(user.ID, time.Now()); err == nil && packRemaining > 0 {
+		text += fmt.Sprintf("\nTraffic packs: %s left", formatBytes(packRemaining))
+	}
+	if subscription, err := b.subscriptionSvc.GetCurrent(user.ID); err == nil && subscription != nil {
+		text += fmt.Sprintf("\nSubscription: %s, expires %s", subscription.Status, subscription.ExpiresAt.Format("2006-01-02 15:04 MST"))
+	}
+	return text
+}
+
+func (b *Bot) setBanned(admin *models.User, email string, banned bool) string {
+	user, err := b.userRepo.FindByEmail(email)
+	if err != nil {
+		return fmt.Sprintf("No user with email %s.", email)
+	}
+	if user.ID == admin.ID {
+		return "You cannot ban yourself."
+	}
+	if user.Banned == banned {
+		if banned {
+			return fmt.Sprintf("%s is already banned.", user.Email)
+		}
+		return fmt.Sprintf("%s is not banned.", user.Email)
+	}
+
+	user.Banned = banned
+	if err := b.userRepo.Update(user); err != nil {
+		b.logger.Error("Failed to update user ban from Telegram", zap.Uint64("user_id", user.ID), zap.Error(err))
+		return "Failed to update the user. Please try again later."
+	}
+
+	reason := "user_unbanned"
+	if banned {
+		reason = "user_banned"
+	}
+	b.nodeUsers.UsersChanged(reason, user.ID)
+	b.logger.Info("User ban changed from Telegram",
+		zap.Uint64("admin_id", admin.ID),
+		zap.Uint64("user_id", user.ID),
+		zap.Bool("banned", banned),
+	)
+
+	if banned {
+		return fmt.Sprintf("%s has been banned.", user.Email)
+	}
+	return fmt.Sprintf("%s has been unbanned.", user.Email)
+}
+
+func (b *Bot) grantTraffic(admin *models.User, args []string) string {
+	const usage = "Usage: /grant <email> <GiB> [days]"
+	if len(args) < 2 || len(args) > 3 {
+		return usage
+	}
+
+	gib, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || gib <= 0 || gib > 1<<20 {
+		return "The amount must be a positive number of GiB, at most 1048576.\n\n" + usage
+	}
+
+	var expiresAt *time.Time
+	if len(args) == 3 {
+		days, err := strconv.Atoi(args[2])
+		if err != nil || days <= 0 {
+			return "Days must be a positive whole number.\n\n" + usage
+		}
+		expiry := time.Now().AddDate(0, 0, days)
+		expiresAt = &expiry
+	}
+
+	user, err := b.userRepo.FindByEmail(args[0])
+	if err != nil {
+		return fmt.Sprintf("No user with email %s.", args[0])
+	}
+
+	pack := &models.TrafficPack{
+		UserID:    user.ID,
+		Bytes:     uint64(gib * (1 << 30)),
+		Source:    "grant",
+		Note:      "Granted on Telegram by " + admin.Email,
+		ExpiresAt: expiresAt,
+	}
+	if err := b.packRepo.Create(pack); err != nil {
+		b.logger.Error("Failed to grant traffic pack from Telegram", zap.Uint64("user_id", user.ID), zap.Error(err))
+		return "Failed to grant the traffic pack. Please try again later."
+	}
+	b.nodeUsers.UsersChanged("pack_granted", user.ID)
+	b.logger.Info("Traffic pack granted from Telegram",
+		zap.Uint64("admin_id", admin.ID),
+		zap.Uint64("user_id", user.ID),
+		zap.Uint64("pack_id", pack.ID),
+		zap.Uint64("bytes", pack.Bytes),
+	)
+
+	text := fmt.Sprintf("Granted %s to %s", formatBytes(pack.Bytes), user.Email)
+	if expiresAt != nil {
+		text += fmt.Sprintf(", expiring %s", expiresAt.Format("2006-01-02 15:04 MST"))
+	}
+	return text + "."
+}
+
+func (b *Bot) offlineNodes() string {
+	nodes, err := b.nodeRepo.FindActiveNodes()
+	if err != nil {
+		b.logger.Error("Failed to list nodes for Telegram", zap.Error(err))
+		return "Failed to list nodes. Please try again later."
+	}
+
+	var sb strings.Builder
+	for _, node := range nodes {
+		if node.HealthState == "online" {
+			continue
+		}
+		lastSeen := "never"
+		if node.LastSeenAt != nil {
+			lastSeen = node.LastSeenAt.Format("2006-01-02 15:04 MST")
+		}
+		fmt.Fprintf(&sb, "\n%s (%s): %s, last seen %s", node.Name, node.Host, node.HealthState, lastSeen)
+	}
+	if sb.Len() == 0 {
+		return fmt.Sprintf("All %d active nodes are online.", len(nodes))
+	}
+	return "Active nodes not online:\n" + sb.String()
+}
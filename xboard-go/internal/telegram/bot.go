@@ -1,22 +1,59 @@
 package telegram
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/config"
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/metrics"
 	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/repository"
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/service"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 type Bot struct {
-	bot      *tgbotapi.BotAPI
-	userRepo repository.UserRepository
-	logger   *zap.Logger
+	bot             *tgbotapi.BotAPI
+	serverCfg       *config.ServerConfig
+	userRepo        repository.UserRepository
+	nodeRepo        repository.NodeRepository
+	planRepo        repository.PlanRepository
+	packRepo        repository.TrafficPackRepository
+	accountingSvc   service.AccountingService
+	subscriptionSvc service.SubscriptionService
+	authService     service.AuthService
+	multipliers     service.MultiplierResolver
+	linkSvc         service.TelegramLinkService
+	nodeUsers       service.NodeUserService
+	logger          *zap.Logger
+
+	broadcasts chan broadcastJob
+	// pendingBroadcasts holds announcements awaiting confirmation, by the
+	// admin's chat
+	pendingMu         sync.Mutex
+	pendingBroadcasts map[int64]string
 }
 
-func NewBot(cfg *config.TelegramConfig, userRepo repository.UserRepository, logger *zap.Logger) (*Bot, error) {
+func NewBot(
+	cfg *config.TelegramConfig,
+	serverCfg *config.ServerConfig,
+	userRepo repository.UserRepository,
+	nodeRepo repository.NodeRepository,
+	planRepo repository.PlanRepository,
+	packRepo repository.TrafficPackRepository,
+	accountingSvc service.AccountingService,
+	subscriptionSvc service.SubscriptionService,
+	authService service.AuthService,
+	multipliers service.MultiplierResolver,
+	linkSvc service.TelegramLinkService,
+	nodeUsers service.NodeUserService,
+	logger *zap.Logger,
+) (*Bot, error) {
 	if cfg.Token == "" {
 		logger.Warn("Telegram token not configured, bot will not start")
 		return nil, nil
@@ -29,10 +66,35 @@ func NewBot(cfg *config.TelegramConfig, userRepo repository.UserRepository, logg
 
 	logger.Info("Telegram bot authorized", zap.String("username", bot.Self.UserName))
 
+	// Shown in the command menu of Telegram clients
+	if _, err := bot.Request(tgbotapi.NewSetMyCommands(
+		tgbotapi.BotCommand{Command: "usage", Description: "Usage in the current period"},
+		tgbotapi.BotCommand{Command: "nodes", Description: "Nodes on your plan"},
+		tgbotapi.BotCommand{Command: "plan", Description: "Your plan and subscription"},
+		tgbotapi.BotCommand{Command: "sub", Description: "Your subscription link"},
+		tgbotapi.BotCommand{Command: "resetsub", Description: "Reset your subscription link"},
+		tgbotapi.BotCommand{Command: "link", Description: "Link your account"},
+		tgbotapi.BotCommand{Command: "unlink", Description: "Unlink your account"},
+	)); err != nil {
+		logger.Warn("Failed to register Telegram bot commands", zap.Error(err))
+	}
+
 	return &Bot{
-		bot:      bot,
-		userRepo: userRepo,
-		logger:   logger,
+		bot:               bot,
+		serverCfg:         serverCfg,
+		userRepo:          userRepo,
+		nodeRepo:          nodeRepo,
+		planRepo:          planRepo,
+		packRepo:          packRepo,
+		accountingSvc:     accountingSvc,
+		subscriptionSvc:   subscriptionSvc,
+		authService:       authService,
+		multipliers:       multipliers,
+		linkSvc:           linkSvc,
+		nodeUsers:         nodeUsers,
+		logger:            logger,
+		broadcasts:        make(chan broadcastJob, broadcastQueueSize),
+		pendingBroadcasts: make(map[int64]string),
 	}, nil
 }
 
@@ -41,17 +103,20 @@ func (b *Bot) Start() {
 		return
 	}
 
+	go b.runBroadcasts()
+
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
 	updates := b.bot.GetUpdatesChan(u)
 
 	for update := range updates {
-		if update.Message == nil {
-			continue
+		switch {
+		case update.CallbackQuery != nil:
+			b.handleCallback(update.CallbackQuery)
+		case update.Message != nil:
+			b.handleMessage(update.Message)
 		}
-
-		b.handleMessage(update.Message)
 	}
 }
 
@@ -65,15 +130,25 @@ func (b *Bot) handleMessage(message *tgbotapi.Message) {
 func (b *Bot) handleCommand(message *tgbotapi.Message) {
 	switch message.Command() {
 	case "start":
+		// Deep links (t.me/<bot>?start=<token>) arrive as /start <token>
+		if token := strings.TrimSpace(message.CommandArguments()); token != "" {
+			b.handleLink(message, token)
+			return
+		}
+		if _, err := b.userRepo.FindByTelegramChatID(message.Chat.ID); err == nil {
+			b.handleViewCommand(message, viewMenu)
+			return
+		}
 		b.sendMessage(message.Chat.ID, "Welcome! Use /link <token> to link your account.")
 	case "link":
-		// TODO: Implement token-based linking
-		args := message.CommandArguments()
-		if args == "" {
+		token := strings.TrimSpace(message.CommandArguments())
+		if token == "" {
 			b.sendMessage(message.Chat.ID, "Usage: /link <token>")
 			return
 		}
-		b.sendMessage(message.Chat.ID, "Token linking not yet implemented. Token: "+args)
+		b.handleLink(message, token)
+	case "unlink":
+		b.handleUnlink(message)
 	case "status":
 		// Check if user is linked
 		user, err := b.userRepo.FindByTelegramChatID(message.Chat.ID)
@@ -82,9 +157,65 @@ func (b *Bot) handleCommand(message *tgbotapi.Message) {
 			return
 		}
 		b.sendMessage(message.Chat.ID, fmt.Sprintf("Your account (%s) is linked!", user.Email))
+	case "usage":
+		b.handleViewCommand(message, viewUsage)
+	case "nodes":
+		b.handleViewCommand(message, viewNodes)
+	case "plan":
+		b.handleViewCommand(message, viewPlan)
+	case "sub":
+		b.handleViewCommand(message, viewSub)
+	case "resetsub":
+		b.handleViewCommand(message, viewResetSub)
+	case "admin", "user", "ban", "unban", "grant", "offline", "broadcast":
+		b.handleAdminCommand(message)
+	default:
+		b.sendUnknownCommand(message.Chat.ID)
+	}
+}
+
+func (b *Bot) sendUnknownCommand(chatID int64) {
+	b.sendMessage(chatID, "Unknown command. Available commands: /start, /link, /unlink, /status, /usage, /nodes, /plan, /sub, /resetsub")
+}
+
+func (b *Bot) handleLink(message *tgbotapi.Message, token string) {
+	// Notifications are personal, so only private chats can be linked
+	if !message.Chat.IsPrivate() {
+		b.sendMessage(message.Chat.ID, "Accounts can only be linked in a private chat with the bot.")
+		return
+	}
+
+	user, err := b.linkSvc.Link(token, message.Chat.ID)
+	switch {
+	case err == nil:
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("Your account (%s) is now linked. Notifications will be sent here.", user.Email))
+	case errors.Is(err, service.ErrChatAlreadyLinked):
+		linked, err := b.userRepo.FindByTelegramChatID(message.Chat.ID)
+		if err != nil {
+			b.sendMessage(message.Chat.ID, "This chat is already linked to an account. Use /unlink first.")
+			return
+		}
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("This chat is already linked to %s. Use /unlink first.", linked.Email))
+	case errors.Is(err, service.ErrInvalidLinkToken):
+		b.sendMessage(message.Chat.ID, "This link token is invalid, already used or expired. Generate a new one in your dashboard.")
 	default:
-		b.sendMessage(message.Chat.ID, "Unknown command. Available commands: /start, /link, /status")
+		b.logger.Error("Failed to link Telegram chat", zap.Int64("chat_id", message.Chat.ID), zap.Error(err))
+		b.sendMessage(message.Chat.ID, "Failed to link your account. Please try again later.")
+	}
+}
+
+func (b *Bot) handleUnlink(message *tgbotapi.Message) {
+	user, err := b.linkSvc.Unlink(message.Chat.ID)
+	if err == gorm.ErrRecordNotFound {
+		b.sendMessage(message.Chat.ID, "This chat is not linked to any account.")
+		return
+	}
+	if err != nil {
+		b.logger.Error("Failed to unlink Telegram chat", zap.Int64("chat_id", message.Chat.ID), zap.Error(err))
+		b.sendMessage(message.Chat.ID, "Failed to unlink your account. Please try again later.")
+		return
 	}
+	b.sendMessage(message.Chat.ID, fmt.Sprintf("Your account (%s) has been unlinked. You will no longer receive notifications here.", user.Email))
 }
 
 func (b *Bot) sendMessage(chatID int64, text string) {
@@ -134,6 +265,53 @@ func FormatUsageNotification(email string, realUp, realDown, billableUp, billabl
 	)
 }
 
+func FormatSubscriptionExpiredNotification(fallbackPlan string) string {
+	if fallbackPlan == "" {
+		return "Your subscription has expired and your plan has been removed.\n\n" +
+			"Renew your subscription to continue using the service."
+	}
+	return fmt.Sprintf(
+		"Your subscription has expired.\n\n"+
+			"You have been moved to the %s plan. Renew your subscription to restore your previous plan.",
+		fallbackPlan,
+	)
+}
+
+func FormatSubscriptionRenewedNotification(expiresAt time.Time) string {
+	return fmt.Sprintf(
+		"Your subscription has been renewed automatically.\n\n"+
+			"New expiry date: %s",
+		expiresAt.Format("2006-01-02 15:04 MST"),
+	)
+}
+
+func FormatNodeDownAlert(name, host string, lastSeenAt *time.Time) string {
+	lastSeen := "never"
+	if lastSeenAt != nil {
+		lastSeen = lastSeenAt.Format("2006-01-02 15:04 MST")
+	}
+	return fmt.Sprintf(
+		"Node offline: %s (%s)\n\n"+
+			"Last heartbeat: %s",
+		name,
+		host,
+		lastSeen,
+	)
+}
+
+func FormatNodeRecoveredAlert(name, host string, downSince *time.Time) string {
+	if downSince == nil {
+		return fmt.Sprintf("Node recovered: %s (%s)", name, host)
+	}
+	return fmt.Sprintf(
+		"Node recovered: %s (%s)\n\n"+
+			"Downtime: %s",
+		name,
+		host,
+		time.Since(*downSince).Round(time.Minute),
+	)
+}
+
 func formatBytes(bytes uint64) string {
 	const unit = 1024
 	if bytes < unit {
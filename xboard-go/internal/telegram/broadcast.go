@@ -0,0 +1,147 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	// broadcastInterval spaces broadcast messages to stay below Telegram's
+	// limit of about 30 messages per second across chats
+	broadcastInterval = time.Second / 25
+	// broadcastQueueSize bounds the broadcasts waiting to be sent
+	broadcastQueueSize = 8
+	// broadcastRetries is how often a message hitting flood control is
+	// retried after the wait Telegram asks for
+	broadcastRetries = 3
+
+	callbackBroadcastSend   = "broadcast_send"
+	callbackBroadcastCancel = "broadcast_cancel"
+)
+
+// broadcastJob is a confirmed announcement waiting to be sent
+type broadcastJob struct {
+	adminID     uint64
+	adminChatID int64
+	text        string
+}
+
+// prepareBroadcast holds the announcement until the admin confirms it
+func (b *Bot) prepareBroadcast(message *tgbotapi.Message) {
+	text := strings.TrimSpace(message.CommandArguments())
+	if text == "" {
+		b.sendMessage(message.Chat.ID, "Usage: /broadcast <message>")
+		return
+	}
+
+	recipients, err := b.userRepo.FindLinkedUsers()
+	if err != nil {
+		b.logger.Error("Failed to list broadcast recipients", zap.Error(err))
+		b.sendMessage(message.Chat.ID, "Failed to list recipients. Please try again later.")
+		return
+	}
+
+	b.pendingMu.Lock()
+	b.pendingBroadcasts[message.Chat.ID] = text
+	b.pendingMu.Unlock()
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(
+		"Send this announcement to %d linked users?\n\n%s", len(recipients), text,
+	))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Send", callbackBroadcastSend),
+			tgbotapi.NewInlineKeyboardButtonData("Cancel", callbackBroadcastCancel),
+		),
+	)
+	if _, err := b.bot.Send(msg); err != nil {
+		b.logger.Error("Failed to send Telegram message", zap.Error(err))
+	}
+}
+
+// decideBroadcast queues or drops the announcement waiting for the admin's
+// confirmation, returning the text the confirmation prompt is replaced with
+func (b *Bot) decideBroadcast(chat *tgbotapi.Chat, decision string) string {
+	admin := b.adminFor(chat)
+	if admin == nil {
+		return "This button is no longer supported."
+	}
+
+	b.pendingMu.Lock()
+	text, ok := b.pendingBroadcasts[chat.ID]
+	delete(b.pendingBroadcasts, chat.ID)
+	b.pendingMu.Unlock()
+	if !ok {
+		return "No announcement is waiting to be sent."
+	}
+
+	if decision == callbackBroadcastCancel {
+		return "Announcement cancelled."
+	}
+
+	select {
+	case b.broadcasts <- broadcastJob{adminID: admin.ID, adminChatID: chat.ID, text: text}:
+		b.logger.Info("Broadcast queued", zap.Uint64("admin_id", admin.ID))
+		return "Announcement queued. You will get a report once it has been sent."
+	default:
+		return "Too many announcements are queued. Please try again later."
+	}
+}
+
+// runBroadcasts sends queued announcements one at a time, so together they
+// stay within Telegram's rate limit
+func (b *Bot) runBroadcasts() {
+	for job := range b.broadcasts {
+		recipients, err := b.userRepo.FindLinkedUsers()
+		if err != nil {
+			b.logger.Error("Failed to list broadcast recipients", zap.Error(err))
+			b.sendMessage(job.adminChatID, "Failed to send the announcement: recipients could not be listed.")
+			continue
+		}
+
+		delivered, failed := 0, 0
+		ticker := time.NewTicker(broadcastInterval)
+		for _, user := range recipients {
+			<-ticker.C
+			if err := b.deliverBroadcast(*user.TelegramChatID, job.text); err != nil {
+				// Users who blocked the bot end up here too
+				b.logger.Debug("Failed to deliver broadcast",
+					zap.Uint64("user_id", user.ID),
+					zap.Error(err),
+				)
+				failed++
+				continue
+			}
+			delivered++
+		}
+		ticker.Stop()
+
+		b.logger.Info("Broadcast sent",
+			zap.Uint64("admin_id", job.adminID),
+			zap.Int("delivered", delivered),
+			zap.Int("failed", failed),
+		)
+		b.sendMessage(job.adminChatID, fmt.Sprintf(
+			"Announcement sent: delivered to %d of %d linked users, %d failed.",
+			delivered, len(recipients), failed,
+		))
+	}
+}
+
+// deliverBroadcast sends one announcement, waiting out flood control
+func (b *Bot) deliverBroadcast(chatID int64, text string) error {
+	for attempt := 0; ; attempt++ {
+		err := b.SendNotification(chatID, text, "broadcast")
+
+		var tgErr *tgbotapi.Error
+		if err == nil || attempt >= broadcastRetries || !errors.As(err, &tgErr) || tgErr.RetryAfter <= 0 {
+			return err
+		}
+		time.Sleep(time.Duration(tgErr.RetryAfter) * time.Second)
+	}
+}
@@ -0,0 +1,292 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/KexiChanProjectProxy/Next-Board/xboard-go/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// Views of a linked account, shown by the command of the same name and by
+// the inline keyboard buttons, whose callback data is the view name
+const (
+	viewMenu            = "menu"
+	viewUsage           = "usage"
+	viewNodes           = "nodes"
+	viewPlan            = "plan"
+	viewSub             = "sub"
+	viewResetSub        = "resetsub"
+	viewResetSubConfirm = "resetsub_confirm"
+)
+
+// privateViews show the subscription URL, which grants access to the
+// account's nodes, so they are never shown in group chats
+var privateViews = map[string]bool{
+	viewSub:             true,
+	viewResetSub:        true,
+	viewResetSubConfirm: true,
+}
+
+// handleViewCommand answers a command showing one of the account views
+func (b *Bot) handleViewCommand(message *tgbotapi.Message, view string) {
+	text, keyboard := b.render(message.Chat, view)
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	if keyboard != nil {
+		msg.ReplyMarkup = *keyboard
+	}
+	if _, err := b.bot.Send(msg); err != nil {
+		b.logger.Error("Failed to send Telegram message", zap.Error(err))
+	}
+}
+
+// handleCallback switches the message a button was pressed on to the view
+// the button names, or answers a broadcast confirmation
+func (b *Bot) handleCallback(query *tgbotapi.CallbackQuery) {
+	if _, err := b.bot.Request(tgbotapi.NewCallback(query.ID, "")); err != nil {
+		b.logger.Debug("Failed to answer callback query", zap.Error(err))
+	}
+	if query.Message == nil {
+		return
+	}
+
+	var text string
+	var keyboard *tgbotapi.InlineKeyboardMarkup
+	switch query.Data {
+	case callbackBroadcastSend, callbackBroadcastCancel:
+		text = b.decideBroadcast(query.Message.Chat, query.Data)
+	default:
+		text, keyboard = b.render(query.Message.Chat, query.Data)
+	}
+
+	var edit tgbotapi.EditMessageTextConfig
+	if keyboard != nil {
+		edit = tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, text, *keyboard)
+	} else {
+		edit = tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	}
+	if _, err := b.bot.Send(edit); err != nil {
+		// Pressing a button that leaves the message unchanged fails too
+		b.logger.Debug("Failed to edit Telegram message", zap.Error(err))
+	}
+}
+
+// render builds a view of the account linked to the chat
+func (b *Bot) render(chat *tgbotapi.Chat, view string) (string, *tgbotapi.InlineKeyboardMarkup) {
+	if privateViews[view] && !chat.IsPrivate() {
+		return "Your subscription link can only be shown in a private chat with the bot.", nil
+	}
+
+	user, err := b.userRepo.FindByTelegramChatID(chat.ID)
+	if err != nil {
+		return "Your account is not linked. Use /link <token> to link.", nil
+	}
+	if user.Banned {
+		return "Your account is banned.", nil
+	}
+
+	var text string
+	switch view {
+	case viewMenu:
+		text = fmt.Sprintf("Account: %s\n\nChoose what to show.", user.Email)
+	case viewUsage:
+		text, err = b.usageText(user)
+	case viewNodes:
+		text, err = b.nodesText(user)
+	case viewPlan:
+		text, err = b.planText(user)
+	case viewSub:
+		text, err = b.subText(user, false)
+	case viewResetSub:
+		return "Reset your subscription link?\n\n" +
+			"The current link stops working and clients must import the new one.", confirmResetKeyboard()
+	case viewResetSubConfirm:
+		text, err = b.subText(user, true)
+	default:
+		return "This button is no longer supported.", menuKeyboard(chat.IsPrivate())
+	}
+	if err != nil {
+		b.logger.Error("Failed to render Telegram view",
+			zap.String("view", view),
+			zap.Uint64("user_id", user.ID),
+			zap.Error(err),
+		)
+		text = "Something went wrong. Please try again later."
+	}
+
+	if view == viewSub || view == viewResetSubConfirm {
+		return text, subKeyboard()
+	}
+	return text, menuKeyboard(chat.IsPrivate())
+}
+
+func (b *Bot) usageText(user *models.User) (string, error) {
+	usage, err := b.accountingSvc.GetCurrentUsage(user.ID)
+	if err != nil {
+		return "No usage recorded in the current period yet.", nil
+	}
+
+	quota := usage.EffectiveQuota(user.Plan)
+	billable := usage.BillableBytesUp + usage.BillableBytesDown
+	var percentUsed float64
+	if quota > 0 {
+		percentUsed = float64(billable) / float64(quota) * 100
+	}
+
+	text := FormatUsageNotification(
+		user.Email,
+		usage.RealBytesUp,
+		usage.RealBytesDown,
+		usage.BillableBytesUp,
+		usage.BillableBytesDown,
+		quota,
+		percentUsed,
+	)
+
+	packRemaining, err := b.packRepo.GetRemaining(user.ID, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if packRemaining > 0 {
+		text += fmt.Sprintf("\nTraffic packs: %s left", formatBytes(packRemaining))
+	}
+	return text + fmt.Sprintf("\nResets: %s", usage.PeriodEnd.Format("2006-01-02 15:04 MST")), nil
+}
+
+func (b *Bot) nodesText(user *models.User) (string, error) {
+	if user.PlanID == nil {
+		return "You have no plan, so no nodes are available.", nil
+	}
+	plan, err := b.planRepo.FindByIDWithLabels(*user.PlanID)
+	if err != nil {
+		return "You have no plan, so no nodes are available.", nil
+	}
+
+	nodes, err := b.nodeRepo.FindActiveNodes()
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	now := time.Now()
+	for _, node := range nodes {
+		if !plan.AllowsNode(&node) {
+			continue
+		}
+		multiplier, err := b.multipliers.Resolve(plan.ID, node.ID, now)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, "\n%s: %s, x%s", node.Name, node.HealthState, formatMultiplier(multiplier))
+	}
+	if sb.Len() == 0 {
+		return fmt.Sprintf("Your plan (%s) includes no nodes right now.", plan.Name), nil
+	}
+	return fmt.Sprintf("Nodes on your plan (%s)\n%s\n\nTraffic counts against your quota at the multiplier shown.", plan.Name, sb.String()), nil
+}
+
+func (b *Bot) planText(user *models.User) (string, error) {
+	subscription, err := b.subscriptionSvc.GetCurrent(user.ID)
+	if err != nil {
+		return "", err
+	}
+
+	// A lapsed subscription no longer grants its plan, even before the
+	// expiry job has moved the user to the fallback plan
+	if user.Plan == nil || (subscription != nil && !subscription.IsActive(time.Now())) {
+		return "You have no active plan.", nil
+	}
+
+	text := fmt.Sprintf(
+		"Plan: %s\n\n"+
+			"Quota: %s\n"+
+			"Resets: %s",
+		user.Plan.Name,
+		formatBytes(user.Plan.QuotaBytes),
+		user.Plan.ResetPeriod,
+	)
+	if user.Plan.SpeedLimit > 0 {
+		text += fmt.Sprintf("\nSpeed limit: %d Mbps", user.Plan.SpeedLimit)
+	}
+	if user.Plan.DeviceLimit > 0 {
+		text += fmt.Sprintf("\nDevice limit: %d", user.Plan.DeviceLimit)
+	}
+
+	if subscription != nil {
+		renewal := "expires"
+		if subscription.AutoRenew {
+			renewal = "renews"
+		}
+		text += fmt.Sprintf("\n\nSubscription %s %s", renewal, subscription.ExpiresAt.Format("2006-01-02 15:04 MST"))
+	}
+	return text, nil
+}
+
+// subText shows the subscription URL, issuing a token on first use or
+// rotating it when reset is set
+func (b *Bot) subText(user *models.User, reset bool) (string, error) {
+	base := strings.TrimRight(b.serverCfg.PublicURL, "/")
+	if base == "" {
+		return "Your subscription link is only available in the dashboard.", nil
+	}
+
+	var token string
+	var err error
+	if reset || user.Token == nil {
+		if token, err = b.authService.ResetSubscribeToken(user); err != nil {
+			return "", err
+		}
+	} else {
+		token = *user.Token
+	}
+
+	url := base + "/sub/" + token
+	if reset {
+		return "Your subscription link has been reset. Import the new link in your clients:\n\n" + url, nil
+	}
+	return "Your subscription link. Keep it private, it grants access to your nodes:\n\n" + url, nil
+}
+
+func menuKeyboard(private bool) *tgbotapi.InlineKeyboardMarkup {
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Usage", viewUsage),
+			tgbotapi.NewInlineKeyboardButtonData("Plan", viewPlan),
+			tgbotapi.NewInlineKeyboardButtonData("Nodes", viewNodes),
+		),
+	}
+	if private {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Subscription link", viewSub),
+		))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &keyboard
+}
+
+func subKeyboard() *tgbotapi.InlineKeyboardMarkup {
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Reset link", viewResetSub),
+			tgbotapi.NewInlineKeyboardButtonData("Back", viewMenu),
+		),
+	)
+	return &keyboard
+}
+
+func confirmResetKeyboard() *tgbotapi.InlineKeyboardMarkup {
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Yes, reset", viewResetSubConfirm),
+			tgbotapi.NewInlineKeyboardButtonData("Cancel", viewSub),
+		),
+	)
+	return &keyboard
+}
+
+func formatMultiplier(multiplier float64) string {
+	return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.2f", multiplier), "0"), ".")
+}
@@ -0,0 +1,10 @@
+-- Remove per-user reset anchor fields and prorated period quotas
+
+ALTER TABLE usage_periods
+    DROP COLUMN quota_bytes;
+
+ALTER TABLE users
+    DROP COLUMN reset_timezone,
+    DROP COLUMN reset_month,
+    DROP COLUMN reset_weekday,
+    DROP COLUMN reset_day;
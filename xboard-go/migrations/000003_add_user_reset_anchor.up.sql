@@ -0,0 +1,11 @@
+-- Add per-user reset anchor fields and prorated period quotas
+-- Anchors let usage periods reset on a user's purchase day instead of the calendar
+
+ALTER TABLE users
+    ADD COLUMN reset_day TINYINT NULL COMMENT 'Day of month (1-31) usage periods reset on',
+    ADD COLUMN reset_weekday TINYINT NULL COMMENT 'Weekday (0=Sunday) weekly periods reset on',
+    ADD COLUMN reset_month TINYINT NULL COMMENT 'Month (1-12) yearly periods reset on',
+    ADD COLUMN reset_timezone VARCHAR(64) NULL COMMENT 'IANA time zone for period boundaries';
+
+ALTER TABLE usage_periods
+    ADD COLUMN quota_bytes BIGINT UNSIGNED NULL COMMENT 'Prorated quota override, NULL uses the plan quota' AFTER billable_bytes_down;
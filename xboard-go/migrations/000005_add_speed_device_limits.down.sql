@@ -0,0 +1,9 @@
+-- Remove speed and device limits
+
+ALTER TABLE users
+    DROP COLUMN device_limit,
+    DROP COLUMN speed_limit;
+
+ALTER TABLE plans
+    DROP COLUMN device_limit,
+    DROP COLUMN speed_limit;
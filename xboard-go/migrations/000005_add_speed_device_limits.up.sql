@@ -0,0 +1,9 @@
+-- Add speed and device limits to plans, with optional per-user overrides
+
+ALTER TABLE plans
+    ADD COLUMN speed_limit BIGINT UNSIGNED NOT NULL DEFAULT 0 COMMENT 'Speed limit in Mbps, 0 = unlimited' AFTER base_multiplier,
+    ADD COLUMN device_limit INT UNSIGNED NOT NULL DEFAULT 0 COMMENT 'Concurrent device limit, 0 = unlimited' AFTER speed_limit;
+
+ALTER TABLE users
+    ADD COLUMN speed_limit BIGINT UNSIGNED NULL COMMENT 'Speed limit override in Mbps, NULL uses the plan',
+    ADD COLUMN device_limit INT UNSIGNED NULL COMMENT 'Device limit override, NULL uses the plan';
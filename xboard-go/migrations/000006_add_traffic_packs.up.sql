@@ -0,0 +1,19 @@
+-- Traffic add-on packs that stack on top of the plan quota
+-- Packs are consumed only after the period's base quota is exhausted
+
+CREATE TABLE IF NOT EXISTS traffic_packs (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    bytes BIGINT UNSIGNED NOT NULL,
+    used_bytes BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    priority INT NOT NULL DEFAULT 0,
+    source ENUM('grant', 'purchase') NOT NULL DEFAULT 'grant',
+    note VARCHAR(255) NOT NULL DEFAULT '',
+    expires_at TIMESTAMP NULL DEFAULT NULL,
+    revoked_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_user_id (user_id),
+    INDEX idx_expires_at (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
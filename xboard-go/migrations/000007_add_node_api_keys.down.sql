@@ -0,0 +1,5 @@
+ALTER TABLE nodes
+    DROP COLUMN api_key_rotated_at,
+    DROP COLUMN previous_api_key_expires_at,
+    DROP COLUMN previous_api_key_hash,
+    DROP COLUMN api_key_hash;
@@ -0,0 +1,9 @@
+-- Per-node API keys, stored as SHA-256 hex digests
+-- Nodes without a key keep authenticating with the global server token
+-- unless node.disable_global_token is set
+
+ALTER TABLE nodes
+    ADD COLUMN api_key_hash CHAR(64) NULL AFTER last_seen_at,
+    ADD COLUMN previous_api_key_hash CHAR(64) NULL AFTER api_key_hash,
+    ADD COLUMN previous_api_key_expires_at TIMESTAMP NULL DEFAULT NULL AFTER previous_api_key_hash,
+    ADD COLUMN api_key_rotated_at TIMESTAMP NULL DEFAULT NULL AFTER previous_api_key_expires_at;
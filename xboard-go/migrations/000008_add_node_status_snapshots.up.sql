@@ -0,0 +1,17 @@
+-- Node status reports, snapshotted once a minute from memory
+
+CREATE TABLE IF NOT EXISTS node_status_snapshots (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    node_id BIGINT UNSIGNED NOT NULL,
+    cpu DECIMAL(5,2) NOT NULL DEFAULT 0,
+    mem_total BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    mem_used BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    swap_total BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    swap_used BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    disk_total BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    disk_used BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    reported_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (node_id) REFERENCES nodes(id) ON DELETE CASCADE,
+    INDEX idx_node_reported (node_id, reported_at),
+    INDEX idx_reported_at (reported_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS node_events;
+
+ALTER TABLE nodes
+    DROP COLUMN health_changed_at,
+    DROP COLUMN health_state;
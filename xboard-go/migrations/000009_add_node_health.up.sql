@@ -0,0 +1,17 @@
+-- Node health derived from heartbeat age, with a log of state changes
+
+ALTER TABLE nodes
+    ADD COLUMN health_state ENUM('unknown', 'online', 'degraded', 'offline') NOT NULL DEFAULT 'unknown' AFTER api_key_rotated_at,
+    ADD COLUMN health_changed_at TIMESTAMP NULL DEFAULT NULL AFTER health_state;
+
+CREATE TABLE IF NOT EXISTS node_events (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    node_id BIGINT UNSIGNED NOT NULL,
+    from_state VARCHAR(20) NOT NULL,
+    to_state VARCHAR(20) NOT NULL,
+    last_seen_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (node_id) REFERENCES nodes(id) ON DELETE CASCADE,
+    INDEX idx_node_id (node_id),
+    INDEX idx_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS node_push_receipts;
+
+ALTER TABLE nodes
+    DROP COLUMN last_push_seq;
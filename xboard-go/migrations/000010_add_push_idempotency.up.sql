@@ -0,0 +1,15 @@
+-- Idempotent traffic pushes: request IDs remembered per node, and the
+-- highest sequence number each node has pushed
+
+ALTER TABLE nodes
+    ADD COLUMN last_push_seq BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER health_changed_at;
+
+CREATE TABLE IF NOT EXISTS node_push_receipts (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    node_id BIGINT UNSIGNED NOT NULL,
+    request_id VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (node_id) REFERENCES nodes(id) ON DELETE CASCADE,
+    UNIQUE KEY idx_node_request (node_id, request_id),
+    INDEX idx_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
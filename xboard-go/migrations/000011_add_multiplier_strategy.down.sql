@@ -0,0 +1,5 @@
+ALTER TABLE labels
+    DROP COLUMN priority;
+
+ALTER TABLE plans
+    DROP COLUMN multiplier_strategy;
@@ -0,0 +1,8 @@
+-- Per-plan strategy for combining label multipliers, and label priority for
+-- the priority strategy
+
+ALTER TABLE plans
+    ADD COLUMN multiplier_strategy ENUM('product', 'max', 'min', 'priority', 'sum') NOT NULL DEFAULT 'product' AFTER device_limit;
+
+ALTER TABLE labels
+    ADD COLUMN priority INT NOT NULL DEFAULT 0 AFTER description;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS multiplier_schedules;
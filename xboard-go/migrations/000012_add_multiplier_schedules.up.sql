@@ -0,0 +1,24 @@
+-- Recurring time windows that scale the multiplier of a node, a label or a
+-- plan, e.g. off-peak discounts
+
+CREATE TABLE IF NOT EXISTS multiplier_schedules (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    node_id BIGINT UNSIGNED NULL DEFAULT NULL,
+    label_id BIGINT UNSIGNED NULL DEFAULT NULL,
+    plan_id BIGINT UNSIGNED NULL DEFAULT NULL,
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    weekdays TINYINT UNSIGNED NOT NULL DEFAULT 127 COMMENT 'Bit 0 is Sunday, bit 6 Saturday',
+    start_time CHAR(5) NOT NULL COMMENT 'HH:MM',
+    end_time CHAR(5) NOT NULL COMMENT 'HH:MM, before start_time wraps past midnight',
+    multiplier DECIMAL(10,4) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (node_id) REFERENCES nodes(id) ON DELETE CASCADE,
+    FOREIGN KEY (label_id) REFERENCES labels(id) ON DELETE CASCADE,
+    FOREIGN KEY (plan_id) REFERENCES plans(id) ON DELETE CASCADE,
+    INDEX idx_node_id (node_id),
+    INDEX idx_label_id (label_id),
+    INDEX idx_plan_id (plan_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,11 @@
+ALTER TABLE traffic_packs
+    DROP INDEX idx_user_usable;
+
+ALTER TABLE subscriptions
+    DROP INDEX idx_user_status_expires;
+
+ALTER TABLE users
+    DROP INDEX idx_plan_banned;
+
+ALTER TABLE plan_labels
+    DROP INDEX idx_label_plan;
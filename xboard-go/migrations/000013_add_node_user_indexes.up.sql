@@ -0,0 +1,13 @@
+-- Indexes for the joined query that builds a node's user list
+
+ALTER TABLE plan_labels
+    ADD INDEX idx_label_plan (label_id, plan_id);
+
+ALTER TABLE users
+    ADD INDEX idx_plan_banned (plan_id, banned);
+
+ALTER TABLE subscriptions
+    ADD INDEX idx_user_status_expires (user_id, status, expires_at);
+
+ALTER TABLE traffic_packs
+    ADD INDEX idx_user_usable (user_id, revoked_at, expires_at);
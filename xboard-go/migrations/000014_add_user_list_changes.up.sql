@@ -0,0 +1,12 @@
+-- Log of events that may change node user lists. The latest id is the user
+-- list version nodes fetch deltas from.
+
+CREATE TABLE IF NOT EXISTS user_list_changes (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NULL DEFAULT NULL,
+    plan_id BIGINT UNSIGNED NULL DEFAULT NULL,
+    node_id BIGINT UNSIGNED NULL DEFAULT NULL,
+    reason VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
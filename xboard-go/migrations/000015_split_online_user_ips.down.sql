@@ -0,0 +1,6 @@
+DELETE FROM online_users;
+
+ALTER TABLE online_users
+    DROP INDEX unique_user_node_ip,
+    DROP COLUMN node_identifier,
+    ADD UNIQUE KEY unique_user_node_ip (user_id, node_id, ip_address);
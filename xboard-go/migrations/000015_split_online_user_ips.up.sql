@@ -0,0 +1,10 @@
+-- Alive entries are "IP_nodeIdentifier". Store the IP and the node-side
+-- identifier apart, so a device seen on several nodes counts once.
+
+-- Existing rows hold the raw entries; nodes report again within a minute
+DELETE FROM online_users;
+
+ALTER TABLE online_users
+    ADD COLUMN node_identifier VARCHAR(64) NOT NULL DEFAULT '' AFTER ip_address,
+    DROP INDEX unique_user_node_ip,
+    ADD UNIQUE KEY unique_user_node_ip (user_id, node_id, ip_address, node_identifier);
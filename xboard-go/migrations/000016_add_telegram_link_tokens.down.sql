@@ -0,0 +1,5 @@
+ALTER TABLE users
+    DROP INDEX idx_telegram_chat_id,
+    ADD INDEX idx_telegram_chat_id (telegram_chat_id);
+
+DROP TABLE IF EXISTS telegram_link_tokens;
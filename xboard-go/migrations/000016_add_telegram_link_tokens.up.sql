@@ -0,0 +1,30 @@
+-- Single-use tokens users send to the Telegram bot to link their chat
+
+CREATE TABLE IF NOT EXISTS telegram_link_tokens (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    token VARCHAR(64) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE KEY unique_token (token),
+    INDEX idx_user_id (user_id),
+    INDEX idx_expires_at (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- A chat can be linked to one account only; keep the oldest link of any
+-- chat linked to several
+UPDATE users u
+JOIN (
+    SELECT telegram_chat_id, MIN(id) AS keep_id
+    FROM users
+    WHERE telegram_chat_id IS NOT NULL
+    GROUP BY telegram_chat_id
+    HAVING COUNT(*) > 1
+) d ON u.telegram_chat_id = d.telegram_chat_id AND u.id <> d.keep_id
+SET u.telegram_chat_id = NULL, u.telegram_linked_at = NULL;
+
+ALTER TABLE users
+    DROP INDEX idx_telegram_chat_id,
+    ADD UNIQUE KEY idx_telegram_chat_id (telegram_chat_id);
@@ -0,0 +1,5 @@
+-- Default thresholds are kept; values that no longer fit are dropped
+DELETE FROM telegram_thresholds WHERE threshold_value >= 100000000;
+
+ALTER TABLE telegram_thresholds
+    MODIFY threshold_value DECIMAL(10, 2) NOT NULL;
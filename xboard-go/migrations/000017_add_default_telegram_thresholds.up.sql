@@ -0,0 +1,16 @@
+-- Widen threshold values so bytes_remaining thresholds can hold any quota
+ALTER TABLE telegram_thresholds
+    MODIFY threshold_value DECIMAL(20, 2) NOT NULL;
+
+-- Give users without thresholds the defaults new users start with
+INSERT INTO telegram_thresholds (user_id, threshold_type, threshold_value, enabled)
+SELECT u.id, 'percent', d.threshold_value, TRUE
+FROM users u
+CROSS JOIN (
+    SELECT 50 AS threshold_value
+    UNION ALL SELECT 80
+    UNION ALL SELECT 95
+) d
+WHERE NOT EXISTS (
+    SELECT 1 FROM telegram_thresholds t WHERE t.user_id = u.id
+);
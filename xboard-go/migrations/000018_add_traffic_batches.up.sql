@@ -0,0 +1,10 @@
+-- Traffic batches flushed from the ingest write-ahead log, so a batch
+-- replayed after a crash is not billed twice
+
+CREATE TABLE IF NOT EXISTS traffic_batches (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    batch_id VARCHAR(36) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY idx_batch_id (batch_id),
+    INDEX idx_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;